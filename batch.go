@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// ExchangeResult is the outcome of one of the exchanges of
+// [ExchangeAll] or [LookupAll].
+type ExchangeResult struct {
+	Response *dns.Msg
+	Err      error
+}
+
+// ExchangeAll passes many requests to the given [Exchanger] with at most
+// maxParallel exchanges in flight, and returns the results in the same
+// order as the requests.
+// If maxParallel isn't positive, [client.DefaultWorkerPoolSize] will be used.
+// Without a context all the exchanges fail as bad requests.
+func ExchangeAll(ctx context.Context, e Exchanger,
+	reqs []*dns.Msg, maxParallel int) []ExchangeResult {
+	//
+	c := client.ExchangeFunc(func(ctx context.Context, req *dns.Msg,
+		_ string) (*dns.Msg, time.Duration, error) {
+		//
+		if e == nil {
+			return nil, 0, errors.ErrBadRequest()
+		}
+
+		resp, err := e.Exchange(ctx, req)
+		return resp, 0, err
+	})
+
+	results := client.ExchangeBatch(ctx, c, "", reqs, maxParallel)
+
+	out := make([]ExchangeResult, len(results))
+	for i, r := range results {
+		out[i] = ExchangeResult{
+			Response: r.Response,
+			Err:      r.Err,
+		}
+	}
+	return out
+}
+
+// LookupAll is a variant of [ExchangeAll] taking INET questions
+// instead of pre-assembled requests.
+func LookupAll(ctx context.Context, e Exchanger,
+	questions []dns.Question, maxParallel int) []ExchangeResult {
+	//
+	reqs := make([]*dns.Msg, len(questions))
+	for i, q := range questions {
+		reqs[i] = newRequestFromQuestion(q)
+	}
+
+	return ExchangeAll(ctx, e, reqs, maxParallel)
+}
@@ -0,0 +1,72 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestExchangeAll(t *testing.T) {
+	// answers with the qName as TXT, taking longer for earlier requests
+	e := ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		q := req.Question[0]
+		time.Sleep(time.Duration(len(q.Name)) * time.Millisecond)
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = []dns.RR{
+			&dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+				Txt: []string{q.Name},
+			},
+		}
+		return resp, nil
+	})
+
+	var questions []dns.Question
+	for i := 10; i > 0; i-- {
+		name := fmt.Sprintf("%0*d.example.", i, i)
+		questions = append(questions, dns.Question{Name: name, Qtype: dns.TypeTXT})
+	}
+
+	results := LookupAll(context.Background(), e, questions, 3)
+	for i, r := range results {
+		switch {
+		case r.Err != nil:
+			t.Errorf("%v: %s", i, r.Err)
+		case r.Response.Question[0].Name != questions[i].Name:
+			t.Errorf("%v: got %q, expected %q", i,
+				r.Response.Question[0].Name, questions[i].Name)
+		}
+	}
+}
+
+func TestExchangeAllNoContext(t *testing.T) {
+	var ctx context.Context
+
+	e := ExchangerFunc(func(_ context.Context, _ *dns.Msg) (*dns.Msg, error) {
+		t.Error("exchange without context")
+		return nil, nil
+	})
+
+	questions := []dns.Question{
+		{Name: "example.org.", Qtype: dns.TypeA},
+		{Name: "example.net.", Qtype: dns.TypeA},
+	}
+
+	results := LookupAll(ctx, e, questions, 0)
+	if len(results) != len(questions) {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	for i, r := range results {
+		if r.Err == nil || r.Err.Error() != errors.ErrBadRequest().Error() {
+			t.Errorf("%v: unexpected error %v", i, r.Err)
+		}
+	}
+}
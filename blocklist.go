@@ -0,0 +1,213 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*Blocklist)(nil)
+	_ Exchanger = (*Blocklist)(nil)
+)
+
+// DefaultBlockTTL is the TTL of the records answering blocked
+// queries if not specified.
+const DefaultBlockTTL = 60
+
+// BlockAction indicates how a [Blocklist] answers blocked queries.
+type BlockAction int
+
+const (
+	// BlockNXDOMAIN answers blocked queries with NXDOMAIN.
+	BlockNXDOMAIN BlockAction = iota
+	// BlockNullIP answers blocked A and AAAA queries with
+	// 0.0.0.0 and :: respectively, and others with NODATA.
+	BlockNullIP
+	// BlockIP answers blocked A and AAAA queries with the
+	// configured addresses, and others with NODATA.
+	BlockIP
+)
+
+// BlocklistStats describes the activity of a [Blocklist].
+type BlocklistStats struct {
+	// Queries is the number of requests seen.
+	Queries uint64
+	// Blocked is the number of requests blocked.
+	Blocked uint64
+	// Allowed is the number of requests matching the allowlist.
+	Allowed uint64
+	// Exempted is the number of requests not checked because
+	// of the client making them.
+	Exempted uint64
+}
+
+// Blocklist is an [Exchanger] middleware answering by itself the
+// queries for names matching the Block list, unless they match the
+// Allow list too or come from an exempted client.
+type Blocklist struct {
+	next Exchanger
+
+	// Block lists the names to block.
+	Block *NameList
+	// Allow lists the names never blocked.
+	Allow *NameList
+
+	// Action indicates how blocked queries are answered.
+	Action BlockAction
+	// Addrs are the addresses given to blocked queries
+	// when using [BlockIP].
+	Addrs []netip.Addr
+	// TTL is the TTL of the records of blocked responses.
+	// [DefaultBlockTTL] is used if zero.
+	TTL uint32
+
+	// ClientAddr, if set, extracts the address of the client
+	// from the context, like set by server.Handler.RemoteAddr.
+	ClientAddr *core.ContextKey[netip.Addr]
+	// Exempt lists the networks of the clients whose queries
+	// are never blocked.
+	Exempt []netip.Prefix
+
+	queries  atomic.Uint64
+	blocked  atomic.Uint64
+	allowed  atomic.Uint64
+	exempted atomic.Uint64
+}
+
+// Lookup implements the [Lookuper] interface.
+func (b *Blocklist) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return b.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface, answering blocked
+// requests and passing the rest to the next [Exchanger].
+func (b *Blocklist) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	q := msgQuestion(req)
+	if ctx == nil || q == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	b.queries.Add(1)
+	if q.Qclass == dns.ClassINET && b.isBlocked(ctx, q.Name) {
+		b.blocked.Add(1)
+		return b.blockedReply(req), nil
+	}
+
+	return b.next.Exchange(ctx, req)
+}
+
+// IsBlocked tells if a name matches the Block list but not the
+// Allow list, regardless of the client.
+func (b *Blocklist) IsBlocked(qName string) bool {
+	return !b.Allow.Contains(qName) && b.Block.Contains(qName)
+}
+
+func (b *Blocklist) isBlocked(ctx context.Context, qName string) bool {
+	switch {
+	case !b.Block.Contains(qName):
+		return false
+	case b.Allow.Contains(qName):
+		b.allowed.Add(1)
+		return false
+	case b.isExempt(ctx):
+		b.exempted.Add(1)
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *Blocklist) isExempt(ctx context.Context) bool {
+	if b.ClientAddr == nil || len(b.Exempt) == 0 {
+		return false
+	}
+
+	addr, ok := b.ClientAddr.Get(ctx)
+	if !ok {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, p := range b.Exempt {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Blocklist) blockedReply(req *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+
+	switch b.Action {
+	case BlockNullIP:
+		resp.Answer = b.blockedAnswer(req.Question[0], blockNullAddrs)
+	case BlockIP:
+		resp.Answer = b.blockedAnswer(req.Question[0], b.Addrs)
+	default:
+		resp.Rcode = dns.RcodeNameError
+	}
+	return resp
+}
+
+var blockNullAddrs = []netip.Addr{
+	netip.IPv4Unspecified(),
+	netip.IPv6Unspecified(),
+}
+
+func (b *Blocklist) blockedAnswer(q dns.Question, addrs []netip.Addr) []dns.RR {
+	ttl := core.IIf(b.TTL > 0, b.TTL, DefaultBlockTTL)
+	return addrsAnswer(q, addrs, ttl)
+}
+
+// addrsAnswer returns the A or AAAA records answering the question
+// using the addresses of the matching family.
+func addrsAnswer(q dns.Question, addrs []netip.Addr, ttl uint32) []dns.RR {
+	var out []dns.RR
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		switch {
+		case q.Qtype == dns.TypeA && addr.Is4(),
+			q.Qtype == dns.TypeAAAA && addr.Is6():
+			rr, _ := newGlueRR(q.Name, ttl, addr)
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// Stats returns the current [BlocklistStats] of the [Blocklist].
+func (b *Blocklist) Stats() BlocklistStats {
+	return BlocklistStats{
+		Queries:  b.queries.Load(),
+		Blocked:  b.blocked.Load(),
+		Allowed:  b.allowed.Load(),
+		Exempted: b.exempted.Load(),
+	}
+}
+
+// NewBlocklist creates a [Blocklist] middleware with empty Block
+// and Allow lists, passing requests not blocked to the given
+// [Exchanger].
+func NewBlocklist(next Exchanger) (*Blocklist, error) {
+	if next == nil {
+		return nil, core.ErrInvalid
+	}
+
+	return &Blocklist{
+		next:  next,
+		Block: new(NameList),
+		Allow: new(NameList),
+	}, nil
+}
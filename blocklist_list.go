@@ -0,0 +1,337 @@
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+// DefaultListWatchInterval indicates how often [NameList.WatchFile]
+// checks the file for changes if not specified.
+const DefaultListWatchInterval = 30 * time.Second
+
+// NameList is a set of domain name patterns, loaded from files or
+// added directly. The zero value is an empty list ready to use.
+//
+// Patterns can be exact names, wildcards like "*.example.org"
+// matching the names below it, adblock-style rules like
+// "||example.org^" matching the name and those below it, and
+// regular expressions between slashes like "/^ads?[0-9]*\./",
+// matched against the canonical name without the trailing dot.
+type NameList struct {
+	mu      sync.RWMutex
+	sources map[string]*nameSet
+}
+
+// Add adds patterns to the [NameList].
+func (l *NameList) Add(patterns ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	set := l.getSource("")
+	for _, s := range patterns {
+		if err := set.Add(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains tells if a name matches any pattern of the [NameList].
+func (l *NameList) Contains(qName string) bool {
+	if l == nil {
+		return false
+	}
+
+	qName = dns.CanonicalName(qName)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, set := range l.sources {
+		if set.Contains(qName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of patterns in the [NameList].
+func (l *NameList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var n int
+	for _, set := range l.sources {
+		n += set.Len()
+	}
+	return n
+}
+
+// LoadFile replaces the patterns previously loaded from a file,
+// as described in [NameList.LoadReader].
+func (l *NameList) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return l.LoadReader(f, filename)
+}
+
+// LoadReader replaces the patterns previously loaded from the named
+// source. Lists use one pattern per line, in hosts file format, or
+// adblock format where only domain rules are considered.
+// Comments and lines not understood are ignored.
+func (l *NameList) LoadReader(f io.Reader, source string) error {
+	set, err := parseNameList(f)
+	if err != nil {
+		return core.Wrapf(err, "%q: failed to load list", source)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sources == nil {
+		l.sources = make(map[string]*nameSet)
+	}
+	l.sources[source] = set
+	return nil
+}
+
+// WatchFile loads patterns from a file, and keeps reloading it in the
+// background whenever it changes, until the context is cancelled.
+// Errors reloading the file leave the patterns as they were.
+// [DefaultListWatchInterval] is used if interval is zero.
+func (l *NameList) WatchFile(ctx context.Context, filename string, interval time.Duration) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := l.LoadFile(filename); err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		interval = DefaultListWatchInterval
+	}
+
+	go l.watchFile(ctx, filename, interval, fi)
+	return nil
+}
+
+func (l *NameList) watchFile(ctx context.Context, filename string,
+	interval time.Duration, last os.FileInfo) {
+	//
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			last = l.reloadIfChanged(filename, last)
+		}
+	}
+}
+
+// reloadIfChanged reloads a file if it changed since last seen,
+// and returns its new state.
+func (l *NameList) reloadIfChanged(filename string, last os.FileInfo) os.FileInfo {
+	fi, err := os.Stat(filename)
+	switch {
+	case err != nil:
+		// gone
+		return last
+	case fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size():
+		// unchanged
+		return last
+	case l.LoadFile(filename) != nil:
+		// failed, try again later
+		return last
+	default:
+		return fi
+	}
+}
+
+func (l *NameList) getSource(source string) *nameSet {
+	set, ok := l.sources[source]
+	if !ok {
+		if l.sources == nil {
+			l.sources = make(map[string]*nameSet)
+		}
+
+		set = new(nameSet)
+		l.sources[source] = set
+	}
+	return set
+}
+
+// nameSet holds the patterns of one source of a [NameList].
+type nameSet struct {
+	exact   map[string]struct{}
+	below   map[string]struct{}
+	regexps []*regexp.Regexp
+}
+
+func (s *nameSet) Contains(qName string) bool {
+	if _, ok := s.exact[qName]; ok {
+		return true
+	}
+
+	if len(s.below) > 0 {
+		for off, end := dns.NextLabel(qName, 0); !end; off, end = dns.NextLabel(qName, off) {
+			if _, ok := s.below[qName[off:]]; ok {
+				return true
+			}
+		}
+	}
+
+	if len(s.regexps) > 0 {
+		name := strings.TrimSuffix(qName, ".")
+		for _, re := range s.regexps {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *nameSet) Len() int {
+	return len(s.exact) + len(s.below) + len(s.regexps)
+}
+
+// Add adds one pattern to the set.
+func (s *nameSet) Add(pattern string) error {
+	switch {
+	case len(pattern) > 2 && pattern[0] == '/' && pattern[len(pattern)-1] == '/':
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return core.Wrapf(core.ErrInvalid, "invalid pattern %q", pattern)
+		}
+		s.regexps = append(s.regexps, re)
+	case strings.HasPrefix(pattern, "||"):
+		name, ok := checkListName(strings.TrimSuffix(pattern[2:], "^"))
+		if !ok {
+			return core.Wrapf(core.ErrInvalid, "invalid pattern %q", pattern)
+		}
+		s.addExact(name)
+		s.addBelow(name)
+	case strings.HasPrefix(pattern, "*."):
+		name, ok := checkListName(pattern[2:])
+		if !ok {
+			return core.Wrapf(core.ErrInvalid, "invalid pattern %q", pattern)
+		}
+		s.addBelow(name)
+	default:
+		name, ok := checkListName(pattern)
+		if !ok {
+			return core.Wrapf(core.ErrInvalid, "invalid pattern %q", pattern)
+		}
+		s.addExact(name)
+	}
+	return nil
+}
+
+func (s *nameSet) addExact(name string) {
+	if s.exact == nil {
+		s.exact = make(map[string]struct{})
+	}
+	s.exact[name] = struct{}{}
+}
+
+func (s *nameSet) addBelow(name string) {
+	if s.below == nil {
+		s.below = make(map[string]struct{})
+	}
+	s.below[name] = struct{}{}
+}
+
+// addLine adds the patterns of a line of a list file, if any.
+func (s *nameSet) addLine(line string) {
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if field[0] == '#' {
+			// comment
+			fields = fields[:i]
+			break
+		}
+	}
+
+	switch {
+	case len(fields) == 0:
+		// empty
+	case len(fields) > 1:
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			s.addHostsNames(fields[1:])
+		}
+	case fields[0][0] == '!', fields[0][0] == '[', strings.HasPrefix(fields[0], "@@"):
+		// adblock comments, headers and exceptions
+	default:
+		pattern, _, _ := strings.Cut(fields[0], "$")
+		_ = s.Add(pattern)
+	}
+}
+
+func (s *nameSet) addHostsNames(names []string) {
+	for _, name := range names {
+		if hostsLocalNames[name] {
+			continue
+		}
+
+		if name, ok := checkListName(name); ok {
+			s.addExact(name)
+		}
+	}
+}
+
+// hostsLocalNames are names commonly found on hosts-format lists
+// that shouldn't be blocked.
+var hostsLocalNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"0.0.0.0":               true,
+}
+
+func checkListName(name string) (string, bool) {
+	if name == "" || strings.ContainsAny(name, "*/|^$@#!") {
+		return "", false
+	}
+
+	if _, ok := dns.IsDomainName(name); !ok {
+		return "", false
+	}
+	return dns.CanonicalName(name), true
+}
+
+func parseNameList(f io.Reader) (*nameSet, error) {
+	set := new(nameSet)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		set.addLine(scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
@@ -0,0 +1,191 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+const testBlockListFile = `# hosts format
+0.0.0.0 localhost
+0.0.0.0 ads.example.com tracker.example.com # trailing comment
+127.0.0.1 metrics.example.net
+
+! adblock format
+[Adblock Plus 2.0]
+||doubleclick.example^
+||thirdparty.example^$third-party
+@@||good.example^
+example.com##.banner
+
+# plain
+plain.example.org
+*.wild.example.org
+/^ad[0-9]+\./
+`
+
+func TestNameList(t *testing.T) {
+	var l NameList
+	if err := l.LoadReader(strings.NewReader(testBlockListFile), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"ads.example.com", true},
+		{"ADS.example.com.", true},
+		{"www.ads.example.com", false},
+		{"tracker.example.com", true},
+		{"metrics.example.net", true},
+		{"localhost", false},
+		{"doubleclick.example", true},
+		{"x.y.doubleclick.example", true},
+		{"thirdparty.example", true},
+		{"good.example", false},
+		{"example.com", false},
+		{"plain.example.org", true},
+		{"wild.example.org", false},
+		{"a.wild.example.org", true},
+		{"ad42.example.org", true},
+		{"ad.example.org", false},
+	}
+
+	for _, tc := range tests {
+		if got := l.Contains(tc.name); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+
+	if err := l.Add("*.extra.example", "/[/"); err == nil {
+		t.Error("invalid regexp accepted")
+	}
+	if !l.Contains("a.extra.example") {
+		t.Error("added pattern not matched")
+	}
+}
+
+func TestNameListReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "block.txt")
+	if err := os.WriteFile(filename, []byte("one.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var l NameList
+	if err := l.Add("manual.example"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.LoadFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filename, []byte("two.example\nthree.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filename, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi2 := l.reloadIfChanged(filename, fi); fi2 == fi {
+		t.Fatal("change not detected")
+	}
+
+	switch {
+	case l.Contains("one.example"):
+		t.Error("old pattern kept")
+	case !l.Contains("two.example"), !l.Contains("manual.example"):
+		t.Error("pattern missing")
+	case l.Len() != 3:
+		t.Errorf("unexpected length: %v", l.Len())
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	clientKey := core.NewContextKey[netip.Addr]("test.client")
+
+	b, err := NewBlocklist(newTestZone(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.ClientAddr = clientKey
+	b.Exempt = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+
+	if err := b.Block.Add("||example.org^"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Allow.Add("www.example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	exempt := clientKey.WithValue(ctx, netip.MustParseAddr("::ffff:192.0.2.10"))
+
+	tests := []struct {
+		ctx    context.Context
+		action BlockAction
+		name   string
+		qType  uint16
+		rcode  int
+		answer string
+	}{
+		{ctx, BlockNXDOMAIN, "ns.example.org", dns.TypeA, dns.RcodeNameError, ""},
+		{ctx, BlockNXDOMAIN, "www.example.org", dns.TypeA, dns.RcodeSuccess, "192.0.2.2"},
+		{exempt, BlockNXDOMAIN, "ns.example.org", dns.TypeA, dns.RcodeSuccess, "192.0.2.1"},
+		{ctx, BlockNullIP, "ns.example.org", dns.TypeA, dns.RcodeSuccess, "0.0.0.0"},
+		{ctx, BlockNullIP, "ns.example.org", dns.TypeAAAA, dns.RcodeSuccess, "::"},
+		{ctx, BlockNullIP, "ns.example.org", dns.TypeTXT, dns.RcodeSuccess, ""},
+		{ctx, BlockIP, "ns.example.org", dns.TypeA, dns.RcodeSuccess, "198.51.100.1"},
+		{ctx, BlockIP, "ns.example.org", dns.TypeAAAA, dns.RcodeSuccess, ""},
+	}
+
+	b.Addrs = []netip.Addr{netip.MustParseAddr("198.51.100.1")}
+	for i, tc := range tests {
+		b.Action = tc.action
+
+		msg, err := b.Lookup(tc.ctx, tc.name, tc.qType)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+
+		var answer string
+		for _, rr := range msg.Answer {
+			switch v := rr.(type) {
+			case *dns.A:
+				answer = v.A.String()
+			case *dns.AAAA:
+				answer = v.AAAA.String()
+			}
+		}
+
+		if msg.Rcode != tc.rcode || answer != tc.answer {
+			t.Errorf("%d: unexpected response %s %q", i,
+				dns.RcodeToString[msg.Rcode], answer)
+		}
+	}
+
+	stats := b.Stats()
+	expected := BlocklistStats{Queries: 8, Blocked: 6, Allowed: 1, Exempted: 1}
+	if stats != expected {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if _, err := NewBlocklist(nil); err == nil {
+		t.Error("nil exchanger accepted")
+	}
+}
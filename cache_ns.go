@@ -38,6 +38,37 @@ type NSCache struct {
 	lru *simplelru.LRU[string, *NSCacheZone]
 
 	persistent map[string]bool
+	refresh    func(qName string)
+	stats      NSCacheStats
+}
+
+// SetRefresher sets a function to be called when a non-persistent
+// zone past its half-life is used, to refresh it in the background.
+// The function must not block.
+func (nsc *NSCache) SetRefresher(fn func(qName string)) {
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+
+	nsc.refresh = fn
+}
+
+func (nsc *NSCache) checkRefresh(zone *NSCacheZone) {
+	if !zone.NeedsRefresh() {
+		return
+	}
+
+	nsc.mu.Lock()
+	fn := nsc.refresh
+	if nsc.persistent[zone.name] {
+		fn = nil
+	} else if fn != nil {
+		nsc.stats.Refreshes++
+	}
+	nsc.mu.Unlock()
+
+	if fn != nil {
+		fn(zone.name)
+	}
 }
 
 // SetLogger attaches a logger to the Cache. [slog.Debug] level
@@ -52,6 +83,13 @@ func (nsc *NSCache) SetLogger(log slog.Logger) {
 	nsc.log = log
 }
 
+func (nsc *NSCache) getLogger() slog.Logger {
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+
+	return nsc.log
+}
+
 func (nsc *NSCache) onLRUAdd(qName string, zone *NSCacheZone, size int, expire time.Time) {
 	if l, ok := nsc.log.Debug().WithEnabled(); ok {
 		l = l.WithFields(slog.Fields{
@@ -88,6 +126,7 @@ func (*NSCache) addLogFieldCleanRR(l slog.Logger, name string, records []dns.RR)
 }
 
 func (nsc *NSCache) onLRUEvict(qName string, zone *NSCacheZone, size int) {
+	nsc.stats.Evictions++
 	nsc.log.Debug().WithFields(slog.Fields{
 		"domain":  qName,
 		"entries": size,
@@ -150,10 +189,12 @@ func (nsc *NSCache) Lookup(qName string) (*NSCacheZone, bool) {
 	for _, name := range nsc.Suffixes(qName) {
 		data, _, ok := nsc.lru.Get(name)
 		if ok {
+			nsc.stats.Hits++
 			return data, true
 		}
 	}
 
+	nsc.stats.Misses++
 	return nil, false
 }
 
@@ -225,6 +266,8 @@ func (nsc *NSCache) ExchangeWithClient(ctx context.Context,
 		return nil, errors.ErrRefused(q.Name)
 	}
 
+	nsc.checkRefresh(zone)
+
 	resp, err := zone.s.ExchangeWithClient(ctx, req, c)
 	switch e := err.(type) {
 	case nil:
@@ -232,12 +275,23 @@ func (nsc *NSCache) ExchangeWithClient(ctx context.Context,
 	case *net.DNSError:
 		if e.Err == errors.NODATA {
 			return nsc.handleNODATA(resp, e)
+		} else if e.IsNotFound {
+			return nsc.handleNXDOMAIN(resp, e)
 		}
 	}
 
 	return nil, err
 }
 
+func (*NSCache) handleNXDOMAIN(resp *dns.Msg, err error) (*dns.Msg, error) {
+	if exdns.HasNsType(resp, dns.TypeSOA) {
+		// pass over SOA data along the error,
+		// for negative caching.
+		return resp, err
+	}
+	return nil, err
+}
+
 func (*NSCache) handleNODATA(resp *dns.Msg, err error) (*dns.Msg, error) {
 	if exdns.HasNsType(resp, dns.TypeSOA) {
 		// pass over SOA data
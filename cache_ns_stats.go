@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// NSCacheStats describes the state and activity of a [NSCache].
+type NSCacheStats struct {
+	// Entries is the number of zones cached.
+	Entries int
+	// Records is the number of NS and glue entries cached.
+	Records int
+
+	// Hits counts the lookups that found a zone.
+	Hits uint64
+	// Misses counts the lookups that found nothing.
+	Misses uint64
+	// Evictions counts the zones removed from the cache.
+	Evictions uint64
+	// Refreshes counts the background refreshes requested.
+	Refreshes uint64
+}
+
+// Stats returns the current [NSCacheStats] of the [NSCache].
+func (nsc *NSCache) Stats() NSCacheStats {
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+
+	out := nsc.stats
+	out.Entries = nsc.lru.Len()
+	out.Records = nsc.lru.Size()
+	return out
+}
+
+// NSCacheZoneSnapshot describes a zone in the [NSCache]
+// at a given moment.
+type NSCacheZoneSnapshot struct {
+	Name       string
+	NS         []dns.RR
+	Glue       []dns.RR
+	Expire     time.Time
+	HalfLife   time.Time
+	Persistent bool
+}
+
+// ForEachZone calls a function with a snapshot of each zone
+// in the [NSCache]. Return true to terminate the loop.
+func (nsc *NSCache) ForEachZone(fn func(NSCacheZoneSnapshot) bool) {
+	if fn == nil {
+		return
+	}
+
+	for _, s := range nsc.snapshot() {
+		if fn(s) {
+			break
+		}
+	}
+}
+
+// Dump returns a snapshot of every zone in the [NSCache].
+func (nsc *NSCache) Dump() []NSCacheZoneSnapshot {
+	return nsc.snapshot()
+}
+
+func (nsc *NSCache) snapshot() []NSCacheZoneSnapshot {
+	var zones []*NSCacheZone
+	var persistent []bool
+
+	nsc.mu.Lock()
+	nsc.lru.ForEach(func(name string, zone *NSCacheZone, _ int, _ time.Time) bool {
+		zones = append(zones, zone)
+		persistent = append(persistent, nsc.persistent[name])
+		return false
+	})
+	nsc.mu.Unlock()
+
+	out := make([]NSCacheZoneSnapshot, len(zones))
+	for i, zone := range zones {
+		out[i] = zone.snapshot(persistent[i])
+	}
+	return out
+}
+
+// revive:disable:flag-parameter
+
+func (zone *NSCacheZone) snapshot(persistent bool) NSCacheZoneSnapshot {
+	// revive:enable:flag-parameter
+	ttl := zone.TTL()
+
+	zone.mu.Lock()
+	defer zone.mu.Unlock()
+
+	return NSCacheZoneSnapshot{
+		Name:       zone.name,
+		NS:         zone.unsafeExportNS(ttl),
+		Glue:       zone.unsafeExportGlue(ttl),
+		Expire:     zone.until,
+		HalfLife:   zone.halfLife,
+		Persistent: persistent,
+	}
+}
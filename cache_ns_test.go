@@ -1,6 +1,16 @@
 package resolver
 
-import "testing"
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
 
 type SuffixCases struct {
 	Name     string
@@ -48,3 +58,145 @@ func TestNSCacheSuffixes(t *testing.T) {
 		tc.Test(t, nsc)
 	}
 }
+
+func TestNSCacheRefresher(t *testing.T) {
+	var refreshed []string
+
+	nsc := NewNSCache("test", 0)
+	nsc.SetRefresher(func(qName string) {
+		refreshed = append(refreshed, qName)
+	})
+
+	zone := NewNSCacheZoneFromMap("example.org.", 60, map[string]string{
+		"ns1.example.org": "192.0.2.1",
+	})
+	if err := nsc.Add(zone); err != nil {
+		t.Fatal(err)
+	}
+
+	nsc.checkRefresh(zone)
+	if len(refreshed) != 0 {
+		t.Fatalf("fresh zone refreshed: %q", refreshed)
+	}
+
+	zone.halfLife = time.Now().Add(-time.Second)
+	nsc.checkRefresh(zone)
+	if len(refreshed) != 1 || refreshed[0] != "example.org." {
+		t.Fatalf("expected example.org. refreshed, got %q", refreshed)
+	}
+
+	if err := nsc.SetPersistence("example.org.", true); err != nil {
+		t.Fatal(err)
+	}
+	nsc.checkRefresh(zone)
+	if len(refreshed) != 1 {
+		t.Errorf("persistent zone refreshed: %q", refreshed)
+	}
+}
+
+func TestZoneRefresherRetry(t *testing.T) {
+	zr := newZoneRefresher()
+
+	if !zr.TryStart("example.org.", time.Minute) {
+		t.Fatal("first attempt rejected")
+	}
+	if zr.TryStart("example.org.", time.Minute) {
+		t.Error("concurrent attempt accepted")
+	}
+
+	zr.Done("example.org.")
+	if !zr.TryStart("example.org.", time.Minute) {
+		t.Error("attempt after completion rejected")
+	}
+}
+
+func TestNSCacheStats(t *testing.T) {
+	nsc := NewNSCache("test", 0)
+
+	zone := NewNSCacheZoneFromMap("example.org.", 60, map[string]string{
+		"ns1.example.org": "192.0.2.1",
+		"ns2.example.org": "192.0.2.2",
+	})
+	if err := nsc.Add(zone); err != nil {
+		t.Fatal(err)
+	}
+
+	nsc.Lookup("www.example.org.")
+	nsc.Lookup("example.net.")
+
+	stats := nsc.Stats()
+	expected := NSCacheStats{Entries: 1, Records: 4, Hits: 1, Misses: 1}
+	if stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	dump := nsc.Dump()
+	switch {
+	case len(dump) != 1:
+		t.Fatalf("expected one zone, got %v", len(dump))
+	case dump[0].Name != "example.org.":
+		t.Errorf("unexpected zone %q", dump[0].Name)
+	case len(dump[0].NS) != 2, len(dump[0].Glue) != 2:
+		t.Errorf("unexpected records: %v %v", dump[0].NS, dump[0].Glue)
+	case dump[0].NS[0].Header().Ttl == 0:
+		t.Error("TTL not exported")
+	}
+
+	nsc.Evict("example.org.")
+	if stats := nsc.Stats(); stats.Entries != 0 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats after eviction: %+v", stats)
+	}
+}
+
+func TestNSCacheZoneCaps(t *testing.T) {
+	newDelegation := func(reverse bool) *dns.Msg {
+		resp := new(dns.Msg)
+		for i := 0; i < 30; i++ {
+			n := i
+			if reverse {
+				n = 29 - i
+			}
+
+			name := fmt.Sprintf("ns%02d.example.org.", n)
+			resp.Ns = append(resp.Ns, &dns.NS{
+				Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60},
+				Ns:  name,
+			})
+			for j := 1; j <= 3; j++ {
+				resp.Extra = append(resp.Extra, &dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.IPv4(192, 0, 2, byte(n*3+j)),
+				})
+			}
+		}
+		return resp
+	}
+
+	var first []string
+	for _, reverse := range []bool{false, true} {
+		zone, err := NewNSCacheZoneFromDelegation(newDelegation(reverse))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		var glue int
+		zone.ForEachNS(func(name string, addrs []netip.Addr) {
+			names = append(names, name)
+			glue += len(addrs)
+		})
+		sort.Strings(names)
+
+		switch {
+		case len(names) != NSCacheZoneMaxNS:
+			t.Errorf("expected %v NS, got %v", NSCacheZoneMaxNS, len(names))
+		case glue > NSCacheZoneMaxGlue:
+			t.Errorf("expected at most %v glue addresses, got %v", NSCacheZoneMaxGlue, glue)
+		case names[0] != "ns00.example.org.":
+			t.Errorf("unexpected first NS %q", names[0])
+		case first != nil && strings.Join(first, ",") != strings.Join(names, ","):
+			t.Errorf("truncation depends on order: %q vs %q", first, names)
+		}
+		first = names
+	}
+}
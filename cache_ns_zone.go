@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/netip"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +21,14 @@ const (
 	// MinimumNSCacheTTL tells the minimum time, in seconds,
 	// entries remain in the cache
 	MinimumNSCacheTTL = 10
+
+	// NSCacheZoneMaxNS is the maximum number of NS names
+	// a [NSCacheZone] accepts.
+	NSCacheZoneMaxNS = 20
+
+	// NSCacheZoneMaxGlue is the maximum number of glue
+	// addresses a [NSCacheZone] accepts.
+	NSCacheZoneMaxGlue = 40
 )
 
 // NSCacheZone represents the NS data and glue for a domain name.
@@ -29,6 +38,7 @@ type NSCacheZone struct {
 	ns       []string
 	sortedNS []string
 	glue     map[string][]netip.Addr
+	nglue    int
 
 	ttl      uint32
 	until    time.Time
@@ -37,7 +47,8 @@ type NSCacheZone struct {
 	deadline time.Duration
 	interval time.Duration
 
-	s *Pool
+	s     *Pool
+	infra *InfraCache
 }
 
 // Name returns the domain name associated to these servers.
@@ -53,7 +64,7 @@ func (zone *NSCacheZone) Expire() time.Time {
 // TTL returns the number of seconds the data has to live.
 func (zone *NSCacheZone) TTL() uint32 {
 	now := time.Now()
-	duration := now.Sub(zone.until)
+	duration := zone.until.Sub(now)
 	if duration > 0 {
 		return uint32(duration / time.Second)
 	}
@@ -68,7 +79,15 @@ func (zone *NSCacheZone) OriginalTTL() uint32 {
 
 // NeedsRefresh tells when this information should be refreshed.
 func (zone *NSCacheZone) NeedsRefresh() bool {
-	return time.Now().After(zone.halfLife)
+	return time.Now().After(zone.HalfLife())
+}
+
+// HalfLife returns when the zone should be refreshed.
+func (zone *NSCacheZone) HalfLife() time.Time {
+	zone.mu.Lock()
+	defer zone.mu.Unlock()
+
+	return zone.halfLife
 }
 
 // Len returns the number of dns.RR entries stored.
@@ -107,6 +126,18 @@ func (zone *NSCacheZone) SetResilience(attempts int, deadline, interval time.Dur
 	}
 }
 
+// SetInfraCache sets the [InfraCache] used to choose which
+// nameserver of the zone to ask.
+func (zone *NSCacheZone) SetInfraCache(infra *InfraCache) {
+	zone.mu.Lock()
+	defer zone.mu.Unlock()
+
+	zone.infra = infra
+	if zone.s != nil {
+		zone.s.SetInfraCache(infra)
+	}
+}
+
 // SetTTL sets the expiration and half-life times in
 // seconds from Now.
 func (zone *NSCacheZone) SetTTL(ttl, half uint32) {
@@ -165,6 +196,9 @@ func (zone *NSCacheZone) unsafeIndex() {
 	zone.s.Attempts = zone.attempts
 	zone.s.Interval = zone.interval
 	zone.s.Deadline = zone.deadline
+	zone.s.Failover = true
+	zone.s.zone = zone.name
+	zone.s.SetInfraCache(zone.infra)
 }
 
 // ReplyNS produces a response message equivalent to
@@ -339,6 +373,9 @@ func (zone *NSCacheZone) AddNS(name string) bool {
 	if _, ok := zone.glue[name]; ok {
 		// known
 		return false
+	} else if len(zone.ns) >= NSCacheZoneMaxNS {
+		// full
+		return false
 	}
 
 	zone.ns = append(zone.ns, name)
@@ -362,8 +399,15 @@ func (zone *NSCacheZone) AddGlue(name string, addrs ...netip.Addr) bool {
 		}
 
 		for _, addr := range addrs {
+			if zone.nglue >= NSCacheZoneMaxGlue {
+				// full
+				break
+			}
+
 			if !core.SliceContainsFn(s, addr, eq) {
-				zone.glue[name] = append(s, addr)
+				s = append(s, addr)
+				zone.glue[name] = s
+				zone.nglue++
 				zone.s = nil
 				added = true
 			}
@@ -379,9 +423,16 @@ func (zone *NSCacheZone) SetGlue(name string, addrs []netip.Addr) bool {
 	zone.mu.Lock()
 	defer zone.mu.Unlock()
 
-	if _, ok := zone.glue[name]; ok {
+	if old, ok := zone.glue[name]; ok {
 		// known NS
+		zone.nglue -= len(old)
+		if n := NSCacheZoneMaxGlue - zone.nglue; len(addrs) > n {
+			// truncate
+			addrs = addrs[:n]
+		}
+
 		zone.glue[name] = addrs
+		zone.nglue += len(addrs)
 		zone.s = nil
 		return true
 	}
@@ -433,10 +484,14 @@ func (zone *NSCacheZone) ForEachNS(fn func(name string, addrs []netip.Addr)) {
 		zone.mu.Lock()
 		names := make([]string, len(zone.ns))
 		copy(names, zone.ns)
+		glue := make([][]netip.Addr, len(names))
+		for i, name := range names {
+			glue[i] = core.SliceCopy(zone.glue[name])
+		}
 		zone.mu.Unlock()
 
-		for _, name := range names {
-			fn(name, zone.glue[name])
+		for i, name := range names {
+			fn(name, glue[i])
 		}
 	}
 }
@@ -639,8 +694,9 @@ func assembleNSCacheZoneFromRR(ns, extra []dns.RR) (*NSCacheZone, uint32, bool)
 
 	zone := NewNSCacheZone("")
 
-	// collect NS entries
-	exdns.ForEachRR(ns, func(rr *dns.NS) {
+	// collect NS entries, sorted so truncation
+	// doesn't depend on the order of the response.
+	exdns.ForEachRR(sortNSCacheRR(ns), func(rr *dns.NS) {
 		hdr := rr.Header()
 
 		if zone.name == "" {
@@ -657,7 +713,7 @@ func assembleNSCacheZoneFromRR(ns, extra []dns.RR) (*NSCacheZone, uint32, bool)
 	})
 
 	// collect A/AAAA entries
-	exdns.ForEachRR(extra, func(rr dns.RR) {
+	exdns.ForEachRR(sortNSCacheRR(extra), func(rr dns.RR) {
 		if zone.AddGlueRR(rr) {
 			// accepted
 			if n := rr.Header().Ttl; n < ttl {
@@ -669,10 +725,34 @@ func assembleNSCacheZoneFromRR(ns, extra []dns.RR) (*NSCacheZone, uint32, bool)
 	return zone, ttl, len(zone.ns) > 0
 }
 
+// sortNSCacheRR returns a copy of the records sorted by
+// name, type and data, ignoring the TTL.
+func sortNSCacheRR(records []dns.RR) []dns.RR {
+	keys := make(map[dns.RR]string, len(records))
+	for _, rr := range records {
+		hdr := rr.Header()
+		data := strings.TrimPrefix(rr.String(), hdr.String())
+		keys[rr] = strings.ToLower(hdr.Name + " " + dns.TypeToString[hdr.Rrtype] + " " + data)
+	}
+
+	out := core.SliceCopy(records)
+	sort.SliceStable(out, func(i, j int) bool {
+		return keys[out[i]] < keys[out[j]]
+	})
+	return out
+}
+
 func assembleNSCacheZoneFromMap(qName string, m map[string]string) *NSCacheZone {
 	zone := NewNSCacheZone(qName)
 
-	for k, sAddr := range m {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		sAddr := m[k]
 		k = dns.Fqdn(k)
 		addr, _ := netip.ParseAddr(sAddr)
 		if addr.IsValid() {
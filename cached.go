@@ -0,0 +1,359 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/cache/x/simplelru"
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/internal/flight"
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*Cached)(nil)
+	_ Exchanger = (*Cached)(nil)
+)
+
+const (
+	// DefaultCachedSize indicates how many responses [Cached]
+	// remembers if not specified.
+	DefaultCachedSize = 4096
+
+	// DefaultCachedPrefetchTimeout indicates how long [Cached]
+	// waits for a prefetch to complete.
+	DefaultCachedPrefetchTimeout = 5 * time.Second
+
+	// DefaultCachedStaleTTL is the TTL, in seconds, of the records
+	// of stale responses served by [Cached], as recommended by RFC 8767.
+	DefaultCachedStaleTTL = 30
+)
+
+// Cached is an [Exchanger] middleware remembering successful
+// responses for as long as their records allow, and serving
+// copies with the remaining TTL.
+type Cached struct {
+	mu    sync.Mutex
+	g     flight.Group
+	next  Exchanger
+	lru   *simplelru.LRU[cachedKey, *cachedEntry]
+	bytes bool
+	low   int
+
+	shared CacheStore
+
+	// OnEvict, if set, is called when an entry is removed from
+	// the cache, with its size. It's called with the cache locked
+	// so it must not use the [Cached] itself.
+	OnEvict func(qName string, qType uint16, size int)
+	// OnEvent, if set, is called on every [CachedEvent]. Like OnEvict,
+	// it may be called with the cache locked.
+	OnEvent func(ev CachedEvent, qName string, qType uint16)
+
+	counters cachedCounters
+	policies []CachePolicy
+
+	// PrefetchHits is the number of hits after which an entry is
+	// refreshed in the background once less than 10% of its TTL
+	// remains. Zero disables prefetching.
+	PrefetchHits int
+
+	// MinTTL is the lowest TTL, in seconds, of the records
+	// cached and returned.
+	MinTTL uint32
+	// MaxTTL is the highest TTL, in seconds, of the records
+	// cached and returned. Zero means no limit.
+	MaxTTL uint32
+
+	// ServeStale is how long past their expiration responses are
+	// kept to be served, with [DefaultCachedStaleTTL], when the
+	// upstream [Exchanger] fails, as described by RFC 8767.
+	// Zero disables serving stale responses.
+	ServeStale time.Duration
+}
+
+// cachedKey identifies a cached response by its question
+type cachedKey struct {
+	name   string
+	qType  uint16
+	qClass uint16
+}
+
+func (k cachedKey) String() string {
+	return fmt.Sprintf("%s %v %v", k.name, k.qClass, k.qType)
+}
+
+func newCachedKey(q *dns.Question) cachedKey {
+	return cachedKey{
+		name:   dns.CanonicalName(q.Name),
+		qType:  q.Qtype,
+		qClass: q.Qclass,
+	}
+}
+
+// cachedEntry is a response stored by [Cached]
+type cachedEntry struct {
+	msg   *dns.Msg
+	added time.Time
+	ttl   uint32
+	hits  int
+
+	prefetching bool
+}
+
+// NeedsPrefetch tells if the entry is popular enough and
+// close enough to expire to be refreshed in advance.
+func (e *cachedEntry) NeedsPrefetch(threshold int) bool {
+	if threshold <= 0 || e.hits < threshold || e.prefetching {
+		return false
+	}
+
+	ttl := time.Duration(e.ttl) * time.Second
+	return time.Since(e.added) > ttl-ttl/10
+}
+
+// Lookup implements the [Lookuper] interface using the cache
+// when possible.
+func (c *Cached) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	if ctx == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return c.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface using the cache
+// when possible, and storing successful responses otherwise.
+func (c *Cached) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if ctx == nil || req == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	q := msgQuestion(req)
+	if q == nil {
+		// nothing to answer
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		return msg, nil
+	}
+
+	key := newCachedKey(q)
+	policy := c.getPolicy(key)
+	if policy.Bypass {
+		return c.next.Exchange(ctx, req)
+	}
+
+	if resp, ok := c.get(key); ok {
+		c.event(CachedHit, key)
+		if t, ok := GetQueryTrace(ctx); ok {
+			t.setCacheHit()
+		}
+		return c.restore(req, resp), nil
+	}
+
+	c.event(CachedMiss, key)
+	return c.exchangeMiss(ctx, req, key, &policy)
+}
+
+// exchangeMiss passes a request upstream and stores the response.
+// Concurrent misses for the same key share a single exchange, which
+// isn't bound to the context of the caller that started it.
+func (c *Cached) exchangeMiss(ctx context.Context, req *dns.Msg,
+	key cachedKey, policy *CachePolicy) (*dns.Msg, error) {
+	//
+	// the exchange may outlive the caller, so it gets
+	// its own copy of the request.
+	req2 := req.Copy()
+	v, err, shared := c.g.Do(ctx, key.String(), func(ctx context.Context) (any, error) {
+		return c.doExchangeMiss(ctx, req2, key, policy)
+	})
+
+	resp, _ := v.(*dns.Msg)
+	switch {
+	case resp == nil && ctx.Err() != nil:
+		// caller gave up
+		return nil, errors.ErrTimeout(key.name, err)
+	case shared && resp != nil:
+		// each waiter gets its own copy
+		resp = c.restore(req, resp.Copy())
+	}
+	return resp, err
+}
+
+func (c *Cached) doExchangeMiss(ctx context.Context, req *dns.Msg,
+	key cachedKey, policy *CachePolicy) (*dns.Msg, error) {
+	//
+	if c.loadShared(ctx, key) {
+		// found in the external store
+		if resp, ok := c.get(key); ok {
+			return c.restore(req, resp), nil
+		}
+	}
+
+	resp, err := c.next.Exchange(ctx, req)
+	switch {
+	case err == nil && resp != nil:
+		resp = policy.clamp(resp)
+		c.saveShared(ctx, key, c.store(key, resp))
+	case errors.IsNotFound(err):
+		// NXDOMAIN
+	default:
+		c.event(CachedError, key)
+		if stale, ok := c.getStale(key); ok {
+			c.event(CachedStale, key)
+			return c.restore(req, stale), nil
+		}
+	}
+	return resp, err
+}
+
+// get returns a copy of a cached response with the TTLs
+// reduced by the time spent in the cache. Entries whose TTL
+// reached zero are dropped, unless they can still be served stale.
+func (c *Cached) get(key cachedKey) (*dns.Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, _, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	resp := e.msg.Copy()
+	if exdns.DecayTTL(resp, time.Since(e.added)) {
+		// expired, but kept while it can be served stale
+		if c.ServeStale <= 0 {
+			c.lru.Evict(key)
+		}
+		return nil, false
+	}
+
+	e.hits++
+	if e.NeedsPrefetch(c.PrefetchHits) {
+		e.prefetching = true
+		go c.prefetch(key, e)
+	}
+	return resp, true
+}
+
+// getStale returns a copy of an expired response still within the
+// [Cached.ServeStale] window, with the TTLs set to [DefaultCachedStaleTTL].
+func (c *Cached) getStale(key cachedKey) (*dns.Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, _, ok := c.lru.Get(key)
+	if !ok || c.ServeStale <= 0 {
+		return nil, false
+	}
+
+	ttl := time.Duration(e.ttl) * time.Second
+	if time.Since(e.added) > ttl+c.ServeStale {
+		// too old
+		return nil, false
+	}
+
+	resp := e.msg.Copy()
+	exdns.ForEachTTLRR(resp, func(rr dns.RR) {
+		rr.Header().Ttl = DefaultCachedStaleTTL
+	})
+	return resp, true
+}
+
+// prefetch refreshes an entry in the background. If the
+// refresh can't replace it, the entry can be prefetched again.
+func (c *Cached) prefetch(key cachedKey, e *cachedEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCachedPrefetchTimeout)
+	defer cancel()
+
+	c.event(CachedPrefetch, key)
+
+	req := exdns.NewRequestFromParts(key.name, key.qClass, key.qType)
+	resp, err := c.next.Exchange(ctx, req)
+	if err == nil && resp != nil {
+		policy := c.getPolicy(key)
+		if e2 := c.store(key, policy.clamp(resp)); e2 != nil {
+			c.saveShared(ctx, key, e2)
+			return
+		}
+	}
+
+	c.mu.Lock()
+	e.prefetching = false
+	c.mu.Unlock()
+}
+
+// restore adapts a cached response to the request.
+func (*Cached) restore(req, resp *dns.Msg) *dns.Msg {
+	resp.Id = req.Id
+	resp.Question = core.SliceCopy(req.Question[:1])
+	return resp
+}
+
+// store remembers a response if cacheable, for
+// as long as its shortest TTL, and returns the new entry.
+func (c *Cached) store(key cachedKey, resp *dns.Msg) *cachedEntry {
+	ttl, ok := cachedTTL(resp)
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	e := &cachedEntry{
+		msg:   resp.Copy(),
+		added: now,
+		ttl:   ttl,
+	}
+
+	c.mu.Lock()
+	c.unsafeAdd(key, e, now.Add(time.Duration(ttl)*time.Second+c.ServeStale))
+	c.mu.Unlock()
+
+	c.event(CachedInsert, key)
+	return e
+}
+
+// cachedTTL tells if a response can be cached, and for how long.
+func cachedTTL(resp *dns.Msg) (uint32, bool) {
+	switch {
+	case resp.Rcode != dns.RcodeSuccess, resp.Truncated:
+		return 0, false
+	case len(resp.Answer) == 0:
+		// not positive
+		return 0, false
+	}
+
+	ttl, ok := exdns.MinTTL(resp)
+	return ttl, ok && ttl > 0
+}
+
+// NewCachedExchanger creates a [Cached] [Exchanger] remembering
+// up to size responses from the given [Exchanger].
+// [DefaultCachedSize] is used if size is zero.
+func NewCachedExchanger(next Exchanger, size int) (*Cached, error) {
+	if next == nil || size < 0 {
+		return nil, core.ErrInvalid
+	}
+
+	if size == 0 {
+		size = DefaultCachedSize
+	}
+
+	return newCached(next, size, false), nil
+}
+
+func newCached(next Exchanger, size int, bytes bool) *Cached {
+	c := &Cached{
+		next:  next,
+		bytes: bytes,
+	}
+	c.lru = simplelru.NewLRU[cachedKey, *cachedEntry](size, nil, c.onLRUEvict)
+	return c
+}
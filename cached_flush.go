@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	_ CacheFlusher = (*Cached)(nil)
+)
+
+// CacheFlusher is implemented by caches that can be invalidated,
+// i.e. from an administrative interface after zone changes.
+// Each method returns the number of entries removed.
+type CacheFlusher interface {
+	Flush() int
+	FlushName(name string) int
+	FlushTree(suffix string) int
+}
+
+// Flush removes all entries from the cache.
+func (c *Cached) Flush() int {
+	return c.flushFn(func(cachedKey) bool { return true })
+}
+
+// FlushName removes all entries for the given name,
+// of any type.
+func (c *Cached) FlushName(name string) int {
+	name = dns.CanonicalName(name)
+	return c.flushFn(func(key cachedKey) bool {
+		return key.name == name
+	})
+}
+
+// FlushTree removes all entries for the given name
+// and every name under it.
+func (c *Cached) FlushTree(suffix string) int {
+	suffix = dns.CanonicalName(suffix)
+	return c.flushFn(func(key cachedKey) bool {
+		return dns.IsSubDomain(suffix, key.name)
+	})
+}
+
+func (c *Cached) flushFn(match func(cachedKey) bool) int {
+	var keys []cachedKey
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.ForEach(func(key cachedKey, _ *cachedEntry, _ int, _ time.Time) bool {
+		if match(key) {
+			keys = append(keys, key)
+		}
+		return false
+	})
+
+	for _, key := range keys {
+		c.lru.Evict(key)
+	}
+	return len(keys)
+}
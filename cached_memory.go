@@ -0,0 +1,80 @@
+package resolver
+
+import (
+	"time"
+
+	"darvaza.org/core"
+)
+
+// NewCachedExchangerBytes creates a [Cached] [Exchanger] accounting
+// the packed size of the responses. When the usage exceeds the high
+// watermark the least recently used entries are evicted until it's
+// below the low watermark, or just fits if low is zero.
+func NewCachedExchangerBytes(next Exchanger, high, low int) (*Cached, error) {
+	if next == nil || high <= 0 || low < 0 || low > high {
+		return nil, core.ErrInvalid
+	}
+
+	c := newCached(next, high, true)
+	c.low = low
+	return c, nil
+}
+
+// Len returns the number of entries in the cache.
+func (c *Cached) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Size returns the usage of the cache, in bytes if created
+// using [NewCachedExchangerBytes] or entries otherwise.
+func (c *Cached) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Size()
+}
+
+func (c *Cached) entrySize(e *cachedEntry) int {
+	if c.bytes {
+		return e.msg.Len()
+	}
+	return 1
+}
+
+func (c *Cached) unsafeAdd(key cachedKey, e *cachedEntry, expire time.Time) {
+	if c.lru.Add(key, e, c.entrySize(e), expire) && c.low > 0 {
+		// high watermark reached
+		c.unsafeTrim(c.low)
+	}
+}
+
+// unsafeTrim evicts the least recently used entries
+// until the usage is at most size.
+func (c *Cached) unsafeTrim(size int) {
+	var keys []cachedKey
+
+	excess := c.lru.Size() - size
+	c.lru.ForEach(func(key cachedKey, _ *cachedEntry, n int, _ time.Time) bool {
+		if excess <= 0 {
+			return true
+		}
+
+		keys = append(keys, key)
+		excess -= n
+		return false
+	})
+
+	for _, key := range keys {
+		c.lru.Evict(key)
+	}
+}
+
+func (c *Cached) onLRUEvict(key cachedKey, _ *cachedEntry, size int) {
+	if fn := c.OnEvict; fn != nil {
+		fn(key.name, key.qType, size)
+	}
+	c.event(CachedEvict, key)
+}
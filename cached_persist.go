@@ -0,0 +1,171 @@
+package resolver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// cachedFileMagic identifies the format of files written by [Cached.Save].
+var cachedFileMagic = [4]byte{'R', 'C', 0, 1}
+
+// cachedRecordHeader precedes every packed message in a file
+// written by [Cached.Save].
+type cachedRecordHeader struct {
+	Added  int64
+	Expire int64
+	Len    uint16
+}
+
+// Save writes all live entries of the cache, packed and with their
+// absolute expiration time, so they can be restored using [Cached.Load].
+func (c *Cached) Save(w io.Writer) error {
+	var entries []*cachedEntry
+	var expires []time.Time
+
+	c.mu.Lock()
+	c.lru.ForEach(func(_ cachedKey, e *cachedEntry, _ int, expire time.Time) bool {
+		entries = append(entries, e)
+		expires = append(expires, expire)
+		return false
+	})
+	c.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	if _, err := buf.Write(cachedFileMagic[:]); err != nil {
+		return err
+	}
+
+	for i, e := range entries {
+		if err := writeCachedRecord(buf, e, expires[i]); err != nil {
+			return err
+		}
+	}
+
+	return buf.Flush()
+}
+
+func writeCachedRecord(w io.Writer, e *cachedEntry, expire time.Time) error {
+	b, err := e.msg.Pack()
+	if err != nil {
+		// skip
+		return nil
+	}
+
+	hdr := cachedRecordHeader{
+		Added:  e.added.UnixNano(),
+		Expire: expire.UnixNano(),
+		Len:    uint16(len(b)),
+	}
+
+	if err := binary.Write(w, binary.BigEndian, &hdr); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Load restores entries written by [Cached.Save], skipping
+// those already expired, and returns how many were restored.
+func (c *Cached) Load(r io.Reader) (int, error) {
+	var magic [4]byte
+	var count int
+
+	buf := bufio.NewReader(r)
+	if _, err := io.ReadFull(buf, magic[:]); err != nil {
+		return 0, err
+	} else if magic != cachedFileMagic {
+		return 0, core.Wrap(core.ErrInvalid, "unknown cache file format")
+	}
+
+	for {
+		key, e, expire, err := readCachedRecord(buf)
+		switch {
+		case err == io.EOF:
+			return count, nil
+		case err != nil:
+			return count, err
+		case e == nil || time.Now().After(expire):
+			// skip
+			continue
+		}
+
+		c.mu.Lock()
+		c.unsafeAdd(key, e, expire)
+		c.mu.Unlock()
+		count++
+	}
+}
+
+func readCachedRecord(r io.Reader) (cachedKey, *cachedEntry, time.Time, error) {
+	var hdr cachedRecordHeader
+
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return cachedKey{}, nil, time.Time{}, err
+	}
+
+	b := make([]byte, hdr.Len)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return cachedKey{}, nil, time.Time{}, core.Wrap(err, "truncated cache file")
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(b); err != nil {
+		// unusable, skip
+		return cachedKey{}, nil, time.Time{}, nil
+	}
+
+	q := msgQuestion(msg)
+	if q == nil {
+		// unusable, skip
+		return cachedKey{}, nil, time.Time{}, nil
+	}
+
+	e := &cachedEntry{
+		msg:   msg,
+		added: time.Unix(0, hdr.Added),
+	}
+	e.ttl, _ = exdns.MinTTL(msg)
+	return newCachedKey(q), e, time.Unix(0, hdr.Expire), nil
+}
+
+// SaveFile writes the cache to a file using [Cached.Save],
+// replacing it atomically.
+func (c *Cached) SaveFile(filename string) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), ".cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := c.Save(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// LoadFile restores the cache from a file written by [Cached.SaveFile].
+func (c *Cached) LoadFile(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return c.Load(f)
+}
@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+// CachePolicy describes how [Cached] treats the requests matching it.
+// Empty criteria match everything.
+type CachePolicy struct {
+	// Suffix restricts the policy to a domain and its subdomains.
+	Suffix string
+	// FirstLabel restricts the policy to names starting with
+	// the given label, i.e. "_acme-challenge".
+	FirstLabel string
+	// Types restricts the policy to the given query types.
+	Types []uint16
+
+	// Bypass disables the cache for matching requests.
+	Bypass bool
+	// MinTTL overrides [Cached.MinTTL] if not zero.
+	MinTTL uint32
+	// MaxTTL overrides [Cached.MaxTTL] if not zero.
+	MaxTTL uint32
+}
+
+// Match tells if the policy applies to a request.
+func (p *CachePolicy) Match(qName string, qType uint16) bool {
+	switch {
+	case len(p.Types) > 0 && !core.SliceContains(p.Types, qType):
+		return false
+	case p.Suffix != "" && !dns.IsSubDomain(p.Suffix, qName):
+		return false
+	case p.FirstLabel != "":
+		label, _, _ := strings.Cut(qName, ".")
+		return strings.EqualFold(label, p.FirstLabel)
+	default:
+		return true
+	}
+}
+
+// AddPolicy appends a [CachePolicy] to the [Cached] [Exchanger].
+// Policies are evaluated in order, and the first matching wins.
+func (c *Cached) AddPolicy(p CachePolicy) error {
+	if p.MaxTTL > 0 && p.MaxTTL < p.MinTTL {
+		return core.Wrap(core.ErrInvalid, "invalid TTL range")
+	}
+
+	if p.Suffix != "" {
+		p.Suffix = dns.CanonicalName(p.Suffix)
+	}
+	p.Types = core.SliceCopy(p.Types)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.policies = append(c.policies, p)
+	return nil
+}
+
+// getPolicy returns the policy applicable to a request, combined
+// with the defaults of the [Cached] [Exchanger].
+func (c *Cached) getPolicy(key cachedKey) CachePolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := CachePolicy{
+		MinTTL: c.MinTTL,
+		MaxTTL: c.MaxTTL,
+	}
+
+	for i := range c.policies {
+		p := &c.policies[i]
+		if p.Match(key.name, key.qType) {
+			out.Bypass = p.Bypass
+			out.MinTTL = core.IIf(p.MinTTL > 0, p.MinTTL, out.MinTTL)
+			out.MaxTTL = core.IIf(p.MaxTTL > 0, p.MaxTTL, out.MaxTTL)
+			break
+		}
+	}
+
+	return out
+}
+
+// clamp adjusts the TTLs of a response according to the policy.
+func (p *CachePolicy) clamp(resp *dns.Msg) *dns.Msg {
+	return clampMsgTTL(resp, p.MinTTL, p.MaxTTL)
+}
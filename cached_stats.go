@@ -0,0 +1,114 @@
+package resolver
+
+import (
+	"sync/atomic"
+
+	"darvaza.org/resolver/pkg/metrics"
+)
+
+// CachedEvent identifies what happened within a [Cached] [Exchanger].
+type CachedEvent int
+
+const (
+	// CachedHit indicates a request was answered from the cache.
+	CachedHit CachedEvent = iota
+	// CachedMiss indicates a request had to be passed upstream.
+	CachedMiss
+	// CachedInsert indicates a response was stored.
+	CachedInsert
+	// CachedEvict indicates an entry was removed.
+	CachedEvict
+	// CachedPrefetch indicates an entry is being refreshed in advance.
+	CachedPrefetch
+	// CachedError indicates the upstream [Exchanger] failed.
+	CachedError
+	// CachedStale indicates an expired response was served
+	// because the upstream [Exchanger] failed.
+	CachedStale
+)
+
+func (ev CachedEvent) String() string {
+	switch ev {
+	case CachedHit:
+		return "hit"
+	case CachedMiss:
+		return "miss"
+	case CachedInsert:
+		return "insert"
+	case CachedEvict:
+		return "evict"
+	case CachedPrefetch:
+		return "prefetch"
+	case CachedError:
+		return "error"
+	case CachedStale:
+		return "stale"
+	default:
+		return "unknown"
+	}
+}
+
+// CachedStats describes the state and activity of a [Cached] [Exchanger].
+type CachedStats struct {
+	// Entries is the number of responses cached.
+	Entries int
+	// Size is the usage of the cache, in bytes or entries.
+	Size int
+
+	Hits       uint64
+	Misses     uint64
+	Inserts    uint64
+	Evictions  uint64
+	Prefetches uint64
+	Errors     uint64
+	Stale      uint64
+}
+
+type cachedCounters [CachedStale + 1]atomic.Uint64
+
+// Stats returns the current [CachedStats] of the cache.
+func (c *Cached) Stats() CachedStats {
+	c.mu.Lock()
+	entries, size := c.lru.Len(), c.lru.Size()
+	c.mu.Unlock()
+
+	n := &c.counters
+	return CachedStats{
+		Entries:    entries,
+		Size:       size,
+		Hits:       n[CachedHit].Load(),
+		Misses:     n[CachedMiss].Load(),
+		Inserts:    n[CachedInsert].Load(),
+		Evictions:  n[CachedEvict].Load(),
+		Prefetches: n[CachedPrefetch].Load(),
+		Errors:     n[CachedError].Load(),
+		Stale:      n[CachedStale].Load(),
+	}
+}
+
+func (c *Cached) event(ev CachedEvent, key cachedKey) {
+	c.counters[ev].Add(1)
+	if fn := c.OnEvent; fn != nil {
+		fn(ev, key.name, key.qType)
+	}
+}
+
+// RegisterMetrics exports the [CachedStats] of the cache to a
+// [metrics.Registry], labelled with the given name.
+func (c *Cached) RegisterMetrics(reg *metrics.Registry, name string) {
+	events := reg.Counter("resolver_cache_events_total",
+		"Cache events by kind.", "cache", "event")
+	for ev := CachedHit; ev <= CachedStale; ev++ {
+		n := &c.counters[ev]
+		events.SetFunc(func() float64 { return float64(n.Load()) }, name, ev.String())
+	}
+
+	reg.Gauge("resolver_cache_entries", "Responses cached.", "cache").
+		SetFunc(func() float64 { return float64(c.Stats().Entries) }, name)
+
+	reg.Gauge("resolver_cache_hit_ratio", "Requests answered from the cache.", "cache").
+		SetFunc(func() float64 {
+			n := &c.counters
+			return metrics.Ratio(n[CachedHit].Load(), n[CachedMiss].Load())
+		}, name)
+}
@@ -0,0 +1,105 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+var (
+	_ CacheStore = CacheStoreFuncs{}
+)
+
+// CacheStore is an external key-value store [Cached] can use
+// as second tier, allowing many instances to share responses.
+type CacheStore interface {
+	// Get returns the value stored for a key, or nil if
+	// there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores a value for the given time.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// CacheStoreFuncs is an adapter to use a pair of functions,
+// like those of a Redis or memcached client, as [CacheStore].
+type CacheStoreFuncs struct {
+	GetFunc func(ctx context.Context, key string) ([]byte, error)
+	SetFunc func(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// Get calls GetFunc if set.
+func (f CacheStoreFuncs) Get(ctx context.Context, key string) ([]byte, error) {
+	if f.GetFunc == nil {
+		return nil, nil
+	}
+	return f.GetFunc(ctx, key)
+}
+
+// Set calls SetFunc if set.
+func (f CacheStoreFuncs) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if f.SetFunc == nil {
+		return nil
+	}
+	return f.SetFunc(ctx, key, value, ttl)
+}
+
+// SetStore attaches an external [CacheStore] to the [Cached].
+// Local misses are looked up on it before going upstream,
+// and new responses are written to it. Failures of the store
+// are ignored.
+func (c *Cached) SetStore(store CacheStore) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.shared = store
+}
+
+func (c *Cached) getStore() CacheStore {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.shared
+}
+
+// loadShared attempts to get a response from the external store,
+// adding it to the local cache if found.
+func (c *Cached) loadShared(ctx context.Context, key cachedKey) bool {
+	store := c.getStore()
+	if store == nil {
+		return false
+	}
+
+	b, err := store.Get(ctx, key.String())
+	if err != nil || len(b) == 0 {
+		return false
+	}
+
+	k2, e, expire, err := readCachedRecord(bytes.NewReader(b))
+	switch {
+	case err != nil, e == nil, k2 != key, time.Now().After(expire):
+		// unusable
+		return false
+	}
+
+	c.mu.Lock()
+	c.unsafeAdd(key, e, expire)
+	c.mu.Unlock()
+	return true
+}
+
+// saveShared writes an entry to the external store.
+func (c *Cached) saveShared(ctx context.Context, key cachedKey, e *cachedEntry) {
+	store := c.getStore()
+	if store == nil || e == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+
+	ttl := time.Duration(e.ttl) * time.Second
+	if err := writeCachedRecord(&buf, e, e.added.Add(ttl)); err != nil || buf.Len() == 0 {
+		return
+	}
+
+	_ = store.Set(ctx, key.String(), buf.Bytes(), ttl)
+}
@@ -0,0 +1,629 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/metrics"
+)
+
+func newTestCachedUpstream(calls *int, ttl uint32) ExchangerFunc {
+	return func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		*calls++
+
+		q := req.Question[0]
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		if q.Qtype == dns.TypeA {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   net.IPv4(192, 0, 2, 1),
+			})
+		}
+		return resp, nil
+	}
+}
+
+func TestCached(t *testing.T) {
+	var calls int
+
+	c, err := NewCachedExchanger(newTestCachedUpstream(&calls, 300), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, qName := range []string{"example.org.", "EXAMPLE.org.", "example.org."} {
+		req := new(dns.Msg)
+		req.SetQuestion(qName, dns.TypeA)
+
+		resp, err := c.Exchange(ctx, req)
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case resp.Id != req.Id:
+			t.Errorf("%s: ID not restored", qName)
+		case resp.Question[0].Name != qName:
+			t.Errorf("%s: question not restored: %q", qName, resp.Question[0].Name)
+		case len(resp.Answer) != 1:
+			t.Errorf("%s: unexpected answer: %v", qName, resp.Answer)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected one upstream query, got %v", calls)
+	}
+
+	tctx, trace := WithQueryTrace(ctx)
+	if _, err := c.Lookup(tctx, "example.org.", dns.TypeA); err != nil || !trace.CacheHit() {
+		t.Errorf("cache hit not traced: %v", err)
+	}
+
+	// empty answers aren't cached
+	for i := 0; i < 2; i++ {
+		_, _ = c.Lookup(ctx, "example.org.", dns.TypeAAAA)
+	}
+	if calls != 3 {
+		t.Errorf("expected NODATA not to be cached, calls:%v", calls)
+	}
+}
+
+func TestCachedTTL(t *testing.T) {
+	var calls int
+
+	c, err := NewCachedExchanger(newTestCachedUpstream(&calls, 300), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := c.Lookup(ctx, "example.org.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+
+	// pretend it was cached 100s ago
+	e, _, _ := c.lru.Get(cachedKey{"example.org.", dns.TypeA, dns.ClassINET})
+	e.added = e.added.Add(-100 * time.Second)
+
+	resp, err := c.Lookup(ctx, "example.org.", dns.TypeA)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case resp.Answer[0].Header().Ttl != 200:
+		t.Errorf("expected TTL 200, got %v", resp.Answer[0].Header().Ttl)
+	case e.msg.Answer[0].Header().Ttl != 300:
+		t.Error("cached response modified")
+	}
+
+	// zero TTL isn't cached
+	c2, _ := NewCachedExchanger(newTestCachedUpstream(&calls, 0), 0)
+	calls = 0
+	for i := 0; i < 2; i++ {
+		_, _ = c2.Lookup(ctx, "example.org.", dns.TypeA)
+	}
+	if calls != 2 {
+		t.Errorf("expected zero TTL not to be cached, calls:%v", calls)
+	}
+}
+
+func TestCachedPrefetch(t *testing.T) {
+	var calls atomic.Int32
+
+	next := ExchangerFunc(func(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+		var n int
+		calls.Add(1)
+		return newTestCachedUpstream(&n, 300)(ctx, req)
+	})
+
+	c, err := NewCachedExchanger(next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PrefetchHits = 2
+
+	ctx := context.Background()
+	key := cachedKey{"example.org.", dns.TypeA, dns.ClassINET}
+	age := func(d time.Duration) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		e, _, _ := c.lru.Get(key)
+		e.added = e.added.Add(-d)
+	}
+
+	_, _ = c.Lookup(ctx, "example.org.", dns.TypeA)
+	_, _ = c.Lookup(ctx, "example.org.", dns.TypeA)
+	_, _ = c.Lookup(ctx, "example.org.", dns.TypeA)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("unexpected prefetch of fresh entry, calls:%v", n)
+	}
+
+	// popular and about to expire
+	age(280 * time.Second)
+	_, _ = c.Lookup(ctx, "example.org.", dns.TypeA)
+
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected a prefetch, calls:%v", n)
+	}
+}
+
+func TestCachedPrefetchFailed(t *testing.T) {
+	var calls atomic.Int32
+
+	// only the first exchange succeeds
+	next := ExchangerFunc(func(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+		var n int
+		if calls.Add(1) > 1 {
+			return nil, errors.ErrTimeout(req.Question[0].Name, nil)
+		}
+		return newTestCachedUpstream(&n, 300)(ctx, req)
+	})
+
+	c, err := NewCachedExchanger(next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PrefetchHits = 1
+
+	ctx := context.Background()
+	key := cachedKey{"example.org.", dns.TypeA, dns.ClassINET}
+	prefetching := func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		e, _, _ := c.lru.Get(key)
+		return e.prefetching
+	}
+
+	_, _ = c.Lookup(ctx, "example.org.", dns.TypeA)
+	c.mu.Lock()
+	e, _, _ := c.lru.Get(key)
+	e.added = e.added.Add(-280 * time.Second)
+	c.mu.Unlock()
+
+	for i := int32(2); i <= 3; i++ {
+		_, _ = c.Lookup(ctx, "example.org.", dns.TypeA)
+
+		deadline := time.Now().Add(time.Second)
+		for (calls.Load() < i || prefetching()) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+
+		if n := calls.Load(); n != i {
+			t.Fatalf("expected a new prefetch after a failed one, calls:%v", n)
+		}
+	}
+}
+
+func TestCachedFlush(t *testing.T) {
+	var calls int
+
+	c, err := NewCachedExchanger(newTestCachedUpstream(&calls, 300), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fill := func() {
+		for _, qName := range []string{
+			"example.org.", "www.example.org.", "a.b.example.org.", "example.net.",
+		} {
+			_, _ = c.Lookup(context.Background(), qName, dns.TypeA)
+		}
+	}
+
+	fill()
+	if n := c.FlushName("WWW.example.org"); n != 1 {
+		t.Errorf("FlushName: expected 1, got %v", n)
+	}
+
+	fill()
+	if n := c.FlushTree("example.org"); n != 3 {
+		t.Errorf("FlushTree: expected 3, got %v", n)
+	}
+
+	fill()
+	if n := c.Flush(); n != 4 {
+		t.Errorf("Flush: expected 4, got %v", n)
+	}
+}
+
+func TestCachedPersistence(t *testing.T) {
+	var calls int
+
+	c, err := NewCachedExchanger(newTestCachedUpstream(&calls, 300), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, qName := range []string{"example.org.", "example.net."} {
+		_, _ = c.Lookup(context.Background(), qName, dns.TypeA)
+	}
+
+	filename := filepath.Join(t.TempDir(), "cache.bin")
+	if err := c.SaveFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	c2, _ := NewCachedExchanger(newTestCachedUpstream(&calls, 300), 0)
+	n, err := c2.LoadFile(filename)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case n != 2:
+		t.Fatalf("expected 2 entries, got %v", n)
+	}
+
+	calls = 0
+	resp, err := c2.Lookup(context.Background(), "example.net.", dns.TypeA)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case calls != 0:
+		t.Error("restored entry not used")
+	case len(resp.Answer) != 1:
+		t.Errorf("unexpected answer: %v", resp.Answer)
+	}
+
+	if _, err := c2.Load(strings.NewReader("garbage")); err == nil {
+		t.Error("invalid file accepted")
+	}
+}
+
+func TestCachedBytes(t *testing.T) {
+	var calls, evicted int
+
+	next := newTestCachedUpstream(&calls, 300)
+
+	// measure one response
+	req := new(dns.Msg)
+	req.SetQuestion("a.example.org.", dns.TypeA)
+	resp, _ := next(context.Background(), req)
+	size := resp.Len()
+
+	c, err := NewCachedExchangerBytes(next, 3*size+size/2, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.OnEvict = func(string, uint16, int) { evicted++ }
+
+	for _, qName := range []string{"a.example.org.", "b.example.org.", "c.example.org."} {
+		_, _ = c.Lookup(context.Background(), qName, dns.TypeA)
+	}
+	if n := c.Size(); n != 3*size {
+		t.Fatalf("expected %v bytes, got %v", 3*size, n)
+	}
+
+	// over the high watermark
+	_, _ = c.Lookup(context.Background(), "d.example.org.", dns.TypeA)
+	switch {
+	case c.Size() > size:
+		t.Errorf("expected at most %v bytes, got %v", size, c.Size())
+	case c.Len() != 1:
+		t.Errorf("expected one entry, got %v", c.Len())
+	case evicted != 3:
+		t.Errorf("expected 3 evictions, got %v", evicted)
+	}
+
+	if _, err := NewCachedExchangerBytes(next, 10, 20); err == nil {
+		t.Error("inverted watermarks accepted")
+	}
+}
+
+func TestCachedStats(t *testing.T) {
+	var calls int
+	var down bool
+	events := make(map[CachedEvent]int)
+
+	next := newTestCachedUpstream(&calls, 300)
+	c, err := NewCachedExchanger(ExchangerFunc(func(ctx context.Context,
+		req *dns.Msg) (*dns.Msg, error) {
+		if name := req.Question[0].Name; down || name == "fail.example." {
+			return nil, errors.ErrTimeout(name, nil)
+		}
+		return next(ctx, req)
+	}), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.OnEvent = func(ev CachedEvent, _ string, _ uint16) { events[ev]++ }
+	c.ServeStale = time.Hour
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, _ = c.Lookup(ctx, "example.org.", dns.TypeA)
+	}
+	_, _ = c.Lookup(ctx, "fail.example.", dns.TypeA)
+
+	// expired while the upstream is down
+	e, _, _ := c.lru.Get(cachedKey{"example.org.", dns.TypeA, dns.ClassINET})
+	e.added = e.added.Add(-400 * time.Second)
+	down = true
+
+	resp, err := c.Lookup(ctx, "example.org.", dns.TypeA)
+	switch {
+	case err != nil:
+		t.Errorf("stale response not served: %v", err)
+	case resp.Answer[0].Header().Ttl != DefaultCachedStaleTTL:
+		t.Errorf("stale response served with TTL %v", resp.Answer[0].Header().Ttl)
+	}
+
+	// too old to be served
+	e.added = e.added.Add(-time.Hour)
+	if _, err := c.Lookup(ctx, "example.org.", dns.TypeA); err == nil {
+		t.Error("stale response served past the ServeStale window")
+	}
+	c.Flush()
+
+	expected := CachedStats{Hits: 2, Misses: 4, Inserts: 1, Evictions: 1, Errors: 3, Stale: 1}
+	if stats := c.Stats(); stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	if events[CachedHit] != 2 || events[CachedError] != 3 || events[CachedStale] != 1 {
+		t.Errorf("unexpected events: %v", events)
+	}
+
+	reg := metrics.NewRegistry()
+	c.RegisterMetrics(reg, "test")
+	snapshot := reg.Snapshot()
+	if v := snapshot["resolver_cache_hit_ratio"][`{cache="test"}`]; v != 1.0/3 {
+		t.Errorf("unexpected hit ratio %v", v)
+	}
+	if v := snapshot["resolver_cache_events_total"][`{cache="test",event="stale"}`]; v != 1.0 {
+		t.Errorf("unexpected stale responses %v", v)
+	}
+}
+
+func TestCachedPolicy(t *testing.T) {
+	var calls int
+
+	c, err := NewCachedExchanger(newTestCachedUpstream(&calls, 300), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []CachePolicy{
+		{Types: []uint16{dns.TypeANY}, Bypass: true},
+		{Suffix: "corp.internal", Bypass: true},
+		{FirstLabel: "_acme-challenge", MaxTTL: 5},
+	} {
+		if err := c.AddPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		qName string
+		qType uint16
+		calls int
+		ttl   uint32
+	}{
+		{"example.org.", dns.TypeA, 1, 300},
+		{"example.org.", dns.TypeANY, 2, 0},
+		{"www.corp.internal.", dns.TypeA, 2, 300},
+		{"_acme-challenge.example.org.", dns.TypeA, 1, 5},
+	}
+
+	for _, tc := range tests {
+		calls = 0
+		for i := 0; i < 2; i++ {
+			resp, err := c.Lookup(context.Background(), tc.qName, tc.qType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Answer) > 0 && resp.Answer[0].Header().Ttl != tc.ttl {
+				t.Errorf("%s: expected TTL %v, got %v", tc.qName, tc.ttl, resp.Answer[0].Header().Ttl)
+			}
+		}
+
+		if calls != tc.calls {
+			t.Errorf("%s/%s: expected %v upstream queries, got %v",
+				tc.qName, dns.TypeToString[tc.qType], tc.calls, calls)
+		}
+	}
+}
+
+func TestCachedCoalescing(t *testing.T) {
+	var calls atomic.Int32
+
+	release := make(chan struct{})
+	next := ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		calls.Add(1)
+		<-release
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		return resp, nil
+	})
+
+	c, err := NewCachedExchanger(next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := new(dns.Msg)
+			req.SetQuestion("example.org.", dns.TypeA)
+			resp, err := c.Exchange(context.Background(), req)
+			switch {
+			case err != nil:
+				errs <- err
+			case resp.Id != req.Id, len(resp.Answer) != 1:
+				errs <- fmt.Errorf("unexpected response: %v", resp)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected one upstream query, got %v", got)
+	}
+}
+
+func TestCachedCoalescingCancel(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	next := ExchangerFunc(func(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+		close(started)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		return resp, nil
+	})
+
+	c, err := NewCachedExchanger(next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the leader gives up while the exchange is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := c.Lookup(ctx, "example.org.", dns.TypeA)
+		leader <- err
+	}()
+	<-started
+
+	waiter := make(chan *dns.Msg, 1)
+	go func() {
+		resp, err := c.Lookup(context.Background(), "example.org.", dns.TypeA)
+		if err != nil {
+			t.Error(err)
+		}
+		waiter <- resp
+	}()
+
+	for c.g.Stats().Coalesced == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-leader; err == nil {
+		t.Error("cancelled leader got an answer")
+	}
+
+	close(release)
+	if resp := <-waiter; resp == nil || len(resp.Answer) != 1 {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestCachedAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	seen := make(chan string, 1)
+	next := ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		<-release
+		seen <- req.Question[0].Name
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, nil
+	})
+
+	c, err := NewCachedExchanger(next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller gives up, and reuses its request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	if _, err := c.Exchange(ctx, req); err == nil {
+		t.Fatal("abandoned exchange answered")
+	}
+	req.Question[0].Name = "example.net."
+
+	close(release)
+	if name := <-seen; name != "example.org." {
+		t.Errorf("abandoned exchange saw %q", name)
+	}
+}
+
+func TestCachedStore(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+
+	m := make(map[string][]byte)
+	store := CacheStoreFuncs{
+		GetFunc: func(_ context.Context, key string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return m[key], nil
+		},
+		SetFunc: func(_ context.Context, key string, value []byte, _ time.Duration) error {
+			mu.Lock()
+			defer mu.Unlock()
+			m[key] = value
+			return nil
+		},
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		c, err := NewCachedExchanger(newTestCachedUpstream(&calls, 300), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetStore(store)
+
+		resp, err := c.Lookup(ctx, "example.org.", dns.TypeA)
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case len(resp.Answer) != 1:
+			t.Errorf("unexpected answer: %v", resp.Answer)
+		case c.Len() != 1:
+			t.Errorf("response not cached locally")
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected one upstream query, got %v", calls)
+	}
+}
@@ -0,0 +1,143 @@
+package resolver
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*ChainLookuper)(nil)
+	_ Exchanger = (*ChainLookuper)(nil)
+)
+
+// ChainCondition is a set of outcomes after which a [ChainLookuper]
+// moves on to the next step.
+type ChainCondition uint
+
+const (
+	// ChainOnNXDOMAIN advances when the name doesn't exist.
+	ChainOnNXDOMAIN ChainCondition = 1 << iota
+	// ChainOnNODATA advances when the name has no records of the
+	// requested type.
+	ChainOnNODATA
+	// ChainOnSERVFAIL advances on SERVFAIL, REFUSED and any other
+	// failure but timeouts.
+	ChainOnSERVFAIL
+	// ChainOnTimeout advances on timeouts.
+	ChainOnTimeout
+
+	// ChainOnError advances on any failure, but not on
+	// negative answers.
+	ChainOnError = ChainOnSERVFAIL | ChainOnTimeout
+	// ChainOnAll advances on anything but a positive answer.
+	ChainOnAll = ChainOnNXDOMAIN | ChainOnNODATA | ChainOnError
+)
+
+// ChainLookuper tries a list of [Lookuper]s in order, moving on to the
+// next after the outcomes given by its [ChainCondition], to express
+// pipelines like "hosts file, then cache, then forwarders, then iterate".
+// The result of the last step is returned as-is.
+type ChainLookuper struct {
+	steps   []Exchanger
+	advance ChainCondition
+}
+
+// Lookup performs a lookup using the steps in order.
+func (r *ChainLookuper) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return r.Exchange(ctx, req)
+}
+
+// Exchange passes the request to each step in order, until one
+// gives an outcome not listed in the [ChainCondition].
+func (r *ChainLookuper) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	var resp *dns.Msg
+	var err error
+
+	if ctx == nil || req == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	for i, h := range r.steps {
+		resp, err = h.Exchange(ctx, req)
+		if i == len(r.steps)-1 || ctx.Err() != nil {
+			break
+		}
+
+		if r.advance&chainOutcome(resp, err) == 0 {
+			break
+		}
+	}
+
+	return resp, err
+}
+
+// chainOutcome classifies the result of an exchange, returning zero
+// for positive answers.
+func chainOutcome(resp *dns.Msg, err error) ChainCondition {
+	if err != nil {
+		return chainErrorOutcome(err)
+	}
+
+	switch {
+	case resp == nil:
+		return ChainOnSERVFAIL
+	case resp.Rcode == dns.RcodeNameError:
+		return ChainOnNXDOMAIN
+	case resp.Rcode != dns.RcodeSuccess:
+		return ChainOnSERVFAIL
+	case len(resp.Answer) == 0:
+		return ChainOnNODATA
+	default:
+		return 0
+	}
+}
+
+func chainErrorOutcome(err error) ChainCondition {
+	e, _ := err.(*net.DNSError)
+	switch {
+	case e != nil && e.Err == errors.NODATA:
+		return ChainOnNODATA
+	case errors.IsNotFound(err):
+		return ChainOnNXDOMAIN
+	case errors.IsTimeout(err):
+		return ChainOnTimeout
+	default:
+		return ChainOnSERVFAIL
+	}
+}
+
+// NewChainLookuper creates a [ChainLookuper] trying the given
+// [Lookuper]s in order, advancing after the given outcomes.
+// [Lookuper]s that don't implement [Exchanger] are only given
+// the name and type of the request.
+func NewChainLookuper(advance ChainCondition, steps ...Lookuper) (*ChainLookuper, error) {
+	if len(steps) == 0 || advance == 0 {
+		return nil, core.ErrInvalid
+	}
+
+	r := &ChainLookuper{
+		steps:   make([]Exchanger, 0, len(steps)),
+		advance: advance,
+	}
+
+	for _, h := range steps {
+		switch v := h.(type) {
+		case nil:
+			return nil, core.Wrap(core.ErrInvalid, "nil step")
+		case Exchanger:
+			r.steps = append(r.steps, v)
+		default:
+			r.steps = append(r.steps, LookuperFunc(h.Lookup))
+		}
+	}
+
+	return r, nil
+}
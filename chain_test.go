@@ -0,0 +1,66 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func newTestChainStep(name string, calls *[]string, err error) Lookuper {
+	return ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		*calls = append(*calls, name)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		return resp, nil
+	})
+}
+
+func TestChainLookuper(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance ChainCondition
+		err     error
+		calls   int
+		ok      bool
+	}{
+		{"nxdomain", ChainOnNXDOMAIN, errors.ErrNotFound("example.org."), 2, true},
+		{"nxdomain-final", ChainOnError, errors.ErrNotFound("example.org."), 1, false},
+		{"nodata", ChainOnNODATA, errors.ErrTypeNotFound("example.org."), 2, true},
+		{"timeout", ChainOnTimeout, errors.ErrTimeout("example.org.", nil), 2, true},
+		{"servfail", ChainOnTimeout, errors.ErrRefused("example.org."), 1, false},
+		{"success", ChainOnAll, nil, 1, true},
+	}
+
+	for _, tc := range tests {
+		var calls []string
+
+		r, err := NewChainLookuper(tc.advance,
+			newTestChainStep("first", &calls, tc.err),
+			newTestChainStep("second", &calls, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = r.Lookup(context.Background(), "example.org.", dns.TypeA)
+		switch {
+		case len(calls) != tc.calls:
+			t.Errorf("%s: unexpected steps: %v", tc.name, calls)
+		case tc.ok && err != nil:
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		case !tc.ok && err == nil:
+			t.Errorf("%s: error expected", tc.name)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package resolver
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*CNAMEFlattener)(nil)
+	_ Exchanger = (*CNAMEFlattener)(nil)
+)
+
+// MaxCNAMEChain is the longest CNAME chain a [CNAMEFlattener]
+// follows before failing.
+const MaxCNAMEChain = 8
+
+// CNAMEFlattener is an [Exchanger] middleware resolving the CNAME
+// chains found answering A and AAAA queries, and returning only the
+// terminal addresses under the name asked, with the lowest TTL of the
+// chain. This allows serving "ALIAS" records, like on the apex of
+// a zone, from authoritative data.
+type CNAMEFlattener struct {
+	next Exchanger
+
+	// Targets, if set, resolves the targets of the CNAME records
+	// instead of the next [Exchanger], like a recursive resolver
+	// when the next is authoritative.
+	Targets Exchanger
+}
+
+// Lookup implements the [Lookuper] interface.
+func (f *CNAMEFlattener) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return f.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface, flattening
+// the CNAME chains of A and AAAA answers.
+func (f *CNAMEFlattener) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if ctx == nil || req == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	resp, err := f.next.Exchange(ctx, req)
+	if err != nil || resp == nil || resp.Rcode != dns.RcodeSuccess ||
+		len(req.Question) != 1 {
+		return resp, err
+	}
+
+	q := req.Question[0]
+	switch {
+	case q.Qclass != dns.ClassINET:
+		return resp, nil
+	case q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA:
+		return resp, nil
+	case findCNAME(resp.Answer, q.Name) == nil:
+		// nothing to flatten
+		return resp, nil
+	default:
+		return f.flatten(ctx, req, resp)
+	}
+}
+
+func (f *CNAMEFlattener) flatten(ctx context.Context, req, resp *dns.Msg) (*dns.Msg, error) {
+	var asked string
+	var err error
+
+	q := req.Question[0]
+	name, ttl := q.Name, uint32(math.MaxUint32)
+	msg := resp
+
+	for hops := 0; ; {
+		if cname := findCNAME(msg.Answer, name); cname != nil {
+			// follow the chain
+			if hops++; hops > MaxCNAMEChain {
+				return nil, errors.ErrInternalError(q.Name, "")
+			}
+			ttl = min(ttl, cname.Hdr.Ttl)
+			name = cname.Target
+			continue
+		}
+
+		addrs := findAddrs(msg.Answer, name, q.Qtype)
+		if len(addrs) > 0 || strings.EqualFold(asked, name) {
+			return flattenedReply(req, resp, addrs, ttl), nil
+		}
+
+		// continue outside the response
+		asked = name
+		msg, err = f.resolveTarget(ctx, req, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *CNAMEFlattener) resolveTarget(ctx context.Context, req *dns.Msg, name string) (*dns.Msg, error) {
+	e := core.Coalesce(f.Targets, f.next)
+
+	req2 := exdns.NewRequestFromParts(name, dns.ClassINET, req.Question[0].Qtype)
+	req2.RecursionDesired = req.RecursionDesired || f.Targets != nil
+
+	msg, err := e.Exchange(ctx, req2)
+	switch {
+	case errors.IsNotFound(err):
+		// the alias exists regardless, NODATA
+		return new(dns.Msg), nil
+	case err != nil:
+		return nil, err
+	case msg == nil:
+		return nil, errors.ErrBadResponse()
+	case msg.Rcode == dns.RcodeNameError:
+		return new(dns.Msg), nil
+	case msg.Rcode != dns.RcodeSuccess:
+		return nil, errors.MsgAsError(msg)
+	default:
+		return msg, nil
+	}
+}
+
+func findCNAME(records []dns.RR, name string) *dns.CNAME {
+	for _, rr := range records {
+		if v, ok := rr.(*dns.CNAME); ok && strings.EqualFold(v.Hdr.Name, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func findAddrs(records []dns.RR, name string, qType uint16) []dns.RR {
+	var out []dns.RR
+	for _, rr := range records {
+		hdr := rr.Header()
+		if hdr.Rrtype == qType && strings.EqualFold(hdr.Name, name) {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// flattenedReply assembles the response to the request using the
+// terminal records, renamed and with the lowest TTL of the chain.
+func flattenedReply(req, resp *dns.Msg, addrs []dns.RR, ttl uint32) *dns.Msg {
+	out := resp.Copy()
+	out.Id = req.Id
+	out.Answer = make([]dns.RR, 0, len(addrs))
+	out.Extra = nil
+
+	for _, rr := range addrs {
+		rr = dns.Copy(rr)
+		hdr := rr.Header()
+		hdr.Name = req.Question[0].Name
+		hdr.Ttl = min(hdr.Ttl, ttl)
+		out.Answer = append(out.Answer, rr)
+	}
+	return out
+}
+
+// NewCNAMEFlattener creates a [CNAMEFlattener] middleware flattening
+// the CNAME chains of the responses of the given [Exchanger].
+func NewCNAMEFlattener(next Exchanger) (*CNAMEFlattener, error) {
+	if next == nil {
+		return nil, core.ErrInvalid
+	}
+	return &CNAMEFlattener{next: next}, nil
+}
@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCNAMEFlattener(t *testing.T) {
+	z := newTestZone(t)
+	for _, s := range []string{
+		"apex.example.org. 300 IN CNAME alias.example.org.",
+		"out.example.org. 120 IN CNAME cdn.example.net.",
+		"gone.example.org. 120 IN CNAME missing.example.net.",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Add(rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := NewCNAMEFlattener(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var targets []string
+	f.Targets = ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		q := req.Question[0]
+		targets = append(targets, q.Name)
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		if q.Name != "cdn.example.net." {
+			resp.Rcode = dns.RcodeNameError
+			return resp, nil
+		}
+
+		rr, _ := dns.NewRR("cdn.example.net. 60 IN A 198.51.100.1")
+		resp.Answer = []dns.RR{rr}
+		return resp, nil
+	})
+
+	tests := []struct {
+		name   string
+		qType  uint16
+		rrType uint16
+		count  int
+		ttl    uint32
+	}{
+		{"www.example.org", dns.TypeA, dns.TypeA, 1, 3600},
+		{"alias.example.org", dns.TypeA, dns.TypeA, 1, 3600},
+		{"apex.example.org", dns.TypeA, dns.TypeA, 1, 300},
+		{"out.example.org", dns.TypeA, dns.TypeA, 1, 60},
+		{"gone.example.org", dns.TypeA, 0, 0, 0},
+		{"alias.example.org", dns.TypeAAAA, 0, 0, 0},
+		{"alias.example.org", dns.TypeTXT, dns.TypeCNAME, 1, 3600},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, tc := range tests {
+		qName := dns.Fqdn(tc.name)
+		msg, err := f.Lookup(ctx, qName, tc.qType)
+		if err != nil {
+			t.Errorf("%s/%s: %v", tc.name, dns.TypeToString[tc.qType], err)
+			continue
+		}
+
+		if len(msg.Answer) != tc.count {
+			t.Errorf("%s/%s: unexpected answer: %v",
+				tc.name, dns.TypeToString[tc.qType], msg.Answer)
+			continue
+		}
+
+		for _, rr := range msg.Answer {
+			hdr := rr.Header()
+			if hdr.Name != qName || hdr.Rrtype != tc.rrType || hdr.Ttl != tc.ttl {
+				t.Errorf("%s/%s: unexpected record: %v",
+					tc.name, dns.TypeToString[tc.qType], rr)
+			}
+		}
+	}
+
+	// www.example.org has no AAAA in the zone
+	if len(targets) != 3 || targets[0] != "cdn.example.net." ||
+		targets[1] != "missing.example.net." || targets[2] != "www.example.org." {
+		t.Errorf("unexpected targets resolved: %v", targets)
+	}
+
+	if _, err := NewCNAMEFlattener(nil); err == nil {
+		t.Error("nil exchanger accepted")
+	}
+}
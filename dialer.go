@@ -0,0 +1,179 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// DefaultFallbackDelay is how long [Dialer] waits for a connection
+// attempt before starting the next one if not specified, as
+// recommended by RFC 8305.
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+// Dialer establishes TCP and UDP connections resolving host names
+// with the given [Resolver], and racing the addresses following the
+// Happy Eyeballs algorithm, RFC 8305. Addresses are tried in the
+// order given by the [Resolver], alternating address families.
+type Dialer struct {
+	r Resolver
+
+	// NetDialer, if set, makes the connections.
+	NetDialer *net.Dialer
+
+	// FallbackDelay is how long to wait for a connection attempt
+	// before starting the next one in parallel.
+	// [DefaultFallbackDelay] is used if zero.
+	FallbackDelay time.Duration
+}
+
+// Dial connects to the address on the named network.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network, resolving
+// the host using the [Resolver]. Networks other than TCP and UDP are
+// passed as-is to the NetDialer.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	family, ok := dialerFamily(network)
+	if !ok {
+		return d.netDialer().DialContext(ctx, network, address)
+	}
+
+	host, service, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		// literal address
+		return d.netDialer().DialContext(ctx, network, address)
+	}
+
+	port, err := net.DefaultResolver.LookupPort(ctx, network, service)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := d.r.LookupNetIP(ctx, family, host)
+	switch {
+	case err != nil:
+		return nil, err
+	case len(addrs) == 0:
+		return nil, errors.ErrNotFound(host)
+	}
+
+	return d.dialParallel(ctx, network, interleaveAddrs(addrs), uint16(port))
+}
+
+func (d *Dialer) netDialer() *net.Dialer {
+	if d.NetDialer != nil {
+		return d.NetDialer
+	}
+	return &net.Dialer{}
+}
+
+func dialerFamily(network string) (string, bool) {
+	switch network {
+	case "tcp", "udp":
+		return "ip", true
+	case "tcp4", "udp4":
+		return "ip4", true
+	case "tcp6", "udp6":
+		return "ip6", true
+	default:
+		return "", false
+	}
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialParallel starts a connection attempt to each address in turn,
+// every FallbackDelay or as soon as the previous fails, and returns
+// the first to succeed.
+func (d *Dialer) dialParallel(ctx context.Context, network string,
+	addrs []netip.Addr, port uint16) (net.Conn, error) {
+	//
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	delay := d.FallbackDelay
+	if delay <= 0 {
+		delay = DefaultFallbackDelay
+	}
+
+	nd := d.netDialer()
+	results := make(chan dialResult, len(addrs))
+
+	var next, pending int
+	var timer *time.Timer
+	var firstErr error
+
+	start := func() {
+		address := netip.AddrPortFrom(addrs[next], port).String()
+		next++
+		pending++
+		go func() {
+			conn, err := nd.DialContext(ctx, network, address)
+			results <- dialResult{conn, err}
+		}()
+
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.NewTimer(delay)
+	}
+
+	start()
+	defer func() { timer.Stop() }()
+
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				go closeDialResults(results, pending)
+				return res.conn, nil
+			}
+
+			firstErr = core.Coalesce(firstErr, res.err)
+			if next < len(addrs) {
+				start()
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+			}
+		}
+	}
+
+	return nil, firstErr
+}
+
+// closeDialResults closes the connections established by
+// attempts that lost the race.
+func closeDialResults(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		if res := <-results; res.conn != nil {
+			_ = res.conn.Close()
+		}
+	}
+}
+
+// NewDialer creates a [Dialer] resolving names with the given [Resolver].
+func NewDialer(r Resolver) (*Dialer, error) {
+	if r == nil {
+		return nil, core.Wrap(core.ErrInvalid, "resolver required")
+	}
+	return &Dialer{r: r}, nil
+}
@@ -0,0 +1,64 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialer(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	// nobody listens on 127.0.0.2
+	r := newTestZoneResolver(t,
+		"dial.example.org. 60 IN A 127.0.0.2",
+		"dial.example.org. 60 IN A 127.0.0.1",
+	)
+	r.AddressOrder = AddressOrderNone
+
+	d, err := NewDialer(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.FallbackDelay = 10 * time.Millisecond
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, host := range []string{"dial.example.org", "127.0.0.1"} {
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+
+		if s := conn.RemoteAddr().String(); s != l.Addr().String() {
+			t.Errorf("%s: connected to %s", host, s)
+		}
+		_ = conn.Close()
+	}
+
+	if _, err := d.DialContext(ctx, "tcp", "missing.example.org:"+port); err == nil {
+		t.Error("dialing a missing host succeeded")
+	}
+
+	if _, err := NewDialer(nil); err == nil {
+		t.Error("nil resolver accepted")
+	}
+}
@@ -0,0 +1,239 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*DiscoveryLookuper)(nil)
+	_ Exchanger = (*DiscoveryLookuper)(nil)
+)
+
+// DefaultDiscoveryTTL is the TTL of the records answered by a
+// [DiscoveryLookuper] if not specified.
+const DefaultDiscoveryTTL = 5
+
+// ServiceDiscovery is implemented by service-discovery backends
+// providing the services a [DiscoveryLookuper] answers for. Names
+// existing only because there are services under them, the empty
+// non-terminals, are described by an empty [Service].
+type ServiceDiscovery interface {
+	// LookupService returns the [Service] owning the given
+	// canonical name, or a not-found error if none does.
+	LookupService(ctx context.Context, qName string) (*Service, error)
+}
+
+// Service describes a name provided by a [ServiceDiscovery].
+type Service struct {
+	// Name is the canonical name of the service.
+	Name string
+	// Alias, if set, makes the name a CNAME of it.
+	Alias string
+	// Addrs are the addresses answering A and AAAA queries.
+	Addrs []netip.Addr
+	// Ports are the ports answering SRV queries.
+	Ports []ServicePort
+}
+
+// ServicePort describes a port of a [Service].
+type ServicePort struct {
+	// Name is the name of the port, like "http".
+	Name string
+	// Protocol is the transport protocol, like "tcp".
+	Protocol string
+	// Port is the port number.
+	Port uint16
+	// Target is the host providing the port,
+	// the service itself if empty.
+	Target string
+}
+
+// Matches tells if the [ServicePort] is the one named on
+// the labels of a SRV name, like "_http._tcp".
+func (sp *ServicePort) Matches(name, proto string) bool {
+	return strings.EqualFold("_"+sp.Name, name) &&
+		strings.EqualFold("_"+sp.Protocol, proto)
+}
+
+// DiscoveryLookuper answers authoritatively for the names under
+// a domain using a [ServiceDiscovery] backend. A and AAAA queries
+// are answered with the addresses of the service, and SRV queries
+// with its ports, all of them or those named like
+// "_http._tcp.<service>". Names outside the domain are REFUSED.
+// Negative answers include a synthetic SOA of the domain, as
+// described by RFC 2308.
+type DiscoveryLookuper struct {
+	sd     ServiceDiscovery
+	domain string
+
+	// TTL is the TTL of the records answered.
+	// [DefaultDiscoveryTTL] is used if zero.
+	TTL uint32
+}
+
+// Lookup implements the [Lookuper] interface.
+func (dl *DiscoveryLookuper) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return dl.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface.
+func (dl *DiscoveryLookuper) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	q := msgQuestion(req)
+	if ctx == nil || q == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	name := dns.CanonicalName(q.Name)
+	if q.Qclass != dns.ClassINET || !dns.IsSubDomain(dl.domain, name) {
+		return nil, errors.ErrRefused(q.Name)
+	}
+
+	svc, err := dl.lookupService(ctx, name)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	switch {
+	case name == dl.domain && q.Qtype == dns.TypeSOA:
+		resp.Answer = []dns.RR{dl.soa()}
+		return resp, nil
+	case name == dl.domain && svc == nil:
+		// apex, NODATA
+	case svc == nil:
+		resp.Rcode = dns.RcodeNameError
+	default:
+		resp.Answer = dl.answer(*q, svc)
+	}
+
+	if len(resp.Answer) == 0 {
+		resp.Ns = []dns.RR{dl.soa()}
+	}
+	return resp, nil
+}
+
+// lookupService finds the [Service] owning a name. Names like
+// "_http._tcp.<service>" get a [Service] only holding the ports
+// matching.
+func (dl *DiscoveryLookuper) lookupService(ctx context.Context, name string) (*Service, error) {
+	svc, err := dl.sd.LookupService(ctx, name)
+	if err == nil || !errors.IsNotFound(err) {
+		return svc, err
+	}
+
+	labels, idx := dns.SplitDomainName(name), dns.Split(name)
+	switch {
+	case len(labels) < 3, labels[0][0] != '_', labels[1][0] != '_':
+		return nil, err
+	case !dns.IsSubDomain(dl.domain, name[idx[2]:]):
+		return nil, err
+	}
+
+	svc, err = dl.sd.LookupService(ctx, name[idx[2]:])
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []ServicePort
+	for _, sp := range svc.Ports {
+		if sp.Matches(labels[0], labels[1]) {
+			ports = append(ports, sp)
+		}
+	}
+
+	if len(ports) == 0 {
+		return nil, errors.ErrNotFound(name)
+	}
+	return &Service{Name: svc.Name, Ports: ports}, nil
+}
+
+func (dl *DiscoveryLookuper) ttl() uint32 {
+	return core.IIf(dl.TTL > 0, dl.TTL, DefaultDiscoveryTTL)
+}
+
+// soa returns the synthetic SOA record of the domain, allowing
+// negative answers to be cached as long as the positive ones.
+func (dl *DiscoveryLookuper) soa() *dns.SOA {
+	ttl := dl.ttl()
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   dl.domain,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Ns:      "ns." + dl.domain,
+		Mbox:    "hostmaster." + dl.domain,
+		Serial:  1,
+		Refresh: 7200,
+		Retry:   1800,
+		Expire:  86400,
+		Minttl:  ttl,
+	}
+}
+
+func (dl *DiscoveryLookuper) answer(q dns.Question, svc *Service) []dns.RR {
+	ttl := dl.ttl()
+	hdr := dns.RR_Header{
+		Name:  q.Name,
+		Class: dns.ClassINET,
+		Ttl:   ttl,
+	}
+
+	switch {
+	case svc.Alias != "":
+		hdr.Rrtype = dns.TypeCNAME
+		return []dns.RR{&dns.CNAME{Hdr: hdr, Target: dns.Fqdn(svc.Alias)}}
+	case q.Qtype == dns.TypeA, q.Qtype == dns.TypeAAAA:
+		return addrsAnswer(q, svc.Addrs, ttl)
+	case q.Qtype == dns.TypeSRV:
+		hdr.Rrtype = dns.TypeSRV
+		return serviceSRV(hdr, svc)
+	default:
+		// NODATA
+		return nil
+	}
+}
+
+func serviceSRV(hdr dns.RR_Header, svc *Service) []dns.RR {
+	out := make([]dns.RR, 0, len(svc.Ports))
+	for _, sp := range svc.Ports {
+		out = append(out, &dns.SRV{
+			Hdr:    hdr,
+			Weight: 100,
+			Port:   sp.Port,
+			Target: dns.Fqdn(core.Coalesce(sp.Target, svc.Name)),
+		})
+	}
+	return out
+}
+
+// NewDiscoveryLookuper creates a [DiscoveryLookuper] answering for
+// the names under the given domain using a [ServiceDiscovery].
+func NewDiscoveryLookuper(domain string, sd ServiceDiscovery) (*DiscoveryLookuper, error) {
+	if sd == nil {
+		return nil, core.Wrap(core.ErrInvalid, "service discovery required")
+	}
+
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return nil, core.Wrapf(core.ErrInvalid, "invalid domain %q", domain)
+	}
+
+	return &DiscoveryLookuper{
+		sd:     sd,
+		domain: dns.CanonicalName(domain),
+	}, nil
+}
@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+type testDiscovery map[string]*Service
+
+func (m testDiscovery) LookupService(_ context.Context, qName string) (*Service, error) {
+	if svc, ok := m[qName]; ok {
+		return svc, nil
+	}
+	return nil, errors.ErrNotFound(qName)
+}
+
+func TestDiscoveryLookuper(t *testing.T) {
+	dl, err := NewDiscoveryLookuper("consul", testDiscovery{
+		"service.consul.": {Name: "service.consul."},
+		"web.service.consul.": {
+			Name:  "web.service.consul.",
+			Addrs: []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+			Ports: []ServicePort{
+				{Name: "http", Protocol: "tcp", Port: 80},
+				{Name: "admin", Protocol: "tcp", Port: 8080, Target: "node1.consul."},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		qType  uint16
+		rcode  int
+		answer []string
+	}{
+		{"web.service.consul", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.1"}},
+		{"web.service.consul", dns.TypeTXT, dns.RcodeSuccess, nil},
+		{"web.service.consul", dns.TypeSRV, dns.RcodeSuccess, []string{
+			"web.service.consul.", "node1.consul.",
+		}},
+		{"_admin._tcp.web.service.consul", dns.TypeSRV, dns.RcodeSuccess, []string{"node1.consul."}},
+		{"_admin._tcp.web.service.consul", dns.TypeA, dns.RcodeSuccess, nil},
+		{"_ftp._tcp.web.service.consul", dns.TypeSRV, dns.RcodeNameError, nil},
+		{"db.service.consul", dns.TypeA, dns.RcodeNameError, nil},
+		{"service.consul", dns.TypeA, dns.RcodeSuccess, nil},
+		{"consul", dns.TypeA, dns.RcodeSuccess, nil},
+		{"consul", dns.TypeSOA, dns.RcodeSuccess, nil},
+	}
+
+	for _, tc := range tests {
+		msg, err := dl.Lookup(context.Background(), tc.name, tc.qType)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		var answer []string
+		for _, rr := range msg.Answer {
+			switch v := rr.(type) {
+			case *dns.A:
+				answer = append(answer, v.A.String())
+			case *dns.SRV:
+				answer = append(answer, v.Target)
+			}
+		}
+
+		if msg.Rcode != tc.rcode || !msg.Authoritative || !equalStrings(answer, tc.answer) {
+			t.Errorf("%s/%s: unexpected response %s %q", tc.name,
+				dns.TypeToString[tc.qType], dns.RcodeToString[msg.Rcode], answer)
+		}
+
+		// RFC 2308
+		soa, ok := exdns.GetFirstRR[*dns.SOA](msg.Ns)
+		switch {
+		case len(msg.Answer) > 0:
+			// positive
+		case !ok || soa.Hdr.Name != "consul." || soa.Minttl != DefaultDiscoveryTTL:
+			t.Errorf("%s/%s: negative answer without SOA: %v", tc.name,
+				dns.TypeToString[tc.qType], msg.Ns)
+		}
+	}
+
+	if _, err := dl.Lookup(context.Background(), "www.example.org", dns.TypeA); err == nil {
+		t.Error("name outside the domain not refused")
+	}
+	if _, err := NewDiscoveryLookuper("consul", nil); err == nil {
+		t.Error("nil discovery accepted")
+	}
+}
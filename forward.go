@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*ForwardFirst)(nil)
+	_ Exchanger = (*ForwardFirst)(nil)
+)
+
+// ForwardFirst is an [Exchanger] that passes requests to a forwarder
+// first, and falls back to another [Exchanger], typically iterating
+// from the roots, when the forwarder fails or responds SERVFAIL
+// or REFUSED. Like BIND's "forward first".
+type ForwardFirst struct {
+	forward  Exchanger
+	fallback Exchanger
+}
+
+// Lookup performs a lookup using the forwarder first.
+func (r *ForwardFirst) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return r.Exchange(ctx, req)
+}
+
+// Exchange sends the request to the forwarder, and to the
+// fallback [Exchanger] if the forwarder can't answer.
+func (r *ForwardFirst) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if ctx == nil || req == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	req2 := req
+	if !req.RecursionDesired {
+		req2 = req.Copy()
+		req2.RecursionDesired = true
+	}
+
+	resp, err := r.forward.Exchange(ctx, req2)
+	if !r.shouldFallback(ctx, resp, err) {
+		return resp, err
+	}
+
+	return r.fallback.Exchange(ctx, req)
+}
+
+func (*ForwardFirst) shouldFallback(ctx context.Context, resp *dns.Msg, err error) bool {
+	switch {
+	case ctx.Err() != nil:
+		// caller is gone
+		return false
+	case err == nil:
+		return resp == nil ||
+			resp.Rcode == dns.RcodeServerFailure ||
+			resp.Rcode == dns.RcodeRefused
+	case errors.IsNotFound(err):
+		// NXDOMAIN and NODATA are answers
+		return false
+	default:
+		return true
+	}
+}
+
+// NewForwardFirst creates a [ForwardFirst] [Exchanger] using the
+// given forwarder and fallback.
+func NewForwardFirst(forward, fallback Exchanger) (*ForwardFirst, error) {
+	if forward == nil || fallback == nil {
+		return nil, core.Wrap(core.ErrInvalid, "forwarder and fallback required")
+	}
+
+	return &ForwardFirst{
+		forward:  forward,
+		fallback: fallback,
+	}, nil
+}
+
+// NewForwardFirstResolver creates a [LookupResolver] forwarding to the
+// given recursive servers first, and iterating from the roots if they fail.
+func NewForwardFirstResolver(servers ...string) (*LookupResolver, error) {
+	forward, err := NewPoolExchanger(nil, servers...)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback, err := NewRootLookuper("")
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := NewForwardFirst(forward, fallback)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(h), nil
+}
@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestForwardFirst(t *testing.T) {
+	reply := func(rcode int) ExchangerFunc {
+		return func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+			resp := new(dns.Msg)
+			resp.SetRcode(req, rcode)
+			return resp, nil
+		}
+	}
+
+	fail := func(err error) ExchangerFunc {
+		return func(context.Context, *dns.Msg) (*dns.Msg, error) {
+			return nil, err
+		}
+	}
+
+	tests := []struct {
+		name     string
+		forward  Exchanger
+		fallback bool
+	}{
+		{"success", reply(dns.RcodeSuccess), false},
+		{"nxdomain", reply(dns.RcodeNameError), false},
+		{"servfail", reply(dns.RcodeServerFailure), true},
+		{"refused", reply(dns.RcodeRefused), true},
+		{"not found", fail(errors.ErrNotFound("example.org.")), false},
+		{"timeout", fail(errors.ErrTimeout("example.org.", nil)), true},
+	}
+
+	for _, tc := range tests {
+		var called bool
+
+		fallback := ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+			called = true
+			return reply(dns.RcodeSuccess)(context.Background(), req)
+		})
+
+		r, err := NewForwardFirst(tc.forward, fallback)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = r.Lookup(context.Background(), "example.org", dns.TypeA)
+		if called != tc.fallback {
+			t.Errorf("%s: fallback expected:%v got:%v", tc.name, tc.fallback, called)
+		}
+	}
+}
@@ -13,11 +13,11 @@ require (
 	github.com/miekg/dns v1.1.62
 	golang.org/x/net v0.33.0
 	golang.org/x/sync v0.10.0
+	golang.org/x/sys v0.28.0
 )
 
 require (
 	golang.org/x/mod v0.20.0 // indirect
-	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/tools v0.24.0 // indirect
 )
@@ -0,0 +1,281 @@
+package resolver
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/cache/x/simplelru"
+
+	"darvaza.org/resolver/pkg/metrics"
+)
+
+const (
+	// DefaultInfraCacheSize indicates how many servers the [InfraCache]
+	// remembers if not specified.
+	DefaultInfraCacheSize = 4096
+
+	// DefaultInfraCacheTTL indicates how long the [InfraCache] remembers
+	// a server since the last update if not specified.
+	DefaultInfraCacheTTL = 15 * time.Minute
+
+	// InfraUnknownRTO is the retransmission timeout assumed for
+	// servers we know nothing about, encouraging their exploration.
+	InfraUnknownRTO = 376 * time.Millisecond
+
+	// InfraMinRTO is the lowest retransmission timeout considered.
+	InfraMinRTO = 50 * time.Millisecond
+
+	// InfraMaxRTO is the highest retransmission timeout considered.
+	// Servers reaching it are only used if nothing else is available.
+	InfraMaxRTO = 120 * time.Second
+
+	// DefaultLameHoldDown indicates how long a nameserver is considered
+	// lame for a zone after misbehaving.
+	DefaultLameHoldDown = 15 * time.Minute
+
+	// InfraSelectionBand indicates how much slower than the fastest
+	// candidate a server can be and still get chosen at random.
+	InfraSelectionBand = 400 * time.Millisecond
+)
+
+// InfraStats describes what the [InfraCache] knows about a server.
+type InfraStats struct {
+	SRTT     time.Duration
+	RTTVar   time.Duration
+	RTO      time.Duration
+	Timeouts int
+	EDNS     bool
+	Updated  time.Time
+}
+
+// InfraCache remembers per server round-trip times, timeouts and EDNS0
+// capability, and uses the smoothed RTT to choose the best nameserver
+// among candidates.
+type InfraCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	lru  *simplelru.LRU[string, *InfraStats]
+	lame *simplelru.LRU[infraLameKey, string]
+
+	metrics atomic.Pointer[infraMetrics]
+
+	// LameHoldDown indicates how long a nameserver remains lame.
+	// [DefaultLameHoldDown] is used if zero.
+	LameHoldDown time.Duration
+}
+
+type infraLameKey struct {
+	zone   string
+	server string
+}
+
+// infraMetrics are the metrics reported by an [InfraCache].
+type infraMetrics struct {
+	rtt      *metrics.Histogram
+	timeouts *metrics.Counter
+}
+
+// SetMetrics makes the [InfraCache] report the round-trip times and
+// timeouts it records, of all servers combined, to a [metrics.Registry].
+// nil stops it.
+func (ic *InfraCache) SetMetrics(reg *metrics.Registry) {
+	if reg == nil {
+		ic.metrics.Store(nil)
+		return
+	}
+
+	ic.metrics.Store(&infraMetrics{
+		rtt: reg.Histogram("resolver_infra_rtt_seconds",
+			"Round-trip time of the responses from nameservers.", nil),
+		timeouts: reg.Counter("resolver_infra_timeouts_total",
+			"Exchanges with nameservers that timed out."),
+	})
+}
+
+// Update records a successful exchange with a server.
+func (ic *InfraCache) Update(server string, rtt time.Duration, edns bool) {
+	if im := ic.metrics.Load(); im != nil {
+		im.rtt.ObserveDuration(rtt)
+	}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	s, ok := ic.unsafeGet(server)
+	if !ok || s.SRTT == 0 {
+		// first sample
+		s.SRTT = rtt
+		s.RTTVar = rtt / 2
+	} else {
+		// RFC 6298
+		delta := s.SRTT - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.RTTVar = (3*s.RTTVar + delta) / 4
+		s.SRTT = (7*s.SRTT + rtt) / 8
+	}
+
+	s.RTO = clampRTO(s.SRTT + 4*s.RTTVar)
+	s.Timeouts = 0
+	s.EDNS = edns
+	ic.unsafeSet(server, s)
+}
+
+// Timeout records a failed exchange with a server, doubling
+// its retransmission timeout.
+func (ic *InfraCache) Timeout(server string) {
+	if im := ic.metrics.Load(); im != nil {
+		im.timeouts.Inc()
+	}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	s, _ := ic.unsafeGet(server)
+	s.RTO = clampRTO(2 * s.RTO)
+	s.Timeouts++
+	ic.unsafeSet(server, s)
+}
+
+// Get returns what is known about a server.
+func (ic *InfraCache) Get(server string) (InfraStats, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	s, _, ok := ic.lru.Get(server)
+	if !ok {
+		return InfraStats{}, false
+	}
+	return *s, true
+}
+
+// RTO returns the retransmission timeout of a server, or
+// [InfraUnknownRTO] if it isn't known.
+func (ic *InfraCache) RTO(server string) time.Duration {
+	if s, ok := ic.Get(server); ok {
+		return s.RTO
+	}
+	return InfraUnknownRTO
+}
+
+// Select chooses one of the given servers, picking at random among the
+// candidates whose RTO is within [InfraSelectionBand] of the fastest one.
+// Servers that reached [InfraMaxRTO] are only used as last resort.
+func (ic *InfraCache) Select(servers []string) string {
+	switch len(servers) {
+	case 0:
+		return ""
+	case 1:
+		return servers[0]
+	}
+
+	rto := make([]time.Duration, len(servers))
+	best := InfraMaxRTO
+	for i, server := range servers {
+		rto[i] = ic.RTO(server)
+		if rto[i] < best {
+			best = rto[i]
+		}
+	}
+
+	candidates := make([]string, 0, len(servers))
+	for i, server := range servers {
+		if rto[i] <= best+InfraSelectionBand {
+			candidates = append(candidates, server)
+		}
+	}
+
+	// #nosec G404 -- only used to spread the load
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// SetLame flags a nameserver as lame for a zone, for the duration
+// of [InfraCache.LameHoldDown], indicating the reason.
+func (ic *InfraCache) SetLame(zone, server, reason string) {
+	holdDown := ic.LameHoldDown
+	if holdDown <= 0 {
+		holdDown = DefaultLameHoldDown
+	}
+
+	key := infraLameKey{dns.CanonicalName(zone), server}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	ic.lame.Add(key, reason, 1, time.Now().Add(holdDown))
+}
+
+// IsLame tells if a nameserver is considered lame for a zone.
+func (ic *InfraCache) IsLame(zone, server string) bool {
+	key := infraLameKey{dns.CanonicalName(zone), server}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	_, _, ok := ic.lame.Get(key)
+	return ok
+}
+
+// ForEachLame calls a function for each nameserver currently considered
+// lame, the zone, the reason, and when it will be reconsidered.
+// Return true to terminate the loop.
+func (ic *InfraCache) ForEachLame(fn func(zone, server, reason string, until time.Time) bool) {
+	if fn == nil {
+		return
+	}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	ic.lame.ForEach(func(key infraLameKey, reason string, _ int, until time.Time) bool {
+		return fn(key.zone, key.server, reason, until)
+	})
+}
+
+func (ic *InfraCache) unsafeGet(server string) (*InfraStats, bool) {
+	if s, _, ok := ic.lru.Get(server); ok {
+		return s, true
+	}
+
+	return &InfraStats{RTO: InfraUnknownRTO}, false
+}
+
+func (ic *InfraCache) unsafeSet(server string, s *InfraStats) {
+	s.Updated = time.Now()
+	ic.lru.Add(server, s, 1, s.Updated.Add(ic.ttl))
+}
+
+func clampRTO(rto time.Duration) time.Duration {
+	switch {
+	case rto < InfraMinRTO:
+		return InfraMinRTO
+	case rto > InfraMaxRTO:
+		return InfraMaxRTO
+	default:
+		return rto
+	}
+}
+
+// NewInfraCache creates a new [InfraCache] remembering up to size servers
+// for ttl since their last update. Zero values will be replaced by
+// [DefaultInfraCacheSize] and [DefaultInfraCacheTTL] respectively.
+func NewInfraCache(size int, ttl time.Duration) *InfraCache {
+	if size <= 0 {
+		size = DefaultInfraCacheSize
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultInfraCacheTTL
+	}
+
+	return &InfraCache{
+		ttl:  ttl,
+		lru:  simplelru.NewLRU[string, *InfraStats](size, nil, nil),
+		lame: simplelru.NewLRU[infraLameKey, string](size, nil, nil),
+	}
+}
@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestInfraCacheSelect(t *testing.T) {
+	ic := NewInfraCache(0, 0)
+
+	ic.Update("fast", 10*time.Millisecond, true)
+	ic.Update("slow", 900*time.Millisecond, false)
+	ic.Timeout("dead")
+	ic.Timeout("dead")
+
+	servers := []string{"slow", "dead", "fast"}
+	for i := 0; i < 32; i++ {
+		if s := ic.Select(servers); s != "fast" {
+			t.Fatalf("selected %q instead of %q", s, "fast")
+		}
+	}
+
+	st, ok := ic.Get("dead")
+	switch {
+	case !ok:
+		t.Error("dead server not recorded")
+	case st.Timeouts != 2 || st.RTO != 4*InfraUnknownRTO:
+		t.Errorf("unexpected stats for dead server: %+v", st)
+	}
+
+	// unknown servers are explored alongside fast ones
+	seen := make(map[string]bool)
+	for i := 0; i < 256; i++ {
+		seen[ic.Select([]string{"fast", "unknown"})] = true
+	}
+	if !seen["unknown"] {
+		t.Error("unknown server never explored")
+	}
+}
+
+func TestLameReason(t *testing.T) {
+	referral := func(owner string, aa bool) *dns.Msg {
+		m := new(dns.Msg)
+		m.Authoritative = aa
+		m.Ns = []dns.RR{
+			&dns.NS{
+				Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeNS, Class: dns.ClassINET},
+				Ns:  "ns1." + owner,
+			},
+		}
+		return m
+	}
+
+	refused := new(dns.Msg)
+	refused.Rcode = dns.RcodeRefused
+
+	tests := []struct {
+		zone   string
+		resp   *dns.Msg
+		reason string
+	}{
+		{"example.org.", referral("sub.example.org.", false), ""},
+		{"example.org.", referral("example.org.", true), ""},
+		{"example.org.", referral("org.", false), "upward referral"},
+		{"example.org.", referral("example.org.", false), "upward referral"},
+		{"example.org.", new(dns.Msg), "not authoritative"},
+		{"example.org.", refused, "refused"},
+	}
+
+	for i, tc := range tests {
+		if s := lameReason(tc.zone, tc.resp); s != tc.reason {
+			t.Errorf("%v: %q, expected %q", i, s, tc.reason)
+		}
+	}
+}
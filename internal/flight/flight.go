@@ -0,0 +1,204 @@
+// Package flight implements a duplicate call suppression mechanism
+// where the shared execution is detached from the context of the
+// caller that started it.
+package flight
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Group coalesces calls with the same key into a single execution.
+// The execution runs on a context detached from the callers', which
+// is cancelled when the longest deadline among the waiters is reached,
+// or when all waiters have given up. The zero value is ready to use.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+	limit int
+
+	requests   uint64
+	executions uint64
+	coalesced  uint64
+}
+
+// Stats describes the activity of a [Group].
+type Stats struct {
+	// Requests is the number of calls to [Group.Do].
+	Requests uint64
+	// Executions is the number of times the function was run.
+	Executions uint64
+	// Coalesced is the number of calls that waited for an
+	// execution started by another.
+	Coalesced uint64
+	// Inflight is the number of keys currently executing.
+	Inflight int
+}
+
+// Stats returns the counters of the [Group].
+func (g *Group) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return Stats{
+		Requests:   g.requests,
+		Executions: g.executions,
+		Coalesced:  g.coalesced,
+		Inflight:   len(g.calls),
+	}
+}
+
+// SetLimit sets the maximum number of keys tracked at the same time.
+// When reached, calls with new keys run on their own without
+// being shared. Zero means no limit.
+func (g *Group) SetLimit(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.limit = max(n, 0)
+}
+
+type call struct {
+	key    string
+	ctx    context.Context
+	cancel context.CancelFunc
+	timer  *time.Timer
+
+	// waiters is the number of callers still waiting
+	waiters int
+	// dups is the number of callers that joined
+	dups int
+	// unbounded indicates a waiter has no deadline
+	unbounded bool
+	deadline  time.Time
+
+	done   chan struct{}
+	val    any
+	err    error
+	shared bool
+}
+
+// Do executes and returns the result of the given function, making
+// sure only one execution is in-flight for a given key at a time.
+// If a duplicate comes in, the duplicate caller waits for the original
+// to complete and receives the same results. If the caller's context
+// is cancelled while waiting, its error is returned instead but the
+// execution carries on for the others.
+// The shared return value indicates whether the result was given
+// to multiple callers.
+func (g *Group) Do(ctx context.Context, key string,
+	fn func(context.Context) (any, error)) (v any, err error, shared bool) {
+	//
+	c, shared := g.join(ctx, key, fn)
+
+	select {
+	case <-c.done:
+		return c.val, c.err, c.shared
+	case <-ctx.Done():
+		g.leave(c)
+		return nil, ctx.Err(), shared
+	}
+}
+
+// join returns the call for a key, starting one if needed.
+func (g *Group) join(ctx context.Context, key string,
+	fn func(context.Context) (any, error)) (*call, bool) {
+	//
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.requests++
+	if c, ok := g.calls[key]; ok {
+		g.coalesced++
+		c.waiters++
+		c.dups++
+		c.extend(ctx)
+		return c, true
+	}
+
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	g.executions++
+
+	c := &call{
+		key:     key,
+		done:    make(chan struct{}),
+		waiters: 1,
+	}
+	c.ctx, c.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	c.extend(ctx)
+	if g.limit == 0 || len(g.calls) < g.limit {
+		g.calls[key] = c
+	}
+
+	go g.run(c, fn)
+	return c, false
+}
+
+func (g *Group) run(c *call, fn func(context.Context) (any, error)) {
+	defer c.cancel()
+
+	v, err := fn(c.ctx)
+
+	g.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	g.unsafeRemove(c)
+	c.val, c.err, c.shared = v, err, c.dups > 0
+	g.mu.Unlock()
+
+	close(c.done)
+}
+
+// leave removes a waiter from a call, cancelling the execution
+// if none remains.
+func (g *Group) leave(c *call) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c.waiters--
+	if c.waiters == 0 {
+		// abandoned
+		g.unsafeRemove(c)
+		c.cancel()
+	}
+}
+
+func (g *Group) unsafeRemove(c *call) {
+	if g.calls[c.key] == c {
+		delete(g.calls, c.key)
+	}
+}
+
+// extend makes sure the execution can last as long as the
+// deadline of the given context.
+func (c *call) extend(ctx context.Context) {
+	switch deadline, ok := ctx.Deadline(); {
+	case c.unbounded:
+		// nothing to do
+	case !ok:
+		// no limit
+		c.unbounded = true
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+	case c.timer == nil:
+		c.deadline = deadline
+		c.timer = time.AfterFunc(time.Until(deadline), c.cancel)
+	case deadline.After(c.deadline):
+		c.deadline = deadline
+		c.timer.Reset(time.Until(deadline))
+	}
+}
+
+// Forget makes future calls with the given key start a new
+// execution instead of waiting for the current one.
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.calls, key)
+}
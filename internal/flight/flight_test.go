@@ -0,0 +1,137 @@
+package flight
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGroupDetached(t *testing.T) {
+	var g Group
+
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (any, error) {
+		select {
+		case <-release:
+			return "ok", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// the first caller gives up
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	res1 := make(chan error, 1)
+	go func() {
+		_, err, _ := g.Do(ctx1, "key", fn)
+		res1 <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	res2 := make(chan any, 1)
+	go func() {
+		v, _, shared := g.Do(context.Background(), "key", fn)
+		if !shared {
+			v = nil
+		}
+		res2 <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel1()
+	if err := <-res1; err != context.Canceled {
+		t.Errorf("unexpected error for cancelled caller: %v", err)
+	}
+
+	close(release)
+	if v := <-res2; v != "ok" {
+		t.Errorf("unexpected result for remaining caller: %v", v)
+	}
+}
+
+func TestGroupAbandoned(t *testing.T) {
+	var g Group
+
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err, _ := g.Do(ctx, "key", fn); err == nil {
+		t.Error("error expected")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("abandoned execution not cancelled")
+	}
+}
+
+func TestGroupDeadline(t *testing.T) {
+	var g Group
+
+	fn := func(ctx context.Context) (any, error) {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return "ok", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// the shared execution lasts as long as the longest deadline
+	ctx1, cancel1 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel1()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+
+	go func() { _, _, _ = g.Do(ctx1, "key", fn) }()
+	time.Sleep(time.Millisecond)
+
+	v, err, _ := g.Do(ctx2, "key", fn)
+	if err != nil || v != "ok" {
+		t.Errorf("unexpected result: %v, %v", v, err)
+	}
+}
+
+func TestGroupStats(t *testing.T) {
+	var g Group
+
+	release := make(chan struct{})
+	fn := func(context.Context) (any, error) {
+		<-release
+		return "ok", nil
+	}
+
+	g.SetLimit(1)
+
+	done := make(chan struct{})
+	for _, key := range []string{"a", "a", "a", "b"} {
+		go func(key string) {
+			_, _, _ = g.Do(context.Background(), key, fn)
+			done <- struct{}{}
+		}(key)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if st := g.Stats(); st.Inflight != 1 {
+		t.Errorf("unexpected inflight keys: %+v", st)
+	}
+
+	close(release)
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+
+	st := g.Stats()
+	switch {
+	case st.Requests != 4, st.Executions != 2, st.Coalesced != 2, st.Inflight != 0:
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/netip"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -15,6 +15,7 @@ import (
 	"darvaza.org/resolver/pkg/client"
 	"darvaza.org/resolver/pkg/errors"
 	"darvaza.org/resolver/pkg/exdns"
+	"darvaza.org/resolver/pkg/metrics"
 )
 
 var (
@@ -22,6 +23,8 @@ var (
 	_ Exchanger = (*RootLookuper)(nil)
 	_ Lookuper  = (*IteratorLookuper)(nil)
 	_ Exchanger = (*IteratorLookuper)(nil)
+
+	_ client.IPv6Setter = (*IteratorLookuper)(nil)
 )
 
 var roots = map[string]string{
@@ -40,6 +43,24 @@ var roots = map[string]string{
 	"m.root-servers.net": "202.12.27.33",
 }
 
+var roots6 = map[string]string{
+	"a.root-servers.net": "2001:503:ba3e::2:30",
+	"b.root-servers.net": "2801:1b8:10::b",
+	"c.root-servers.net": "2001:500:2::c",
+	"d.root-servers.net": "2001:500:2d::d",
+	"e.root-servers.net": "2001:500:a8::e",
+	"f.root-servers.net": "2001:500:2f::f",
+	"g.root-servers.net": "2001:500:12::d0d",
+	"h.root-servers.net": "2001:500:1::53",
+	"i.root-servers.net": "2001:7fe::53",
+	"j.root-servers.net": "2001:503:c27::2:30",
+	"k.root-servers.net": "2001:7fd::1",
+	"l.root-servers.net": "2001:500:9f::42",
+	"m.root-servers.net": "2001:dc3::35",
+}
+
+const rootServersTTL = 518400
+
 const (
 	// DefaultIteratorAttempts indicates how many times a request
 	// will be tried by default.
@@ -60,8 +81,39 @@ const (
 	// for the previous attempt to finish before starting a new one.
 	// This can be changed using [IteratorLookuper.SetResilience]
 	DefaultIteratorInterval = 10 * time.Millisecond
+
+	// DefaultIteratorGlueTimeout indicates how long are we willing
+	// to wait for the addresses of the nameservers of a zone.
+	// This can be changed using [IteratorLookuper.SetTimeouts]
+	DefaultIteratorGlueTimeout = 1 * time.Second
+
+	// DefaultIteratorReferralTimeout indicates how long are we willing
+	// to wait for each step of the iteration, including failover between
+	// the nameservers of a zone.
+	// This can be changed using [IteratorLookuper.SetTimeouts]
+	DefaultIteratorReferralTimeout = 2 * time.Second
+
+	// DefaultIteratorQueryTimeout indicates how long are we willing
+	// to wait for a query to be resolved when the caller's context
+	// has no deadline.
+	// This can be changed using [IteratorLookuper.SetTimeouts]
+	DefaultIteratorQueryTimeout = 10 * time.Second
 )
 
+// IteratorTimeouts describes the time budgets of the [IteratorLookuper].
+// Zero or negative values disable them.
+type IteratorTimeouts struct {
+	// Glue is the maximum time to spend resolving the addresses
+	// of the nameservers of a zone.
+	Glue time.Duration
+	// Referral is the maximum time to spend on each step of the
+	// iteration.
+	Referral time.Duration
+	// Query is the maximum time to spend on a query when the
+	// caller's context has no deadline.
+	Query time.Duration
+}
+
 // RootLookuper does iterative lookup using the root servers.
 type RootLookuper struct {
 	l *IteratorLookuper
@@ -125,16 +177,32 @@ func (r RootLookuper) DisableAAAA() {
 	r.l.DisableAAAA()
 }
 
+// EnableAAAA allows the use of IPv6 entries on NS glue.
+func (r RootLookuper) EnableAAAA() {
+	r.l.EnableAAAA()
+}
+
+// SetIPv6 allows or prevents the use of IPv6 entries on NS glue.
+func (r RootLookuper) SetIPv6(enabled bool) {
+	r.l.SetIPv6(enabled)
+}
+
 // IteratorLookuper is a generic iterative lookuper, caching zones
 // glue and NS information.
 type IteratorLookuper struct {
-	c    client.Client
-	nsc  *NSCache
-	aaaa bool
+	c     client.Client
+	nsc   *NSCache
+	infra *InfraCache
+	glue  *glueFetcher
+	zr    *zoneRefresher
+	fwd   *forwardZones
+	aaaa  atomic.Bool
 
 	attempts int
 	deadline time.Duration
 	interval time.Duration
+	limits   IteratorLimits
+	timeouts IteratorTimeouts
 }
 
 // SetPersistent flags a zone for being restored automatically
@@ -144,14 +212,30 @@ func (r *IteratorLookuper) SetPersistent(qName string) error {
 }
 
 // AddRootServers loads the embedded table of root servers,
+// including their IPv6 addresses unless AAAA is disabled,
 // and made persistent.
 func (r *IteratorLookuper) AddRootServers() error {
-	return r.AddMapPersistent(".", 518400, roots)
+	zone := NewNSCacheZoneFromMap(".", rootServersTTL, roots)
+	if r.useAAAA() {
+		for name, s := range roots6 {
+			addr, err := netip.ParseAddr(s)
+			if err == nil {
+				zone.AddGlue(dns.Fqdn(name), addr)
+			}
+		}
+	}
+
+	r.setZoneParameters(zone, 0)
+	if err := r.nsc.Add(zone); err != nil {
+		return err
+	}
+
+	return r.SetPersistent(".")
 }
 
 // AddMap loads NS servers from a map
 func (r *IteratorLookuper) AddMap(qName string, ttl uint32, servers map[string]string) error {
-	if !r.aaaa {
+	if !r.useAAAA() {
 		servers = r.mapWithoutAAAA(servers)
 	}
 
@@ -201,37 +285,42 @@ func (r *IteratorLookuper) AddFrom(ctx context.Context,
 	}
 
 	// pull the real information
-	if r.deadline > 0 {
-		var cancel context.CancelFunc
-		deadline := time.Now().Add(r.deadline)
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
 
-		ctx, cancel = context.WithDeadline(ctx, deadline)
-		defer cancel()
+	zone, err = r.pullZone(ctx, qName, ttl)
+	if err == nil {
+		err = r.nsc.Add(zone)
 	}
 
-	resp, err := r.lookupAddFrom(ctx, qName)
 	if err != nil {
 		r.nsc.Evict(qName)
-		return err
+		return core.Wrapf(err, "%q: failed to create zone", qName)
 	}
 
-	// assemble final Zone
-	zone, err = NewNSCacheZoneFromNS(resp)
-	if err == nil {
-		r.setZoneParameters(zone, ttl)
-		err = r.getGlue(ctx, zone)
+	return nil
+}
+
+// pullZone asks the authoritative servers of a zone for their
+// NS records and assembles a new [NSCacheZone] with them.
+func (r *IteratorLookuper) pullZone(ctx context.Context,
+	qName string, ttl uint32) (*NSCacheZone, error) {
+	//
+	resp, err := r.lookupAddFrom(ctx, qName)
+	if err != nil {
+		return nil, err
 	}
 
+	zone, err := NewNSCacheZoneFromNS(resp)
 	if err == nil {
-		err = r.nsc.Add(zone)
+		r.setZoneParameters(zone, ttl)
+		err = r.getGlue(ctx, zone)
 	}
 
 	if err != nil {
-		r.nsc.Evict(qName)
-		return core.Wrapf(err, "%q: failed to create zone", qName)
+		return nil, err
 	}
-
-	return nil
+	return zone, nil
 }
 
 func (r *IteratorLookuper) setZoneParameters(zone *NSCacheZone, ttl uint32) {
@@ -239,10 +328,11 @@ func (r *IteratorLookuper) setZoneParameters(zone *NSCacheZone, ttl uint32) {
 		zone.SetTTL(ttl, ttl/2)
 	}
 	zone.SetResilience(r.attempts, r.deadline, r.interval)
+	zone.SetInfraCache(r.infra)
 }
 
 func (r *IteratorLookuper) lookupAddFrom(ctx context.Context, qName string) (*dns.Msg, error) {
-	resp, err := r.Lookup(ctx, qName, dns.TypeNS)
+	resp, err := r.lookupNegative(ctx, qName, dns.TypeNS)
 	if err2 := exdns.ValidateResponse("", resp, err); err2 != nil {
 		return nil, err2
 	}
@@ -253,7 +343,7 @@ func (r *IteratorLookuper) lookupAddFrom(ctx context.Context, qName string) (*dn
 	}
 
 	// Remove AAAA if we don't support it
-	if !r.aaaa {
+	if !r.useAAAA() {
 		resp = r.responseWithoutAAAA(resp)
 	}
 
@@ -291,9 +381,39 @@ func (r *IteratorLookuper) newManualZone(qName string, servers ...string) (*NSCa
 	return zone, nil
 }
 
+// InfraCache returns the [InfraCache] used to choose nameservers
+// by their round-trip time.
+func (r *IteratorLookuper) InfraCache() *InfraCache {
+	return r.infra
+}
+
 // DisableAAAA prevents the use of IPv6 entries on NS glue.
 func (r *IteratorLookuper) DisableAAAA() {
-	r.aaaa = false
+	r.SetIPv6(false)
+}
+
+// EnableAAAA allows the use of IPv6 entries on NS glue.
+func (r *IteratorLookuper) EnableAAAA() {
+	r.SetIPv6(true)
+}
+
+// SetIPv6 allows or prevents the use of IPv6 entries on NS glue.
+// It's safe to call at any time, but zones already cached keep their
+// addresses until refreshed.
+func (r *IteratorLookuper) SetIPv6(enabled bool) {
+	r.aaaa.Store(enabled)
+}
+
+// DetectIPv6 checks again if the system supports IPv6, and allows
+// or prevents the use of IPv6 entries on NS glue accordingly.
+func (r *IteratorLookuper) DetectIPv6() bool {
+	ok := client.ProbeIPv6Support()
+	r.SetIPv6(ok)
+	return ok
+}
+
+func (r *IteratorLookuper) useAAAA() bool {
+	return r.aaaa.Load()
 }
 
 // SetLogger sets [NSCache]'s logger. [slog.Debug] is used to record
@@ -302,6 +422,23 @@ func (r *IteratorLookuper) SetLogger(log slog.Logger) {
 	r.nsc.SetLogger(log)
 }
 
+// SetMetrics makes the [IteratorLookuper] report the round-trip times of
+// the nameservers and the activity of its [NSCache] to a [metrics.Registry].
+func (r *IteratorLookuper) SetMetrics(reg *metrics.Registry) {
+	r.infra.SetMetrics(reg)
+	if reg == nil {
+		return
+	}
+
+	reg.Gauge("resolver_nscache_zones", "Zones in the NS cache.").
+		SetFunc(func() float64 { return float64(r.nsc.Stats().Entries) })
+
+	lookups := reg.Counter("resolver_nscache_lookups_total",
+		"NS cache lookups by result.", "result")
+	lookups.SetFunc(func() float64 { return float64(r.nsc.Stats().Hits) }, "hit")
+	lookups.SetFunc(func() float64 { return float64(r.nsc.Stats().Misses) }, "miss")
+}
+
 // SetResilience specifies retry parameters to use when doing an Exchange.
 //
 // `attempts` indicates how many times a request will be tried, and
@@ -324,6 +461,39 @@ func (r *IteratorLookuper) SetResilience(attempts int, deadline, interval time.D
 	r.interval = interval
 }
 
+// SetTimeouts specifies the time budgets of the iteration.
+func (r *IteratorLookuper) SetTimeouts(timeouts IteratorTimeouts) {
+	r.timeouts = timeouts
+}
+
+// Timeouts returns the time budgets of the iteration.
+func (r *IteratorLookuper) Timeouts() IteratorTimeouts {
+	return r.timeouts
+}
+
+// newQueryContext prepares the context of a new query, unless
+// it's a sub-query of another.
+func (r *IteratorLookuper) newQueryContext(ctx context.Context,
+	qName string) (context.Context, context.CancelFunc) {
+	//
+	if _, ok := iteratorBudgetCtxKey.Get(ctx); ok {
+		// sub-query
+		return ctx, func() {}
+	}
+
+	ctx = r.withBudget(ctx, qName)
+	return r.withQueryTimeout(ctx)
+}
+
+// withQueryTimeout applies the default query timeout if the
+// context doesn't have a deadline.
+func (r *IteratorLookuper) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); !ok && r.timeouts.Query > 0 {
+		return context.WithTimeout(ctx, r.timeouts.Query)
+	}
+	return ctx, func() {}
+}
+
 // Lookup performs an iterative lookup
 func (r *IteratorLookuper) Lookup(ctx context.Context,
 	name string, qType uint16) (*dns.Msg, error) {
@@ -332,7 +502,22 @@ func (r *IteratorLookuper) Lookup(ctx context.Context,
 		return nil, errors.ErrBadRequest()
 	}
 
+	resp, err := r.lookupRaw(ctx, name, qType)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// lookupRaw performs an iterative lookup, returning the
+// NXDOMAIN response along the error when available.
+func (r *IteratorLookuper) lookupRaw(ctx context.Context,
+	name string, qType uint16) (*dns.Msg, error) {
+	//
 	req := exdns.NewRequestFromParts(dns.Fqdn(name), dns.ClassINET, qType)
+	ctx, cancel := r.newQueryContext(ctx, req.Question[0].Name)
+	defer cancel()
+
 	return r.doIterate(ctx, req)
 }
 
@@ -352,6 +537,8 @@ func (r *IteratorLookuper) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg
 
 	// sanitize request
 	req2 := exdns.NewRequestFromParts(q.Name, q.Qclass, q.Qtype)
+	ctx, cancel := r.newQueryContext(ctx, q.Name)
+	defer cancel()
 
 	// TODO: preserve EDNS0_SUBNET
 	// TODO: any other option useful/safe on the original request to cherry-pick?
@@ -361,12 +548,17 @@ func (r *IteratorLookuper) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg
 }
 
 func (r *IteratorLookuper) doIterate(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if resp, ok, err := r.doForward(ctx, req); ok {
+		// forward zone
+		return resp, err
+	}
+
 	for {
 		resp, err := r.doIteratePass(ctx, req)
 		switch {
 		case err != nil:
-			// failed
-			return nil, err
+			// failed, but NXDOMAIN may carry a response
+			return resp, err
 		case r.responseIsFinal(resp):
 			return resp, nil
 		}
@@ -377,7 +569,7 @@ func (r *IteratorLookuper) doIteratePass(ctx context.Context, req *dns.Msg) (*dn
 	resp, err := r.doExchange(ctx, req)
 	switch {
 	case err != nil:
-		return nil, err
+		return resp, err
 	case resp == nil:
 		return nil, errors.ErrBadResponse()
 	case resp.Rcode == dns.RcodeSuccess:
@@ -403,6 +595,17 @@ func (r *IteratorLookuper) handleSuccess(ctx context.Context,
 }
 
 func (r *IteratorLookuper) doExchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if err := getIteratorBudget(ctx).SpendQuery(); err != nil {
+		return nil, err
+	}
+
+	if d := r.timeouts.Referral; d > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -441,6 +644,12 @@ func (r *IteratorLookuper) handleSuccessAnswer(ctx context.Context,
 
 func (r *IteratorLookuper) handleCNAMEAnswer(ctx context.Context,
 	req, resp *dns.Msg, cname string) (*dns.Msg, error) {
+	budget := getIteratorBudget(ctx)
+	if err := budget.SpendCNAME(); err != nil {
+		// too deep, likely a loop
+		return nil, err
+	}
+
 	// assemble request for information about the CNAME
 	q := msgQuestion(req)
 	req2 := exdns.NewRequestFromParts(dns.Fqdn(cname), q.Qclass, q.Qtype)
@@ -452,17 +661,20 @@ func (r *IteratorLookuper) handleCNAMEAnswer(ctx context.Context,
 
 	// ask
 	resp2, err := r.Exchange(ctx, req2)
-	if err != nil {
+	switch {
+	case err == nil:
+		// merge
+		return r.mergeCNAMEAnswer(resp, resp2), nil
+	case budget.Exhausted():
+		// out of budget further down the chain
+		return nil, err
+	default:
 		// failed, return what we had.
 		return resp, nil
 	}
-
-	// merge
-	resp3 := r.mergeCNAMEAnswer(resp, resp2)
-	return resp3, nil
 }
 
-func (IteratorLookuper) mergeCNAMEAnswer(resp1, resp2 *dns.Msg) *dns.Msg {
+func (*IteratorLookuper) mergeCNAMEAnswer(resp1, resp2 *dns.Msg) *dns.Msg {
 	resp := resp1.Copy()
 	exdns.ForEachRR(resp2.Answer, func(rr dns.RR) {
 		resp.Answer = append(resp.Answer, rr)
@@ -488,6 +700,10 @@ func (r *IteratorLookuper) handleSuccessDelegation(ctx context.Context,
 		panic("unreachable")
 	}
 
+	if err := getIteratorBudget(ctx).SpendReferral(); err != nil {
+		return nil, err
+	}
+
 	name := ns.Header().Name
 	if _, _, ok := r.nsc.Get(name); !ok {
 		// not cached
@@ -501,7 +717,7 @@ func (r *IteratorLookuper) handleSuccessDelegation(ctx context.Context,
 }
 
 func (r *IteratorLookuper) addDelegation(ctx context.Context, resp *dns.Msg) (bool, error) {
-	if !r.aaaa {
+	if !r.useAAAA() {
 		resp = r.responseWithoutAAAA(resp)
 	}
 
@@ -522,85 +738,12 @@ func (r *IteratorLookuper) addDelegation(ctx context.Context, resp *dns.Msg) (bo
 	return err == nil, err
 }
 
-// revive:disable:cognitive-complexity
-func (r *IteratorLookuper) getGlue(ctx context.Context,
-	zone *NSCacheZone) error {
-	// revive:enable:cognitive-complexity
-	var wg sync.WaitGroup
-	var cancel context.CancelFunc
-
-	if r.deadline > 0 {
-		deadline := time.Now().Add(r.deadline)
-		ctx, cancel = context.WithDeadline(ctx, deadline)
-		defer cancel()
-	}
-
-	spawnGoGetGlue := func(qName string, qType uint16) {
-		wg.Add(1)
-
-		go func() {
-			defer wg.Done()
-			r.goGetGlue(ctx, qName, qType, zone)
-		}()
-	}
-
-	zone.ForEachNS(func(qName string, addrs []netip.Addr) {
-		switch {
-		case len(addrs) > 0:
-			return
-		case dns.IsSubDomain(zone.name, qName):
-			return
-		}
-
-		spawnGoGetGlue(qName, dns.TypeA)
-		if r.aaaa {
-			spawnGoGetGlue(qName, dns.TypeAAAA)
-		}
-	})
-
-	wg.Wait()
-
-	if !zone.HasGlue() {
-		// nothing
-		return errors.ErrTimeout(zone.name, nil)
-	}
-
-	return nil
-}
-
-func (r *IteratorLookuper) goGetGlue(ctx context.Context,
-	qName string, qType uint16, zone *NSCacheZone) bool {
-	//
-	var addrs []netip.Addr
-
-	resp, err := r.Lookup(ctx, qName, qType)
-	if err != nil {
-		return false
-	}
-
-	eqAddr := func(a, b netip.Addr) bool {
-		return a.Compare(b) == 0
-	}
-
-	exdns.ForEachAnswer(resp, func(rr dns.RR) {
-		ip, ok := r.getIPfromRR(rr)
-		if ok && !core.SliceContainsFn(addrs, ip, eqAddr) {
-			addrs = append(addrs, ip)
-		}
-	})
-
-	if len(addrs) > 0 {
-		return zone.SetGlue(qName, addrs)
-	}
-	return false
-}
-
 func (r *IteratorLookuper) getIPfromRR(rr dns.RR) (netip.Addr, bool) {
 	switch v := rr.(type) {
 	case *dns.A:
 		return netip.AddrFromSlice(v.A)
 	case *dns.AAAA:
-		if r.aaaa {
+		if r.useAAAA() {
 			return netip.AddrFromSlice(v.AAAA)
 		}
 	}
@@ -695,7 +838,7 @@ func (r *IteratorLookuper) ParseAddrs(servers []string) ([]netip.Addr, error) {
 func (r *IteratorLookuper) ParseAddr(server string) (netip.Addr, bool, error) {
 	ip, err := core.ParseAddr(server)
 	if ip.IsValid() {
-		if r.aaaa || ip.Is4() {
+		if r.useAAAA() || ip.Is4() {
 			return ip, true, nil
 		}
 	}
@@ -712,14 +855,24 @@ func NewIteratorLookuper(name string, maxRR uint, c client.Client) *IteratorLook
 	}
 
 	iter := &IteratorLookuper{
-		c:    c,
-		nsc:  NewNSCache(name, maxRR),
-		aaaa: client.HasIPv6Support(),
+		c:     c,
+		nsc:   NewNSCache(name, maxRR),
+		infra: NewInfraCache(0, 0),
+		glue:  newGlueFetcher(),
+		zr:    newZoneRefresher(),
+		fwd:   new(forwardZones),
 
 		attempts: DefaultIteratorAttempts,
 		deadline: DefaultIteratorDeadline,
 		interval: DefaultIteratorInterval,
+		timeouts: IteratorTimeouts{
+			Glue:     DefaultIteratorGlueTimeout,
+			Referral: DefaultIteratorReferralTimeout,
+			Query:    DefaultIteratorQueryTimeout,
+		},
 	}
 
+	iter.aaaa.Store(client.HasIPv6Support())
+	iter.nsc.SetRefresher(iter.refreshZoneAsync)
 	return iter
 }
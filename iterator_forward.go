@@ -0,0 +1,139 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// StubZoneTTL is the TTL given to stub zones, which are
+// persistent so it only determines how often their glue
+// is refreshed from the given addresses.
+const StubZoneTTL = 86400
+
+// forwardZones maps domains to the [Exchanger] handling them
+type forwardZones struct {
+	mu    sync.RWMutex
+	zones map[string]Exchanger
+}
+
+// Get finds the [Exchanger] of the closest forward zone
+// containing the name.
+func (fz *forwardZones) Get(qName string) (string, Exchanger, bool) {
+	fz.mu.RLock()
+	defer fz.mu.RUnlock()
+
+	if len(fz.zones) == 0 {
+		return "", nil, false
+	}
+
+	for off, end := 0, false; !end; off, end = dns.NextLabel(qName, off) {
+		name := qName[off:]
+		if e, ok := fz.zones[name]; ok {
+			return name, e, true
+		}
+	}
+
+	// and the root
+	e, ok := fz.zones["."]
+	return ".", e, ok
+}
+
+func (fz *forwardZones) Set(qName string, e Exchanger) {
+	fz.mu.Lock()
+	defer fz.mu.Unlock()
+
+	if e == nil {
+		delete(fz.zones, qName)
+		return
+	}
+
+	if fz.zones == nil {
+		fz.zones = make(map[string]Exchanger)
+	}
+	fz.zones[qName] = e
+}
+
+// Replace sets all the zones at once.
+func (fz *forwardZones) Replace(zones map[string]Exchanger) {
+	fz.mu.Lock()
+	defer fz.mu.Unlock()
+
+	fz.zones = zones
+}
+
+// Export returns a copy of the zones.
+func (fz *forwardZones) Export() map[string]Exchanger {
+	fz.mu.RLock()
+	defer fz.mu.RUnlock()
+
+	out := make(map[string]Exchanger, len(fz.zones))
+	for name, e := range fz.zones {
+		out[name] = e
+	}
+	return out
+}
+
+// AddStubZone makes queries under the given domain go directly to
+// the given authoritative servers instead of iterating from the roots.
+// Delegations found below it are followed as usual.
+func (r *IteratorLookuper) AddStubZone(qName string, servers ...string) error {
+	qName = dns.CanonicalName(qName)
+	if err := r.AddServer(qName, StubZoneTTL, servers...); err != nil {
+		return err
+	}
+
+	return r.SetPersistent(qName)
+}
+
+// AddForwardZone makes queries under the given domain be passed to
+// the given [Exchanger], typically a recursive [Pool], instead of
+// iterating. Failures of the [Exchanger] are returned as-is, without
+// falling back to iteration.
+func (r *IteratorLookuper) AddForwardZone(qName string, e Exchanger) error {
+	if e == nil {
+		return core.Wrap(core.ErrInvalid, "no exchanger specified")
+	}
+
+	r.fwd.Set(dns.CanonicalName(qName), e)
+	return nil
+}
+
+// RemoveForwardZone stops forwarding queries under the given domain.
+func (r *IteratorLookuper) RemoveForwardZone(qName string) {
+	r.fwd.Set(dns.CanonicalName(qName), nil)
+}
+
+// doForward passes the request to the [Exchanger] of the
+// forward zone containing it, if any.
+func (r *IteratorLookuper) doForward(ctx context.Context,
+	req *dns.Msg) (*dns.Msg, bool, error) {
+	//
+	q := msgQuestion(req)
+	if q == nil {
+		return nil, false, nil
+	}
+
+	_, e, ok := r.fwd.Get(dns.CanonicalName(q.Name))
+	if !ok {
+		return nil, false, nil
+	}
+
+	if err := getIteratorBudget(ctx).SpendQuery(); err != nil {
+		return nil, true, err
+	}
+
+	req2 := req.Copy()
+	req2.RecursionDesired = true
+
+	resp, err := e.Exchange(ctx, req2)
+	if err == nil && resp == nil {
+		err = errors.ErrBadResponse()
+	}
+	return resp, true, err
+}
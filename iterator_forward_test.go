@@ -0,0 +1,61 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestForwardZones(t *testing.T) {
+	var hits []string
+
+	newForwarder := func(name string) Exchanger {
+		return ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+			hits = append(hits, name)
+			if !req.RecursionDesired {
+				t.Errorf("%s: recursion not requested", name)
+			}
+
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			return resp, nil
+		})
+	}
+
+	r := NewIteratorLookuper("test", 0, nil)
+	if err := r.AddForwardZone("corp.example", newForwarder("corp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddForwardZone("lab.corp.example.", newForwarder("lab")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		qName    string
+		expected string
+	}{
+		{"corp.example.", "corp"},
+		{"www.corp.example.", "corp"},
+		{"x.lab.corp.example.", "lab"},
+		{"LAB.corp.example.", "lab"},
+	}
+
+	for _, tc := range tests {
+		hits = hits[:0]
+		if _, err := r.Lookup(context.Background(), tc.qName, dns.TypeA); err != nil {
+			t.Errorf("%s: %v", tc.qName, err)
+		} else if len(hits) != 1 || hits[0] != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.qName, tc.expected, hits)
+		}
+	}
+
+	r.RemoveForwardZone("lab.corp.example")
+	if name, _, ok := r.fwd.Get("x.lab.corp.example."); !ok || name != "corp.example." {
+		t.Errorf("expected corp.example. after removal, got %q", name)
+	}
+
+	if _, _, ok := r.fwd.Get("example.org."); ok {
+		t.Error("unexpected forward zone for example.org.")
+	}
+}
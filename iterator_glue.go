@@ -0,0 +1,349 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/cache/x/simplelru"
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/internal/flight"
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// DefaultGlueNegativeTTL indicates how long the [IteratorLookuper]
+	// remembers a nameserver name couldn't be resolved, when the
+	// response doesn't indicate it.
+	DefaultGlueNegativeTTL = 1 * time.Minute
+
+	// MaxGlueNegativeTTL indicates the maximum time the [IteratorLookuper]
+	// remembers a nameserver name couldn't be resolved.
+	MaxGlueNegativeTTL = 1 * time.Hour
+
+	// DefaultGlueNegativeSize indicates how many unresolvable
+	// nameserver names the [IteratorLookuper] remembers.
+	DefaultGlueNegativeSize = 1024
+)
+
+// glueFetcher coordinates glue sub-queries across concurrent
+// iterations, and remembers the names that couldn't be resolved.
+type glueFetcher struct {
+	g     flight.Group
+	mu    sync.Mutex
+	neg   *simplelru.LRU[string, bool]
+	waits map[string]map[string]int
+}
+
+var glueChainCtxKey = core.NewContextKey[*glueChain]("resolver.iterator.glue")
+
+// glueChain lists the glue sub-queries a lookup is nested in,
+// to detect nameservers depending on each other.
+type glueChain struct {
+	key  string
+	next *glueChain
+}
+
+// Contains tells if the chain includes the given key.
+func (gc *glueChain) Contains(key string) bool {
+	for ; gc != nil; gc = gc.next {
+		if gc.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// withGlueChain appends a key to the glue chain of the context,
+// failing if it's already part of it.
+func withGlueChain(ctx context.Context, key string) (context.Context, bool) {
+	gc, _ := glueChainCtxKey.Get(ctx)
+	if gc.Contains(key) {
+		return ctx, false
+	}
+
+	return glueChainCtxKey.WithValue(ctx, &glueChain{key, gc}), true
+}
+
+// IsNegative tells if a name is known not to exist (NXDOMAIN),
+// or not to have records of the given type (NODATA).
+func (gf *glueFetcher) IsNegative(key string) (nxdomain, ok bool) {
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+
+	nxdomain, _, ok = gf.neg.Get(key)
+	return nxdomain, ok
+}
+
+// revive:disable:flag-parameter
+
+// SetNegative remembers a name doesn't exist, or doesn't
+// have records of the given type, for a while.
+func (gf *glueFetcher) SetNegative(key string, nxdomain bool, ttl time.Duration) {
+	// revive:enable:flag-parameter
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+
+	gf.neg.Add(key, nxdomain, 1, time.Now().Add(ttl))
+}
+
+// Enter records the glue sub-query at the head of the chain waits
+// for the one of the given key, failing if that one already waits,
+// directly or not, for any sub-query of the chain.
+func (gf *glueFetcher) Enter(gc *glueChain, key string) bool {
+	if gc == nil {
+		// nobody waits for the top-level lookup
+		return true
+	}
+
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+
+	if gf.unsafeWaitsFor(key, gc) {
+		return false
+	}
+
+	if gf.waits == nil {
+		gf.waits = make(map[string]map[string]int)
+	}
+	m, ok := gf.waits[gc.key]
+	if !ok {
+		m = make(map[string]int)
+		gf.waits[gc.key] = m
+	}
+	m[key]++
+	return true
+}
+
+// Leave undoes a successful [glueFetcher.Enter].
+func (gf *glueFetcher) Leave(gc *glueChain, key string) {
+	if gc == nil {
+		return
+	}
+
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+
+	m := gf.waits[gc.key]
+	if m[key]--; m[key] <= 0 {
+		delete(m, key)
+	}
+	if len(m) == 0 {
+		delete(gf.waits, gc.key)
+	}
+}
+
+func (gf *glueFetcher) unsafeWaitsFor(key string, gc *glueChain) bool {
+	visited := make(map[string]bool)
+	pending := []string{key}
+	for len(pending) > 0 {
+		key, pending = pending[0], pending[1:]
+		switch {
+		case gc.Contains(key):
+			return true
+		case visited[key]:
+			continue
+		}
+
+		visited[key] = true
+		for next := range gf.waits[key] {
+			pending = append(pending, next)
+		}
+	}
+	return false
+}
+
+func negativeKey(qName string, qType uint16) string {
+	return dns.CanonicalName(qName) + " " + dns.TypeToString[qType]
+}
+
+// negativeTTL determines how long a negative response can be
+// cached, as described by RFC 2308.
+func negativeTTL(resp *dns.Msg) time.Duration {
+	if resp == nil {
+		return DefaultGlueNegativeTTL
+	}
+
+	soa, ok := exdns.GetFirstRR[*dns.SOA](resp.Ns)
+	if !ok {
+		return DefaultGlueNegativeTTL
+	}
+
+	ttl := time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+	switch {
+	case ttl < MinimumNSCacheTTL*time.Second:
+		return MinimumNSCacheTTL * time.Second
+	case ttl > MaxGlueNegativeTTL:
+		return MaxGlueNegativeTTL
+	default:
+		return ttl
+	}
+}
+
+func newGlueFetcher() *glueFetcher {
+	return &glueFetcher{
+		neg: simplelru.NewLRU[string, bool](DefaultGlueNegativeSize, nil, nil),
+	}
+}
+
+// getGlue resolves the addresses of the out-of-bailiwick nameservers
+// of the zone lacking them, within the caller's budget.
+func (r *IteratorLookuper) getGlue(ctx context.Context,
+	zone *NSCacheZone) error {
+	//
+	var wg sync.WaitGroup
+
+	if d := r.timeouts.Glue; d > 0 {
+		// the earliest of the caller's and ours
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	spawn := func(qName string, qType uint16) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.goGetGlue(ctx, qName, qType, zone)
+		}()
+	}
+
+	zone.ForEachNS(func(qName string, addrs []netip.Addr) {
+		switch {
+		case len(addrs) > 0, dns.IsSubDomain(zone.name, qName):
+			return
+		}
+
+		spawn(qName, dns.TypeA)
+		if r.useAAAA() {
+			spawn(qName, dns.TypeAAAA)
+		}
+	})
+
+	wg.Wait()
+
+	if !zone.HasGlue() {
+		// nothing
+		return errors.ErrTimeout(zone.name, ctx.Err())
+	}
+
+	return nil
+}
+
+func (r *IteratorLookuper) goGetGlue(ctx context.Context,
+	qName string, qType uint16, zone *NSCacheZone) bool {
+	//
+	addrs := r.fetchGlue(ctx, qName, qType)
+	if len(addrs) > 0 {
+		return zone.AddGlue(qName, addrs...)
+	}
+	return false
+}
+
+// fetchGlue resolves the addresses of a nameserver, sharing the
+// sub-query with any concurrent iteration asking for the same.
+// Nameservers whose resolution depends on themselves are skipped,
+// also when the dependency goes through sub-queries started by others.
+func (r *IteratorLookuper) fetchGlue(ctx context.Context,
+	qName string, qType uint16) []netip.Addr {
+	//
+	key := negativeKey(qName, qType)
+	if _, ok := r.glue.IsNegative(key); ok {
+		// known to fail
+		return nil
+	}
+
+	parent, _ := glueChainCtxKey.Get(ctx)
+	ctx, ok := withGlueChain(ctx, key)
+	switch {
+	case !ok:
+		// cycle
+		return nil
+	case ctx.Err() != nil:
+		// out of time
+		return nil
+	case !r.glue.Enter(parent, key):
+		// cycle through sub-queries shared with others
+		return nil
+	}
+	defer r.glue.Leave(parent, key)
+
+	if getIteratorBudget(ctx).SpendGlue() != nil {
+		// out of budget
+		return nil
+	}
+
+	// the shared sub-query outlives the caller if others wait for it,
+	// and the caller stops waiting when its own context is done.
+	v, _, _ := r.glue.g.Do(ctx, key, func(ctx context.Context) (any, error) {
+		return r.lookupGlue(ctx, qName, qType)
+	})
+
+	addrs, _ := v.([]netip.Addr)
+	return addrs
+}
+
+func (r *IteratorLookuper) lookupGlue(ctx context.Context,
+	qName string, qType uint16) ([]netip.Addr, error) {
+	//
+	var addrs []netip.Addr
+
+	resp, err := r.lookupNegative(ctx, qName, qType)
+	if err != nil {
+		return nil, err
+	}
+
+	eqAddr := func(a, b netip.Addr) bool {
+		return a.Compare(b) == 0
+	}
+
+	exdns.ForEachAnswer(resp, func(rr dns.RR) {
+		ip, ok := r.getIPfromRR(rr)
+		if ok && !core.SliceContainsFn(addrs, ip, eqAddr) {
+			addrs = append(addrs, ip)
+		}
+	})
+
+	if len(addrs) == 0 && len(resp.Answer) > 0 {
+		// nothing usable, i.e. CNAME to nowhere
+		r.glue.SetNegative(negativeKey(qName, qType), false, DefaultGlueNegativeTTL)
+	}
+
+	return addrs, nil
+}
+
+// lookupNegative performs an internal lookup remembering NXDOMAIN
+// and NODATA responses for the negative TTL of the zone, so broken
+// delegations don't cause repeated queries.
+func (r *IteratorLookuper) lookupNegative(ctx context.Context,
+	qName string, qType uint16) (*dns.Msg, error) {
+	//
+	key := negativeKey(qName, qType)
+	if nxdomain, ok := r.glue.IsNegative(key); ok {
+		if nxdomain {
+			return nil, errors.ErrNotFound(qName)
+		}
+		return nil, errors.ErrTypeNotFound(qName)
+	}
+
+	resp, err := r.lookupRaw(ctx, qName, qType)
+	switch {
+	case err == nil && len(resp.Answer) == 0:
+		// NODATA
+		r.glue.SetNegative(key, false, negativeTTL(resp))
+	case errors.IsNotFound(err):
+		// NXDOMAIN
+		r.glue.SetNegative(key, true, negativeTTL(resp))
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	return resp, nil
+}
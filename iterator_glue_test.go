@@ -0,0 +1,243 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func newTestSOA(ttl, minTTL uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   "example.org.",
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Ns:     "ns1.example.org.",
+		Mbox:   "hostmaster.example.org.",
+		Minttl: minTTL,
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	tests := []struct {
+		soa      *dns.SOA
+		expected time.Duration
+	}{
+		{nil, DefaultGlueNegativeTTL},
+		{newTestSOA(300, 60), 60 * time.Second},
+		{newTestSOA(30, 600), 30 * time.Second},
+		{newTestSOA(1, 1), MinimumNSCacheTTL * time.Second},
+		{newTestSOA(86400, 86400), MaxGlueNegativeTTL},
+	}
+
+	for i, tc := range tests {
+		resp := new(dns.Msg)
+		if tc.soa != nil {
+			resp.Ns = append(resp.Ns, tc.soa)
+		}
+
+		if ttl := negativeTTL(resp); ttl != tc.expected {
+			t.Errorf("%v: expected %v, got %v", i, tc.expected, ttl)
+		}
+	}
+}
+
+func TestLookupNegative(t *testing.T) {
+	var calls int
+
+	r := NewIteratorLookuper("test", 0, nil)
+	_ = r.AddForwardZone("example.org", ExchangerFunc(func(_ context.Context,
+		req *dns.Msg) (*dns.Msg, error) {
+		calls++
+
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeNameError)
+		resp.Ns = append(resp.Ns, newTestSOA(300, 60))
+		return resp, errors.ErrNotFound(req.Question[0].Name)
+	}))
+
+	for i := 0; i < 3; i++ {
+		_, err := r.lookupNegative(context.Background(), "ns1.example.org.", dns.TypeA)
+		if !errors.IsNotFound(err) {
+			t.Fatalf("%v: expected NXDOMAIN, got %v", i, err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected a single upstream query, got %v", calls)
+	}
+}
+
+func TestGlueShared(t *testing.T) {
+	const lookups = 8
+
+	release := make(chan struct{})
+
+	// glue answers wait until every iteration asked for them
+	tn := newTestIterNet(t, testIterZones)
+	tn.Hook = func(ctx context.Context, _ string, q dns.Question) error {
+		if strings.HasSuffix(q.Name, ".noglue.net.") {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	r := newTestIterator(t, tn)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, lookups)
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Lookup(context.Background(), "www.noglue.org.", dns.TypeA)
+			errs <- err
+		}()
+	}
+
+	// two nameservers per iteration
+	deadline := time.Now().Add(5 * time.Second)
+	for r.glue.g.Stats().Requests < 2*lookups {
+		if time.Now().After(deadline) {
+			t.Fatalf("glue requests not made: %+v", r.glue.g.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, name := range []string{"ns1.noglue.net.", "ns2.noglue.net."} {
+		if n := tn.Queries("192.0.2.3", name, dns.TypeA); n != 1 {
+			t.Errorf("%s: asked %v times, expected once", name, n)
+		}
+	}
+}
+
+func TestGlueNegative(t *testing.T) {
+	tn := newTestIterNet(t, testIterZones)
+	r := newTestIterator(t, tn)
+
+	// both zones delegated to a nameserver that doesn't exist,
+	// and another that does.
+	for _, name := range []string{"www.bad1.org.", "www.bad2.org."} {
+		if _, err := r.Lookup(context.Background(), name, dns.TypeA); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	if n := tn.Queries("192.0.2.3", "ns.missing.net.", dns.TypeA); n != 1 {
+		t.Errorf("missing nameserver asked %v times, expected once", n)
+	}
+
+	if nxdomain, ok := r.glue.IsNegative(negativeKey("ns.missing.net.", dns.TypeA)); !ok || !nxdomain {
+		t.Error("missing nameserver not remembered")
+	}
+}
+
+func TestGlueCycle(t *testing.T) {
+	r := newTestIterator(t, newTestIterNet(t, testIterZones))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the nameservers of cyc1.org and cyc2.net depend on each other
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Lookup(ctx, "www.cyc1.org.", dns.TypeA)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("unresolvable delegation succeeded")
+		}
+		if ctx.Err() != nil {
+			t.Error("cycle only broken by the deadline")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cyclic glue never finished")
+	}
+
+	testGlueCycleShared(t)
+}
+
+// testGlueCycleShared resolves both sides of the cycle at once, each
+// waiting on the other's shared glue sub-query.
+func testGlueCycleShared(t *testing.T) {
+	var mu sync.Mutex
+	var once sync.Once
+	seen := make(map[string]bool)
+	release := make(chan struct{})
+
+	// the nameservers are only resolved once both sub-queries started
+	tn := newTestIterNet(t, testIterZones)
+	tn.Hook = func(ctx context.Context, _ string, q dns.Question) error {
+		switch q.Name {
+		case "ns.cyc1.org.", "ns.cyc2.net.":
+		default:
+			return nil
+		}
+
+		mu.Lock()
+		seen[q.Name] = true
+		if len(seen) == 2 {
+			once.Do(func() { close(release) })
+		}
+		mu.Unlock()
+
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	r := newTestIterator(t, tn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 2)
+	for _, name := range []string{"www.cyc1.org.", "www.cyc2.net."} {
+		go func(name string) {
+			_, err := r.Lookup(ctx, name, dns.TypeA)
+			done <- err
+		}(name)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("unresolvable delegation succeeded")
+			}
+			if time.Since(start) >= DefaultIteratorGlueTimeout {
+				t.Error("shared cycle only broken by the glue timeout")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("shared cyclic glue never finished")
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package resolver
+
+import (
+	"io"
+	"os"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// AddRootHintsFromFile loads a root hints file in the format of
+// the IANA's named.root and installs it as the persistent root zone.
+func (r *IteratorLookuper) AddRootHintsFromFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return r.AddRootHintsFromReader(f, filename)
+}
+
+// AddRootHintsFromReader loads root hints in the format of the IANA's
+// named.root and installs them as the persistent root zone.
+// The filename is only used on error messages.
+func (r *IteratorLookuper) AddRootHintsFromReader(f io.Reader, filename string) error {
+	zone, err := r.parseRootHints(f, filename)
+	if err != nil {
+		return core.Wrapf(err, "%q: failed to load root hints", filename)
+	}
+
+	r.setZoneParameters(zone, 0)
+	if err := r.nsc.Add(zone); err != nil {
+		return err
+	}
+
+	return r.SetPersistent(".")
+}
+
+func (r *IteratorLookuper) parseRootHints(f io.Reader, filename string) (*NSCacheZone, error) {
+	var ns, extra []dns.RR
+
+	zp := dns.NewZoneParser(f, ".", filename)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch v := rr.(type) {
+		case *dns.NS:
+			if v.Hdr.Name == "." {
+				ns = append(ns, rr)
+			}
+		case *dns.A:
+			extra = append(extra, rr)
+		case *dns.AAAA:
+			if r.useAAAA() {
+				extra = append(extra, rr)
+			}
+		}
+	}
+
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	zone, ttl, ok := assembleNSCacheZoneFromRR(ns, extra)
+	switch {
+	case !ok:
+		return nil, errors.New("no root NS records found")
+	case !zone.HasGlue():
+		return nil, errors.New("no root server addresses found")
+	}
+
+	zone.SetTTL(ttl, ttl/2)
+	return zone, nil
+}
@@ -0,0 +1,54 @@
+package resolver
+
+import (
+	"strings"
+	"testing"
+)
+
+const testRootHints = `;       This file holds the information on root name servers needed to
+;       initialize cache of Internet domain name servers
+;
+; FORMERLY NS.INTERNIC.NET
+;
+.                        3600000      NS    A.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+A.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:ba3e::2:30
+;
+; FORMERLY NS1.ISI.EDU
+;
+.                        3600000      NS    B.ROOT-SERVERS.NET.
+B.ROOT-SERVERS.NET.      3600000      A     170.247.170.2
+B.ROOT-SERVERS.NET.      3600000      AAAA  2801:1b8:10::b
+; End of file
+`
+
+func TestAddRootHints(t *testing.T) {
+	for _, aaaa := range []bool{false, true} {
+		r := NewIteratorLookuper("test", 0, nil)
+		r.SetIPv6(aaaa)
+
+		err := r.AddRootHintsFromReader(strings.NewReader(testRootHints), "named.root")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		zone, _, ok := r.nsc.Get(".")
+		if !ok {
+			t.Fatal("root zone not installed")
+		}
+
+		expected := map[bool]int{false: 2, true: 4}[aaaa]
+		if addrs := zone.Addrs(); len(addrs) != expected {
+			t.Errorf("aaaa:%v: %q, expected %v addresses", aaaa, addrs, expected)
+		}
+	}
+}
+
+func TestAddRootHintsInvalid(t *testing.T) {
+	r := NewIteratorLookuper("test", 0, nil)
+
+	err := r.AddRootHintsFromReader(strings.NewReader("; empty\n"), "empty.root")
+	if err == nil {
+		t.Error("empty hints accepted")
+	}
+}
@@ -0,0 +1,146 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"darvaza.org/core"
+)
+
+const (
+	// DefaultIteratorMaxReferrals is the maximum number of referrals
+	// followed by a single query unless specified otherwise.
+	DefaultIteratorMaxReferrals = 32
+
+	// DefaultIteratorMaxCNAMEDepth is the maximum number of CNAME
+	// records followed by a single query unless specified otherwise.
+	DefaultIteratorMaxCNAMEDepth = 11
+
+	// DefaultIteratorMaxGlueQueries is the maximum number of glue
+	// sub-queries a single query can trigger unless specified otherwise.
+	DefaultIteratorMaxGlueQueries = 32
+
+	// DefaultIteratorMaxQueries is the maximum number of upstream
+	// exchanges a single query can trigger unless specified otherwise.
+	DefaultIteratorMaxQueries = 96
+)
+
+// IteratorLimits describes the work a single query can cause to the
+// [IteratorLookuper], including any CNAME and glue sub-queries.
+// Zero values are replaced by their defaults, and negative values
+// disable the limit.
+type IteratorLimits struct {
+	MaxReferrals   int
+	MaxCNAMEDepth  int
+	MaxGlueQueries int
+	MaxQueries     int
+}
+
+// SetDefaults fills the gaps in the [IteratorLimits].
+func (l *IteratorLimits) SetDefaults() {
+	setIntDefault(&l.MaxReferrals, DefaultIteratorMaxReferrals)
+	setIntDefault(&l.MaxCNAMEDepth, DefaultIteratorMaxCNAMEDepth)
+	setIntDefault(&l.MaxGlueQueries, DefaultIteratorMaxGlueQueries)
+	setIntDefault(&l.MaxQueries, DefaultIteratorMaxQueries)
+}
+
+func setIntDefault(p *int, def int) {
+	if *p == 0 {
+		*p = def
+	}
+}
+
+var iteratorBudgetCtxKey = core.NewContextKey[*iteratorBudget]("resolver.iterator.budget")
+
+// iteratorBudget tracks the work done on behalf of a query
+type iteratorBudget struct {
+	limits IteratorLimits
+	qName  string
+
+	referrals atomic.Int32
+	cnames    atomic.Int32
+	glue      atomic.Int32
+	queries   atomic.Int32
+	exhausted atomic.Bool
+}
+
+func (b *iteratorBudget) spend(counter *atomic.Int32, limit int, what string) error {
+	if limit < 0 {
+		// unlimited
+		return nil
+	}
+
+	if n := counter.Add(1); int(n) > limit {
+		b.exhausted.Store(true)
+		return &net.DNSError{
+			Err:  "iteration limit exceeded: " + what,
+			Name: b.qName,
+		}
+	}
+	return nil
+}
+
+// Exhausted tells if any of the limits has been exceeded.
+func (b *iteratorBudget) Exhausted() bool {
+	return b.exhausted.Load()
+}
+
+// SpendReferral accounts for a referral being followed.
+func (b *iteratorBudget) SpendReferral() error {
+	return b.spend(&b.referrals, b.limits.MaxReferrals, "referrals")
+}
+
+// SpendCNAME accounts for a CNAME being followed.
+func (b *iteratorBudget) SpendCNAME() error {
+	return b.spend(&b.cnames, b.limits.MaxCNAMEDepth, "CNAME chain")
+}
+
+// SpendGlue accounts for a glue sub-query.
+func (b *iteratorBudget) SpendGlue() error {
+	return b.spend(&b.glue, b.limits.MaxGlueQueries, "glue queries")
+}
+
+// SpendQuery accounts for an upstream exchange.
+func (b *iteratorBudget) SpendQuery() error {
+	return b.spend(&b.queries, b.limits.MaxQueries, "upstream queries")
+}
+
+// withBudget attaches a new budget to the context unless
+// it already carries one.
+func (r *IteratorLookuper) withBudget(ctx context.Context, qName string) context.Context {
+	if _, ok := iteratorBudgetCtxKey.Get(ctx); ok {
+		return ctx
+	}
+
+	b := &iteratorBudget{
+		limits: r.limits,
+		qName:  qName,
+	}
+	b.limits.SetDefaults()
+
+	return iteratorBudgetCtxKey.WithValue(ctx, b)
+}
+
+func getIteratorBudget(ctx context.Context) *iteratorBudget {
+	b, ok := iteratorBudgetCtxKey.Get(ctx)
+	if !ok {
+		// unrestricted
+		b = &iteratorBudget{
+			limits: IteratorLimits{-1, -1, -1, -1},
+		}
+	}
+	return b
+}
+
+// SetLimits sets the amount of work a single query can cause.
+func (r *IteratorLookuper) SetLimits(limits IteratorLimits) {
+	r.limits = limits
+}
+
+// Limits returns the limits applied to each query.
+func (r *IteratorLookuper) Limits() IteratorLimits {
+	limits := r.limits
+	limits.SetDefaults()
+	return limits
+}
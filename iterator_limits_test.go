@@ -0,0 +1,86 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+func TestIteratorLimits(t *testing.T) {
+	unlimited := IteratorLimits{-1, -1, -1, -1}
+
+	tests := []struct {
+		name   string
+		qName  string
+		limits func(*IteratorLimits)
+		failed bool
+	}{
+		{"cname", "alias.org.", nil, false},
+		{"cname loop", "loop.org.", func(l *IteratorLimits) { l.MaxCNAMEDepth = 0 }, true},
+		{"cname depth", "alias.org.", func(l *IteratorLimits) { l.MaxCNAMEDepth = 1 }, false},
+		{"referrals", "www.sub.org.", func(l *IteratorLimits) { l.MaxReferrals = 2 }, false},
+		{"referrals exceeded", "www.sub.org.", func(l *IteratorLimits) { l.MaxReferrals = 1 }, true},
+		{"queries", "www.sub.org.", func(l *IteratorLimits) { l.MaxQueries = 3 }, false},
+		{"queries exceeded", "www.sub.org.", func(l *IteratorLimits) { l.MaxQueries = 2 }, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestIterator(t, newTestIterNet(t, testIterZones))
+
+			limits := unlimited
+			if tc.limits != nil {
+				tc.limits(&limits)
+			}
+			r.SetLimits(limits)
+
+			resp, err := r.Lookup(context.Background(), tc.qName, dns.TypeA)
+			switch {
+			case !tc.failed && err != nil:
+				t.Fatal(err)
+			case !tc.failed && !exdns.HasAnswerType(resp, dns.TypeA):
+				t.Errorf("no address on %v", resp.Answer)
+			case tc.failed && err == nil:
+				t.Errorf("limit not enforced, got %v", resp.Answer)
+			case tc.failed && !strings.Contains(err.Error(), "iteration limit exceeded"):
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestIteratorLimitsGlue(t *testing.T) {
+	tn := newTestIterNet(t, testIterZones)
+	r := newTestIterator(t, tn)
+	r.SetLimits(IteratorLimits{-1, -1, 1, -1})
+
+	if _, err := r.Lookup(context.Background(), "www.noglue.org.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+
+	// only one of the two nameservers resolved
+	n1 := tn.Queries("192.0.2.3", "ns1.noglue.net.", dns.TypeA)
+	n2 := tn.Queries("192.0.2.3", "ns2.noglue.net.", dns.TypeA)
+	if n1+n2 != 1 {
+		t.Errorf("%v glue queries, expected 1", n1+n2)
+	}
+
+	// but a fresh budget allows both
+	tn = newTestIterNet(t, testIterZones)
+	r = newTestIterator(t, tn)
+	r.SetLimits(IteratorLimits{-1, -1, 2, -1})
+
+	if _, err := r.Lookup(context.Background(), "www.noglue.org.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+
+	n1 = tn.Queries("192.0.2.3", "ns1.noglue.net.", dns.TypeA)
+	n2 = tn.Queries("192.0.2.3", "ns2.noglue.net.", dns.TypeA)
+	if n1 != 1 || n2 != 1 {
+		t.Errorf("%v/%v glue queries, expected 1/1", n1, n2)
+	}
+}
@@ -0,0 +1,133 @@
+package resolver
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/slog"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// DefaultPrimingRetry indicates how long to wait before trying
+	// again after a failed root priming.
+	DefaultPrimingRetry = 1 * time.Minute
+)
+
+// Prime asks the known root servers for the authoritative list of
+// root servers, and replaces the hints with it.
+func (r *IteratorLookuper) Prime(ctx context.Context) error {
+	if ctx == nil {
+		return core.ErrInvalid
+	}
+
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	old, _, _ := r.nsc.Get(".")
+
+	zone, err := r.primeZone(ctx)
+	if err != nil {
+		return core.Wrap(err, "root priming failed")
+	}
+
+	r.setZoneParameters(zone, 0)
+	if err := r.nsc.Add(zone); err != nil {
+		return err
+	}
+
+	r.logRootChanges(old, zone)
+	return r.SetPersistent(".")
+}
+
+func (r *IteratorLookuper) primeZone(ctx context.Context) (*NSCacheZone, error) {
+	req := exdns.NewRequestFromParts(".", dns.ClassINET, dns.TypeNS)
+	resp, err := r.nsc.ExchangeWithClient(ctx, req, r.c)
+	switch {
+	case err != nil:
+		return nil, err
+	case !resp.Authoritative:
+		return nil, core.Wrap(core.ErrInvalid, "not authoritative")
+	}
+
+	if !r.useAAAA() {
+		resp = r.responseWithoutAAAA(resp)
+	}
+
+	zone, err := NewNSCacheZoneFromNS(resp)
+	switch {
+	case err != nil:
+		return nil, err
+	case zone.Name() != ".":
+		return nil, errors.ErrBadResponse()
+	case !zone.HasGlue():
+		return nil, errors.New("no root server addresses received")
+	default:
+		return zone, nil
+	}
+}
+
+func (r *IteratorLookuper) logRootChanges(old, zone *NSCacheZone) {
+	var oldAddrs []string
+	if old != nil {
+		oldAddrs = old.Addrs()
+	}
+	addrs := zone.Addrs()
+
+	added := core.SliceMinus(addrs, oldAddrs)
+	removed := core.SliceMinus(oldAddrs, addrs)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	l := r.nsc.getLogger().Info().WithField("servers", len(addrs))
+	if len(added) > 0 {
+		l = l.WithField("added", added)
+	}
+	if len(removed) > 0 {
+		l = l.WithField("removed", removed)
+	}
+	l.Print("root servers updated")
+}
+
+// StartPriming primes the root zone and keeps refreshing it in the
+// background before it expires, until the context is cancelled.
+// Failures are retried in the background, but the outcome of the
+// first attempt is returned.
+func (r *IteratorLookuper) StartPriming(ctx context.Context) error {
+	if ctx == nil {
+		return core.ErrInvalid
+	}
+
+	err := r.Prime(ctx)
+	go r.runPriming(ctx, err)
+	return err
+}
+
+func (r *IteratorLookuper) runPriming(ctx context.Context, err error) {
+	for {
+		wait := DefaultPrimingRetry
+		if err != nil {
+			r.nsc.getLogger().Warn().WithField(slog.ErrorFieldName, err).
+				Print("root priming failed, will retry")
+		} else if zone, _, ok := r.nsc.Get("."); ok {
+			wait = time.Until(zone.HalfLife())
+		}
+
+		if wait < MinimumNSCacheTTL*time.Second {
+			wait = MinimumNSCacheTTL * time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+			err = r.Prime(ctx)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/slog"
+
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+var _ slog.Logger = (*testLogger)(nil)
+
+// testLogEntry is an entry recorded by a [testLogger]
+type testLogEntry struct {
+	Level   slog.LogLevel
+	Message string
+	Fields  slog.Fields
+}
+
+// testLogger is a [slog.Logger] recording the entries printed
+type testLogger struct {
+	mu      *sync.Mutex
+	entries *[]testLogEntry
+	level   slog.LogLevel
+	fields  slog.Fields
+}
+
+func newTestLogger() *testLogger {
+	return &testLogger{
+		mu:      new(sync.Mutex),
+		entries: new([]testLogEntry),
+	}
+}
+
+// Entries returns the entries recorded so far.
+func (l *testLogger) Entries() []testLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]testLogEntry(nil), *l.entries...)
+}
+
+func (l *testLogger) Debug() slog.Logger { return l.WithLevel(slog.Debug) }
+func (l *testLogger) Info() slog.Logger  { return l.WithLevel(slog.Info) }
+func (l *testLogger) Warn() slog.Logger  { return l.WithLevel(slog.Warn) }
+func (l *testLogger) Error() slog.Logger { return l.WithLevel(slog.Error) }
+func (l *testLogger) Fatal() slog.Logger { return l.WithLevel(slog.Fatal) }
+func (l *testLogger) Panic() slog.Logger { return l.WithLevel(slog.Panic) }
+
+func (l *testLogger) Print(args ...any) { l.add(fmt.Sprint(args...)) }
+func (l *testLogger) Println(args ...any) {
+	l.add(strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+func (l *testLogger) Printf(format string, args ...any) { l.add(fmt.Sprintf(format, args...)) }
+
+func (l *testLogger) add(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	*l.entries = append(*l.entries, testLogEntry{l.level, msg, l.fields})
+}
+
+func (l *testLogger) WithLevel(level slog.LogLevel) slog.Logger {
+	l2 := *l
+	l2.level = level
+	return &l2
+}
+
+func (l *testLogger) WithStack(int) slog.Logger { return l }
+
+func (l *testLogger) WithField(label string, value any) slog.Logger {
+	return l.WithFields(slog.Fields{label: value})
+}
+
+func (l *testLogger) WithFields(fields map[string]any) slog.Logger {
+	l2 := *l
+	l2.fields = make(slog.Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		l2.fields[k] = v
+	}
+	for k, v := range fields {
+		l2.fields[k] = v
+	}
+	return &l2
+}
+
+func (*testLogger) Enabled() bool                      { return true }
+func (l *testLogger) WithEnabled() (slog.Logger, bool) { return l, true }
+
+// newTestRootServer returns a [client.Client] answering the root
+// NS query with the given root servers, named after their index.
+func newTestRootServer(addrs ...string) client.Client {
+	return client.ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		_ string) (*dns.Msg, time.Duration, error) {
+		//
+		q := req.Question[0]
+		if q.Name != "." || q.Qtype != dns.TypeNS {
+			return nil, 0, errors.ErrRefused(q.Name)
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Authoritative = true
+		for i, addr := range addrs {
+			name := fmt.Sprintf("%c.root.test.", 'a'+i)
+			hdr := dns.RR_Header{Name: ".", Class: dns.ClassINET, Ttl: 518400}
+			hdr.Rrtype = dns.TypeNS
+			resp.Answer = append(resp.Answer, &dns.NS{Hdr: hdr, Ns: name})
+
+			// as unpacked from the wire
+			hdr.Name, hdr.Rrtype = name, dns.TypeA
+			resp.Extra = append(resp.Extra, &dns.A{
+				Hdr: hdr,
+				A:   netip.MustParseAddr(addr).AsSlice(),
+			})
+		}
+		return resp, time.Millisecond, nil
+	})
+}
+
+func TestPrime(t *testing.T) {
+	log := newTestLogger()
+
+	r := NewIteratorLookuper("test", 0, newTestRootServer("192.0.2.100", "192.0.2.101"))
+	r.SetIPv6(false)
+	r.SetLogger(log)
+	if err := r.AddServer(".", 0, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Prime(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// hints replaced
+	zone, _, ok := r.nsc.Get(".")
+	if !ok {
+		t.Fatal("root zone missing")
+	}
+	expected := []string{"192.0.2.100", "192.0.2.101"}
+	if addrs := zone.Addrs(); !equalStrings(addrs, expected) {
+		t.Errorf("root servers %q, expected %q", addrs, expected)
+	}
+
+	// change logged
+	var changes []testLogEntry
+	for _, e := range log.Entries() {
+		if e.Message == "root servers updated" {
+			changes = append(changes, e)
+		}
+	}
+
+	switch {
+	case len(changes) != 1:
+		t.Fatalf("%v changes logged, expected 1", len(changes))
+	case changes[0].Level != slog.Info:
+		t.Errorf("change logged at level %v", changes[0].Level)
+	}
+
+	fields := changes[0].Fields
+	if added, _ := fields["added"].([]string); !equalStrings(added, expected) {
+		t.Errorf("added %q, expected %q", added, expected)
+	}
+	if removed, _ := fields["removed"].([]string); !equalStrings(removed, []string{"192.0.2.1"}) {
+		t.Errorf("removed %q, expected the hint", removed)
+	}
+
+	// no changes, nothing logged
+	n := len(log.Entries())
+	if err := r.Prime(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range log.Entries()[n:] {
+		if e.Message == "root servers updated" {
+			t.Errorf("unchanged root servers logged: %+v", e)
+		}
+	}
+}
+
+func TestStartPrimingFailure(t *testing.T) {
+	log := newTestLogger()
+
+	// no root servers received
+	r := NewIteratorLookuper("test", 0, newTestRootServer())
+	r.SetIPv6(false)
+	r.SetLogger(log)
+	if err := r.AddServer(".", 0, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := r.StartPriming(ctx); err == nil {
+		t.Fatal("priming without root servers succeeded")
+	}
+
+	// the hints are kept
+	if zone, _, ok := r.nsc.Get("."); !ok || !equalStrings(zone.Addrs(), []string{"192.0.2.1"}) {
+		t.Error("root hints lost")
+	}
+
+	// and the failure logged by the background priming
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, e := range log.Entries() {
+			if e.Level == slog.Warn && strings.Contains(e.Message, "root priming failed") {
+				if e.Fields[slog.ErrorFieldName] == nil {
+					t.Error("failure logged without error")
+				}
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("failure not logged: %+v", log.Entries())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"darvaza.org/slog"
+)
+
+// DefaultZoneRefreshRetry indicates how long to wait before
+// trying again to refresh a zone after a failure.
+const DefaultZoneRefreshRetry = 30 * time.Second
+
+// zoneRefresher coordinates the background refresh of
+// zones past their half-life.
+type zoneRefresher struct {
+	g    singleflight.Group
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// TryStart tells if a zone can be refreshed now, and prevents
+// further attempts until it's done or retry has passed.
+func (zr *zoneRefresher) TryStart(qName string, retry time.Duration) bool {
+	zr.mu.Lock()
+	defer zr.mu.Unlock()
+
+	now := time.Now()
+	if next, ok := zr.next[qName]; ok && now.Before(next) {
+		return false
+	}
+
+	zr.next[qName] = now.Add(retry)
+	return true
+}
+
+// Done allows a zone to be refreshed again once it needs it.
+func (zr *zoneRefresher) Done(qName string) {
+	zr.mu.Lock()
+	defer zr.mu.Unlock()
+
+	delete(zr.next, qName)
+}
+
+func newZoneRefresher() *zoneRefresher {
+	return &zoneRefresher{
+		next: make(map[string]time.Time),
+	}
+}
+
+// refreshZoneAsync refreshes a zone in the background
+// unless it's already being refreshed or recently failed.
+func (r *IteratorLookuper) refreshZoneAsync(qName string) {
+	if r.zr.TryStart(qName, DefaultZoneRefreshRetry) {
+		go func() {
+			_, _, _ = r.zr.g.Do(qName, func() (any, error) {
+				return nil, r.RefreshZone(context.Background(), qName)
+			})
+		}()
+	}
+}
+
+// RefreshZone asks the authoritative servers of a cached zone for
+// their NS records and glue, and replaces the cached information.
+// It is called automatically in the background when a zone past its
+// half-life is used.
+func (r *IteratorLookuper) RefreshZone(ctx context.Context, qName string) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	zone, err := r.pullZone(ctx, qName, 0)
+	if err == nil {
+		err = r.nsc.Add(zone)
+	}
+
+	log := r.nsc.getLogger()
+	if err != nil {
+		log.Debug().WithFields(slog.Fields{
+			"domain":            qName,
+			slog.ErrorFieldName: err,
+		}).Print("zone refresh failed")
+		return err
+	}
+
+	r.zr.Done(qName)
+	log.Debug().WithField("domain", qName).Print("zone refreshed")
+	return nil
+}
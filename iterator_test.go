@@ -2,13 +2,181 @@ package resolver
 
 import (
 	"context"
+	"net/netip"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/errors"
 	"darvaza.org/resolver/pkg/exdns"
 )
 
+// testIterRoot is the address of the root server of a [testIterNet]
+const testIterRoot = "192.0.2.1"
+
+// testIterNet is a fake network of authoritative servers for an
+// [IteratorLookuper] to iterate, each serving one or more [Zone]s.
+type testIterNet struct {
+	mu      sync.Mutex
+	zones   map[string][]*Zone
+	queries map[string]int
+
+	// Hook, if set, is called before answering, and
+	// its error returned instead of the answer.
+	Hook func(ctx context.Context, server string, q dns.Question) error
+}
+
+// Queries returns how many times a server was asked
+// for a name and type.
+func (tn *testIterNet) Queries(server, qName string, qType uint16) int {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+
+	return tn.queries[server+" "+negativeKey(qName, qType)]
+}
+
+// ExchangeContext implements the [client.Client] interface.
+func (tn *testIterNet) ExchangeContext(ctx context.Context, req *dns.Msg,
+	server string) (*dns.Msg, time.Duration, error) {
+	//
+	ap, err := netip.ParseAddrPort(server)
+	if err != nil {
+		return nil, 0, err
+	}
+	host := ap.Addr().Unmap().String()
+
+	q := req.Question[0]
+
+	tn.mu.Lock()
+	z := tn.getZone(host, q.Name)
+	tn.queries[host+" "+negativeKey(q.Name, q.Qtype)]++
+	tn.mu.Unlock()
+
+	if tn.Hook != nil {
+		if err := tn.Hook(ctx, host, q); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if z == nil {
+		return nil, 0, errors.ErrTimeout(q.Name, nil)
+	}
+
+	resp, err := z.Exchange(ctx, req)
+	return resp, time.Millisecond, err
+}
+
+// getZone returns the closest [Zone] of a server for a name
+func (tn *testIterNet) getZone(server, qName string) *Zone {
+	var best *Zone
+	for _, z := range tn.zones[server] {
+		if z.Contains(qName) && (best == nil || len(z.Origin()) > len(best.Origin())) {
+			best = z
+		}
+	}
+	return best
+}
+
+// newTestIterNet creates a [testIterNet] from the records of the
+// zones served by each address, each starting with its SOA.
+func newTestIterNet(t *testing.T, servers map[string][]string) *testIterNet {
+	tn := &testIterNet{
+		zones:   make(map[string][]*Zone),
+		queries: make(map[string]int),
+	}
+
+	for addr, records := range servers {
+		var z *Zone
+		for _, s := range records {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rr.Header().Rrtype == dns.TypeSOA {
+				z, err = NewZone(rr.Header().Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tn.zones[addr] = append(tn.zones[addr], z)
+			}
+
+			if err := z.Add(rr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return tn
+}
+
+// newTestIterator creates an [IteratorLookuper] iterating a
+// [testIterNet], starting at [testIterRoot], and without IPv6.
+func newTestIterator(t *testing.T, tn *testIterNet) *IteratorLookuper {
+	var c client.Client = tn
+
+	r := NewIteratorLookuper("test", 0, c)
+	r.SetIPv6(false)
+	if err := r.AddServer(".", 0, testIterRoot); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// testIterZones is a small internet with a CNAME loop, delegations
+// without glue, one of them to a nameserver that doesn't exist,
+// nameservers depending on each other, and a delegation two
+// levels deep.
+var testIterZones = map[string][]string{
+	testIterRoot: {
+		". 86400 IN SOA a.root. hostmaster.root. 1 1800 900 604800 86400",
+		"org. 86400 IN NS ns.org.",
+		"ns.org. 86400 IN A 192.0.2.2",
+		"net. 86400 IN NS ns.net.",
+		"ns.net. 86400 IN A 192.0.2.3",
+	},
+	"192.0.2.2": {
+		"org. 3600 IN SOA ns.org. hostmaster.org. 1 7200 3600 1209600 300",
+		"www.org. 3600 IN A 192.0.2.10",
+		"alias.org. 3600 IN CNAME www.net.",
+		"loop.org. 3600 IN CNAME loop.net.",
+		"sub.org. 3600 IN NS ns.sub.org.",
+		"ns.sub.org. 3600 IN A 192.0.2.4",
+		"noglue.org. 3600 IN NS ns1.noglue.net.",
+		"noglue.org. 3600 IN NS ns2.noglue.net.",
+		"bad1.org. 3600 IN NS ns.missing.net.",
+		"bad1.org. 3600 IN NS ns.bad.net.",
+		"bad2.org. 3600 IN NS ns.missing.net.",
+		"bad2.org. 3600 IN NS ns.bad.net.",
+		"cyc1.org. 3600 IN NS ns.cyc2.net.",
+	},
+	"192.0.2.3": {
+		"net. 3600 IN SOA ns.net. hostmaster.net. 1 7200 3600 1209600 300",
+		"www.net. 3600 IN A 192.0.2.11",
+		"loop.net. 3600 IN CNAME loop.org.",
+		"ns1.noglue.net. 3600 IN A 192.0.2.5",
+		"ns2.noglue.net. 3600 IN A 192.0.2.5",
+		"ns.bad.net. 3600 IN A 192.0.2.6",
+		"cyc2.net. 3600 IN NS ns.cyc1.org.",
+	},
+	"192.0.2.4": {
+		"sub.org. 3600 IN SOA ns.sub.org. hostmaster.sub.org. 1 7200 3600 1209600 300",
+		"www.sub.org. 3600 IN A 192.0.2.12",
+	},
+	"192.0.2.5": {
+		"noglue.org. 3600 IN SOA ns1.noglue.net. hostmaster.noglue.org. 1 7200 3600 1209600 300",
+		"www.noglue.org. 3600 IN A 192.0.2.13",
+	},
+	"192.0.2.6": {
+		"bad1.org. 3600 IN SOA ns.bad.net. hostmaster.bad1.org. 1 7200 3600 1209600 300",
+		"www.bad1.org. 3600 IN A 192.0.2.14",
+		"bad2.org. 3600 IN SOA ns.bad.net. hostmaster.bad2.org. 1 7200 3600 1209600 300",
+		"www.bad2.org. 3600 IN A 192.0.2.15",
+	},
+}
+
 func TestRootLookup(t *testing.T) {
 	root, err := NewRootLookuper("")
 	if err != nil {
@@ -63,3 +231,140 @@ func testRootTypeA(t *testing.T, h Lookuper, name, address string) {
 
 	t.Logf("%s: %s", name, first)
 }
+
+func TestIteratorTimeouts(t *testing.T) {
+	r := NewIteratorLookuper("test", 0, nil)
+
+	defaults := IteratorTimeouts{
+		Glue:     DefaultIteratorGlueTimeout,
+		Referral: DefaultIteratorReferralTimeout,
+		Query:    DefaultIteratorQueryTimeout,
+	}
+	if got := r.Timeouts(); got != defaults {
+		t.Errorf("expected defaults %+v, got %+v", defaults, got)
+	}
+
+	custom := IteratorTimeouts{
+		Glue:     100 * time.Millisecond,
+		Referral: 200 * time.Millisecond,
+		Query:    300 * time.Millisecond,
+	}
+	r.SetTimeouts(custom)
+	if got := r.Timeouts(); got != custom {
+		t.Errorf("expected %+v, got %+v", custom, got)
+	}
+
+	// without deadline, the query timeout applies
+	ctx, cancel := r.newQueryContext(context.Background(), "example.org.")
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > custom.Query {
+		t.Errorf("unexpected query deadline %v", deadline)
+	}
+
+	// sub-queries keep the context of their query
+	if ctx2, cancel2 := r.newQueryContext(ctx, "ns.example.org."); ctx2 != ctx {
+		t.Error("sub-query got a new context")
+	} else {
+		cancel2()
+	}
+
+	// the caller's deadline is preferred
+	ctx3, cancel3 := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel3()
+
+	ctx4, cancel4 := r.newQueryContext(ctx3, "example.org.")
+	defer cancel4()
+
+	if deadline, _ := ctx4.Deadline(); time.Until(deadline) < time.Minute {
+		t.Errorf("caller's deadline replaced by %v", deadline)
+	}
+}
+
+func TestIteratorTimeoutsSlowUpstream(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts IteratorTimeouts
+	}{
+		{"query", IteratorTimeouts{Query: 50 * time.Millisecond}},
+		{"referral", IteratorTimeouts{Referral: 50 * time.Millisecond}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// the .org servers never answer
+			tn := newTestIterNet(t, testIterZones)
+			tn.Hook = func(ctx context.Context, server string, _ dns.Question) error {
+				if server == "192.0.2.2" {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				return nil
+			}
+
+			r := newTestIterator(t, tn)
+			r.SetTimeouts(tc.timeouts)
+
+			start := time.Now()
+			_, err := r.Lookup(context.Background(), "www.org.", dns.TypeA)
+			elapsed := time.Since(start)
+
+			switch {
+			case err == nil:
+				t.Error("slow upstream answered")
+			case elapsed > DefaultIteratorDeadline/2:
+				t.Errorf("cut off after %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestAddRootServersIPv6(t *testing.T) {
+	root := testIterZones[testIterRoot]
+
+	// the root zone is only reachable over IPv6
+	servers := make(map[string][]string)
+	for addr, records := range testIterZones {
+		if addr != testIterRoot {
+			servers[addr] = records
+		}
+	}
+	for _, addr := range roots6 {
+		servers[addr] = root
+	}
+	tn := newTestIterNet(t, servers)
+
+	for _, ipv6 := range []bool{true, false} {
+		r := NewIteratorLookuper("test", 0, tn)
+		r.SetIPv6(ipv6)
+		r.SetResilience(-1, time.Second, 0)
+		if err := r.AddRootServers(); err != nil {
+			t.Fatal(err)
+		}
+
+		zone, _, ok := r.nsc.Get(".")
+		if !ok {
+			t.Fatal("root zone not loaded")
+		}
+
+		var n int
+		for _, s := range zone.Addrs() {
+			if netip.MustParseAddr(s).Is6() {
+				n++
+			}
+		}
+
+		_, err := r.Lookup(context.Background(), "www.org.", dns.TypeA)
+		switch {
+		case ipv6 && n != len(roots6):
+			t.Errorf("%v of %v IPv6 root hints loaded", n, len(roots6))
+		case ipv6 && err != nil:
+			t.Errorf("IPv6 root servers not used: %v", err)
+		case !ipv6 && n != 0:
+			t.Errorf("%v IPv6 root hints loaded while disabled", n)
+		case !ipv6 && err == nil:
+			t.Error("IPv6 root servers used while disabled")
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*LocalRecords)(nil)
+	_ Exchanger = (*LocalRecords)(nil)
+)
+
+// DefaultLocalTTL is the TTL given to the records of
+// [LocalRecords] if not specified.
+const DefaultLocalTTL = 60
+
+// LocalRecords is an [Exchanger] answering authoritatively for a set
+// of individual names, like the hosts of containers and VMs, without
+// needing a full [Zone], and passing everything else to the next
+// [Exchanger]. Records can be added and removed at runtime.
+type LocalRecords struct {
+	next Exchanger
+
+	mu    sync.RWMutex
+	nodes map[string][]dns.RR
+}
+
+// Lookup implements the [Lookuper] interface.
+func (lr *LocalRecords) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return lr.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface. Requests for known
+// names are answered authoritatively, following aliases between them,
+// and unknown names are passed to the next [Exchanger].
+func (lr *LocalRecords) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	q := msgQuestion(req)
+	if ctx == nil || q == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	if q.Qclass == dns.ClassINET {
+		if resp, ok := lr.answer(req, *q); ok {
+			return resp, nil
+		}
+	}
+
+	return lr.next.Exchange(ctx, req)
+}
+
+func (lr *LocalRecords) answer(req *dns.Msg, q dns.Question) (*dns.Msg, bool) {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	records, ok := lr.nodes[dns.CanonicalName(q.Name)]
+	if !ok {
+		return nil, false
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	qName := q.Name
+	for i := 0; ok && i <= ZoneMaxCNAME; i++ {
+		qName, ok = lr.unsafeAnswer(resp, qName, records, q.Qtype)
+		if ok {
+			records, ok = lr.nodes[dns.CanonicalName(qName)]
+		}
+	}
+
+	return resp, true
+}
+
+// unsafeAnswer adds the matching records to the response, returning
+// the target to follow if it's an alias.
+func (*LocalRecords) unsafeAnswer(resp *dns.Msg, qName string,
+	records []dns.RR, qType uint16) (string, bool) {
+	//
+	var answer []dns.RR
+	for _, rr := range records {
+		if qType == dns.TypeANY || rr.Header().Rrtype == qType {
+			answer = append(answer, zoneCopyRR(rr, qName))
+		}
+	}
+
+	if len(answer) == 0 && qType != dns.TypeCNAME {
+		if cname, ok := exdns.GetFirstRR[*dns.CNAME](records); ok {
+			resp.Answer = append(resp.Answer, zoneCopyRR(cname, qName))
+			return cname.Target, true
+		}
+	}
+
+	resp.Answer = append(resp.Answer, answer...)
+	return "", false
+}
+
+// Add adds records of class INET. Names holding a CNAME can't
+// have other records.
+func (lr *LocalRecords) Add(records ...dns.RR) error {
+	for _, rr := range records {
+		if rr == nil || rr.Header().Class != dns.ClassINET {
+			return core.Wrap(core.ErrInvalid, "invalid record")
+		}
+	}
+
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	for _, rr := range records {
+		if err := lr.unsafeAdd(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lr *LocalRecords) unsafeAdd(rr dns.RR) error {
+	rr = dns.Copy(rr)
+	hdr := rr.Header()
+	hdr.Name = dns.CanonicalName(hdr.Name)
+
+	records := lr.nodes[hdr.Name]
+	for _, old := range records {
+		switch {
+		case dns.IsDuplicate(old, rr):
+			return nil
+		case hdr.Rrtype == dns.TypeCNAME, old.Header().Rrtype == dns.TypeCNAME:
+			return core.Wrapf(core.ErrExists, "%q: CNAME and other data", hdr.Name)
+		}
+	}
+
+	if lr.nodes == nil {
+		lr.nodes = make(map[string][]dns.RR)
+	}
+	lr.nodes[hdr.Name] = append(records, rr)
+	return nil
+}
+
+// AddHost adds A and AAAA records for a name, and the PTR records
+// of its addresses, like a hosts file.
+func (lr *LocalRecords) AddHost(name string, addrs ...netip.Addr) error {
+	name = dns.Fqdn(name)
+
+	records := make([]dns.RR, 0, 2*len(addrs))
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+
+		rr, ok := newGlueRR(name, DefaultLocalTTL, addr)
+		if !ok {
+			return core.Wrap(core.ErrInvalid, "invalid address")
+		}
+
+		arpa, err := dns.ReverseAddr(addr.String())
+		if err != nil {
+			return err
+		}
+
+		records = append(records, rr, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   arpa,
+				Class:  dns.ClassINET,
+				Rrtype: dns.TypePTR,
+				Ttl:    DefaultLocalTTL,
+			},
+			Ptr: name,
+		})
+	}
+
+	return lr.Add(records...)
+}
+
+// AddMap adds records given as a map of names to lists of values.
+// Values are either IP addresses, handled as in [LocalRecords.AddHost],
+// or the type and data of a record in master file format, optionally
+// preceded by a TTL, like `TXT "hello"`, `CNAME www.example.org.`
+// or `300 PTR host.example.org.`.
+func (lr *LocalRecords) AddMap(m map[string][]string) error {
+	for name, values := range m {
+		for _, s := range values {
+			if err := lr.addValue(name, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (lr *LocalRecords) addValue(name, value string) error {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return lr.AddHost(name, addr)
+	}
+
+	ttl := DefaultLocalTTL
+	if first, rest, ok := strings.Cut(value, " "); ok {
+		if n, err := strconv.ParseUint(first, 10, 32); err == nil {
+			ttl, value = int(n), rest
+		}
+	}
+
+	rr, err := dns.NewRR(dns.Fqdn(name) + " " + strconv.Itoa(ttl) + " IN " + value)
+	switch {
+	case err != nil:
+		return core.Wrapf(err, "%q: invalid record", name)
+	case rr == nil:
+		return core.Wrapf(core.ErrInvalid, "%q: empty record", name)
+	default:
+		return lr.Add(rr)
+	}
+}
+
+// Remove removes all the records of the given names,
+// and the PTR records pointing to them.
+func (lr *LocalRecords) Remove(names ...string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	for _, name := range names {
+		name = dns.CanonicalName(name)
+		delete(lr.nodes, name)
+
+		for owner, records := range lr.nodes {
+			records = exdns.TrimRR(records, func(rr dns.RR) bool {
+				ptr, ok := rr.(*dns.PTR)
+				return ok && dns.CanonicalName(ptr.Ptr) == name
+			})
+
+			if len(records) == 0 {
+				delete(lr.nodes, owner)
+			} else {
+				lr.nodes[owner] = records
+			}
+		}
+	}
+}
+
+// Names returns the names with records.
+func (lr *LocalRecords) Names() []string {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	names := make([]string, 0, len(lr.nodes))
+	for name := range lr.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewLocalRecords creates a [LocalRecords] [Exchanger] passing
+// requests for unknown names to the given [Exchanger].
+func NewLocalRecords(next Exchanger) (*LocalRecords, error) {
+	if next == nil {
+		return nil, core.ErrInvalid
+	}
+
+	return &LocalRecords{
+		next:  next,
+		nodes: make(map[string][]dns.RR),
+	}, nil
+}
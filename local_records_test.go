@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLocalRecords(t *testing.T) {
+	lr, err := NewLocalRecords(newTestZone(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = lr.AddMap(map[string][]string{
+		"web.local":       {"10.0.0.2", "fd00::2"},
+		"db.local":        {"10.0.0.3", `TXT "primary"`},
+		"alias.local":     {"300 CNAME web.local."},
+		"www.example.org": {"10.0.0.4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		qType  uint16
+		rcode  int
+		answer []string
+	}{
+		{"web.local", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.2"}},
+		{"WEB.local", dns.TypeAAAA, dns.RcodeSuccess, []string{"fd00::2"}},
+		{"web.local", dns.TypeTXT, dns.RcodeSuccess, nil},
+		{"db.local", dns.TypeTXT, dns.RcodeSuccess, []string{"primary"}},
+		{"alias.local", dns.TypeA, dns.RcodeSuccess, []string{"web.local.", "10.0.0.2"}},
+		{"2.0.0.10.in-addr.arpa", dns.TypePTR, dns.RcodeSuccess, []string{"web.local."}},
+		{"www.example.org", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.4"}},
+		{"ns.example.org", dns.TypeA, dns.RcodeSuccess, []string{"192.0.2.1"}},
+		{"missing.example.org", dns.TypeA, dns.RcodeNameError, nil},
+	}
+
+	for _, tc := range tests {
+		msg, err := lr.Lookup(context.Background(), tc.name, tc.qType)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		answer := testRecordsData(msg.Answer)
+		if msg.Rcode != tc.rcode || !equalStrings(answer, tc.answer) {
+			t.Errorf("%s/%s: unexpected response %s %q", tc.name,
+				dns.TypeToString[tc.qType], dns.RcodeToString[msg.Rcode], answer)
+		}
+	}
+
+	if err := lr.AddMap(map[string][]string{"alias.local": {"10.0.0.5"}}); err == nil {
+		t.Error("CNAME and other data accepted")
+	}
+	if err := lr.AddHost("bad.local", netip.Addr{}); err == nil {
+		t.Error("invalid address accepted")
+	}
+
+	lr.Remove("web.local")
+	msg, err := lr.Lookup(context.Background(), "2.0.0.10.in-addr.arpa", dns.TypePTR)
+	if err == nil && len(msg.Answer) > 0 {
+		t.Errorf("PTR kept after removal: %v", msg.Answer)
+	}
+
+	names := lr.Names()
+	if len(names) != 5 {
+		t.Errorf("unexpected names: %v", names)
+	}
+
+	if _, err := NewLocalRecords(nil); err == nil {
+		t.Error("nil exchanger accepted")
+	}
+}
+
+func testRecordsData(records []dns.RR) []string {
+	var out []string
+	for _, rr := range records {
+		switch v := rr.(type) {
+		case *dns.A:
+			out = append(out, v.A.String())
+		case *dns.AAAA:
+			out = append(out, v.AAAA.String())
+		case *dns.TXT:
+			out = append(out, v.Txt...)
+		case *dns.CNAME:
+			out = append(out, v.Target)
+		case *dns.PTR:
+			out = append(out, v.Ptr)
+		case *dns.NS:
+			out = append(out, v.Ns)
+		}
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,287 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*MDNSLookuper)(nil)
+	_ Exchanger = (*MDNSLookuper)(nil)
+)
+
+// DefaultMDNSTimeout is how long [MDNSLookuper] waits for
+// answers if not specified.
+const DefaultMDNSTimeout = time.Second
+
+var (
+	mdnsGroupIPv4 = net.IPv4(224, 0, 0, 251)
+	mdnsGroupIPv6 = net.ParseIP("ff02::fb")
+)
+
+// mdnsDomains are the names resolved using multicast DNS,
+// RFC 6762 section 3 and 4.
+var mdnsDomains = []string{
+	"local.",
+	"254.169.in-addr.arpa.",
+	"8.e.f.ip6.arpa.",
+	"9.e.f.ip6.arpa.",
+	"a.e.f.ip6.arpa.",
+	"b.e.f.ip6.arpa.",
+}
+
+// IsMDNSName tells if a name is resolved using multicast DNS,
+// being under .local or the reverse of a link-local address.
+func IsMDNSName(name string) bool {
+	name = dns.CanonicalName(name)
+	for _, domain := range mdnsDomains {
+		if dns.IsSubDomain(domain, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MDNSLookuper resolves .local and link-local reverse names using
+// multicast DNS one-shot queries, RFC 6762 section 5.1, taking the
+// first answer received. Other names are passed to the next
+// [Exchanger], if any, or refused.
+type MDNSLookuper struct {
+	next Exchanger
+
+	// groups overrides the destinations of the queries.
+	groups []*net.UDPAddr
+
+	// Timeout is how long to wait for answers.
+	// [DefaultMDNSTimeout] is used if zero.
+	Timeout time.Duration
+}
+
+// Lookup resolves a name using multicast DNS.
+func (r *MDNSLookuper) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return r.Exchange(ctx, req)
+}
+
+// Exchange resolves the request using multicast DNS if the name
+// qualifies, or passes it to the next [Exchanger].
+func (r *MDNSLookuper) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if ctx == nil || req == nil || len(req.Question) != 1 {
+		return nil, errors.ErrBadRequest()
+	}
+
+	q := req.Question[0]
+	switch {
+	case IsMDNSName(q.Name):
+		return r.query(ctx, req)
+	case r.next != nil:
+		return r.next.Exchange(ctx, req)
+	default:
+		return nil, errors.ErrRefused(q.Name)
+	}
+}
+
+func (r *MDNSLookuper) query(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	q := req.Question[0]
+	qName := dns.CanonicalName(q.Name)
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultMDNSTimeout
+	}
+
+	ctx2, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(qName, q.Qtype)
+	msg.RecursionDesired = false
+
+	conns, err := r.send(msg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+
+	ch := make(chan *dns.Msg, len(conns))
+	for _, c := range conns {
+		go mdnsReceive(ctx2, c, msg, ch)
+	}
+
+	select {
+	case resp := <-ch:
+		return mdnsReply(req, resp), nil
+	case <-ctx2.Done():
+		if err := ctx.Err(); err != nil {
+			return nil, errors.ErrTimeout(qName, err)
+		}
+		// nobody answered
+		return nil, errors.ErrNotFound(qName)
+	}
+}
+
+// send sends the query to the multicast groups from ephemeral
+// ports, one per address family, so responders answer directly.
+func (r *MDNSLookuper) send(msg *dns.Msg) ([]*net.UDPConn, error) {
+	wire, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	var sent int
+	conns := make(map[string]*net.UDPConn)
+	for _, dst := range r.destinations() {
+		network := "udp6"
+		if dst.IP.To4() != nil {
+			network = "udp4"
+		}
+
+		c, ok := conns[network]
+		if !ok {
+			c, err = net.ListenUDP(network, nil)
+			if err != nil {
+				firstErr = core.Coalesce(firstErr, err)
+				continue
+			}
+			conns[network] = c
+		}
+
+		if _, err := c.WriteToUDP(wire, dst); err != nil {
+			firstErr = core.Coalesce(firstErr, err)
+		} else {
+			sent++
+		}
+	}
+
+	out := make([]*net.UDPConn, 0, len(conns))
+	for _, c := range conns {
+		if sent == 0 {
+			_ = c.Close()
+		} else {
+			out = append(out, c)
+		}
+	}
+
+	if sent == 0 {
+		if firstErr == nil {
+			firstErr = errors.ErrInternalError(msg.Question[0].Name, "")
+		}
+		return nil, firstErr
+	}
+	return out, nil
+}
+
+// destinations returns the multicast groups to query, IPv4 and
+// IPv6 on each interface that is up and supports multicast.
+func (r *MDNSLookuper) destinations() []*net.UDPAddr {
+	if len(r.groups) > 0 {
+		return r.groups
+	}
+
+	out := []*net.UDPAddr{{IP: mdnsGroupIPv4, Port: 5353}}
+
+	ifaces, _ := net.Interfaces()
+	for _, ifi := range ifaces {
+		const flags = net.FlagUp | net.FlagMulticast
+		if ifi.Flags&flags == flags && ifi.Flags&net.FlagLoopback == 0 {
+			out = append(out, &net.UDPAddr{
+				IP:   mdnsGroupIPv6,
+				Port: 5353,
+				Zone: ifi.Name,
+			})
+		}
+	}
+	return out
+}
+
+// mdnsReceive waits for the first response answering the query.
+func mdnsReceive(ctx context.Context, c *net.UDPConn, req *dns.Msg, out chan<- *dns.Msg) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.SetReadDeadline(deadline)
+	}
+
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, _, err := c.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		resp := new(dns.Msg)
+		if resp.Unpack(buf[:n]) != nil || !mdnsAnswers(req, resp) {
+			continue
+		}
+
+		out <- resp
+		return
+	}
+}
+
+// mdnsAnswers tells if a response answers the query. Responders
+// echo the ID of one-shot queries, but may also use zero.
+func mdnsAnswers(req, resp *dns.Msg) bool {
+	if !resp.Response || (resp.Id != req.Id && resp.Id != 0) {
+		return false
+	}
+
+	q := req.Question[0]
+	for _, rr := range resp.Answer {
+		if mdnsMatches(q, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+func mdnsMatches(q dns.Question, rr dns.RR) bool {
+	hdr := rr.Header()
+	switch {
+	case !strings.EqualFold(hdr.Name, q.Name):
+		return false
+	case q.Qtype == dns.TypeANY, hdr.Rrtype == q.Qtype:
+		return true
+	default:
+		return hdr.Rrtype == dns.TypeCNAME
+	}
+}
+
+// mdnsReply assembles a reply to the original request from the
+// response of the responder, clearing the cache-flush bit.
+func mdnsReply(req, resp *dns.Msg) *dns.Msg {
+	out := new(dns.Msg)
+	out.SetReply(req)
+	out.Authoritative = true
+
+	for _, rr := range resp.Answer {
+		rr.Header().Class &^= 1 << 15
+		out.Answer = append(out.Answer, rr)
+	}
+
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			rr.Header().Class &^= 1 << 15
+			out.Extra = append(out.Extra, rr)
+		}
+	}
+	return out
+}
+
+// NewMDNSLookuper creates a [MDNSLookuper] passing names not
+// resolved by multicast DNS to the given [Exchanger], if any.
+func NewMDNSLookuper(next Exchanger) (*MDNSLookuper, error) {
+	return &MDNSLookuper{next: next}, nil
+}
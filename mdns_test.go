@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestIsMDNSName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"printer.local", true},
+		{"Printer.LOCAL.", true},
+		{"local.example.org.", false},
+		{"5.1.254.169.in-addr.arpa.", true},
+		{"5.1.168.192.in-addr.arpa.", false},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa.", true},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.c.e.f.ip6.arpa.", false},
+	}
+
+	for _, tc := range tests {
+		if got := IsMDNSName(tc.name); got != tc.want {
+			t.Errorf("%q: got %v, expected %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// newTestMDNSResponder answers A queries for printer.local from
+// a loopback socket, like a responder answering a one-shot query.
+func newTestMDNSResponder(t *testing.T) *net.UDPAddr {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, addr, err := c.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			req := new(dns.Msg)
+			if req.Unpack(buf[:n]) != nil || len(req.Question) != 1 ||
+				req.Question[0].Name != "printer.local." {
+				continue
+			}
+
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			resp.Authoritative = true
+			rr, _ := dns.NewRR("printer.local. 120 IN A 192.0.2.10")
+			rr.Header().Class |= 1 << 15 // cache-flush
+			resp.Answer = append(resp.Answer, rr)
+
+			wire, _ := resp.Pack()
+			_, _ = c.WriteToUDP(wire, addr)
+		}
+	}()
+
+	addr, _ := c.LocalAddr().(*net.UDPAddr)
+	return addr
+}
+
+func TestMDNSLookuper(t *testing.T) {
+	next := ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeRefused)
+		return resp, nil
+	})
+
+	r, err := NewMDNSLookuper(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.groups = []*net.UDPAddr{newTestMDNSResponder(t)}
+	r.Timeout = 200 * time.Millisecond
+
+	ctx := context.Background()
+	resp, err := r.Lookup(ctx, "PRINTER.local", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case len(resp.Answer) != 1:
+		t.Fatalf("unexpected answer: %v", resp.Answer)
+	case resp.Answer[0].Header().Class != dns.ClassINET:
+		t.Errorf("cache-flush bit not cleared: %v", resp.Answer[0])
+	case resp.Answer[0].(*dns.A).A.String() != "192.0.2.10":
+		t.Errorf("unexpected address: %v", resp.Answer[0])
+	}
+
+	_, err = r.Lookup(ctx, "scanner.local", dns.TypeA)
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected NXDOMAIN, got %v", err)
+	}
+
+	resp, err = r.Lookup(ctx, "example.org", dns.TypeA)
+	if err != nil || resp.Rcode != dns.RcodeRefused {
+		t.Errorf("expected next exchanger to be used, got %v %v", resp, err)
+	}
+}
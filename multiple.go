@@ -2,6 +2,7 @@ package resolver
 
 import (
 	"context"
+	"net"
 
 	"darvaza.org/core"
 	"github.com/miekg/dns"
@@ -13,6 +14,10 @@ import (
 // and takes the first non-error answer
 type MultiLookuper struct {
 	m []Lookuper
+
+	// Merge indicates the answers of all successful Lookupers
+	// are to be combined instead of taking the first.
+	Merge bool
 }
 
 // Lookup queries all Lookupers in parallel and returns the
@@ -20,61 +25,96 @@ type MultiLookuper struct {
 func (r MultiLookuper) Lookup(ctx context.Context,
 	qName string, qType uint16) (*dns.Msg, error) {
 	//
-	var wg core.WaitGroup
+	if r.Merge {
+		return r.lookupMerge(ctx, qName, qType)
+	}
 
+	// on return all others are cancelled
 	ctx2, cancel := context.WithCancel(ctx)
-	done := make(chan struct{})
-	ch := make(chan *dns.Msg)
-
-	// on success done will be closed and we cancel
-	// all others
-	go func() {
-		<-done
-		cancel()
-	}()
-
-	for i := range r.m {
-		h := r.m[i]
-		wg.Go(func() error {
-			return r.lookupOne(ctx2, h, ch,
-				qName, qType)
-		})
+	defer cancel()
+
+	// buffered so late workers never block
+	ch := make(chan multiResult, len(r.m))
+	for _, h := range r.m {
+		go func(h Lookuper) {
+			msg, err := h.Lookup(ctx2, qName, qType)
+			ch <- multiResult{msg, err}
+		}(h)
 	}
 
-	// if all workers finished unsuccessfully, free the waiter
-	go func() {
-		_ = wg.Wait()
-		close(ch)
-	}()
-
 	// wait for a response
-	first := <-ch
-	close(done)
+	errs := make([]error, 0, len(r.m))
+	for range r.m {
+		select {
+		case res := <-ch:
+			if res.err == nil && res.msg != nil {
+				// good
+				return res.msg, nil
+			}
+			errs = append(errs, res.err)
+		case <-ctx.Done():
+			return nil, errors.ErrTimeout(qName, ctx.Err())
+		}
+	}
+
+	return nil, multiError(qName, errs)
+}
+
+// multiResult is the outcome of a sub-lookup
+type multiResult struct {
+	msg *dns.Msg
+	err error
+}
+
+// multiError assembles the error of a [MultiLookuper] when none
+// answered. If all agree the name or type don't exist, that is
+// reported. Otherwise all errors are returned in a [core.CompoundError].
+func multiError(qName string, errs []error) error {
+	var out core.CompoundError
 
-	if first != nil {
-		// good
-		return first, nil
+	for _, err := range errs {
+		if err == nil {
+			// no error but no answer either
+			err = errors.ErrTimeoutMessage(qName, errors.NOANSWER)
+		}
+		out.AppendError(err)
 	}
 
-	// first reason
-	err := wg.Err()
-	if err == nil {
-		// none? make one up
-		err = errors.ErrTimeoutMessage(qName, errors.NOANSWER)
+	switch {
+	case len(out.Errs) == 0:
+		return errors.ErrTimeoutMessage(qName, errors.NOANSWER)
+	case len(out.Errs) == 1:
+		return out.Errs[0]
+	case allNotFound(out.Errs):
+		return firstNotFound(out.Errs)
+	default:
+		return out.AsError()
 	}
-	return nil, err
 }
 
-func (MultiLookuper) lookupOne(ctx context.Context,
-	h Lookuper, out chan<- *dns.Msg,
-	qName string, qType uint16,
-) error {
-	//
-	msg, err := h.Lookup(ctx, qName, qType)
-	if err == nil && msg != nil {
-		out <- msg
+// firstNotFound returns the first NODATA error, as the name exists
+// somewhere, or the first NXDOMAIN otherwise.
+func firstNotFound(errs []error) error {
+	for _, err := range errs {
+		if isNoData(err) {
+			return err
+		}
+	}
+	return errs[0]
+}
+
+func isNoData(err error) bool {
+	e, ok := err.(*net.DNSError)
+	return ok && e.Err == errors.NODATA
+}
+
+func allNotFound(errs []error) bool {
+	for _, err := range errs {
+		if !errors.IsNotFound(err) {
+			return false
+		}
 	}
-	return err
+	return true
 }
 
 // NewMultiLookuper creates a new Multilookuper using the
@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// lookupMerge queries all Lookupers in parallel and combines
+// their answers.
+func (r MultiLookuper) lookupMerge(ctx context.Context,
+	qName string, qType uint16) (*dns.Msg, error) {
+	//
+	var wg sync.WaitGroup
+
+	msgs := make([]*dns.Msg, len(r.m))
+	errs := make([]error, len(r.m))
+	for i, h := range r.m {
+		wg.Add(1)
+		go func(i int, h Lookuper) {
+			defer wg.Done()
+			msgs[i], errs[i] = h.Lookup(ctx, qName, qType)
+		}(i, h)
+	}
+	wg.Wait()
+
+	var ok []*dns.Msg
+	for i, msg := range msgs {
+		if errs[i] == nil && msg != nil {
+			ok = append(ok, msg)
+		}
+	}
+
+	if len(ok) > 0 {
+		return mergeAnswers(ok), nil
+	}
+
+	return nil, multiError(qName, errs)
+}
+
+// mergeAnswers combines the answers of many responses into a
+// copy of the first, removing duplicates and using the lowest
+// TTL of each RRset.
+func mergeAnswers(msgs []*dns.Msg) *dns.Msg {
+	out := msgs[0].Copy()
+	for _, msg := range msgs[1:] {
+		for _, rr := range msg.Answer {
+			if !hasDuplicateRR(out.Answer, rr) {
+				out.Answer = append(out.Answer, dns.Copy(rr))
+			}
+		}
+	}
+
+	if len(msgs) > 1 {
+		setRRsetMinTTL(out.Answer)
+	}
+	return out
+}
+
+func hasDuplicateRR(records []dns.RR, rr dns.RR) bool {
+	for _, rr2 := range records {
+		if dns.IsDuplicate(rr, rr2) {
+			return true
+		}
+	}
+	return false
+}
+
+// rrsetKey identifies the RRset a record belongs to
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+	class  uint16
+}
+
+func newRRsetKey(rr dns.RR) rrsetKey {
+	hdr := rr.Header()
+	return rrsetKey{
+		name:   dns.CanonicalName(hdr.Name),
+		rrtype: hdr.Rrtype,
+		class:  hdr.Class,
+	}
+}
+
+// setRRsetMinTTL makes all records of each RRset use the lowest TTL
+// among them.
+func setRRsetMinTTL(records []dns.RR) {
+	ttl := make(map[rrsetKey]uint32)
+	for _, rr := range records {
+		k := newRRsetKey(rr)
+		if v, ok := ttl[k]; !ok || rr.Header().Ttl < v {
+			ttl[k] = rr.Header().Ttl
+		}
+	}
+
+	for _, rr := range records {
+		rr.Header().Ttl = ttl[newRRsetKey(rr)]
+	}
+}
@@ -0,0 +1,99 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func newTestMultiStep(ttl uint32, addrs ...net.IP) Lookuper {
+	return ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		for _, ip := range addrs {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   ip,
+			})
+		}
+		return resp, nil
+	})
+}
+
+func TestMultiLookuperMerge(t *testing.T) {
+	r := NewMultiLookuper(
+		newTestMultiStep(300, net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2)),
+		newTestMultiStep(60, net.IPv4(192, 0, 2, 2), net.IPv4(192, 0, 2, 3)),
+	)
+	r.Merge = true
+
+	resp, err := r.Lookup(context.Background(), "example.org.", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Answer) != 3 {
+		t.Fatalf("unexpected answer: %v", resp.Answer)
+	}
+
+	for _, rr := range resp.Answer {
+		if rr.Header().Ttl != 60 {
+			t.Errorf("unexpected TTL: %v", rr)
+		}
+	}
+}
+
+func newTestMultiError(err error) Lookuper {
+	return LookuperFunc(func(context.Context, string, uint16) (*dns.Msg, error) {
+		return nil, err
+	})
+}
+
+func TestMultiLookuperErrors(t *testing.T) {
+	nx := errors.ErrNotFound("example.org.")
+	nodata := errors.ErrTypeNotFound("example.org.")
+	timeout := errors.ErrTimeout("example.org.", nil)
+
+	r := NewMultiLookuper(newTestMultiError(nx), newTestMultiError(nx))
+	if _, err := r.Lookup(context.Background(), "example.org.", dns.TypeA); !errors.IsNotFound(err) {
+		t.Errorf("NXDOMAIN expected, got %v", err)
+	}
+
+	r = NewMultiLookuper(newTestMultiError(nx), newTestMultiError(nodata))
+	if _, err := r.Lookup(context.Background(), "example.org.", dns.TypeA); err != nodata {
+		t.Errorf("NODATA expected, got %v", err)
+	}
+
+	r = NewMultiLookuper(newTestMultiError(nx), newTestMultiError(timeout))
+	_, err := r.Lookup(context.Background(), "example.org.", dns.TypeA)
+	if ce, ok := err.(*core.CompoundError); !ok || len(ce.Errs) != 2 {
+		t.Errorf("compound error expected, got %v", err)
+	}
+}
+
+func TestMultiLookuperCancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	slow := LookuperFunc(func(ctx context.Context, _ string, _ uint16) (*dns.Msg, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+
+	r := NewMultiLookuper(slow, newTestMultiStep(60, net.IPv4(192, 0, 2, 1)))
+	if _, err := r.Lookup(context.Background(), "example.org.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("slow lookup not cancelled")
+	}
+}
@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// NewNetResolver returns a [net.Resolver] whose connections are
+// served in-process by the given [Lookuper], so libraries only
+// accepting a [net.Resolver] can use any chain built with this
+// package. Lookupers that don't implement [Exchanger] are only
+// given the name and type of the requests.
+func NewNetResolver(h Lookuper) (*net.Resolver, error) {
+	var e Exchanger
+	switch v := h.(type) {
+	case nil:
+		return nil, core.Wrap(core.ErrInvalid, "lookuper required")
+	case Exchanger:
+		e = v
+	default:
+		e = LookuperFunc(h.Lookup)
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     NewExchangerDialer(e),
+	}, nil
+}
+
+// NewExchangerDialer returns a [DialerFunc] ignoring the network and
+// address, and connecting instead to an in-process DNS server backed
+// by the given [Exchanger] over a [net.Pipe], using the TCP framing.
+// Requests are served until the connection is closed.
+func NewExchangerDialer(e Exchanger) DialerFunc {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		client, server := net.Pipe()
+		go servePipe(ctx, e, server)
+		return client, nil
+	}
+}
+
+func servePipe(ctx context.Context, e Exchanger, conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		req, err := readPipeMsg(conn)
+		if err != nil {
+			return
+		}
+
+		resp := exchangePipeMsg(ctx, e, req)
+		if resp == nil || writePipeMsg(conn, resp) != nil {
+			return
+		}
+	}
+}
+
+func exchangePipeMsg(ctx context.Context, e Exchanger, req *dns.Msg) *dns.Msg {
+	if len(req.Question) != 1 {
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeFormatError)
+		return resp
+	}
+
+	resp, err := e.Exchange(ctx, req)
+	if err != nil || resp == nil {
+		resp = errors.ErrorAsMsg(req, err)
+	} else {
+		// answers may be shared
+		resp = resp.Copy()
+	}
+
+	// the in-process server acts as a recursive one
+	resp.Id = req.Id
+	resp.Response = true
+	resp.RecursionDesired = req.RecursionDesired
+	resp.RecursionAvailable = true
+	if len(resp.Question) == 0 {
+		resp.Question = req.Question
+	}
+	return resp
+}
+
+func readPipeMsg(r io.Reader) (*dns.Msg, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(b); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writePipeMsg(w io.Writer, msg *dns.Msg) error {
+	b, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+
+	out := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(out, uint16(len(b)))
+	copy(out[2:], b)
+
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,45 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewNetResolver(t *testing.T) {
+	r, err := NewNetResolver(newTestZone(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := r.LookupHost(ctx, "www.example.org.")
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case len(addrs) != 1 || addrs[0] != "192.0.2.2":
+		t.Errorf("unexpected addresses: %v", addrs)
+	}
+
+	cname, err := r.LookupCNAME(ctx, "alias.example.org.")
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case cname != "www.example.org.":
+		t.Errorf("unexpected CNAME: %q", cname)
+	}
+
+	_, err = r.LookupHost(ctx, "missing.example.org.")
+	var e *net.DNSError
+	if !errors.As(err, &e) || !e.IsNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	if _, err := NewNetResolver(nil); err == nil {
+		t.Error("nil lookuper accepted")
+	}
+}
@@ -0,0 +1,164 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/slog"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*NXRedirect)(nil)
+	_ Exchanger = (*NXRedirect)(nil)
+)
+
+// DefaultRedirectTTL is the TTL of the records of redirected
+// responses if not specified.
+const DefaultRedirectTTL = 60
+
+// NXRedirect is an [Exchanger] middleware replacing the NXDOMAIN
+// responses for names under the configured domains with the given
+// addresses, walled-garden style. A and AAAA queries get the addresses
+// of their family, and others NODATA. A domain of "." catches all.
+//
+// Every redirection is logged at [slog.Info] level, so they are
+// never silent.
+type NXRedirect struct {
+	next Exchanger
+	log  slog.Logger
+
+	// TTL is the TTL of the records of redirected responses.
+	// [DefaultRedirectTTL] is used if zero.
+	TTL uint32
+
+	mu      sync.RWMutex
+	domains map[string][]netip.Addr
+}
+
+// AddDomain makes NXDOMAIN responses for names under the given
+// domain, or the domain itself, be redirected to the given addresses,
+// replacing any previous ones.
+func (nx *NXRedirect) AddDomain(domain string, addrs ...netip.Addr) error {
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return core.Wrapf(core.ErrInvalid, "invalid domain %q", domain)
+	}
+
+	for _, addr := range addrs {
+		if !addr.IsValid() {
+			return core.Wrap(core.ErrInvalid, "invalid address")
+		}
+	}
+
+	nx.mu.Lock()
+	defer nx.mu.Unlock()
+
+	if nx.domains == nil {
+		nx.domains = make(map[string][]netip.Addr)
+	}
+	nx.domains[dns.CanonicalName(domain)] = append([]netip.Addr{}, addrs...)
+	return nil
+}
+
+// RemoveDomain stops redirecting NXDOMAIN responses
+// for the given domain.
+func (nx *NXRedirect) RemoveDomain(domain string) {
+	nx.mu.Lock()
+	defer nx.mu.Unlock()
+
+	delete(nx.domains, dns.CanonicalName(domain))
+}
+
+// getDomain finds the closest domain containing the name.
+func (nx *NXRedirect) getDomain(qName string) (string, []netip.Addr, bool) {
+	nx.mu.RLock()
+	defer nx.mu.RUnlock()
+
+	qName = dns.CanonicalName(qName)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(qName, off) {
+		name := qName[off:]
+		if addrs, ok := nx.domains[name]; ok {
+			return name, addrs, true
+		}
+	}
+
+	// and the root
+	addrs, ok := nx.domains["."]
+	return ".", addrs, ok
+}
+
+// Lookup implements the [Lookuper] interface.
+func (nx *NXRedirect) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return nx.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface, redirecting the
+// NXDOMAIN responses of the configured domains.
+func (nx *NXRedirect) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	q := msgQuestion(req)
+	if ctx == nil || q == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	resp, err := nx.next.Exchange(ctx, req)
+	switch {
+	case q.Qclass != dns.ClassINET:
+		return resp, err
+	case resp != nil && resp.Rcode == dns.RcodeNameError:
+		// NXDOMAIN
+	case resp == nil && errors.IsNotFound(err):
+		// NXDOMAIN as error
+	default:
+		return resp, err
+	}
+
+	domain, addrs, ok := nx.getDomain(q.Name)
+	if !ok {
+		return resp, err
+	}
+
+	return nx.redirect(req, *q, domain, addrs), nil
+}
+
+func (nx *NXRedirect) redirect(req *dns.Msg, q dns.Question,
+	domain string, addrs []netip.Addr) *dns.Msg {
+	//
+	ttl := core.IIf(nx.TTL > 0, nx.TTL, DefaultRedirectTTL)
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+	resp.Answer = addrsAnswer(q, addrs, ttl)
+
+	if l, ok := nx.log.Info().WithEnabled(); ok {
+		l.WithFields(slog.Fields{
+			"qname":   q.Name,
+			"qtype":   dns.TypeToString[q.Qtype],
+			"domain":  domain,
+			"answers": len(resp.Answer),
+		}).Print("NXDOMAIN redirected")
+	}
+	return resp
+}
+
+// NewNXRedirect creates a [NXRedirect] middleware without domains,
+// passing requests to the given [Exchanger] and logging redirections
+// to the given [slog.Logger].
+func NewNXRedirect(next Exchanger, log slog.Logger) (*NXRedirect, error) {
+	if next == nil || log == nil {
+		return nil, core.ErrInvalid
+	}
+
+	return &NXRedirect{
+		next:    next,
+		log:     log,
+		domains: make(map[string][]netip.Addr),
+	}, nil
+}
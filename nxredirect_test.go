@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/slog/handlers/discard"
+)
+
+func TestNXRedirect(t *testing.T) {
+	nx, err := NewNXRedirect(newTestZone(t), discard.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = nx.AddDomain("wild.example.org",
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("fd00::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nx.AddDomain("portal.example.org", netip.MustParseAddr("10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		qType  uint16
+		rcode  int
+		answer []string
+	}{
+		{"www.example.org", dns.TypeA, dns.RcodeSuccess, []string{"192.0.2.2"}},
+		{"missing.example.org", dns.TypeA, dns.RcodeNameError, nil},
+		{"a.b.wild.example.org", dns.TypeTXT, dns.RcodeSuccess, []string{"wild"}},
+		{"portal.example.org", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.2"}},
+		{"x.portal.example.org", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.2"}},
+		{"x.portal.example.org", dns.TypeAAAA, dns.RcodeSuccess, nil},
+		{"x.portal.example.org", dns.TypeMX, dns.RcodeSuccess, nil},
+	}
+
+	for _, tc := range tests {
+		msg, err := nx.Lookup(context.Background(), tc.name, tc.qType)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		answer := testRecordsData(msg.Answer)
+		if msg.Rcode != tc.rcode || !equalStrings(answer, tc.answer) {
+			t.Errorf("%s/%s: unexpected response %s %q", tc.name,
+				dns.TypeToString[tc.qType], dns.RcodeToString[msg.Rcode], answer)
+		}
+	}
+
+	// catch-all
+	if err := nx.AddDomain(".", netip.MustParseAddr("10.0.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	nx.RemoveDomain("portal.example.org")
+
+	for _, name := range []string{"missing.example.org", "x.portal.example.org"} {
+		msg, err := nx.Lookup(context.Background(), name, dns.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if answer := testRecordsData(msg.Answer); !equalStrings(answer, []string{"10.0.0.3"}) {
+			t.Errorf("%s: unexpected answer %q", name, answer)
+		}
+	}
+
+	if _, err := NewNXRedirect(newTestZone(t), nil); err == nil {
+		t.Error("nil logger accepted")
+	}
+}
@@ -0,0 +1,210 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/client"
+)
+
+var (
+	maxAttemptsCtxKey      = core.NewContextKey[int]("resolver.attempts")
+	exchangeDeadlineCtxKey = core.NewContextKey[time.Duration]("resolver.deadline")
+	hedgingIntervalCtxKey  = core.NewContextKey[time.Duration]("resolver.interval")
+	ednsOptionsCtxKey      = core.NewContextKey[[]dns.EDNS0]("resolver.edns")
+	recursionDesiredCtxKey = core.NewContextKey[bool]("resolver.rd")
+	checkingDisabledCtxKey = core.NewContextKey[bool]("resolver.cd")
+	transportHintCtxKey    = core.NewContextKey[TransportHint]("resolver.transport")
+)
+
+// WithMaxAttempts returns a context overriding how many times
+// a [Pool] tries each query. A negative value indicates it
+// will keep on trying.
+func WithMaxAttempts(ctx context.Context, attempts int) context.Context {
+	return maxAttemptsCtxKey.WithValue(ctx, attempts)
+}
+
+// GetMaxAttempts returns the number of attempts set on the context
+// using [WithMaxAttempts], if any.
+func GetMaxAttempts(ctx context.Context) (int, bool) {
+	return maxAttemptsCtxKey.Get(ctx)
+}
+
+// WithExchangeDeadline returns a context overriding the maximum time
+// each exchange of a [Pool] can take. Zero removes the limit.
+func WithExchangeDeadline(ctx context.Context, deadline time.Duration) context.Context {
+	return exchangeDeadlineCtxKey.WithValue(ctx, deadline)
+}
+
+// GetExchangeDeadline returns the exchange deadline set on the context
+// using [WithExchangeDeadline], if any.
+func GetExchangeDeadline(ctx context.Context) (time.Duration, bool) {
+	return exchangeDeadlineCtxKey.Get(ctx)
+}
+
+// WithHedgingInterval returns a context overriding how long a [Pool]
+// waits before starting a new attempt in parallel. Zero makes it wait
+// for the previous attempt to finish.
+func WithHedgingInterval(ctx context.Context, interval time.Duration) context.Context {
+	return hedgingIntervalCtxKey.WithValue(ctx, interval)
+}
+
+// GetHedgingInterval returns the hedging interval set on the context
+// using [WithHedgingInterval], if any.
+func GetHedgingInterval(ctx context.Context) (time.Duration, bool) {
+	return hedgingIntervalCtxKey.Get(ctx)
+}
+
+// WithEDNSOptions returns a context adding EDNS0 options, like
+// [dns.EDNS0_SUBNET] or [dns.EDNS0_NSID], to the requests a [Pool]
+// or [SingleLookuper] send, replacing those of the same code.
+func WithEDNSOptions(ctx context.Context, opts ...dns.EDNS0) context.Context {
+	if prev, ok := GetEDNSOptions(ctx); ok {
+		opts = append(append([]dns.EDNS0(nil), prev...), opts...)
+	}
+	return ednsOptionsCtxKey.WithValue(ctx, opts)
+}
+
+// GetEDNSOptions returns the EDNS0 options set on the context
+// using [WithEDNSOptions], if any.
+func GetEDNSOptions(ctx context.Context) ([]dns.EDNS0, bool) {
+	return ednsOptionsCtxKey.Get(ctx)
+}
+
+// WithRecursionDesired returns a context overriding the RD bit
+// of the requests a [Pool] or [SingleLookuper] send.
+func WithRecursionDesired(ctx context.Context, rd bool) context.Context {
+	return recursionDesiredCtxKey.WithValue(ctx, rd)
+}
+
+// GetRecursionDesired returns the RD bit set on the context
+// using [WithRecursionDesired], if any.
+func GetRecursionDesired(ctx context.Context) (bool, bool) {
+	return recursionDesiredCtxKey.Get(ctx)
+}
+
+// WithCheckingDisabled returns a context overriding the CD bit
+// of the requests a [Pool] or [SingleLookuper] send, asking
+// validating servers to skip DNSSEC checks.
+func WithCheckingDisabled(ctx context.Context, cd bool) context.Context {
+	return checkingDisabledCtxKey.WithValue(ctx, cd)
+}
+
+// GetCheckingDisabled returns the CD bit set on the context
+// using [WithCheckingDisabled], if any.
+func GetCheckingDisabled(ctx context.Context) (bool, bool) {
+	return checkingDisabledCtxKey.Get(ctx)
+}
+
+// TransportHint indicates the transport preferred for a request.
+type TransportHint int
+
+const (
+	// TransportAuto leaves the choice to the [client.Client].
+	TransportAuto TransportHint = iota
+	// TransportUDP requests plain UDP.
+	TransportUDP
+	// TransportTCP requests plain TCP.
+	TransportTCP
+	// TransportTLS requests DNS over TLS.
+	TransportTLS
+)
+
+// WithTransportHint returns a context making a [Pool] or
+// [SingleLookuper] use the given transport. It's honoured when the
+// client is a [*dns.Client] or a [*client.Auto], and ignored otherwise.
+func WithTransportHint(ctx context.Context, hint TransportHint) context.Context {
+	return transportHintCtxKey.WithValue(ctx, hint)
+}
+
+// GetTransportHint returns the transport hint set on the context
+// using [WithTransportHint], if any.
+func GetTransportHint(ctx context.Context) (TransportHint, bool) {
+	return transportHintCtxKey.Get(ctx)
+}
+
+// applyRequestOptions returns a copy of the request modified as set
+// on the context, or the original if there is nothing to change.
+func applyRequestOptions(ctx context.Context, req *dns.Msg) *dns.Msg {
+	opts, hasOpts := GetEDNSOptions(ctx)
+	rd, hasRD := GetRecursionDesired(ctx)
+	cd, hasCD := GetCheckingDisabled(ctx)
+
+	switch {
+	case hasRD && req.RecursionDesired != rd,
+		hasCD && req.CheckingDisabled != cd,
+		hasOpts && len(opts) > 0:
+		req = req.Copy()
+	default:
+		return req
+	}
+
+	if hasRD {
+		req.RecursionDesired = rd
+	}
+	if hasCD {
+		req.CheckingDisabled = cd
+	}
+	if len(opts) > 0 {
+		setEDNSOptions(req, opts)
+	}
+	return req
+}
+
+func setEDNSOptions(req *dns.Msg, opts []dns.EDNS0) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		req.SetEdns0(dns.DefaultMsgSize, false)
+		opt = req.IsEdns0()
+	}
+
+	for _, o := range opts {
+		code := o.Option()
+		out := opt.Option[:0]
+		for _, prev := range opt.Option {
+			if prev.Option() != code {
+				out = append(out, prev)
+			}
+		}
+		opt.Option = append(out, o)
+	}
+}
+
+// transportNetworks maps transport hints to the [dns.Client]
+// network and the [client.Auto] server prefix.
+var transportNetworks = map[TransportHint][2]string{
+	TransportUDP: {"udp", "udp://"},
+	TransportTCP: {"tcp", "tcp://"},
+	TransportTLS: {"tcp-tls", "tls://"},
+}
+
+// transportClient adapts the client and server to the transport
+// hint set on the context.
+func transportClient(ctx context.Context, c client.Client,
+	server string) (client.Client, string) {
+	//
+	hint, _ := GetTransportHint(ctx)
+	nets, ok := transportNetworks[hint]
+	if !ok {
+		return c, server
+	}
+
+	switch v := c.(type) {
+	case *dns.Client:
+		c2 := *v
+		c2.Net = nets[0]
+		return &c2, server
+	case *client.Auto:
+		if _, s, ok := strings.Cut(server, "://"); ok {
+			server = s
+		}
+		return c, nets[1] + server
+	default:
+		return c, server
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -13,7 +14,8 @@ import (
 )
 
 var (
-	_ Client = (*Auto)(nil)
+	_ Client     = (*Auto)(nil)
+	_ IPv6Setter = (*Auto)(nil)
 )
 
 // Auto is a client that allows different networks based on the server's
@@ -27,7 +29,14 @@ type Auto struct {
 	TCP Client
 	TLS Client
 
-	sfc *SingleFlight
+	sfc    *SingleFlight
+	noIPv6 atomic.Bool
+}
+
+// SetIPv6 allows or prevents exchanges with IPv6 servers,
+// which fail immediately when disabled.
+func (c *Auto) SetIPv6(enabled bool) {
+	c.noIPv6.Store(!enabled)
 }
 
 // ExchangeContext uses different exchange networks based on the prefix
@@ -47,10 +56,16 @@ func (c *Auto) sfExchange(ctx context.Context, req *dns.Msg,
 		"tls://",
 	} {
 		if s, ok := strings.CutPrefix(server, p); ok {
+			if err := c.checkIPv6(s); err != nil {
+				return nil, 0, err
+			}
 			return c.sfNetExchange(ctx, req, p, s)
 		}
 	}
 
+	if err := c.checkIPv6(server); err != nil {
+		return nil, 0, err
+	}
 	return c.sfAutoExchange(ctx, req, server)
 }
 
@@ -101,6 +116,17 @@ func (c *Auto) sfNetExchange(ctx context.Context, req *dns.Msg,
 	return next.ExchangeContext(ctx, req, server)
 }
 
+func (c *Auto) checkIPv6(server string) error {
+	if c.noIPv6.Load() && isIPv6Server(server) {
+		return &net.DNSError{
+			Err:         "IPv6 disabled",
+			Server:      server,
+			IsTemporary: true,
+		}
+	}
+	return nil
+}
+
 func isTruncated(err error) bool {
 	if e, ok := err.(*net.DNSError); ok {
 		return e.Err == errors.TRUNCATED
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// BatchResult is the outcome of one of the exchanges of
+// [ExchangeBatch].
+type BatchResult struct {
+	Response *dns.Msg
+	Err      error
+	RTT      time.Duration
+}
+
+// ExchangeBatch sends many requests to the same server using the given
+// [Client] with at most maxParallel exchanges in flight, and returns
+// the results in the same order as the requests.
+// If maxParallel isn't positive, [DefaultWorkerPoolSize] will be used.
+// Without a context all the exchanges fail as bad requests.
+func ExchangeBatch(ctx context.Context, c Client, server string,
+	reqs []*dns.Msg, maxParallel int) []BatchResult {
+	//
+	var wg sync.WaitGroup
+
+	out := make([]BatchResult, len(reqs))
+	if ctx == nil {
+		for i := range out {
+			out[i].Err = errors.ErrBadRequest()
+		}
+		return out
+	}
+
+	if maxParallel <= 0 {
+		maxParallel = DefaultWorkerPoolSize
+	}
+
+	barrier := make(chan struct{}, maxParallel)
+
+	for i := range reqs {
+		// wait for a slot
+		select {
+		case barrier <- struct{}{}:
+		case <-ctx.Done():
+			fillBatchErrors(out[i:], reqs[i:], ctx.Err())
+			wg.Wait()
+			return out
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-barrier }()
+
+			out[i] = doExchangeBatchOne(ctx, c, reqs[i], server)
+		}(i)
+	}
+
+	wg.Wait()
+	return out
+}
+
+func doExchangeBatchOne(ctx context.Context, c Client,
+	req *dns.Msg, server string) BatchResult {
+	//
+	if c == nil || req == nil {
+		return BatchResult{Err: errors.ErrBadRequest()}
+	}
+
+	resp, rtt, err := c.ExchangeContext(ctx, req, server)
+	return BatchResult{
+		Response: resp,
+		Err:      err,
+		RTT:      rtt,
+	}
+}
+
+func fillBatchErrors(out []BatchResult, reqs []*dns.Msg, err error) {
+	for i, req := range reqs {
+		var qName string
+		if req != nil && len(req.Question) > 0 {
+			qName = req.Question[0].Name
+		}
+
+		out[i] = BatchResult{Err: errors.ErrTimeout(qName, err)}
+	}
+}
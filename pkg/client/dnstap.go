@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver/pkg/dnstap"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+var (
+	_ Client    = (*Dnstap)(nil)
+	_ Unwrapper = (*Dnstap)(nil)
+)
+
+// Dnstap is a [Client] middleware logging the exchanges
+// via a [dnstap.Sender].
+type Dnstap struct {
+	c Client
+	s *dnstap.Sender
+
+	// Type is the dnstap type of the queries, [dnstap.ResolverQuery]
+	// unless specified. Responses use the next one.
+	Type dnstap.MessageType
+}
+
+// ExchangeContext passes the request to the next [Client], logging
+// the query and the response if sampled.
+func (c *Dnstap) ExchangeContext(ctx context.Context, req *dns.Msg,
+	server string) (*dns.Msg, time.Duration, error) {
+	//
+	if req == nil {
+		return nil, 0, errors.ErrBadRequest()
+	}
+
+	if !c.s.Sampled() {
+		return c.c.ExchangeContext(ctx, req, server)
+	}
+
+	qType := core.Coalesce(c.Type, dnstap.ResolverQuery)
+	m := &dnstap.Message{
+		Type:         qType,
+		Protocol:     c.protocol(server),
+		ResponseAddr: serverAddrPort(server),
+		QueryTime:    time.Now(),
+	}
+	m.QueryMessage, _ = req.Pack()
+	c.s.Send(m)
+
+	resp, rtt, err := c.c.ExchangeContext(ctx, req, server)
+	if resp != nil {
+		m2 := *m
+		m2.Type = qType + 1
+		m2.QueryMessage = nil
+		m2.ResponseTime = time.Now()
+		m2.ResponseMessage, _ = resp.Pack()
+		c.s.Send(&m2)
+	}
+	return resp, rtt, err
+}
+
+// protocol guesses the transport used to reach the server.
+func (c *Dnstap) protocol(server string) dnstap.SocketProtocol {
+	switch {
+	case strings.HasPrefix(server, "tls://"):
+		return dnstap.DOT
+	case strings.HasPrefix(server, "tcp://"):
+		return dnstap.TCP
+	case strings.HasPrefix(server, "udp://"):
+		return dnstap.UDP
+	}
+
+	if dc := c.Unwrap(); dc != nil {
+		switch dc.Net {
+		case "tcp-tls", "tcp4-tls", "tcp6-tls":
+			return dnstap.DOT
+		case "tcp", "tcp4", "tcp6":
+			return dnstap.TCP
+		}
+	}
+	return dnstap.UDP
+}
+
+// serverAddrPort parses the address of a server, if possible.
+func serverAddrPort(server string) netip.AddrPort {
+	if _, s, ok := strings.Cut(server, "://"); ok {
+		server = s
+	}
+
+	ap, _ := netip.ParseAddrPort(server)
+	return ap
+}
+
+// Unwrap returns the underlying [dns.Client].
+func (c *Dnstap) Unwrap() *dns.Client {
+	return Unwrap(c.c)
+}
+
+// NewDnstap creates a [Client] middleware logging the exchanges
+// via the given [dnstap.Sender].
+func NewDnstap(c Client, s *dnstap.Sender) (*Dnstap, error) {
+	if c == nil || s == nil {
+		return nil, core.ErrInvalid
+	}
+
+	return &Dnstap{c: c, s: s}, nil
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+var (
+	_ Client    = (*EDNS)(nil)
+	_ Unwrapper = (*EDNS)(nil)
+)
+
+const (
+	// DefaultEDNSBufferSize is the maximum UDP buffer size advertised
+	// by [EDNS] unless specified otherwise, as recommended by
+	// the DNS Flag Day 2020 to avoid IP fragmentation.
+	DefaultEDNSBufferSize = 1232
+
+	// DefaultEDNSHoldDown indicates how long [EDNS] remembers a server
+	// doesn't support EDNS0 before trying again.
+	DefaultEDNSHoldDown = 10 * time.Minute
+)
+
+// EDNS is a [Client] middleware that negotiates EDNS0 support and UDP
+// buffer sizes per server. It clamps the advertised buffer size to avoid
+// fragmentation, remembers the size advertised by each server, and retries
+// without EDNS0 when a server responds FORMERR or BADVERS.
+type EDNS struct {
+	mu      sync.Mutex
+	c       Client
+	servers map[string]*ednsServer
+
+	// MaxUDPSize is the maximum buffer size we advertise.
+	// [DefaultEDNSBufferSize] will be used if zero.
+	MaxUDPSize uint16
+	// HoldDown indicates how long to remember a server doesn't
+	// support EDNS0. [DefaultEDNSHoldDown] will be used if zero.
+	HoldDown time.Duration
+}
+
+type ednsServer struct {
+	noEDNS  time.Time
+	udpSize uint16
+}
+
+// ExchangeContext adjusts the EDNS0 options of the request to what
+// the server is known to support, and retries without EDNS0 if rejected.
+func (c *EDNS) ExchangeContext(ctx context.Context, req *dns.Msg,
+	server string) (*dns.Msg, time.Duration, error) {
+	//
+	if ctx == nil || req == nil {
+		return nil, 0, errors.ErrBadRequest()
+	}
+
+	start := time.Now()
+	if req.IsEdns0() == nil {
+		// plain request
+		resp, _, err := c.c.ExchangeContext(ctx, req, server)
+		return resp, time.Since(start), err
+	}
+
+	req2 := c.prepare(req, server)
+	resp, _, err := c.c.ExchangeContext(ctx, req2, server)
+	if err == nil && req2.IsEdns0() != nil && c.isRejected(resp) {
+		// retry without EDNS0
+		c.setNoEDNS(server)
+
+		req2 = c.prepare(req, server)
+		resp, _, err = c.c.ExchangeContext(ctx, req2, server)
+	}
+
+	if err == nil {
+		c.learn(server, resp)
+	}
+
+	return resp, time.Since(start), err
+}
+
+// prepare returns a copy of the request with its OPT record
+// adjusted for the server.
+func (c *EDNS) prepare(req *dns.Msg, server string) *dns.Msg {
+	size, ok := c.getUDPSize(server)
+
+	req2 := req.Copy()
+	if !ok {
+		// remove OPT
+		req2.Extra = core.SliceReplaceFn(req2.Extra,
+			func(_ []dns.RR, rr dns.RR) (dns.RR, bool) {
+				return rr, rr.Header().Rrtype != dns.TypeOPT
+			})
+		return req2
+	}
+
+	if opt := req2.IsEdns0(); opt != nil && opt.UDPSize() > size {
+		opt.SetUDPSize(size)
+	}
+	return req2
+}
+
+func (*EDNS) isRejected(resp *dns.Msg) bool {
+	switch {
+	case resp == nil:
+		return false
+	case resp.Rcode == dns.RcodeFormatError:
+		return true
+	case resp.Rcode == dns.RcodeBadVers:
+		return true
+	default:
+		return false
+	}
+}
+
+// getUDPSize returns the buffer size to advertise to the server,
+// or false if EDNS0 shouldn't be used.
+func (c *EDNS) getUDPSize(server string) (uint16, bool) {
+	size := c.maxUDPSize()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.servers[server]; ok {
+		if time.Now().Before(s.noEDNS) {
+			return 0, false
+		}
+
+		if s.udpSize >= dns.MinMsgSize && s.udpSize < size {
+			size = s.udpSize
+		}
+	}
+
+	return size, true
+}
+
+func (c *EDNS) maxUDPSize() uint16 {
+	if c.MaxUDPSize >= dns.MinMsgSize {
+		return c.MaxUDPSize
+	}
+	return DefaultEDNSBufferSize
+}
+
+func (c *EDNS) setNoEDNS(server string) {
+	holdDown := c.HoldDown
+	if holdDown <= 0 {
+		holdDown = DefaultEDNSHoldDown
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.getServer(server)
+	s.noEDNS = time.Now().Add(holdDown)
+}
+
+// learn remembers the buffer size advertised by the server.
+func (c *EDNS) learn(server string, resp *dns.Msg) {
+	if resp == nil {
+		return
+	}
+
+	if opt := resp.IsEdns0(); opt != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		s := c.getServer(server)
+		s.noEDNS = time.Time{}
+		s.udpSize = opt.UDPSize()
+	}
+}
+
+func (c *EDNS) getServer(server string) *ednsServer {
+	s, ok := c.servers[server]
+	if !ok {
+		s = new(ednsServer)
+		c.servers[server] = s
+	}
+	return s
+}
+
+// SupportsEDNS tells if a server is believed to support EDNS0,
+// and the buffer size it advertised, if known.
+func (c *EDNS) SupportsEDNS(server string) (bool, uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.servers[server]; ok {
+		return !time.Now().Before(s.noEDNS), s.udpSize
+	}
+	return true, 0
+}
+
+// Unwrap returns the underlying [dns.Client]
+func (c *EDNS) Unwrap() *dns.Client {
+	return Unwrap(c.c)
+}
+
+// NewEDNS creates a [EDNS] middleware around the given [Client],
+// advertising at most maxUDPSize bytes. If zero,
+// [DefaultEDNSBufferSize] will be used.
+func NewEDNS(c Client, maxUDPSize uint16) *EDNS {
+	if c == nil {
+		c = NewDefaultClient(0)
+	}
+
+	return &EDNS{
+		c:          c,
+		servers:    make(map[string]*ednsServer),
+		MaxUDPSize: maxUDPSize,
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestEDNSFallback(t *testing.T) {
+	var calls, plain int
+
+	// server rejecting EDNS0
+	next := ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		_ string) (*dns.Msg, time.Duration, error) {
+		calls++
+
+		resp := new(dns.Msg)
+		if req.IsEdns0() != nil {
+			resp.SetRcode(req, dns.RcodeFormatError)
+		} else {
+			plain++
+			resp.SetReply(req)
+		}
+		return resp, 0, nil
+	})
+
+	c := NewEDNS(next, 0)
+	for i := 0; i < 2; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		req.SetEdns0(4096, false)
+
+		resp, _, err := c.ExchangeContext(context.Background(), req, "192.0.2.1:53")
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case resp.Rcode != dns.RcodeSuccess:
+			t.Fatalf("unexpected rcode %v", dns.RcodeToString[resp.Rcode])
+		}
+	}
+
+	if calls != 3 || plain != 2 {
+		t.Errorf("calls:%v plain:%v, expected 3 and 2", calls, plain)
+	}
+
+	if ok, _ := c.SupportsEDNS("192.0.2.1:53"); ok {
+		t.Error("server still believed to support EDNS0")
+	}
+}
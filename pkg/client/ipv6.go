@@ -1,19 +1,33 @@
 package client
 
-import "net"
+import (
+	"net"
+	"sync/atomic"
+)
 
-var hasIPv6Support bool
+var hasIPv6Support atomic.Bool
 
 // HasIPv6Support tells if the system supports IPv6 or not.
 // This doesn't guarantee connections will be successful.
 func HasIPv6Support() bool {
-	return hasIPv6Support
+	return hasIPv6Support.Load()
 }
 
-func init() {
+// ProbeIPv6Support checks again if the system supports IPv6,
+// updating the value returned by [HasIPv6Support].
+func ProbeIPv6Support() bool {
+	var ok bool
+
 	l, err := net.Listen("tcp6", "::1")
 	if err == nil {
-		hasIPv6Support = true
+		ok = true
 		_ = l.Close()
 	}
+
+	hasIPv6Support.Store(ok)
+	return ok
+}
+
+func init() {
+	ProbeIPv6Support()
 }
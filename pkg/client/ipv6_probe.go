@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIPv6ProbeInterval indicates how often [IPv6Prober]
+	// checks IPv6 reachability unless specified otherwise.
+	DefaultIPv6ProbeInterval = 5 * time.Minute
+
+	// DefaultIPv6ProbeTimeout indicates how long [IPv6Prober]
+	// waits for each probe target.
+	DefaultIPv6ProbeTimeout = 2 * time.Second
+)
+
+// IPv6Setter is implemented by those who can adapt their
+// behaviour when IPv6 connectivity changes.
+type IPv6Setter interface {
+	SetIPv6(enabled bool)
+}
+
+// IPv6Prober periodically checks IPv6 reachability and notifies
+// registered [IPv6Setter]s when it changes.
+// Without targets only the local stack is checked, otherwise
+// IPv6 is considered usable if any target accepts a TCP connection.
+type IPv6Prober struct {
+	mu        sync.Mutex
+	listeners []IPv6Setter
+	targets   []string
+	last      bool
+	known     bool
+
+	// Interval indicates how often to probe.
+	// [DefaultIPv6ProbeInterval] is used if zero.
+	Interval time.Duration
+	// Timeout indicates how long to wait for each target.
+	// [DefaultIPv6ProbeTimeout] is used if zero.
+	Timeout time.Duration
+}
+
+// Register adds a [IPv6Setter] to be notified of changes,
+// and informs it of the current state if known.
+func (p *IPv6Prober) Register(l IPv6Setter) {
+	if l == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.listeners = append(p.listeners, l)
+	if p.known {
+		l.SetIPv6(p.last)
+	}
+}
+
+// Probe checks IPv6 reachability now, updating [HasIPv6Support]
+// and notifying the listeners if it changed.
+func (p *IPv6Prober) Probe(ctx context.Context) bool {
+	ok := hasIPv6Loopback()
+	if ok && len(p.targets) > 0 {
+		ok = p.probeTargets(ctx)
+	}
+	hasIPv6Support.Store(ok)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.known || p.last != ok {
+		p.known = true
+		p.last = ok
+		for _, l := range p.listeners {
+			l.SetIPv6(ok)
+		}
+	}
+
+	return ok
+}
+
+// hasIPv6Loopback tells if the local stack accepts
+// IPv6 listeners.
+func hasIPv6Loopback() bool {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	_ = l.Close()
+	return true
+}
+
+func (p *IPv6Prober) probeTargets(ctx context.Context) bool {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultIPv6ProbeTimeout
+	}
+
+	d := net.Dialer{Timeout: timeout}
+	for _, target := range p.targets {
+		conn, err := d.DialContext(ctx, "tcp6", target)
+		if err == nil {
+			_ = conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// Run probes IPv6 reachability periodically until
+// the context is cancelled.
+func (p *IPv6Prober) Run(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultIPv6ProbeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.Probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// NewIPv6Prober creates a new [IPv6Prober] using the given
+// targets, in "[address]:port" form.
+func NewIPv6Prober(targets ...string) *IPv6Prober {
+	return &IPv6Prober{
+		targets: targets,
+	}
+}
+
+// isIPv6Server tells if a server address is an IPv6 literal.
+func isIPv6Server(server string) bool {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+	}
+
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.Is6() && !addr.Is4In6()
+}
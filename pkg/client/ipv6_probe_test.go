@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type testIPv6Setter struct {
+	calls   int
+	enabled bool
+}
+
+func (s *testIPv6Setter) SetIPv6(enabled bool) {
+	s.calls++
+	s.enabled = enabled
+}
+
+func TestIPv6Prober(t *testing.T) {
+	var l testIPv6Setter
+
+	p := NewIPv6Prober()
+	p.Register(&l)
+	if l.calls != 0 {
+		t.Fatal("listener notified before probing")
+	}
+
+	ok := p.Probe(context.Background())
+	if l.calls != 1 || l.enabled != ok {
+		t.Errorf("expected one notification with %v, got %v/%v", ok, l.calls, l.enabled)
+	}
+
+	// unchanged
+	p.Probe(context.Background())
+	if l.calls != 1 {
+		t.Errorf("unexpected notification, calls:%v", l.calls)
+	}
+
+	// late registration
+	var l2 testIPv6Setter
+	p.Register(&l2)
+	if l2.calls != 1 || l2.enabled != ok {
+		t.Errorf("expected current state on registration, got %v/%v", l2.calls, l2.enabled)
+	}
+}
+
+func TestAutoSetIPv6(t *testing.T) {
+	var calls int
+
+	next := ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		_ string) (*dns.Msg, time.Duration, error) {
+		calls++
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, 0, nil
+	})
+
+	c, err := NewAutoClient(next, next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetIPv6(false)
+
+	tests := []struct {
+		server string
+		ok     bool
+	}{
+		{"192.0.2.1:53", true},
+		{"[2001:db8::1]:53", false},
+		{"udp://[2001:db8::1]:53", false},
+		{"udp://192.0.2.1:53", true},
+	}
+
+	for _, tc := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+
+		_, _, err := c.ExchangeContext(context.Background(), req, tc.server)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%s: expected success:%v, got %v", tc.server, tc.ok, err)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("expected 2 exchanges, got %v", calls)
+	}
+}
+
+func TestIPv6ProberTargets(t *testing.T) {
+	if !hasIPv6Loopback() {
+		t.Skip("no IPv6 loopback")
+	}
+	t.Cleanup(func() { ProbeIPv6Support() })
+
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// a closed port
+	l2, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l2.Addr().String()
+	_ = l2.Close()
+
+	tests := []struct {
+		targets  []string
+		expected bool
+	}{
+		{[]string{l.Addr().String()}, true},
+		{[]string{closed}, false},
+		{[]string{closed, l.Addr().String()}, true},
+	}
+
+	for _, tc := range tests {
+		p := NewIPv6Prober(tc.targets...)
+		p.Timeout = time.Second
+
+		ok := p.Probe(context.Background())
+		switch {
+		case ok != tc.expected:
+			t.Errorf("%v: expected %v, got %v", tc.targets, tc.expected, ok)
+		case HasIPv6Support() != ok:
+			t.Errorf("%v: HasIPv6Support not updated", tc.targets)
+		}
+	}
+}
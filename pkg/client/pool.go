@@ -2,14 +2,16 @@ package client
 
 import (
 	"context"
-	"errors"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 
 	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
 )
 
 var (
@@ -25,17 +27,40 @@ var (
 	_ Worker    = (*WorkerPool)(nil)
 )
 
+// WorkerPoolPolicy indicates what a [WorkerPool] does with new
+// requests when its queue is full.
+type WorkerPoolPolicy int
+
+const (
+	// WorkerPoolBlock makes new requests wait for room in the queue.
+	WorkerPoolBlock WorkerPoolPolicy = iota
+	// WorkerPoolFailFast makes new requests fail immediately.
+	WorkerPoolFailFast
+	// WorkerPoolShedOldest makes the oldest request in the queue
+	// fail to make room for the new one.
+	WorkerPoolShedOldest
+)
+
 // A WorkerPool limits the number of parallel requests.
 type WorkerPool struct {
+	mu        sync.Mutex
 	wg        core.WaitGroup
 	cancelled atomic.Bool
 	cancel    chan struct{}
+	shrink    chan struct{}
 	ch        chan exReq
 	err       error
 
 	c        Client
 	onCancel func(error)
 	max      int
+	queue    int
+	policy   WorkerPoolPolicy
+
+	perServer int
+	servers   map[string]chan struct{}
+
+	stats workerPoolStats
 }
 
 // Unwrap returns the underlying [dns.Client]
@@ -60,7 +85,13 @@ func (wp *WorkerPool) prepare(ctx context.Context) error {
 		wp.max = DefaultWorkerPoolSize
 	}
 
-	wp.ch = make(chan exReq, wp.max)
+	if wp.queue <= 0 {
+		wp.queue = wp.max
+	}
+
+	wp.ch = make(chan exReq, wp.queue)
+	wp.shrink = make(chan struct{})
+	wp.servers = make(map[string]chan struct{})
 
 	// set watchers
 	wp.wg.OnError(wp.wgWatchWorkers)
@@ -104,43 +135,131 @@ func (wp *WorkerPool) doExchange(ctx context.Context,
 	case <-ctx.Done():
 		// deadline
 		return nil, ctx.Err()
-	case r, ok := <-wp.submit(ctx, req, server):
-		if ok {
-			// response received
-			return r.resp, r.err
-		}
-
-		// closed
+	case r := <-wp.submit(ctx, req, server):
+		// response received
+		return r.resp, r.err
+	case <-wp.Done():
+		// workers gone
 		return nil, wp.wg.Err()
 	}
 }
 
 func (wp *WorkerPool) submit(ctx context.Context, req *dns.Msg, server string) <-chan exResp {
 	ch := make(chan exResp, 1)
-	go wp.safeSubmit(ctx, req, server, ch)
+	go wp.enqueue(ctx, req, server, ch)
 	return ch
 }
 
-func (wp *WorkerPool) safeSubmit(ctx context.Context, req *dns.Msg, server string, ch chan<- exResp) {
+func (wp *WorkerPool) enqueue(ctx context.Context, req *dns.Msg, server string, ch chan<- exResp) {
 	r := exReq{
 		ctx:    ctx,
 		req:    req,
 		server: server,
 		ch:     ch,
+		queued: time.Now(),
 	}
 
-	// channel could be closed.
-	defer func() { _ = recover() }()
+	release, err := wp.acquireServer(ctx, server)
+	if err != nil {
+		wp.safeRespond(ch, nil, err)
+		return
+	}
+	r.release = release
+
+	switch wp.policy {
+	case WorkerPoolFailFast:
+		select {
+		case wp.ch <- r:
+		case <-wp.cancel:
+			wp.rejectCancelled(r)
+		default:
+			wp.overloaded(r)
+		}
+	case WorkerPoolShedOldest:
+		wp.enqueueShedding(r)
+	default:
+		select {
+		case wp.ch <- r:
+		case <-wp.cancel:
+			wp.rejectCancelled(r)
+		}
+	}
+}
 
-	wp.ch <- r
+// enqueueShedding adds a request to the queue, failing the
+// oldest ones if needed.
+func (wp *WorkerPool) enqueueShedding(r exReq) {
+	for {
+		select {
+		case wp.ch <- r:
+			return
+		case <-wp.cancel:
+			wp.rejectCancelled(r)
+			return
+		default:
+			select {
+			case old := <-wp.ch:
+				wp.overloaded(old)
+			default:
+			}
+		}
+	}
 }
 
 func (wp *WorkerPool) run() error {
-	for req := range wp.ch {
-		resp, _, err := wp.c.ExchangeContext(req.ctx, req.req, req.server)
-		wp.safeRespond(req.ch, resp, err)
+	for {
+		select {
+		case req := <-wp.ch:
+			wp.process(req)
+		case <-wp.shrink:
+			// retired
+			return nil
+		case <-wp.cancel:
+			// finish what's queued
+			wp.drain()
+			return nil
+		}
 	}
-	return nil
+}
+
+func (wp *WorkerPool) drain() {
+	for {
+		select {
+		case req := <-wp.ch:
+			wp.process(req)
+		default:
+			return
+		}
+	}
+}
+
+func (wp *WorkerPool) process(req exReq) {
+	wp.stats.begin(req.queued)
+	resp, _, err := wp.c.ExchangeContext(req.ctx, req.req, req.server)
+	wp.stats.end(err)
+	wp.respond(req, resp, err)
+}
+
+// rejectCancelled fails a request because the [WorkerPool] is shutting down.
+func (wp *WorkerPool) rejectCancelled(r exReq) {
+	wp.stats.reject()
+	wp.respond(r, nil, wp.err)
+}
+
+// overloaded fails a request because the queue is full.
+func (wp *WorkerPool) overloaded(r exReq) {
+	err := errors.ErrOverloaded(r.req.Question[0].Name, r.server)
+	wp.stats.reject()
+	wp.respond(r, nil, err)
+}
+
+// respond delivers the result of a request, releasing its
+// server slot.
+func (wp *WorkerPool) respond(r exReq, resp *dns.Msg, err error) {
+	if r.release != nil {
+		r.release()
+	}
+	wp.safeRespond(r.ch, resp, err)
 }
 
 func (*WorkerPool) safeRespond(out chan<- exResp, resp *dns.Msg, err error) {
@@ -151,10 +270,12 @@ func (*WorkerPool) safeRespond(out chan<- exResp, resp *dns.Msg, err error) {
 }
 
 type exReq struct {
-	ctx    context.Context
-	req    *dns.Msg
-	ch     chan<- exResp
-	server string
+	ctx     context.Context
+	req     *dns.Msg
+	ch      chan<- exResp
+	server  string
+	queued  time.Time
+	release func()
 }
 
 type exResp struct {
@@ -164,6 +285,9 @@ type exResp struct {
 
 // Start launches the workers
 func (wp *WorkerPool) Start(ctx context.Context) error {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
 	if err := wp.prepare(ctx); err != nil {
 		return err
 	}
@@ -175,6 +299,77 @@ func (wp *WorkerPool) Start(ctx context.Context) error {
 	return nil
 }
 
+// SetQueue sets the size of the queue of requests waiting for a worker,
+// and what to do with new requests when it's full. It must be called
+// before [WorkerPool.Start]. By default the queue holds as many requests
+// as workers, and new requests wait for room.
+func (wp *WorkerPool) SetQueue(size int, policy WorkerPoolPolicy) error {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	switch {
+	case size < 0, policy < WorkerPoolBlock, policy > WorkerPoolShedOldest:
+		return core.ErrInvalid
+	case wp.ch != nil:
+		return core.ErrExists
+	}
+
+	wp.queue = size
+	wp.policy = policy
+	return nil
+}
+
+// Resize changes the number of workers. If the [WorkerPool] is
+// running, workers are added immediately, and retired as they
+// finish their current request.
+func (wp *WorkerPool) Resize(n int) error {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	switch {
+	case n <= 0:
+		return core.ErrInvalid
+	case wp.ch == nil:
+		// not started
+		wp.max = n
+		return nil
+	case wp.IsCancelled():
+		return wp.err
+	}
+
+	for ; wp.max < n; wp.max++ {
+		wp.wg.Go(wp.run)
+	}
+
+	for ; wp.max > n; wp.max-- {
+		go wp.retireOne()
+	}
+	return nil
+}
+
+func (wp *WorkerPool) retireOne() {
+	select {
+	case wp.shrink <- struct{}{}:
+	case <-wp.cancel:
+	}
+}
+
+// Workers returns the number of workers.
+func (wp *WorkerPool) Workers() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	return wp.max
+}
+
+// QueueDepth returns the number of requests waiting for a worker.
+func (wp *WorkerPool) QueueDepth() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	return len(wp.ch)
+}
+
 // Shutdown initiates a shutdown and waits until all workers
 // have finished or the given context expires.
 func (wp *WorkerPool) Shutdown(ctx context.Context) error {
@@ -214,7 +409,6 @@ func (wp *WorkerPool) doCancel(cause error) bool {
 	}
 
 	if wp.cancelled.CompareAndSwap(false, true) {
-		defer close(wp.ch)
 		defer close(wp.cancel)
 		wp.err = cause
 
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+
+	"darvaza.org/core"
+)
+
+// SetMaxPerServer limits how many exchanges with the same server can be
+// queued or in progress at the same time, so a slow server can't take all
+// the workers. Requests over the limit wait without using a worker.
+// It must be called before [WorkerPool.Start]. Zero means no limit.
+func (wp *WorkerPool) SetMaxPerServer(n int) error {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	switch {
+	case n < 0:
+		return core.ErrInvalid
+	case wp.ch != nil:
+		return core.ErrExists
+	}
+
+	wp.perServer = n
+	return nil
+}
+
+// acquireServer waits for a slot to exchange with the given server,
+// and returns the function to release it.
+func (wp *WorkerPool) acquireServer(ctx context.Context, server string) (func(), error) {
+	if wp.perServer == 0 {
+		return nil, nil
+	}
+
+	wp.mu.Lock()
+	sem, ok := wp.servers[server]
+	if !ok {
+		sem = make(chan struct{}, wp.perServer)
+		wp.servers[server] = sem
+	}
+	wp.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-wp.cancel:
+		return nil, wp.err
+	}
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// WorkerPoolWaitBuckets are the upper bounds of the buckets used by
+// [WorkerPoolStats] to count how long requests waited for a worker.
+var WorkerPoolWaitBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// WorkerPoolStats describes the activity of a [WorkerPool].
+type WorkerPoolStats struct {
+	// Workers is the number of workers.
+	Workers int
+	// Busy is the number of workers processing a request.
+	Busy int
+	// QueueDepth is the number of requests waiting for a worker.
+	QueueDepth int
+
+	// Processed is the number of requests passed to the [Client].
+	Processed uint64
+	// Failed is the number of processed requests that returned
+	// an error.
+	Failed uint64
+	// Rejected is the number of requests that failed without
+	// being processed, because the queue was full or the
+	// [WorkerPool] was shutting down.
+	Rejected uint64
+
+	// QueueWait counts how long processed requests waited for a worker,
+	// by [WorkerPoolWaitBuckets], with an extra last entry for longer
+	// waits.
+	QueueWait []uint64
+}
+
+// workerPoolStats tracks the activity of a [WorkerPool].
+type workerPoolStats struct {
+	mu        sync.Mutex
+	busy      int
+	processed uint64
+	failed    uint64
+	rejected  uint64
+	wait      []uint64
+}
+
+func (s *workerPoolStats) begin(queued time.Time) {
+	wait := time.Since(queued)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wait == nil {
+		s.wait = make([]uint64, len(WorkerPoolWaitBuckets)+1)
+	}
+
+	i := 0
+	for i < len(WorkerPoolWaitBuckets) && wait > WorkerPoolWaitBuckets[i] {
+		i++
+	}
+
+	s.wait[i]++
+	s.busy++
+}
+
+func (s *workerPoolStats) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.busy--
+	s.processed++
+	if err != nil {
+		s.failed++
+	}
+}
+
+func (s *workerPoolStats) reject() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rejected++
+}
+
+func (s *workerPoolStats) export(out *WorkerPoolStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out.Busy = s.busy
+	out.Processed = s.processed
+	out.Failed = s.failed
+	out.Rejected = s.rejected
+	out.QueueWait = make([]uint64, len(WorkerPoolWaitBuckets)+1)
+	copy(out.QueueWait, s.wait)
+}
+
+// Stats returns the counters of the [WorkerPool].
+func (wp *WorkerPool) Stats() WorkerPoolStats {
+	out := WorkerPoolStats{
+		Workers:    wp.Workers(),
+		QueueDepth: wp.QueueDepth(),
+	}
+	wp.stats.export(&out)
+	return out
+}
+
+// IsHealthy tells if the [WorkerPool] is running and has room in its
+// queue, making it suitable for readiness probes.
+func (wp *WorkerPool) IsHealthy() bool {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	switch {
+	case wp.ch == nil, wp.IsCancelled(), wp.max <= 0:
+		return false
+	default:
+		return len(wp.ch) < cap(wp.ch)
+	}
+}
@@ -0,0 +1,242 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// newTestBlockedClient returns a [Client] that doesn't answer until
+// released, counting the exchanges in progress.
+func newTestBlockedClient(release <-chan struct{}, busy *atomic.Int32) Client {
+	return ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		_ string) (*dns.Msg, time.Duration, error) {
+		busy.Add(1)
+		defer busy.Add(-1)
+		<-release
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, time.Millisecond, nil
+	})
+}
+
+func newTestWorkerPool(t *testing.T, c Client, workers int) *WorkerPool {
+	wp, err := NewWorkerPool(c, workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wp
+}
+
+func startTestExchanges(wp *WorkerPool, names ...string) []chan error {
+	return startTestExchangesTo(wp, "192.0.2.1:53", names...)
+}
+
+func startTestExchangesTo(wp *WorkerPool, server string, names ...string) []chan error {
+	out := make([]chan error, len(names))
+	for i, name := range names {
+		ch := make(chan error, 1)
+		out[i] = ch
+
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		go func() {
+			_, _, err := wp.ExchangeContext(context.Background(), req, server)
+			ch <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return out
+}
+
+func TestWorkerPoolQueuePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy     WorkerPoolPolicy
+		overloaded int
+	}{
+		{WorkerPoolFailFast, 2},
+		{WorkerPoolShedOldest, 1},
+	} {
+		var busy atomic.Int32
+
+		release := make(chan struct{})
+		wp := newTestWorkerPool(t, newTestBlockedClient(release, &busy), 1)
+		if err := wp.SetQueue(1, tc.policy); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := wp.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// one running, one queued, one extra
+		res := startTestExchanges(wp, "a.example.", "b.example.", "c.example.")
+		if err, ok := (<-res[tc.overloaded]).(*net.DNSError); !ok || err.Err != errors.OVERLOADED {
+			t.Errorf("policy %v: unexpected error: %v", tc.policy, err)
+		}
+		if n := wp.QueueDepth(); n != 1 {
+			t.Errorf("policy %v: unexpected queue depth %v", tc.policy, n)
+		}
+
+		close(release)
+		cancel()
+		_ = wp.Wait()
+	}
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	var busy atomic.Int32
+
+	release := make(chan struct{})
+	wp := newTestWorkerPool(t, newTestBlockedClient(release, &busy), 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := wp.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	res := startTestExchanges(wp, "a.example.", "b.example.", "c.example.")
+	if n := busy.Load(); n != 1 {
+		t.Fatalf("%v exchanges in progress, expected 1", n)
+	}
+
+	if err := wp.Resize(3); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if n := busy.Load(); n != 3 {
+		t.Errorf("%v exchanges in progress after growing, expected 3", n)
+	}
+
+	if err := wp.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	for _, ch := range res {
+		if err := <-ch; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if n := wp.Workers(); n != 1 {
+		t.Errorf("%v workers, expected 1", n)
+	}
+}
+
+func TestWorkerPoolMaxPerServer(t *testing.T) {
+	var busy atomic.Int32
+
+	release := make(chan struct{})
+	slow := newTestBlockedClient(release, &busy)
+	c := ExchangeFunc(func(ctx context.Context, req *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		if server == "192.0.2.1:53" {
+			return slow.ExchangeContext(ctx, req, server)
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, time.Millisecond, nil
+	})
+
+	wp := newTestWorkerPool(t, c, 2)
+	if err := wp.SetMaxPerServer(1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := wp.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := wp.SetMaxPerServer(2); err == nil {
+		t.Error("SetMaxPerServer succeeded after Start")
+	}
+
+	slowRes := startTestExchangesTo(wp, "192.0.2.1:53", "a.example.", "b.example.")
+	if n := busy.Load(); n != 1 {
+		t.Errorf("%v exchanges with the slow server, expected 1", n)
+	}
+
+	// the other worker remains available
+	fastRes := startTestExchangesTo(wp, "192.0.2.2:53", "c.example.")
+	select {
+	case err := <-fastRes[0]:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("exchange with another server blocked")
+	}
+
+	close(release)
+	for _, ch := range slowRes {
+		if err := <-ch; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestWorkerPoolStats(t *testing.T) {
+	var busy atomic.Int32
+
+	release := make(chan struct{})
+	wp := newTestWorkerPool(t, newTestBlockedClient(release, &busy), 1)
+	if wp.IsHealthy() {
+		t.Error("healthy before Start")
+	}
+	if err := wp.SetQueue(1, WorkerPoolFailFast); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := wp.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !wp.IsHealthy() {
+		t.Error("not healthy after Start")
+	}
+
+	// one running, one queued, one rejected
+	res := startTestExchanges(wp, "a.example.", "b.example.", "c.example.")
+	<-res[2]
+
+	st := wp.Stats()
+	if st.Busy != 1 || st.QueueDepth != 1 || st.Rejected != 1 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	if wp.IsHealthy() {
+		t.Error("healthy with a full queue")
+	}
+
+	close(release)
+	for _, ch := range res[:2] {
+		if err := <-ch; err != nil {
+			t.Error(err)
+		}
+	}
+
+	st = wp.Stats()
+	var waits uint64
+	for _, n := range st.QueueWait {
+		waits += n
+	}
+	if st.Busy != 0 || st.Processed != 2 || st.Failed != 0 || waits != 2 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+
+	cancel()
+	_ = wp.Wait()
+	if wp.IsHealthy() {
+		t.Error("healthy after shutdown")
+	}
+}
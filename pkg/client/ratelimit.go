@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+var (
+	_ Client    = (*RateLimit)(nil)
+	_ Unwrapper = (*RateLimit)(nil)
+)
+
+// RateLimit is a [Client] middleware that caps the number of queries
+// per second sent to each server, and optionally to all servers combined,
+// using token buckets.
+type RateLimit struct {
+	mu      sync.Mutex
+	c       Client
+	global  *tokenBucket
+	servers map[string]*tokenBucket
+
+	rate  float64
+	burst int
+
+	// Shed indicates excess requests should fail immediately
+	// instead of waiting for their turn.
+	Shed bool
+}
+
+// ExchangeContext waits until the rate limits allow the request to
+// go through, or fails if [RateLimit.Shed] is set and there are no tokens
+// available.
+func (c *RateLimit) ExchangeContext(ctx context.Context, req *dns.Msg,
+	server string) (*dns.Msg, time.Duration, error) {
+	//
+	if ctx == nil || req == nil {
+		return nil, 0, errors.ErrBadRequest()
+	}
+
+	start := time.Now()
+	if err := c.wait(ctx, req, server); err != nil {
+		return nil, time.Since(start), err
+	}
+
+	resp, _, err := c.c.ExchangeContext(ctx, req, server)
+	return resp, time.Since(start), err
+}
+
+func (c *RateLimit) wait(ctx context.Context, req *dns.Msg, server string) error {
+	var qName string
+	if len(req.Question) > 0 {
+		qName = req.Question[0].Name
+	}
+
+	// the per-server token is taken first, and given back if the
+	// global bucket refuses, so global capacity isn't wasted on
+	// requests that never go out.
+	global, local := c.getBuckets(server)
+	if local != nil {
+		if err := c.waitBucket(ctx, local, qName, server); err != nil {
+			return err
+		}
+	}
+
+	if global != nil {
+		if err := c.waitBucket(ctx, global, qName, server); err != nil {
+			if local != nil {
+				local.Put()
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *RateLimit) waitBucket(ctx context.Context, tb *tokenBucket,
+	qName, server string) error {
+	//
+	for {
+		wait, ok := tb.Take(time.Now())
+		switch {
+		case ok:
+			return nil
+		case c.Shed:
+			return errors.ErrRateLimited(qName, server)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.ErrTimeout(qName, ctx.Err())
+		case <-time.After(wait):
+			// try again
+		}
+	}
+}
+
+func (c *RateLimit) getBuckets(server string) (global, local *tokenBucket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rate > 0 {
+		tb, ok := c.servers[server]
+		if !ok {
+			tb = newTokenBucket(c.rate, c.burst)
+			c.servers[server] = tb
+		}
+		local = tb
+	}
+
+	return c.global, local
+}
+
+// SetGlobalLimit sets the maximum number of queries per second
+// sent to all servers combined. Zero or negative rate disables it.
+func (c *RateLimit) SetGlobalLimit(rate float64, burst int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rate > 0 {
+		c.global = newTokenBucket(rate, burst)
+	} else {
+		c.global = nil
+	}
+}
+
+// Unwrap returns the underlying [dns.Client]
+func (c *RateLimit) Unwrap() *dns.Client {
+	return Unwrap(c.c)
+}
+
+// NewRateLimit creates a [RateLimit] middleware allowing up to `rate`
+// queries per second to each server, with bursts of up to `burst`
+// requests. A zero rate only enforces the limit set using
+// [RateLimit.SetGlobalLimit].
+func NewRateLimit(c Client, rate float64, burst int) (*RateLimit, error) {
+	if c == nil || rate < 0 || burst < 0 {
+		return nil, core.ErrInvalid
+	}
+
+	rl := &RateLimit{
+		c:       c,
+		servers: make(map[string]*tokenBucket),
+		rate:    rate,
+		burst:   burst,
+	}
+	return rl, nil
+}
+
+// tokenBucket is a thread-safe token bucket
+type tokenBucket struct {
+	mu     sync.Mutex
+	last   time.Time
+	rate   float64
+	burst  float64
+	tokens float64
+}
+
+// Take attempts to consume a token, and if there is none it
+// returns how long to wait until the next one.
+func (tb *tokenBucket) Take(now time.Time) (time.Duration, bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+		tb.last = now
+	}
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0, true
+	}
+
+	wait := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+	return wait, false
+}
+
+// Put gives back a token taken but not used.
+func (tb *tokenBucket) Put() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.tokens++; tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		last:   time.Now(),
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
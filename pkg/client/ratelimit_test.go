@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := newTokenBucket(10, 2)
+	now := tb.last
+
+	// burst
+	for i := 0; i < 2; i++ {
+		if _, ok := tb.Take(now); !ok {
+			t.Fatalf("token %v denied within burst", i)
+		}
+	}
+
+	// exhausted
+	wait, ok := tb.Take(now)
+	switch {
+	case ok:
+		t.Fatal("token granted beyond burst")
+	case wait <= 0 || wait > 100*time.Millisecond:
+		t.Errorf("unexpected wait %s", wait)
+	}
+
+	// refilled
+	if _, ok := tb.Take(now.Add(wait)); !ok {
+		t.Errorf("token denied after waiting %s", wait)
+	}
+}
+
+func TestRateLimitGlobalTokens(t *testing.T) {
+	next := ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		_ string) (*dns.Msg, time.Duration, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, time.Millisecond, nil
+	})
+
+	c, err := NewRateLimit(next, 0.001, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetGlobalLimit(0.001, 2)
+	c.Shed = true
+
+	tests := []struct {
+		server string
+		ok     bool
+	}{
+		{"192.0.2.1:53", true},
+		// refused by the per-server limit, without
+		// wasting the global token
+		{"192.0.2.1:53", false},
+		{"192.0.2.2:53", true},
+		// refused by the global limit
+		{"192.0.2.3:53", false},
+	}
+
+	for i, tc := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+
+		_, _, err := c.ExchangeContext(context.Background(), req, tc.server)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%d: %s: unexpected result %v", i, tc.server, err)
+		}
+	}
+
+	// the per-server token refused globally was given back
+	if _, ok := c.servers["192.0.2.3:53"].Take(time.Now()); !ok {
+		t.Error("per-server token lost on global refusal")
+	}
+}
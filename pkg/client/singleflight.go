@@ -5,9 +5,10 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
-	"golang.org/x/sync/singleflight"
 
+	"darvaza.org/resolver/internal/flight"
 	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
 )
 
 var (
@@ -24,7 +25,7 @@ const (
 // SingleFlight wraps a [Client] to minimize redundant queries
 type SingleFlight struct {
 	c   Client
-	g   singleflight.Group
+	g   flight.Group
 	exp time.Duration
 }
 
@@ -62,9 +63,12 @@ func (sfc *SingleFlight) doExchange(ctx context.Context, req *dns.Msg,
 	server string) (*dns.Msg, time.Duration, error) {
 	//
 	key := sfc.RequestKey(req, server)
-	v, err, shared := sfc.g.Do(key, func() (any, error) {
+	// the exchange isn't bound to the first caller's context,
+	// and may outlive it, so it gets its own copy of the request.
+	req2 := req.Copy()
+	v, err, shared := sfc.g.Do(ctx, key, func(ctx context.Context) (any, error) {
 		// TODO: how to allow retries on error properly?
-		data, err := sfc.doExchangeResult(ctx, req, server)
+		data, err := sfc.doExchangeResult(ctx, req2, server)
 
 		sfc.deferredExpiration(key)
 
@@ -73,7 +77,8 @@ func (sfc *SingleFlight) doExchange(ctx context.Context, req *dns.Msg,
 
 	data, ok := v.(sfResult)
 	if !ok {
-		panic("unreachable")
+		// caller gave up
+		return nil, 0, errors.ErrTimeout(req.Question[0].Name, err)
 	}
 
 	return data.Export(req, err, shared)
@@ -127,12 +132,9 @@ func (*SingleFlight) RequestKey(req *dns.Msg, server string) string {
 	var key string
 
 	if req != nil {
-		// serialize the whole request, except the Id.
-		// TODO: could we do better?
-		r2 := req.Copy()
-		r2.Id = 0
-
-		key = r2.String()
+		// serialize the request, without the Id or
+		// anything else not affecting the response.
+		key = exdns.NormalizeRequest(req).String()
 	}
 
 	switch {
@@ -180,3 +182,43 @@ func NewSingleFlight(c Client, exp time.Duration) *SingleFlight {
 
 	return &SingleFlight{c: c, exp: exp}
 }
+
+// SingleFlightStats describes the activity of a SingleFlight layer.
+type SingleFlightStats struct {
+	// Requests is the number of requests received.
+	Requests uint64
+	// Exchanges is the number of unique exchanges passed on.
+	Exchanges uint64
+	// Coalesced is the number of requests that shared the result
+	// of an exchange started by another.
+	Coalesced uint64
+	// Inflight is the number of exchanges currently running.
+	Inflight int
+}
+
+// SharedRatio returns the fraction of requests that
+// shared the result of another.
+func (s SingleFlightStats) SharedRatio() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Coalesced) / float64(s.Requests)
+}
+
+// Stats returns the counters of the [SingleFlight].
+func (sfc *SingleFlight) Stats() SingleFlightStats {
+	st := sfc.g.Stats()
+	return SingleFlightStats{
+		Requests:  st.Requests,
+		Exchanges: st.Executions,
+		Coalesced: st.Coalesced,
+		Inflight:  st.Inflight,
+	}
+}
+
+// SetMaxInflight limits how many distinct requests are tracked at
+// the same time. Once reached, new requests are passed through without
+// coalescing. Zero means no limit.
+func (sfc *SingleFlight) SetMaxInflight(n int) {
+	sfc.g.SetLimit(n)
+}
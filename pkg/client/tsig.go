@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// DefaultTSIGFudge is the allowed clock skew, in seconds,
+	// of the TSIG signatures made by [TSIG].
+	DefaultTSIGFudge = 300
+)
+
+var (
+	_ Client    = (*TSIG)(nil)
+	_ Unwrapper = (*TSIG)(nil)
+)
+
+// TSIG is a [Client] middleware signing the requests to some servers
+// using TSIG, RFC 8945, and requiring signed responses. The underlying
+// [dns.Client] needs the keyring as TsigProvider to sign and verify
+// the messages.
+type TSIG struct {
+	mu      sync.RWMutex
+	c       Client
+	keyring *exdns.TSIGKeyring
+	servers map[string]string
+
+	// Fudge is the allowed clock skew, in seconds.
+	Fudge uint16
+}
+
+// ExchangeContext signs the request if the server has a key assigned.
+func (c *TSIG) ExchangeContext(ctx context.Context, req *dns.Msg,
+	server string) (*dns.Msg, time.Duration, error) {
+	//
+	if ctx == nil || req == nil {
+		return nil, 0, errors.ErrBadRequest()
+	}
+
+	key, ok := c.getServerKey(server)
+	if !ok {
+		return c.c.ExchangeContext(ctx, req, server)
+	}
+
+	req = req.Copy()
+	req.SetTsig(key.Name, key.Algorithm, c.Fudge, time.Now().Unix())
+
+	resp, rtt, err := c.c.ExchangeContext(ctx, req, server)
+	if err == nil && resp.IsTsig() == nil {
+		// unsigned
+		return nil, rtt, errors.ErrBadResponse()
+	}
+	return resp, rtt, err
+}
+
+func (c *TSIG) getServerKey(server string) (exdns.TSIGKey, bool) {
+	c.mu.RLock()
+	name, ok := c.servers[server]
+	c.mu.RUnlock()
+
+	if !ok {
+		return exdns.TSIGKey{}, false
+	}
+	return c.keyring.Get(name)
+}
+
+// SetServerKey assigns a key of the keyring to a server.
+// An empty name stops signing requests to it.
+func (c *TSIG) SetServerKey(server, keyName string) error {
+	if keyName != "" {
+		if _, ok := c.keyring.Get(keyName); !ok {
+			return core.ErrNotExists
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if keyName == "" {
+		delete(c.servers, server)
+	} else {
+		c.servers[server] = keyName
+	}
+	return nil
+}
+
+// Install sets the keyring as TsigProvider of a [dns.Client],
+// for those not reachable via [Unwrap].
+func (c *TSIG) Install(dc *dns.Client) {
+	dc.TsigProvider = c.keyring
+}
+
+// Unwrap returns the underlying [dns.Client]
+func (c *TSIG) Unwrap() *dns.Client {
+	return Unwrap(c.c)
+}
+
+// NewTSIG creates a [TSIG] middleware using the given keyring,
+// which is installed as TsigProvider of the underlying [dns.Client]
+// if reachable.
+func NewTSIG(c Client, keyring *exdns.TSIGKeyring) (*TSIG, error) {
+	if c == nil || keyring == nil {
+		return nil, core.ErrInvalid
+	}
+
+	if dc := Unwrap(c); dc != nil {
+		dc.TsigProvider = keyring
+	}
+
+	ts := &TSIG{
+		c:       c,
+		keyring: keyring,
+		servers: make(map[string]string),
+		Fudge:   DefaultTSIGFudge,
+	}
+	return ts, nil
+}
@@ -0,0 +1,191 @@
+// Package dnstap implements dnstap logging of DNS messages,
+// protobuf over Frame Streams.
+package dnstap
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// MessageType identifies the kind of message being logged.
+type MessageType uint32
+
+// Message types, as defined by dnstap.proto
+const (
+	AuthQuery MessageType = iota + 1
+	AuthResponse
+	ResolverQuery
+	ResolverResponse
+	ClientQuery
+	ClientResponse
+	ForwarderQuery
+	ForwarderResponse
+	StubQuery
+	StubResponse
+	ToolQuery
+	ToolResponse
+	UpdateQuery
+	UpdateResponse
+)
+
+// IsQuery tells if the type describes a query.
+func (t MessageType) IsQuery() bool {
+	return t%2 == 1
+}
+
+// SocketProtocol identifies the transport of the message.
+type SocketProtocol uint32
+
+// Socket protocols, as defined by dnstap.proto
+const (
+	UDP SocketProtocol = iota + 1
+	TCP
+	DOT
+	DOH
+	DNSCryptUDP
+	DNSCryptTCP
+	DOQ
+)
+
+const (
+	socketFamilyINET  = 1
+	socketFamilyINET6 = 2
+
+	dnstapTypeMessage = 1
+)
+
+// Message is a DNS message, or a query and its response, to be
+// logged via dnstap.
+type Message struct {
+	Type     MessageType
+	Protocol SocketProtocol
+
+	QueryAddr    netip.AddrPort
+	ResponseAddr netip.AddrPort
+	// QueryZone is the zone a resolver is querying, if known.
+	QueryZone string
+
+	QueryTime       time.Time
+	QueryMessage    []byte
+	ResponseTime    time.Time
+	ResponseMessage []byte
+}
+
+// Marshal encodes the [Message] as a dnstap protobuf payload,
+// including the identity and version of the server if given.
+func (m *Message) Marshal(identity, version string) []byte {
+	var b []byte
+
+	if identity != "" {
+		b = appendBytes(b, 1, []byte(identity))
+	}
+	if version != "" {
+		b = appendBytes(b, 2, []byte(version))
+	}
+	b = appendBytes(b, 14, m.marshalMessage())
+	b = appendVarint(b, 15, dnstapTypeMessage)
+	return b
+}
+
+func (m *Message) marshalMessage() []byte {
+	var b []byte
+
+	b = appendVarint(b, 1, uint64(m.Type))
+	if family := socketFamily(m.QueryAddr, m.ResponseAddr); family != 0 {
+		b = appendVarint(b, 2, family)
+	}
+	if m.Protocol != 0 {
+		b = appendVarint(b, 3, uint64(m.Protocol))
+	}
+
+	b = appendAddrPort(b, 4, 6, m.QueryAddr)
+	b = appendAddrPort(b, 5, 7, m.ResponseAddr)
+	b = appendTime(b, 8, 9, m.QueryTime)
+
+	if len(m.QueryMessage) > 0 {
+		b = appendBytes(b, 10, m.QueryMessage)
+	}
+	if m.QueryZone != "" {
+		if zone := packName(m.QueryZone); zone != nil {
+			b = appendBytes(b, 11, zone)
+		}
+	}
+
+	b = appendTime(b, 12, 13, m.ResponseTime)
+	if len(m.ResponseMessage) > 0 {
+		b = appendBytes(b, 14, m.ResponseMessage)
+	}
+	return b
+}
+
+func socketFamily(addrs ...netip.AddrPort) uint64 {
+	for _, ap := range addrs {
+		switch {
+		case !ap.IsValid():
+			continue
+		case ap.Addr().Unmap().Is4():
+			return socketFamilyINET
+		default:
+			return socketFamilyINET6
+		}
+	}
+	return 0
+}
+
+func packName(name string) []byte {
+	buf := make([]byte, 256)
+	n, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(b []byte, field, wireType uint64) []byte {
+	return binary.AppendUvarint(b, field<<3|wireType)
+}
+
+func appendVarint(b []byte, field, v uint64) []byte {
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytes(b []byte, field uint64, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendFixed32(b []byte, field uint64, v uint32) []byte {
+	b = appendTag(b, field, wireFixed32)
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+func appendAddrPort(b []byte, addrField, portField uint64, ap netip.AddrPort) []byte {
+	if !ap.IsValid() {
+		return b
+	}
+
+	addr := ap.Addr().Unmap()
+	b = appendBytes(b, addrField, addr.AsSlice())
+	return appendVarint(b, portField, uint64(ap.Port()))
+}
+
+func appendTime(b []byte, secField, nsecField uint64, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+
+	b = appendVarint(b, secField, uint64(t.Unix()))
+	return appendFixed32(b, nsecField, uint32(t.Nanosecond()))
+}
@@ -0,0 +1,174 @@
+package dnstap
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// decodeTestFields decodes a protobuf message into its fields,
+// varints as uint64 and the rest as []byte.
+func decodeTestFields(t *testing.T, b []byte) map[uint64]any {
+	out := make(map[uint64]any)
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad tag")
+		}
+		b = b[n:]
+
+		switch field, wireType := tag>>3, tag&7; wireType {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			out[field], b = v, b[n:]
+		case wireFixed32:
+			out[field], b = b[:4], b[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			out[field], b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %v", wireType)
+		}
+	}
+	return out
+}
+
+func TestMessageMarshal(t *testing.T) {
+	m := &Message{
+		Type:         ClientQuery,
+		Protocol:     UDP,
+		QueryAddr:    netip.MustParseAddrPort("192.0.2.1:5353"),
+		ResponseAddr: netip.MustParseAddrPort("192.0.2.2:53"),
+		QueryTime:    time.Unix(1700000000, 42),
+		QueryMessage: []byte("query"),
+	}
+
+	top := decodeTestFields(t, m.Marshal("ns1", ""))
+	if string(top[1].([]byte)) != "ns1" || top[2] != nil || top[15] != uint64(1) {
+		t.Fatalf("unexpected dnstap fields: %v", top)
+	}
+
+	msg := decodeTestFields(t, top[14].([]byte))
+	for field, expected := range map[uint64]any{
+		1: uint64(ClientQuery),
+		2: uint64(socketFamilyINET),
+		3: uint64(UDP),
+		6: uint64(5353),
+		7: uint64(53),
+		8: uint64(1700000000),
+	} {
+		if msg[field] != expected {
+			t.Errorf("field %v: %v, expected %v", field, msg[field], expected)
+		}
+	}
+
+	if addr, ok := netip.AddrFromSlice(msg[4].([]byte)); !ok || addr.String() != "192.0.2.1" {
+		t.Errorf("unexpected query address %v", msg[4])
+	}
+	if string(msg[10].([]byte)) != "query" || msg[14] != nil {
+		t.Errorf("unexpected messages: %v", msg)
+	}
+}
+
+// runTestCollector accepts one Frame Streams connection and
+// passes the data frames received to the channel.
+func runTestCollector(t *testing.T, ln net.Listener, frames chan<- []byte) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	if err := readControl(conn, controlReady); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := writeControl(conn, controlAccept); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := readControl(conn, controlStart); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			t.Error(err)
+			return
+		}
+
+		length := binary.BigEndian.Uint32(hdr[:])
+		if length == 0 {
+			// STOP
+			_, _ = io.ReadFull(conn, make([]byte, 8))
+			_ = writeControl(conn, controlFinish)
+			close(frames)
+			return
+		}
+
+		b := make([]byte, length)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			t.Error(err)
+			return
+		}
+		frames <- b
+	}
+}
+
+func TestSender(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "dnstap.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	frames := make(chan []byte, 8)
+	go runTestCollector(t, ln, frames)
+
+	s, err := NewSender("unix", socket, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Identity = "test"
+	s.Sample = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+
+	for i := 0; i < 4; i++ {
+		if s.Sampled() {
+			s.Send(&Message{Type: ClientQuery, QueryMessage: []byte{byte(i)}})
+		}
+	}
+
+	for _, expected := range []byte{0, 2} {
+		select {
+		case b := <-frames:
+			msg := decodeTestFields(t, decodeTestFields(t, b)[14].([]byte))
+			if v := msg[10].([]byte); v[0] != expected {
+				t.Errorf("unexpected message %v, expected %v", v[0], expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-frames:
+		if ok {
+			t.Error("unexpected frame")
+		}
+	case <-time.After(time.Second):
+		t.Error("stream not stopped")
+	}
+}
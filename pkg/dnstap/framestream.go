@@ -0,0 +1,117 @@
+package dnstap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// ContentType is the Frame Streams content type of dnstap.
+const ContentType = "protobuf:dnstap.Dnstap"
+
+// Frame Streams control frame types
+const (
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlStop   = 0x03
+	controlReady  = 0x04
+	controlFinish = 0x05
+
+	controlFieldContentType = 0x01
+
+	// maxControlFrameLength limits the control frames accepted.
+	maxControlFrameLength = 512
+)
+
+var errControlFrame = errors.New("invalid Frame Streams control frame")
+
+// writeControl writes a Frame Streams control frame, including the
+// dnstap content type unless it's a FINISH or STOP.
+func writeControl(w io.Writer, controlType uint32) error {
+	var body []byte
+
+	body = binary.BigEndian.AppendUint32(body, controlType)
+	if controlType != controlFinish && controlType != controlStop {
+		body = binary.BigEndian.AppendUint32(body, controlFieldContentType)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(ContentType)))
+		body = append(body, ContentType...)
+	}
+
+	// escape, length, body
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b[4:], uint32(len(body)))
+	b = append(b, body...)
+
+	_, err := w.Write(b)
+	return err
+}
+
+// readControl reads a Frame Streams control frame, expecting the given
+// type, and checks the content type is dnstap if present.
+func readControl(r io.Reader, controlType uint32) error {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(hdr[4:])
+	switch {
+	case binary.BigEndian.Uint32(hdr[:4]) != 0:
+		// not escaped
+		return errControlFrame
+	case length < 4 || length > maxControlFrameLength:
+		return errControlFrame
+	case binary.BigEndian.Uint32(hdr[8:]) != controlType:
+		return errControlFrame
+	}
+
+	fields := make([]byte, length-4)
+	if _, err := io.ReadFull(r, fields); err != nil {
+		return err
+	}
+
+	return checkControlFields(fields)
+}
+
+// checkControlFields checks the content types listed by a control
+// frame include dnstap.
+func checkControlFields(fields []byte) error {
+	var found, listed bool
+
+	for len(fields) > 0 {
+		if len(fields) < 8 {
+			return errControlFrame
+		}
+
+		fieldType := binary.BigEndian.Uint32(fields)
+		length := binary.BigEndian.Uint32(fields[4:])
+		if uint32(len(fields)-8) < length {
+			return errControlFrame
+		}
+
+		value := fields[8 : 8+length]
+		fields = fields[8+length:]
+
+		if fieldType == controlFieldContentType {
+			listed = true
+			found = found || bytes.Equal(value, []byte(ContentType))
+		}
+	}
+
+	if listed && !found {
+		return errControlFrame
+	}
+	return nil
+}
+
+// writeFrame writes a Frame Streams data frame.
+func writeFrame(w io.Writer, payload []byte) error {
+	b := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	b = append(b, payload...)
+
+	_, err := w.Write(b)
+	return err
+}
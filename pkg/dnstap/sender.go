@@ -0,0 +1,178 @@
+package dnstap
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"darvaza.org/core"
+)
+
+const (
+	// DefaultQueueSize is the number of messages a [Sender] holds
+	// while waiting to send them.
+	DefaultQueueSize = 1024
+	// DefaultTimeout is how long a [Sender] waits to connect
+	// and complete the handshake.
+	DefaultTimeout = 5 * time.Second
+	// DefaultReconnectInterval is how long a [Sender] waits before
+	// connecting again after a failure.
+	DefaultReconnectInterval = time.Second
+)
+
+// Sender sends dnstap messages to a Frame Streams collector listening
+// on a unix socket or TCP, reconnecting when needed. Messages are queued,
+// and discarded if the queue is full.
+type Sender struct {
+	queue   chan []byte
+	count   atomic.Uint64
+	dropped atomic.Uint64
+
+	Network string
+	Address string
+
+	// Identity and Version describe the server in every message.
+	Identity string
+	Version  string
+
+	// Sample makes only one in that many exchanges be logged.
+	// Zero or one logs all.
+	Sample uint64
+
+	Timeout           time.Duration
+	ReconnectInterval time.Duration
+}
+
+// NewSender creates a [Sender] for a collector listening on the given
+// "unix" or "tcp" address, with a queue of the given size.
+// [DefaultQueueSize] is used if zero.
+func NewSender(network, address string, queueSize int) (*Sender, error) {
+	switch {
+	case network != "unix" && network != "tcp":
+		return nil, core.Wrapf(core.ErrInvalid, "unsupported network %q", network)
+	case address == "", queueSize < 0:
+		return nil, core.ErrInvalid
+	}
+
+	s := &Sender{
+		queue:             make(chan []byte, core.Coalesce(queueSize, DefaultQueueSize)),
+		Network:           network,
+		Address:           address,
+		Timeout:           DefaultTimeout,
+		ReconnectInterval: DefaultReconnectInterval,
+	}
+	return s, nil
+}
+
+// Sampled tells if the next exchange should be logged,
+// according to Sample.
+func (s *Sender) Sampled() bool {
+	n := s.count.Add(1)
+	return s.Sample < 2 || n%s.Sample == 1
+}
+
+// Send queues a message. It returns false if the queue is full
+// and the message was discarded.
+func (s *Sender) Send(m *Message) bool {
+	if m == nil {
+		return false
+	}
+
+	select {
+	case s.queue <- m.Marshal(s.Identity, s.Version):
+		return true
+	default:
+		s.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns the number of messages discarded because
+// the queue was full.
+func (s *Sender) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Start sends the queued messages in the background until
+// the context is cancelled.
+func (s *Sender) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+func (s *Sender) run(ctx context.Context) {
+	for {
+		conn, err := s.connect(ctx)
+		if err == nil {
+			err = s.serve(ctx, conn)
+		}
+
+		if err == nil {
+			// cancelled
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.ReconnectInterval):
+		}
+	}
+}
+
+// connect dials the collector and completes the bidirectional
+// Frame Streams handshake.
+func (s *Sender) connect(ctx context.Context) (net.Conn, error) {
+	timeout := core.Coalesce(s.Timeout, DefaultTimeout)
+
+	var d net.Dialer
+	ctx2, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := d.DialContext(ctx2, s.Network, s.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	err = writeControl(conn, controlReady)
+	if err == nil {
+		err = readControl(conn, controlAccept)
+	}
+	if err == nil {
+		err = writeControl(conn, controlStart)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// serve writes the queued messages until the context is cancelled,
+// when the stream is stopped, or the connection fails.
+func (s *Sender) serve(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.stop(conn)
+			return nil
+		case b := <-s.queue:
+			if err := writeFrame(conn, b); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// stop ends the stream, waiting for the collector to confirm.
+func (s *Sender) stop(conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(core.Coalesce(s.Timeout, DefaultTimeout)))
+	if writeControl(conn, controlStop) == nil {
+		_ = readControl(conn, controlFinish)
+	}
+}
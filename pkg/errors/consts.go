@@ -23,6 +23,18 @@ const (
 	// NOTIMPLEMENTED is the text on [net.DNSError].Err if the requested
 	// functionality isn't implemented by the server
 	NOTIMPLEMENTED = "feature not implemented by the server"
+	// RATELIMITED is the text on [net.DNSError].Err if the request was
+	// dropped to stay within the configured rate limits
+	RATELIMITED = "request rate limit exceeded"
+	// OVERLOADED is the text on [net.DNSError].Err if the request was
+	// dropped because too many were waiting
+	OVERLOADED = "too many pending requests"
+	// NULLMX is the text on [net.DNSError].Err if the domain explicitly
+	// doesn't accept email, RFC 7505
+	NULLMX = "domain doesn't accept mail (null MX)"
+	// UNAUTHENTICATED is the text on [net.DNSError].Err if the response
+	// was required to be DNSSEC-authenticated but wasn't
+	UNAUTHENTICATED = "response not DNSSEC-authenticated"
 )
 
 var (
@@ -33,6 +33,27 @@ func ErrTypeNotFound(qName string) *net.DNSError {
 	}
 }
 
+// ErrNullMX assembles a net.DNSError indicating
+// the domain has a null MX record, RFC 7505, as it
+// doesn't accept email.
+func ErrNullMX(qName string) *net.DNSError {
+	return &net.DNSError{
+		Err:        NULLMX,
+		Name:       qName,
+		IsNotFound: true,
+	}
+}
+
+// ErrUnauthenticated assembles a net.DNSError indicating
+// the response lacked the AD bit when required.
+func ErrUnauthenticated(qName, server string) *net.DNSError {
+	return &net.DNSError{
+		Err:    UNAUTHENTICATED,
+		Name:   qName,
+		Server: server,
+	}
+}
+
 // ErrTimeoutMessage is a variant of ErrTimeout that uses
 // a given message instead of wrapping an error
 func ErrTimeoutMessage(qName string, msg string) *net.DNSError {
@@ -86,6 +107,28 @@ func ErrRefused(name string) *net.DNSError {
 	}
 }
 
+// ErrRateLimited reports a request was dropped to stay
+// within the rate limits of the server
+func ErrRateLimited(name, server string) *net.DNSError {
+	return &net.DNSError{
+		Err:         RATELIMITED,
+		Name:        name,
+		Server:      server,
+		IsTemporary: true,
+	}
+}
+
+// ErrOverloaded reports a request was dropped because
+// too many were waiting already
+func ErrOverloaded(name, server string) *net.DNSError {
+	return &net.DNSError{
+		Err:         OVERLOADED,
+		Name:        name,
+		Server:      server,
+		IsTemporary: true,
+	}
+}
+
 // ErrTimeout assembles a Timeout() error
 func ErrTimeout(qName string, err error) *net.DNSError {
 	var msg string
@@ -122,6 +165,12 @@ func IsNotFound(err error) bool {
 	}
 }
 
+// IsNullMX checks if the given error represents a null MX, RFC 7505
+func IsNullMX(err error) bool {
+	e, ok := err.(*net.DNSError)
+	return ok && e.Err == NULLMX
+}
+
 // IsTimeout checks if the given error represents a Timeout
 func IsTimeout(err error) bool {
 	switch err {
@@ -91,6 +91,8 @@ func dnsErrorAsMsg(req *dns.Msg, err *net.DNSError) *dns.Msg {
 	case BADRESPONSE:
 	case NOTIMPLEMENTED:
 		return newResponseRcode(req, dns.RcodeNotImplemented)
+	case RATELIMITED:
+		return newResponseRcode(req, dns.RcodeRefused)
 	default:
 		rcode, ok := dns.StringToRcode[err.Err]
 		if ok {
@@ -1,6 +1,11 @@
 package exdns
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
 
 func TestAsServerAddress(t *testing.T) {
 	tests := []struct {
@@ -65,3 +70,97 @@ func TestDecanonize(t *testing.T) {
 		}
 	}
 }
+
+func TestDecayTTL(t *testing.T) {
+	newA := func(ttl uint32) dns.RR {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		}
+	}
+
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{newA(300), newA(60)}
+	msg.SetEdns0(1232, false)
+
+	if ttl, ok := MinTTL(msg); !ok || ttl != 60 {
+		t.Errorf("expected min TTL 60, got %v", ttl)
+	}
+
+	if DecayTTL(msg, 30*time.Second+500*time.Millisecond) {
+		t.Error("unexpected expiration")
+	}
+	if a, b := msg.Answer[0].Header().Ttl, msg.Answer[1].Header().Ttl; a != 270 || b != 30 {
+		t.Errorf("expected 270/30, got %v/%v", a, b)
+	}
+	if opt := msg.IsEdns0(); opt == nil || opt.UDPSize() != 1232 {
+		t.Error("OPT record modified")
+	}
+
+	if !DecayTTL(msg, 30*time.Second) {
+		t.Error("expiration not reported")
+	}
+	if ttl := msg.Answer[1].Header().Ttl; ttl != 0 {
+		t.Errorf("expected TTL 0, got %v", ttl)
+	}
+}
+
+func TestNormalizeRequest(t *testing.T) {
+	a := new(dns.Msg)
+	a.SetQuestion("Example.ORG.", dns.TypeA)
+	a.SetEdns0(1232, true)
+	a.IsEdns0().Option = append(a.IsEdns0().Option,
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0123456789abcdef"},
+		&dns.EDNS0_PADDING{Padding: make([]byte, 16)})
+
+	b := new(dns.Msg)
+	b.SetQuestion("example.org.", dns.TypeA)
+	b.SetEdns0(1232, true)
+	b.Zero = true
+
+	if sa, sb := NormalizeRequest(a).String(), NormalizeRequest(b).String(); sa != sb {
+		t.Errorf("normalized requests differ:\n%s\n%s", sa, sb)
+	}
+
+	// DO matters
+	b.IsEdns0().SetDo(false)
+	if NormalizeRequest(a).String() == NormalizeRequest(b).String() {
+		t.Error("DO bit ignored")
+	}
+
+	if a.Question[0].Name != "Example.ORG." || len(a.IsEdns0().Option) != 2 {
+		t.Error("original request modified")
+	}
+}
+
+func TestTSIGKeyring(t *testing.T) {
+	var kr TSIGKeyring
+
+	key := TSIGKey{
+		Name:      "Key.Example.",
+		Algorithm: dns.HmacSHA256,
+		Secret:    "c2VjcmV0",
+	}
+	if err := kr.Add(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Add(TSIGKey{Name: "bad.", Algorithm: "md4.", Secret: "c2VjcmV0"}); err == nil {
+		t.Error("unsupported algorithm accepted")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.org.", dns.TypeA)
+	msg.SetTsig("key.example.", dns.HmacSHA256, 300, time.Now().Unix())
+
+	b, _, err := dns.TsigGenerateWithProvider(msg, &kr, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dns.TsigVerifyWithProvider(b, &kr, "", false); err != nil {
+		t.Errorf("verification failed: %v", err)
+	}
+
+	kr.Remove("KEY.example.")
+	if err := dns.TsigVerifyWithProvider(b, &kr, "", false); err == nil {
+		t.Error("verified after removing the key")
+	}
+}
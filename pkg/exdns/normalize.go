@@ -0,0 +1,61 @@
+package exdns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// NormalizeRequest returns a copy of a request with everything that
+// doesn't affect the response removed, so trivially different encodings
+// of the same question compare equal. The ID is zeroed, names are
+// lowercased, header bits other than RD, CD and AD are cleared, and
+// EDNS0 padding and cookies are removed.
+func NormalizeRequest(req *dns.Msg) *dns.Msg {
+	if req == nil {
+		return nil
+	}
+
+	out := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Opcode:            req.Opcode,
+			RecursionDesired:  req.RecursionDesired,
+			CheckingDisabled:  req.CheckingDisabled,
+			AuthenticatedData: req.AuthenticatedData,
+		},
+		Question: make([]dns.Question, len(req.Question)),
+	}
+
+	for i, q := range req.Question {
+		q.Name = dns.CanonicalName(q.Name)
+		out.Question[i] = q
+	}
+
+	if opt := req.IsEdns0(); opt != nil {
+		out.Extra = []dns.RR{normalizeOPT(opt)}
+	}
+
+	return out
+}
+
+func normalizeOPT(opt *dns.OPT) *dns.OPT {
+	out := &dns.OPT{
+		Hdr: dns.RR_Header{
+			Name:   ".",
+			Rrtype: dns.TypeOPT,
+		},
+	}
+	out.SetUDPSize(opt.UDPSize())
+	out.SetVersion(opt.Version())
+	out.SetDo(opt.Do())
+
+	for _, o := range opt.Option {
+		switch o.Option() {
+		case dns.EDNS0PADDING, dns.EDNS0COOKIE:
+			// irrelevant
+		default:
+			// shared, options aren't modified
+			out.Option = append(out.Option, o)
+		}
+	}
+
+	return out
+}
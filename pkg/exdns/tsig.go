@@ -0,0 +1,134 @@
+package exdns
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" // #nosec G505 -- hmac-sha1 is still used by TSIG
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+var _ dns.TsigProvider = (*TSIGKeyring)(nil)
+
+// TSIGKey is a TSIG shared secret, RFC 8945.
+type TSIGKey struct {
+	// Name is the name of the key.
+	Name string
+	// Algorithm is the HMAC algorithm, i.e. [dns.HmacSHA256].
+	Algorithm string
+	// Secret is the base64 encoded secret.
+	Secret string
+}
+
+// TSIGKeyring is a [dns.TsigProvider] holding TSIG keys by name.
+// The zero value is ready to use.
+type TSIGKeyring struct {
+	mu   sync.RWMutex
+	keys map[string]tsigKey
+}
+
+type tsigKey struct {
+	TSIGKey
+
+	secret []byte
+}
+
+// Add adds or replaces a key.
+func (kr *TSIGKeyring) Add(key TSIGKey) error {
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	switch {
+	case err != nil, len(secret) == 0, key.Name == "":
+		return core.ErrInvalid
+	case newTSIGHash(key.Algorithm, secret) == nil:
+		return dns.ErrKeyAlg
+	}
+
+	key.Name = dns.CanonicalName(key.Name)
+	key.Algorithm = dns.CanonicalName(key.Algorithm)
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if kr.keys == nil {
+		kr.keys = make(map[string]tsigKey)
+	}
+	kr.keys[key.Name] = tsigKey{key, secret}
+	return nil
+}
+
+// Remove removes a key.
+func (kr *TSIGKeyring) Remove(name string) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	delete(kr.keys, dns.CanonicalName(name))
+}
+
+// Get returns a key by name.
+func (kr *TSIGKeyring) Get(name string) (TSIGKey, bool) {
+	key, ok := kr.get(name)
+	return key.TSIGKey, ok
+}
+
+func (kr *TSIGKeyring) get(name string) (tsigKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, ok := kr.keys[dns.CanonicalName(name)]
+	return key, ok
+}
+
+// Generate implements the [dns.TsigProvider] interface,
+// computing the MAC of a message.
+func (kr *TSIGKeyring) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	key, ok := kr.get(t.Hdr.Name)
+	switch {
+	case !ok:
+		return nil, dns.ErrSecret
+	case key.Algorithm != dns.CanonicalName(t.Algorithm):
+		return nil, dns.ErrKeyAlg
+	}
+
+	h := newTSIGHash(key.Algorithm, key.secret)
+	_, _ = h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+// Verify implements the [dns.TsigProvider] interface,
+// checking the MAC of a message.
+func (kr *TSIGKeyring) Verify(msg []byte, t *dns.TSIG) error {
+	b, err := kr.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil || !hmac.Equal(b, mac) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+func newTSIGHash(algorithm string, secret []byte) hash.Hash {
+	switch dns.CanonicalName(algorithm) {
+	case dns.HmacSHA1:
+		return hmac.New(sha1.New, secret)
+	case dns.HmacSHA224:
+		return hmac.New(sha256.New224, secret)
+	case dns.HmacSHA256:
+		return hmac.New(sha256.New, secret)
+	case dns.HmacSHA384:
+		return hmac.New(sha512.New384, secret)
+	case dns.HmacSHA512:
+		return hmac.New(sha512.New, secret)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package exdns
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ForEachTTLRR calls a function for every record of a message
+// subject to TTL, skipping the OPT pseudo-record.
+func ForEachTTLRR(msg *dns.Msg, fn func(dns.RR)) {
+	if msg == nil || fn == nil {
+		return
+	}
+
+	for _, s := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range s {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				fn(rr)
+			}
+		}
+	}
+}
+
+// MinTTL returns the lowest TTL of the records of a message,
+// or false if it has none.
+func MinTTL(msg *dns.Msg) (uint32, bool) {
+	var ttl uint32
+	var ok bool
+
+	ForEachTTLRR(msg, func(rr dns.RR) {
+		if n := rr.Header().Ttl; !ok || n < ttl {
+			ttl, ok = n, true
+		}
+	})
+
+	return ttl, ok
+}
+
+// DecayTTL reduces the TTL of every record of a message by the given
+// time, in place, and tells if any of them reached zero.
+func DecayTTL(msg *dns.Msg, elapsed time.Duration) bool {
+	var expired bool
+
+	n := uint32(elapsed / time.Second)
+	ForEachTTLRR(msg, func(rr dns.RR) {
+		hdr := rr.Header()
+		if hdr.Ttl > n {
+			hdr.Ttl -= n
+		} else {
+			hdr.Ttl = 0
+			expired = true
+		}
+	})
+
+	return expired
+}
+
+// ClampTTL adjusts, in place, the TTL of every record of a message
+// to be at least minTTL and, if not zero, at most maxTTL.
+func ClampTTL(msg *dns.Msg, minTTL, maxTTL uint32) {
+	ForEachTTLRR(msg, func(rr dns.RR) {
+		hdr := rr.Header()
+		switch {
+		case hdr.Ttl < minTTL:
+			hdr.Ttl = minTTL
+		case maxTTL > 0 && hdr.Ttl > maxTTL:
+			hdr.Ttl = maxTTL
+		}
+	})
+}
@@ -0,0 +1,253 @@
+// Package kubernetes implements a [resolver.ServiceDiscovery] backed
+// by the Services and EndpointSlices of a Kubernetes cluster, following
+// the Kubernetes DNS-Based Service Discovery specification.
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+var _ resolver.ServiceDiscovery = (*Discovery)(nil)
+
+const (
+	// DefaultDomain is the cluster domain used if not specified.
+	DefaultDomain = "cluster.local."
+
+	// DefaultRefreshInterval indicates how often [Discovery.Watch]
+	// reloads the services if not specified.
+	DefaultRefreshInterval = 30 * time.Second
+
+	// ServiceAccountDir is where the credentials of the service
+	// account are mounted within a pod.
+	ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// Config describes how to reach the Kubernetes API.
+type Config struct {
+	// Server is the URL of the API server.
+	Server string
+	// Token is the bearer token used to authenticate.
+	Token string
+	// TokenFile, if set, is read before every refresh to get
+	// the bearer token, as projected tokens are rotated.
+	TokenFile string
+	// HTTPClient, if set, makes the requests, i.e. trusting
+	// the CA of the cluster.
+	HTTPClient *http.Client
+
+	// Domain is the cluster domain. [DefaultDomain] is used
+	// if not specified.
+	Domain string
+	// Namespace, if set, limits the discovery to one namespace.
+	Namespace string
+}
+
+// InClusterConfig returns the [Config] to reach the API server
+// from within a pod, using its service account.
+func InClusterConfig() (*Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running within a Kubernetes cluster")
+	}
+
+	pem, err := os.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("invalid cluster CA certificate")
+	}
+
+	return &Config{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		TokenFile: filepath.Join(ServiceAccountDir, "token"),
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:    pool,
+					MinVersion: tls.VersionTLS12,
+				},
+			},
+		},
+	}, nil
+}
+
+// Discovery is a [resolver.ServiceDiscovery] providing the Services
+// of a Kubernetes cluster. ClusterIP services are given their cluster
+// addresses, headless ones the addresses of their ready endpoints,
+// and ExternalName ones an alias.
+type Discovery struct {
+	cfg    Config
+	domain string
+
+	mu       sync.RWMutex
+	services map[string]*resolver.Service
+}
+
+// Domain returns the canonical cluster domain.
+func (d *Discovery) Domain() string {
+	return d.domain
+}
+
+// LookupService implements the [resolver.ServiceDiscovery] interface.
+// The returned [resolver.Service] must not be modified.
+func (d *Discovery) LookupService(_ context.Context, qName string) (*resolver.Service, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if svc, ok := d.services[dns.CanonicalName(qName)]; ok {
+		return svc, nil
+	}
+	return nil, errors.ErrNotFound(qName)
+}
+
+// Refresh reloads the Services and EndpointSlices from the API server.
+// On error the previous data is kept.
+func (d *Discovery) Refresh(ctx context.Context) error {
+	var services serviceList
+	var slices endpointSliceList
+
+	if err := d.get(ctx, "/api/v1", "services", &services); err != nil {
+		return err
+	}
+
+	if err := d.get(ctx, "/apis/discovery.k8s.io/v1", "endpointslices", &slices); err != nil {
+		return err
+	}
+
+	m := buildServices(d.domain, services.Items, slices.Items)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.services = m
+	return nil
+}
+
+// Watch loads the services, and keeps reloading them in the background
+// until the context is cancelled. Errors reloading leave the services
+// as they were. [DefaultRefreshInterval] is used if interval is zero.
+func (d *Discovery) Watch(ctx context.Context, interval time.Duration) error {
+	if err := d.Refresh(ctx); err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+
+	go d.watch(ctx, interval)
+	return nil
+}
+
+func (d *Discovery) watch(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			// failed, try again later
+			_ = d.Refresh(ctx)
+		}
+	}
+}
+
+// get fetches a list of resources, of the namespace if set.
+func (d *Discovery) get(ctx context.Context, prefix, resource string, out any) error {
+	u := strings.TrimSuffix(d.cfg.Server, "/") + prefix
+	if d.cfg.Namespace != "" {
+		u += "/namespaces/" + d.cfg.Namespace
+	}
+	u += "/" + resource
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	token, err := d.getToken()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := core.Coalesce(d.cfg.HTTPClient, http.DefaultClient).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resource, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return core.Wrapf(err, "%s: invalid response", resource)
+	}
+	return nil
+}
+
+func (d *Discovery) getToken() (string, error) {
+	if d.cfg.TokenFile == "" {
+		return d.cfg.Token, nil
+	}
+
+	b, err := os.ReadFile(d.cfg.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// NewLookuper creates a [resolver.DiscoveryLookuper] answering
+// for the cluster domain using the [Discovery].
+func (d *Discovery) NewLookuper() (*resolver.DiscoveryLookuper, error) {
+	return resolver.NewDiscoveryLookuper(d.domain, d)
+}
+
+// New creates a [Discovery] using the given [Config]. Services
+// aren't loaded until [Discovery.Refresh] or [Discovery.Watch]
+// are called.
+func New(cfg *Config) (*Discovery, error) {
+	switch {
+	case cfg == nil:
+		return nil, core.Wrap(core.ErrInvalid, "config required")
+	case cfg.Server == "":
+		return nil, core.Wrap(core.ErrInvalid, "server required")
+	}
+
+	domain := core.Coalesce(cfg.Domain, DefaultDomain)
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return nil, core.Wrapf(core.ErrInvalid, "invalid domain %q", domain)
+	}
+
+	return &Discovery{
+		cfg:    *cfg,
+		domain: dns.CanonicalName(domain),
+	}, nil
+}
@@ -0,0 +1,175 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testServices = `{"kind":"ServiceList","items":[
+ {"metadata":{"name":"web","namespace":"default"},
+  "spec":{"type":"ClusterIP","clusterIP":"10.96.0.10","clusterIPs":["10.96.0.10","fd00::10"],
+   "ports":[{"name":"http","protocol":"TCP","port":80},{"protocol":"TCP","port":8080}]}},
+ {"metadata":{"name":"db","namespace":"prod"},
+  "spec":{"type":"ClusterIP","clusterIP":"None",
+   "ports":[{"name":"pg","protocol":"TCP","port":5432}]}},
+ {"metadata":{"name":"ext","namespace":"default"},
+  "spec":{"type":"ExternalName","externalName":"www.example.org"}}
+]}`
+
+const testEndpointSlices = `{"kind":"EndpointSliceList","items":[
+ {"metadata":{"name":"db-abc","namespace":"prod","labels":{"kubernetes.io/service-name":"db"}},
+  "addressType":"IPv4",
+  "endpoints":[
+   {"addresses":["10.0.0.1"],"hostname":"db-0","conditions":{"ready":true}},
+   {"addresses":["10.0.0.2"],"conditions":{}},
+   {"addresses":["10.0.0.3"],"conditions":{"ready":false}}],
+  "ports":[{"name":"pg","protocol":"TCP","port":5432}]}
+]}`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	for path, body := range map[string]string{
+		"/api/v1/services":                         testServices,
+		"/apis/discovery.k8s.io/v1/endpointslices": testEndpointSlices,
+	} {
+		body := body
+		mux.HandleFunc(path, func(rw http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") != "Bearer secret" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			_, _ = rw.Write([]byte(body))
+		})
+	}
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDiscovery(t *testing.T) {
+	srv := newTestServer(t)
+
+	d, err := New(&Config{Server: srv.URL, Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := d.NewLookuper()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		qType  uint16
+		rcode  int
+		answer []string
+	}{
+		{"web.default.svc.cluster.local", dns.TypeA, dns.RcodeSuccess, []string{"10.96.0.10"}},
+		{"web.default.svc.cluster.local", dns.TypeAAAA, dns.RcodeSuccess, []string{"fd00::10"}},
+		{"_http._tcp.web.default.svc.cluster.local", dns.TypeSRV, dns.RcodeSuccess,
+			[]string{"web.default.svc.cluster.local.:80"}},
+		{"_http._udp.web.default.svc.cluster.local", dns.TypeSRV, dns.RcodeNameError, nil},
+		{"db.prod.svc.cluster.local", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.1", "10.0.0.2"}},
+		{"db-0.db.prod.svc.cluster.local", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.1"}},
+		{"10-0-0-2.db.prod.svc.cluster.local", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.2"}},
+		{"_pg._tcp.db.prod.svc.cluster.local", dns.TypeSRV, dns.RcodeSuccess, []string{
+			"db-0.db.prod.svc.cluster.local.:5432",
+			"10-0-0-2.db.prod.svc.cluster.local.:5432",
+		}},
+		{"ext.default.svc.cluster.local", dns.TypeA, dns.RcodeSuccess, []string{"www.example.org."}},
+		{"missing.default.svc.cluster.local", dns.TypeA, dns.RcodeNameError, nil},
+		// empty non-terminals
+		{"default.svc.cluster.local", dns.TypeA, dns.RcodeSuccess, nil},
+		{"svc.cluster.local", dns.TypeA, dns.RcodeSuccess, nil},
+		{"cluster.local", dns.TypeA, dns.RcodeSuccess, nil},
+		{"_http._tcp.svc.cluster.local", dns.TypeSRV, dns.RcodeNameError, nil},
+	}
+
+	for _, tc := range tests {
+		msg, err := h.Lookup(context.Background(), tc.name, tc.qType)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		answer := testAnswerData(msg.Answer)
+		if msg.Rcode != tc.rcode || !testEqual(answer, tc.answer) {
+			t.Errorf("%s/%s: unexpected response %s %q", tc.name,
+				dns.TypeToString[tc.qType], dns.RcodeToString[msg.Rcode], answer)
+		}
+
+		if len(msg.Answer) == 0 && !testHasSOA(msg, "cluster.local.") {
+			t.Errorf("%s/%s: negative answer without SOA: %v", tc.name,
+				dns.TypeToString[tc.qType], msg.Ns)
+		}
+	}
+
+	if _, err := h.Lookup(context.Background(), "www.example.org", dns.TypeA); err == nil {
+		t.Error("name outside the cluster domain not refused")
+	}
+
+	// unauthorized
+	d2, err := New(&Config{Server: srv.URL, Token: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Refresh(context.Background()); err == nil {
+		t.Error("unauthorized refresh succeeded")
+	}
+
+	if _, err := New(&Config{}); err == nil {
+		t.Error("config without server accepted")
+	}
+}
+
+func testAnswerData(records []dns.RR) []string {
+	var out []string
+	for _, rr := range records {
+		switch v := rr.(type) {
+		case *dns.A:
+			out = append(out, v.A.String())
+		case *dns.AAAA:
+			out = append(out, v.AAAA.String())
+		case *dns.CNAME:
+			out = append(out, v.Target)
+		case *dns.SRV:
+			out = append(out, v.Target+":"+strconv.Itoa(int(v.Port)))
+		}
+	}
+	return out
+}
+
+// testHasSOA tells if the authority section of a response
+// has the SOA of the given zone.
+func testHasSOA(msg *dns.Msg, zone string) bool {
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok && soa.Hdr.Name == zone {
+			return true
+		}
+	}
+	return false
+}
+
+func testEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
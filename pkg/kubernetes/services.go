@@ -0,0 +1,221 @@
+package kubernetes
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver"
+)
+
+// serviceNameLabel links EndpointSlices to their Service
+const serviceNameLabel = "kubernetes.io/service-name"
+
+type objectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+}
+
+type serviceList struct {
+	Items []service `json:"items"`
+}
+
+type service struct {
+	Metadata objectMeta  `json:"metadata"`
+	Spec     serviceSpec `json:"spec"`
+}
+
+type serviceSpec struct {
+	Type         string        `json:"type"`
+	ClusterIP    string        `json:"clusterIP"`
+	ClusterIPs   []string      `json:"clusterIPs"`
+	ExternalName string        `json:"externalName"`
+	Ports        []servicePort `json:"ports"`
+}
+
+type servicePort struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Port     uint16 `json:"port"`
+}
+
+type endpointSliceList struct {
+	Items []endpointSlice `json:"items"`
+}
+
+type endpointSlice struct {
+	Metadata  objectMeta    `json:"metadata"`
+	Endpoints []endpoint    `json:"endpoints"`
+	Ports     []servicePort `json:"ports"`
+}
+
+type endpoint struct {
+	Addresses  []string           `json:"addresses"`
+	Hostname   string             `json:"hostname"`
+	Conditions endpointConditions `json:"conditions"`
+}
+
+type endpointConditions struct {
+	Ready *bool `json:"ready"`
+}
+
+// IsReady tells if the endpoint can receive traffic,
+// unknown meaning ready.
+func (ep *endpoint) IsReady() bool {
+	return ep.Conditions.Ready == nil || *ep.Conditions.Ready
+}
+
+// servicesBuilder assembles the [resolver.Service]s of a cluster
+type servicesBuilder struct {
+	domain string
+	out    map[string]*resolver.Service
+}
+
+func (b *servicesBuilder) name(labels ...string) string {
+	return strings.ToLower(strings.Join(labels, ".")) + ".svc." + b.domain
+}
+
+func (b *servicesBuilder) get(name string) *resolver.Service {
+	svc, ok := b.out[name]
+	if !ok {
+		svc = &resolver.Service{Name: name}
+		b.out[name] = svc
+		b.addParents(name)
+	}
+	return svc
+}
+
+// addParents adds the names between a service and the cluster domain,
+// like "svc.<domain>" and "<ns>.svc.<domain>", as empty non-terminals.
+func (b *servicesBuilder) addParents(name string) {
+	off, end := dns.NextLabel(name, 0)
+	for ; !end && len(name)-off > len(b.domain); off, end = dns.NextLabel(name, off) {
+		parent := name[off:]
+		if _, ok := b.out[parent]; ok {
+			// and so its parents
+			return
+		}
+		b.out[parent] = &resolver.Service{Name: parent}
+	}
+}
+
+func (b *servicesBuilder) addService(s *service, slices []*endpointSlice) {
+	meta := &s.Metadata
+	svc := b.get(b.name(meta.Name, meta.Namespace))
+
+	switch {
+	case s.Spec.Type == "ExternalName":
+		svc.Alias = s.Spec.ExternalName
+	case s.Spec.ClusterIP == "None":
+		b.addHeadless(svc, meta, slices)
+	default:
+		svc.Addrs = parseAddrs(append([]string{s.Spec.ClusterIP}, s.Spec.ClusterIPs...))
+		for _, p := range s.Spec.Ports {
+			if p.Name != "" {
+				svc.Ports = append(svc.Ports, newServicePort(p, ""))
+			}
+		}
+	}
+}
+
+// addHeadless adds the ready endpoints of a headless service, each
+// with its own name for the SRV records to point to.
+func (b *servicesBuilder) addHeadless(svc *resolver.Service, meta *objectMeta,
+	slices []*endpointSlice) {
+	//
+	for _, es := range slices {
+		for _, ep := range es.Endpoints {
+			if !ep.IsReady() {
+				continue
+			}
+
+			addrs := parseAddrs(ep.Addresses)
+			if len(addrs) == 0 {
+				continue
+			}
+
+			host := ep.Hostname
+			if host == "" {
+				// dashed address, like 10-0-0-1
+				host = strings.NewReplacer(".", "-", ":", "-").Replace(addrs[0].String())
+			}
+
+			target := b.get(b.name(host, meta.Name, meta.Namespace))
+			target.Addrs = append(target.Addrs, addrs...)
+			svc.Addrs = append(svc.Addrs, addrs...)
+
+			for _, p := range es.Ports {
+				if p.Name != "" {
+					svc.Ports = append(svc.Ports, newServicePort(p, target.Name))
+				}
+			}
+		}
+	}
+}
+
+func newServicePort(p servicePort, target string) resolver.ServicePort {
+	proto := p.Protocol
+	if proto == "" {
+		proto = "TCP"
+	}
+
+	return resolver.ServicePort{
+		Name:     strings.ToLower(p.Name),
+		Protocol: strings.ToLower(proto),
+		Port:     p.Port,
+		Target:   target,
+	}
+}
+
+func parseAddrs(ss []string) []netip.Addr {
+	var out []netip.Addr
+	for _, s := range ss {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			continue
+		}
+
+		addr = addr.Unmap()
+		if !containsAddr(out, addr) {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+func containsAddr(addrs []netip.Addr, addr netip.Addr) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// buildServices assembles the [resolver.Service]s of a cluster
+// by canonical name.
+func buildServices(domain string, services []service,
+	slices []endpointSlice) map[string]*resolver.Service {
+	//
+	bySvc := make(map[[2]string][]*endpointSlice)
+	for i := range slices {
+		es := &slices[i]
+		if name := es.Metadata.Labels[serviceNameLabel]; name != "" {
+			key := [2]string{es.Metadata.Namespace, name}
+			bySvc[key] = append(bySvc[key], es)
+		}
+	}
+
+	b := &servicesBuilder{
+		domain: domain,
+		out:    make(map[string]*resolver.Service),
+	}
+
+	for i := range services {
+		s := &services[i]
+		b.addService(s, bySvc[[2]string{s.Metadata.Namespace, s.Metadata.Name}])
+	}
+	return b.out
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+)
+
+var _ http.Handler = (*Registry)(nil)
+
+// TextContentType is the content type of the Prometheus text format.
+const TextContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText writes all the metrics in the Prometheus text format.
+func (reg *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range reg.sortedFamilies() {
+		f.writeText(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format,
+// to be scraped.
+func (reg *Registry) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", TextContentType)
+	_ = reg.WriteText(rw)
+}
+
+// Snapshot returns the current value of all metrics. Series are keyed
+// by their labels in Prometheus notation, histograms described by their
+// "count", "sum" and "buckets".
+func (reg *Registry) Snapshot() map[string]map[string]any {
+	out := make(map[string]map[string]any)
+	for _, f := range reg.sortedFamilies() {
+		m := make(map[string]any)
+		for _, s := range f.snapshot() {
+			m[f.labelString(s.values, "", "")] = f.snapshotValue(&s)
+		}
+		out[f.name] = m
+	}
+	return out
+}
+
+// String returns the [Registry.Snapshot] in JSON, implementing
+// the expvar.Var interface so it can be published.
+func (reg *Registry) String() string {
+	b, err := json.Marshal(reg.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func (f *family) snapshotValue(s *series) any {
+	if f.kind != kindHistogram {
+		return jsonFloat(s.value)
+	}
+
+	buckets := make(map[string]uint64, len(f.buckets))
+	for i, le := range f.buckets {
+		buckets[formatFloat(le)] = s.counts[i]
+	}
+
+	return map[string]any{
+		"count":   s.count,
+		"sum":     jsonFloat(s.sum),
+		"buckets": buckets,
+	}
+}
+
+// jsonFloat replaces values JSON can't represent.
+func jsonFloat(v float64) any {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return formatFloat(v)
+	}
+	return v
+}
+
+func (f *family) writeText(w *bufio.Writer) {
+	series := f.snapshot()
+	if len(series) == 0 {
+		return
+	}
+
+	_, _ = w.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	_, _ = w.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+
+	for i := range series {
+		s := &series[i]
+		if f.kind != kindHistogram {
+			writeSample(w, f.name, f.labelString(s.values, "", ""), s.value)
+			continue
+		}
+
+		for j, le := range f.buckets {
+			labels := f.labelString(s.values, "le", formatFloat(le))
+			writeSample(w, f.name+"_bucket", labels, float64(s.counts[j]))
+		}
+		labels := f.labelString(s.values, "le", "+Inf")
+		writeSample(w, f.name+"_bucket", labels, float64(s.count))
+		writeSample(w, f.name+"_sum", f.labelString(s.values, "", ""), s.sum)
+		writeSample(w, f.name+"_count", f.labelString(s.values, "", ""), float64(s.count))
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	_, _ = w.WriteString(name + labels + " " + formatFloat(v) + "\n")
+}
+
+// labelString renders the labels of a series, and an extra one
+// if given, like `{qtype="A",rcode="NOERROR"}`.
+func (f *family) labelString(values []string, extra, extraValue string) string {
+	if len(values) == 0 && extra == "" {
+		return ""
+	}
+
+	var buf strings.Builder
+	_ = buf.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			_ = buf.WriteByte(',')
+		}
+		_, _ = buf.WriteString(f.labels[i] + `="` + escapeLabel(v) + `"`)
+	}
+	if extra != "" {
+		if len(values) > 0 {
+			_ = buf.WriteByte(',')
+		}
+		_, _ = buf.WriteString(extra + `="` + escapeLabel(extraValue) + `"`)
+	}
+	_ = buf.WriteByte('}')
+	return buf.String()
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func escapeLabel(s string) string {
+	s = escapeHelp(s)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
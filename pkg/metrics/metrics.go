@@ -0,0 +1,284 @@
+// Package metrics provides a minimal registry of counters, gauges and
+// histograms, exported in the Prometheus text format or as an expvar.
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, used by
+// latency histograms.
+var DefaultBuckets = []float64{
+	.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5,
+}
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Registry holds a set of metrics.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty [Registry].
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// Counter returns the counter of the given name, creating it with
+// the given help text and label names if needed.
+func (reg *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{reg.getFamily(name, help, kindCounter, nil, labels)}
+}
+
+// Gauge returns the gauge of the given name, creating it with
+// the given help text and label names if needed.
+func (reg *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{reg.getFamily(name, help, kindGauge, nil, labels)}
+}
+
+// Histogram returns the histogram of the given name, creating it with
+// the given help text, bucket upper bounds and label names if needed.
+// [DefaultBuckets] are used if none are given.
+func (reg *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &Histogram{reg.getFamily(name, help, kindHistogram, buckets, labels)}
+}
+
+// getFamily returns the named family, or creates it. If the name is used
+// by a metric of another kind or labels, a family that won't be exported
+// is returned.
+func (reg *Registry) getFamily(name, help, kind string,
+	buckets []float64, labels []string) *family {
+	//
+	f := newFamily(name, help, kind, buckets, labels)
+	if reg == nil {
+		return f
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if f2, ok := reg.families[name]; ok {
+		if f2.kind == kind && sameStrings(f2.labels, labels) {
+			return f2
+		}
+		return f
+	}
+
+	reg.families[name] = f
+	return f
+}
+
+func (reg *Registry) sortedFamilies() []*family {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	out := make([]*family, 0, len(reg.families))
+	for _, f := range reg.families {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].name < out[j].name
+	})
+	return out
+}
+
+// Counter is a value that only increases, optionally partitioned
+// by labels.
+type Counter struct {
+	f *family
+}
+
+// Add increases the counter for the given label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v > 0 {
+		c.f.update(labelValues, func(s *series) { s.value += v })
+	}
+}
+
+// Inc increases the counter by one for the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// SetFunc makes the counter for the given label values be read
+// from a function when exported.
+func (c *Counter) SetFunc(fn func() float64, labelValues ...string) {
+	c.f.update(labelValues, func(s *series) { s.fn = fn })
+}
+
+// Gauge is a value that can go up and down, optionally partitioned
+// by labels.
+type Gauge struct {
+	f *family
+}
+
+// Set sets the gauge for the given label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value = v })
+}
+
+// Add adds to the gauge for the given label values.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value += v })
+}
+
+// SetFunc makes the gauge for the given label values be read
+// from a function when exported.
+func (g *Gauge) SetFunc(fn func() float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.fn = fn })
+}
+
+// Histogram counts observations in buckets, optionally partitioned
+// by labels.
+type Histogram struct {
+	f *family
+}
+
+// Observe records a value for the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.update(labelValues, func(s *series) {
+		for i, le := range h.f.buckets {
+			if v <= le {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.sum += v
+	})
+}
+
+// ObserveDuration records a duration, in seconds, for the given
+// label values.
+func (h *Histogram) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+// family is a metric and all its series.
+type family struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series is the value of a metric for a set of label values.
+type series struct {
+	values []string
+	value  float64
+	fn     func() float64
+
+	// histogram
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newFamily(name, help, kind string, buckets []float64, labels []string) *family {
+	return &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  append([]string(nil), labels...),
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*series),
+	}
+}
+
+func (f *family) update(values []string, fn func(*series)) {
+	if len(values) != len(f.labels) {
+		// wrong usage, ignored
+		return
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			values: append([]string(nil), values...),
+		}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+// snapshot returns a copy of the series, sorted by label values,
+// reading those provided by functions.
+func (f *family) snapshot() []series {
+	f.mu.Lock()
+	out := make([]series, 0, len(f.series))
+	for _, s := range f.series {
+		v := *s
+		v.counts = append([]uint64(nil), s.counts...)
+		out = append(out, v)
+	}
+	f.mu.Unlock()
+
+	for i := range out {
+		if fn := out[i].fn; fn != nil {
+			out[i].value = fn()
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].values, "\xff") < strings.Join(out[j].values, "\xff")
+	})
+	return out
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Ratio returns a/(a+b), or zero if both are zero.
+func Ratio(a, b uint64) float64 {
+	if a+b == 0 {
+		return 0
+	}
+	return float64(a) / float64(a+b)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+
+	queries := reg.Counter("queries_total", "Queries.", "qtype")
+	queries.Inc("A")
+	queries.Add(2, "AAAA")
+	queries.Inc("A")
+	queries.Inc() // wrong labels
+
+	if reg.Counter("queries_total", "", "qtype") == nil {
+		t.Fatal("counter not shared")
+	}
+	reg.Counter("queries_total", "", "other").Inc("x") // not exported
+
+	reg.Gauge("ratio", "Ratio.").SetFunc(func() float64 { return Ratio(1, 3) })
+
+	h := reg.Histogram("duration_seconds", "Durations.", []float64{.1, 1})
+	h.ObserveDuration(50 * time.Millisecond)
+	h.Observe(.5)
+	h.Observe(2)
+
+	var buf strings.Builder
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 1
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 2.55
+duration_seconds_count 3
+# HELP queries_total Queries.
+# TYPE queries_total counter
+queries_total{qtype="A"} 2
+queries_total{qtype="AAAA"} 2
+# HELP ratio Ratio.
+# TYPE ratio gauge
+ratio 0.25
+`
+	if s := buf.String(); s != expected {
+		t.Errorf("unexpected output:\n%s", s)
+	}
+
+	var snapshot map[string]map[string]any
+	if err := json.Unmarshal([]byte(reg.String()), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if v := snapshot["queries_total"][`{qtype="AAAA"}`]; v != float64(2) {
+		t.Errorf("unexpected snapshot value %v", v)
+	}
+}
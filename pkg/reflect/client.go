@@ -46,6 +46,10 @@ func (c *Client) doExchange(ctx context.Context, req *dns.Msg,
 	var options reflectOptions
 	var id string
 
+	ctx, span := StartSpan(ctx, "dns.exchange", append(RequestAttributes(req),
+		Attribute{Key: "reflect.name", Value: c.name},
+		Attribute{Key: "server.address", Value: server})...)
+
 	start := time.Now()
 	level, enabled := GetEnabled(ctx, c.name)
 	if enabled {
@@ -63,6 +67,7 @@ func (c *Client) doExchange(ctx context.Context, req *dns.Msg,
 	}
 
 	resp, rtt, err := c.next.ExchangeContext(ctx, req, server)
+	EndSpan(span, resp, err)
 	if enabled {
 		options.Err = err
 		options.Response = resp
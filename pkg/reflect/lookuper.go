@@ -49,6 +49,9 @@ func (l *Lookuper) doExchange(ctx context.Context, req *dns.Msg) (*dns.Msg, erro
 	var options reflectOptions
 	var id string
 
+	ctx, span := StartSpan(ctx, "resolver.exchange", append(RequestAttributes(req),
+		Attribute{Key: "reflect.name", Value: l.name})...)
+
 	level, enabled := GetEnabled(ctx, l.name)
 	if enabled {
 		id, _ = GetID(ctx)
@@ -65,6 +68,7 @@ func (l *Lookuper) doExchange(ctx context.Context, req *dns.Msg) (*dns.Msg, erro
 
 	start := time.Now()
 	resp, err := l.next.Exchange(ctx, req)
+	EndSpan(span, resp, err)
 	if enabled {
 		rtt := time.Since(start)
 
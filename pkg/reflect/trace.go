@@ -0,0 +1,115 @@
+package reflect
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+var tracerCtxKey = core.NewContextKey[Tracer]("dns.reflect.tracer")
+
+// Attribute is a key-value pair describing a [Span].
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span is an operation being traced. It mirrors the relevant part
+// of the OpenTelemetry trace.Span so adapting one is trivial.
+type Span interface {
+	// SetAttributes adds attributes to the span.
+	SetAttributes(attrs ...Attribute)
+	// RecordError marks the span as failed.
+	RecordError(err error)
+	// End completes the span.
+	End()
+	// TraceID returns the ID of the trace the span belongs to,
+	// if any.
+	TraceID() string
+}
+
+// Tracer starts [Span]s, as children of the one in the context if any.
+// It mirrors the OpenTelemetry trace.Tracer.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// TracerFunc is a function implementing the [Tracer] interface.
+type TracerFunc func(context.Context, string, ...Attribute) (context.Context, Span)
+
+// Start calls the function.
+func (fn TracerFunc) Start(ctx context.Context, name string,
+	attrs ...Attribute) (context.Context, Span) {
+	//
+	return fn(ctx, name, attrs...)
+}
+
+// WithTracer attaches a [Tracer] to the request's context, making the
+// reflection layers, and the server, create spans.
+func WithTracer(ctx context.Context, t Tracer) context.Context {
+	if t == nil {
+		panic(core.ErrInvalid)
+	}
+
+	return tracerCtxKey.WithValue(ctx, t)
+}
+
+// GetTracer extracts the [Tracer] from the request's context.
+func GetTracer(ctx context.Context) (Tracer, bool) {
+	return tracerCtxKey.Get(ctx)
+}
+
+// StartSpan starts a [Span] using the [Tracer] in the context, or a no-op
+// one if there is none. Unless the context already has a tracing ID,
+// the ID of the trace is attached as such, so logs and spans correlate.
+func StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	t, ok := GetTracer(ctx)
+	if !ok {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := t.Start(ctx, name, attrs...)
+	if _, ok := GetID(ctx); !ok {
+		if id := span.TraceID(); id != "" {
+			ctx = WithFormattedID(ctx, id)
+		}
+	}
+	return ctx, span
+}
+
+// RequestAttributes describes the question of a DNS request.
+func RequestAttributes(req *dns.Msg) []Attribute {
+	if req == nil || len(req.Question) == 0 {
+		return nil
+	}
+
+	q := req.Question[0]
+	return []Attribute{
+		{Key: "dns.question.name", Value: q.Name},
+		{Key: "dns.question.type", Value: dns.TypeToString[q.Qtype]},
+	}
+}
+
+// EndSpan describes the outcome of an exchange on the [Span]
+// and completes it.
+func EndSpan(span Span, resp *dns.Msg, err error) {
+	if resp != nil {
+		span.SetAttributes(
+			Attribute{Key: "dns.response.code", Value: dns.RcodeToString[resp.Rcode]},
+			Attribute{Key: "dns.response.answers", Value: len(resp.Answer)},
+		)
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+func (noopSpan) TraceID() string            { return "" }
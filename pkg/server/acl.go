@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver"
+)
+
+// View routes INET queries from some clients to a
+// different [resolver.Lookuper].
+type View struct {
+	Name     string
+	Networks []netip.Prefix
+	Lookuper resolver.Lookuper
+}
+
+// Contains tells if the [View] applies to the given client address.
+func (v *View) Contains(addr netip.Addr) bool {
+	return prefixesContain(v.Networks, addr)
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client, if known.
+func clientAddr(remoteAddr net.Addr) (netip.Addr, bool) {
+	var addr netip.Addr
+	var ok bool
+
+	switch v := remoteAddr.(type) {
+	case *net.UDPAddr:
+		addr, ok = netip.AddrFromSlice(v.IP)
+	case *net.TCPAddr:
+		addr, ok = netip.AddrFromSlice(v.IP)
+	default:
+		addr, ok = core.AddrFromNetIP(remoteAddr)
+	}
+
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// IsAllowed tells if a client can make INET queries according to
+// the Allow and Deny lists.
+func (h *Handler) IsAllowed(addr netip.Addr) bool {
+	switch {
+	case !addr.IsValid():
+		// unknown client
+		return len(h.Allow) == 0
+	case prefixesContain(h.Deny, addr):
+		return false
+	case len(h.Allow) == 0:
+		return true
+	default:
+		return prefixesContain(h.Allow, addr)
+	}
+}
+
+// getLookuper returns the [resolver.Lookuper] of the first
+// [View] matching the client, or the default one.
+func (h *Handler) getLookuper(addr netip.Addr) resolver.Lookuper {
+	if addr.IsValid() {
+		for i := range h.Views {
+			if v := &h.Views[i]; v.Contains(addr) {
+				return v.Lookuper
+			}
+		}
+	}
+	return h.GetLookuper()
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+const (
+	// MinimalANYTTL is the TTL of the HINFO record used to answer
+	// ANY queries when [Handler.MinimalANY] is set.
+	MinimalANYTTL = 3789
+)
+
+// handleMinimalANY answers an ANY query with a synthesized
+// HINFO record, as described by RFC 8482.
+func handleMinimalANY(w dns.ResponseWriter, r *dns.Msg, q dns.Question) error {
+	m := newResponse(r)
+	m.Answer = []dns.RR{
+		&dns.HINFO{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeHINFO,
+				Class:  q.Qclass,
+				Ttl:    MinimalANYTTL,
+			},
+			Cpu: "RFC8482",
+		},
+	}
+	return w.WriteMsg(m)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver"
+)
+
+// queryRateWindow is the number of seconds the [QueryCounter] rate
+// is averaged over.
+const queryRateWindow = 10
+
+var (
+	_ ChaosStatsProvider = ChaosStats(nil)
+	_ dns.Handler        = (*QueryCounter)(nil)
+)
+
+// ChaosStatsProvider answers stats-oriented CHAOS TXT queries,
+// like "qps.bind." or "uptime.server.", not handled otherwise
+// by the [Handler].
+type ChaosStatsProvider interface {
+	ChaosStat(name string) (string, bool)
+}
+
+// ChaosStats is a [ChaosStatsProvider] using a function for each
+// name, in canonical form.
+type ChaosStats map[string]func() string
+
+// NewChaosStats creates an empty [ChaosStats].
+func NewChaosStats() ChaosStats {
+	return make(ChaosStats)
+}
+
+// ChaosStat returns the value of the named stat.
+func (cs ChaosStats) ChaosStat(name string) (string, bool) {
+	fn, ok := cs[dns.CanonicalName(name)]
+	if !ok || fn == nil {
+		return "", false
+	}
+	return fn(), true
+}
+
+// Set adds a stat to be answered under the given names.
+func (cs ChaosStats) Set(fn func() string, names ...string) {
+	for _, name := range names {
+		cs[dns.CanonicalName(name)] = fn
+	}
+}
+
+// SetUptime answers "uptime.server." and "uptime.bind." with the
+// seconds since the given time.
+func (cs ChaosStats) SetUptime(start time.Time) {
+	cs.Set(func() string {
+		return strconv.FormatInt(int64(time.Since(start)/time.Second), 10)
+	}, "uptime.server.", "uptime.bind.")
+}
+
+// SetCache answers "cachesize.bind.", "cachehits.bind." and
+// "cachemisses.bind." from the [resolver.CachedStats] of the cache.
+func (cs ChaosStats) SetCache(c *resolver.Cached) {
+	cs.Set(func() string {
+		return strconv.Itoa(c.Stats().Entries)
+	}, "cachesize.bind.")
+	cs.Set(func() string {
+		return strconv.FormatUint(c.Stats().Hits, 10)
+	}, "cachehits.bind.")
+	cs.Set(func() string {
+		return strconv.FormatUint(c.Stats().Misses, 10)
+	}, "cachemisses.bind.")
+}
+
+// SetQueryCounter answers "qps.bind." and "queries.bind." from
+// a [QueryCounter].
+func (cs ChaosStats) SetQueryCounter(qc *QueryCounter) {
+	cs.Set(func() string {
+		return strconv.FormatFloat(qc.Rate(), 'f', 2, 64)
+	}, "qps.bind.")
+	cs.Set(func() string {
+		return strconv.FormatUint(qc.Total(), 10)
+	}, "queries.bind.")
+}
+
+// SetInflight answers "inflight.server." with the requests
+// being processed by the [Server].
+func (cs ChaosStats) SetInflight(srv *Server) {
+	cs.Set(func() string {
+		return strconv.Itoa(srv.Inflight())
+	}, "inflight.server.")
+}
+
+// QueryCounter is a [dns.Handler] middleware counting the requests,
+// and their rate per second.
+type QueryCounter struct {
+	Next dns.Handler
+
+	total atomic.Uint64
+
+	mu      sync.Mutex
+	seconds [queryRateWindow + 1]querySecond
+}
+
+type querySecond struct {
+	unix  int64
+	count uint64
+}
+
+// ServeDNS counts the request and passes it to the next [dns.Handler].
+func (qc *QueryCounter) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	qc.serve(qc.Next, w, r)
+}
+
+func (qc *QueryCounter) serve(next dns.Handler, w dns.ResponseWriter, r *dns.Msg) {
+	qc.total.Add(1)
+	qc.count(time.Now().Unix())
+	next.ServeDNS(w, r)
+}
+
+func (qc *QueryCounter) count(now int64) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	s := &qc.seconds[now%int64(len(qc.seconds))]
+	if s.unix != now {
+		s.unix, s.count = now, 0
+	}
+	s.count++
+}
+
+// Total returns the number of requests counted.
+func (qc *QueryCounter) Total() uint64 {
+	return qc.total.Load()
+}
+
+// Rate returns the average number of requests per second over
+// the last complete seconds.
+func (qc *QueryCounter) Rate() float64 {
+	return qc.rate(time.Now().Unix())
+}
+
+func (qc *QueryCounter) rate(now int64) float64 {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	var n uint64
+	for _, s := range qc.seconds {
+		if s.unix < now && s.unix >= now-queryRateWindow {
+			n += s.count
+		}
+	}
+	return float64(n) / queryRateWindow
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestChaosStats(t *testing.T) {
+	qc := new(QueryCounter)
+	stats := NewChaosStats()
+	stats.SetQueryCounter(qc)
+	stats.SetUptime(time.Now().Add(-90 * time.Second))
+
+	h := &Handler{
+		Version: "1.0",
+		Stats:   stats,
+	}
+	h.Use(qc.Wrap)
+	h.SetDefaults()
+
+	tests := []struct {
+		name  string
+		qType uint16
+		rcode int
+		txt   string
+	}{
+		{"version.bind.", dns.TypeTXT, dns.RcodeSuccess, "1.0"},
+		{"Uptime.Server.", dns.TypeTXT, dns.RcodeSuccess, "90"},
+		{"queries.bind.", dns.TypeTXT, dns.RcodeSuccess, "3"},
+		{"queries.bind.", dns.TypeA, dns.RcodeNotImplemented, ""},
+		{"unknown.bind.", dns.TypeTXT, dns.RcodeNotImplemented, ""},
+	}
+
+	for _, tc := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion(tc.name, tc.qType)
+		req.Question[0].Qclass = dns.ClassCHAOS
+
+		rw := &dohResponseWriter{
+			remote: net.UDPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:5353")),
+		}
+		h.ServeDNS(rw, req)
+
+		switch {
+		case rw.msg == nil:
+			t.Errorf("%s: no response", tc.name)
+		case rw.msg.Rcode != tc.rcode:
+			t.Errorf("%s: expected %s, got %s", tc.name,
+				dns.RcodeToString[tc.rcode], dns.RcodeToString[rw.msg.Rcode])
+		case tc.txt == "":
+			// no answer expected
+		case len(rw.msg.Answer) != 1 || rw.msg.Answer[0].(*dns.TXT).Txt[0] != tc.txt:
+			t.Errorf("%s: unexpected answer %v", tc.name, rw.msg.Answer)
+		}
+	}
+}
+
+func TestQueryCounterRate(t *testing.T) {
+	var qc QueryCounter
+
+	for now := int64(100); now < 120; now++ {
+		for i := int64(0); i < now%3; i++ {
+			qc.count(now)
+		}
+	}
+
+	// seconds 109 to 118, the current one excluded
+	if rate := qc.rate(119); rate != 1.0 {
+		t.Errorf("expected 1.0, got %v", rate)
+	}
+}
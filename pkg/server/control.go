@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/slog"
+
+	"darvaza.org/resolver"
+)
+
+var _ http.Handler = (*Control)(nil)
+
+// Control is an [http.Handler] exposing administrative operations
+// over JSON, the moral equivalent of `rndc` or `unbound-control`.
+// Operations on components not set are answered 501.
+//
+//	POST /cache/flush[?name=NAME|?tree=SUFFIX]
+//	POST /zones/reload[?zone=ORIGIN]
+//	GET  /pool
+//	GET  /nscache
+//	POST /log/level?level=LEVEL
+type Control struct {
+	// Cache is flushed via /cache/flush.
+	Cache resolver.CacheFlusher
+	// Pool reports the status of its servers via /pool.
+	Pool *resolver.Pool
+	// NSCache is dumped via /nscache.
+	NSCache *resolver.NSCache
+
+	// Authority holds the zones reloaded via /zones/reload,
+	// from the files in ZoneFiles, by origin.
+	Authority *resolver.Authority
+	ZoneFiles map[string]string
+
+	// SetLogLevel is called to change the log level via /log/level.
+	SetLogLevel func(slog.LogLevel) error
+
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+// ServeHTTP handles the control requests.
+func (c *Control) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.once.Do(c.init)
+	c.mux.ServeHTTP(w, r)
+}
+
+func (c *Control) init() {
+	c.mux = http.NewServeMux()
+	c.handle("/cache/flush", http.MethodPost, c.Cache != nil, c.flushCache)
+	c.handle("/zones/reload", http.MethodPost, c.Authority != nil, c.reloadZones)
+	c.handle("/pool", http.MethodGet, c.Pool != nil, c.poolStatus)
+	c.handle("/nscache", http.MethodGet, c.NSCache != nil, c.dumpNSCache)
+	c.handle("/log/level", http.MethodPost, c.SetLogLevel != nil, c.setLogLevel)
+}
+
+func (c *Control) handle(path, method string, enabled bool,
+	fn func(*http.Request) (any, int, error)) {
+	//
+	c.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !enabled:
+			controlError(w, http.StatusNotImplemented, nil)
+		case r.Method != method:
+			w.Header().Set("Allow", method)
+			controlError(w, http.StatusMethodNotAllowed, nil)
+		default:
+			v, status, err := fn(r)
+			if err != nil {
+				controlError(w, status, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(v)
+		}
+	})
+}
+
+func controlError(w http.ResponseWriter, status int, err error) {
+	msg := http.StatusText(status)
+	if err != nil {
+		msg = err.Error()
+	}
+	http.Error(w, msg, status)
+}
+
+func (c *Control) flushCache(r *http.Request) (any, int, error) {
+	var n int
+
+	q := r.URL.Query()
+	switch {
+	case q.Has("name"):
+		n = c.Cache.FlushName(q.Get("name"))
+	case q.Has("tree"):
+		n = c.Cache.FlushTree(q.Get("tree"))
+	default:
+		n = c.Cache.Flush()
+	}
+
+	return map[string]int{"flushed": n}, http.StatusOK, nil
+}
+
+func (c *Control) reloadZones(r *http.Request) (any, int, error) {
+	var origins []string
+
+	if s := r.URL.Query().Get("zone"); s != "" {
+		origin := dns.CanonicalName(s)
+		if _, ok := c.ZoneFiles[origin]; !ok {
+			return nil, http.StatusNotFound, core.Wrapf(core.ErrNotExists, "%q", origin)
+		}
+		origins = []string{origin}
+	} else {
+		for origin := range c.ZoneFiles {
+			origins = append(origins, origin)
+		}
+		sort.Strings(origins)
+	}
+
+	for _, origin := range origins {
+		z, ok := c.Authority.GetZone(origin)
+		if !ok || z.Origin() != origin {
+			return nil, http.StatusNotFound, core.Wrapf(core.ErrNotExists, "%q", origin)
+		}
+
+		if err := z.LoadFile(c.ZoneFiles[origin]); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	return map[string][]string{"reloaded": origins}, http.StatusOK, nil
+}
+
+func (c *Control) poolStatus(*http.Request) (any, int, error) {
+	return c.Pool.Snapshot(), http.StatusOK, nil
+}
+
+// ControlNSCacheZone describes a zone of the [resolver.NSCache]
+// in the responses of [Control].
+type ControlNSCacheZone struct {
+	Name       string    `json:"name"`
+	NS         []string  `json:"ns"`
+	Glue       []string  `json:"glue,omitempty"`
+	Expire     time.Time `json:"expire"`
+	Persistent bool      `json:"persistent,omitempty"`
+}
+
+func (c *Control) dumpNSCache(*http.Request) (any, int, error) {
+	out := []ControlNSCacheZone{}
+	c.NSCache.ForEachZone(func(s resolver.NSCacheZoneSnapshot) bool {
+		out = append(out, ControlNSCacheZone{
+			Name:       s.Name,
+			NS:         rrStrings(s.NS),
+			Glue:       rrStrings(s.Glue),
+			Expire:     s.Expire,
+			Persistent: s.Persistent,
+		})
+		return false
+	})
+	return out, http.StatusOK, nil
+}
+
+func rrStrings(records []dns.RR) []string {
+	out := make([]string, 0, len(records))
+	for _, rr := range records {
+		out = append(out, rr.String())
+	}
+	return out
+}
+
+func (c *Control) setLogLevel(r *http.Request) (any, int, error) {
+	s := r.URL.Query().Get("level")
+	level, ok := parseLogLevel(s)
+	if !ok {
+		return nil, http.StatusBadRequest, core.Wrapf(core.ErrInvalid, "level %q", s)
+	}
+
+	if err := c.SetLogLevel(level); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return map[string]string{"level": strings.ToLower(s)}, http.StatusOK, nil
+}
+
+func parseLogLevel(s string) (slog.LogLevel, bool) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.Debug, true
+	case "info":
+		return slog.Info, true
+	case "warn", "warning":
+		return slog.Warn, true
+	case "error":
+		return slog.Error, true
+	case "fatal":
+		return slog.Fatal, true
+	case "panic":
+		return slog.Panic, true
+	default:
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= int(slog.Debug) {
+			return slog.LogLevel(n), true
+		}
+		return slog.UndefinedLevel, false
+	}
+}
+
+// ServeUnix serves the [Control] on a unix socket, only accessible to
+// the owner, until the context is cancelled. A stale socket is replaced.
+func (c *Control) ServeUnix(ctx context.Context, path string) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return err
+	}
+
+	hs := &http.Server{
+		Handler:           c,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = hs.Close()
+		case <-done:
+		}
+	}()
+
+	err = hs.Serve(ln)
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
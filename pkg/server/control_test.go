@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/slog"
+
+	"darvaza.org/resolver"
+)
+
+func writeTestControlZone(t *testing.T, filename string, serial int) {
+	s := fmt.Sprintf("$ORIGIN example.org.\n"+
+		"@ 3600 IN SOA ns.example.org. hostmaster.example.org. %d 7200 3600 1209600 300\n"+
+		"www 300 IN A 192.0.2.%d\n", serial, serial)
+	if err := os.WriteFile(filename, []byte(s), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestControl(t *testing.T) (*Control, *slog.LogLevel, string) {
+	next := resolver.ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		rr, err := dns.NewRR(req.Question[0].Name + " 300 IN TXT hello")
+		resp.Answer = []dns.RR{rr}
+		return resp, err
+	})
+
+	cached, err := resolver.NewCachedExchanger(next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = cached.Lookup(context.Background(), "example.org.", dns.TypeTXT)
+
+	filename := filepath.Join(t.TempDir(), "example.org.zone")
+	writeTestControlZone(t, filename, 1)
+	z, err := resolver.NewZoneFromFile("example.org.", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := resolver.NewAuthority(nil, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	level := new(slog.LogLevel)
+	c := &Control{
+		Cache:     cached,
+		Authority: a,
+		ZoneFiles: map[string]string{"example.org.": filename},
+		SetLogLevel: func(l slog.LogLevel) error {
+			*level = l
+			return nil
+		},
+	}
+	return c, level, filename
+}
+
+func TestControl(t *testing.T) {
+	c, level, filename := newTestControl(t)
+	writeTestControlZone(t, filename, 2)
+
+	tests := []struct {
+		method string
+		target string
+		status int
+		body   string
+	}{
+		{"GET", "/cache/flush", http.StatusMethodNotAllowed, ""},
+		{"POST", "/cache/flush?name=example.org.", http.StatusOK, `{"flushed":1}`},
+		{"POST", "/cache/flush", http.StatusOK, `{"flushed":0}`},
+		{"POST", "/zones/reload?zone=example.com", http.StatusNotFound, ""},
+		{"POST", "/zones/reload?zone=Example.Org", http.StatusOK, `{"reloaded":["example.org."]}`},
+		{"GET", "/pool", http.StatusNotImplemented, ""},
+		{"POST", "/log/level?level=verbose", http.StatusBadRequest, ""},
+		{"POST", "/log/level?level=Debug", http.StatusOK, `{"level":"debug"}`},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+
+		body := strings.TrimSpace(rec.Body.String())
+		switch {
+		case rec.Code != tc.status:
+			t.Errorf("%s %s: expected %v, got %v: %s", tc.method, tc.target,
+				tc.status, rec.Code, body)
+		case tc.body != "" && body != tc.body:
+			t.Errorf("%s %s: unexpected body %s", tc.method, tc.target, body)
+		}
+	}
+
+	if z, _ := c.Authority.GetZone("example.org."); z.Serial() != 2 {
+		t.Errorf("zone not reloaded, serial %v", z.Serial())
+	}
+	if *level != slog.Debug {
+		t.Errorf("log level not set, got %v", *level)
+	}
+}
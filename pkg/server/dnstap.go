@@ -0,0 +1,125 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/dnstap"
+)
+
+var (
+	_ dns.Handler        = (*Dnstap)(nil)
+	_ dns.ResponseWriter = (*dnstapWriter)(nil)
+)
+
+// Dnstap is a [dns.Handler] middleware logging the queries and
+// the responses sent via a [dnstap.Sender], as CLIENT_QUERY and
+// CLIENT_RESPONSE messages.
+type Dnstap struct {
+	Next   dns.Handler
+	Sender *dnstap.Sender
+}
+
+// NewDnstap creates a [Dnstap] middleware using the given [dnstap.Sender].
+// next can be nil if it will be used via [Dnstap.Wrap].
+func NewDnstap(next dns.Handler, s *dnstap.Sender) (*Dnstap, error) {
+	if s == nil {
+		return nil, core.ErrInvalid
+	}
+
+	return &Dnstap{Next: next, Sender: s}, nil
+}
+
+// ServeDNS passes the request to the next [dns.Handler], logging
+// the query and the response if sampled.
+func (dt *Dnstap) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	dt.serve(dt.Next, w, r)
+}
+
+func (dt *Dnstap) serve(next dns.Handler, w dns.ResponseWriter, r *dns.Msg) {
+	if !dt.Sender.Sampled() {
+		next.ServeDNS(w, r)
+		return
+	}
+
+	m := &dnstap.Message{
+		Type:         dnstap.ClientQuery,
+		Protocol:     dnstapProtocol(w),
+		QueryAddr:    dnstapAddrPort(w.RemoteAddr()),
+		ResponseAddr: dnstapAddrPort(w.LocalAddr()),
+		QueryTime:    time.Now(),
+	}
+	m.QueryMessage, _ = r.Pack()
+	dt.Sender.Send(m)
+
+	dw := &dnstapWriter{
+		ResponseWriter: w,
+		dt:             dt,
+		m:              *m,
+	}
+	next.ServeDNS(dw, r)
+}
+
+// dnstapProtocol tells the dnstap socket protocol of the request.
+func dnstapProtocol(w dns.ResponseWriter) dnstap.SocketProtocol {
+	switch queryTransport(w) {
+	case "udp":
+		return dnstap.UDP
+	case "tls":
+		return dnstap.DOT
+	case "http", "https":
+		return dnstap.DOH
+	default:
+		return dnstap.TCP
+	}
+}
+
+func dnstapAddrPort(addr net.Addr) netip.AddrPort {
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		return v.AddrPort()
+	case *net.TCPAddr:
+		return v.AddrPort()
+	default:
+		return netip.AddrPort{}
+	}
+}
+
+// dnstapWriter is a [dns.ResponseWriter] logging
+// the responses via [Dnstap].
+type dnstapWriter struct {
+	dns.ResponseWriter
+
+	dt *Dnstap
+	m  dnstap.Message
+}
+
+func (rw *dnstapWriter) WriteMsg(msg *dns.Msg) error {
+	if msg != nil {
+		if b, err := msg.Pack(); err == nil {
+			rw.send(b)
+		}
+	}
+	return rw.ResponseWriter.WriteMsg(msg)
+}
+
+func (rw *dnstapWriter) Write(b []byte) (int, error) {
+	rw.send(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *dnstapWriter) Unwrap() dns.ResponseWriter { return rw.ResponseWriter }
+
+func (rw *dnstapWriter) send(b []byte) {
+	m := rw.m
+	m.Type = dnstap.ClientResponse
+	m.QueryMessage = nil
+	m.ResponseTime = time.Now()
+	m.ResponseMessage = b
+	rw.dt.Sender.Send(&m)
+}
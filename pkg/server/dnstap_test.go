@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+
+	"darvaza.org/resolver/pkg/dnstap"
+)
+
+func TestDnstap(t *testing.T) {
+	s, err := dnstap.NewSender("unix", "/nonexistent", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Sample = 2
+
+	dt, err := NewDnstap(nil, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{Lookuper: newTestLookuper("hello")}
+	h.Use(dt.Wrap)
+	h.SetDefaults()
+
+	// query queued, response dropped
+	serveTestRequest(t, h, "192.0.2.1:5353")
+	if n := s.Dropped(); n != 1 {
+		t.Errorf("unexpected dropped count %v", n)
+	}
+
+	// not sampled
+	serveTestRequest(t, h, "192.0.2.1:5353")
+	if n := s.Dropped(); n != 1 {
+		t.Errorf("unexpected dropped count %v", n)
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// DefaultDoHPort is the port used by DNS-over-HTTPS listeners
+	// when the address doesn't specify one.
+	DefaultDoHPort = "443"
+
+	// DoHPath is the path where DNS-over-HTTPS requests are served.
+	DoHPath = "/dns-query"
+	// DoHContentType is the media type of DNS-over-HTTPS
+	// requests and responses, RFC 8484.
+	DoHContentType = "application/dns-message"
+)
+
+var (
+	_ http.Handler         = (*DoHHandler)(nil)
+	_ dns.ResponseWriter   = (*dohResponseWriter)(nil)
+	_ dns.ConnectionStater = (*dohResponseWriter)(nil)
+)
+
+// DoHHandler is an [http.Handler] implementing DNS-over-HTTPS,
+// RFC 8484, on top of a [dns.Handler].
+type DoHHandler struct {
+	Handler dns.Handler
+
+	// JSON, if set, handles requests asking for [JSONContentType].
+	JSON http.Handler
+
+	// TsigProvider verifies the TSIG of signed requests and signs the
+	// responses. If not set, signed requests fail verification.
+	TsigProvider dns.TsigProvider
+}
+
+// ServeHTTP handles GET and POST DNS-over-HTTPS requests.
+func (h *DoHHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.JSON != nil && isJSONRequest(r) {
+		h.JSON.ServeHTTP(w, r)
+		return
+	}
+
+	req, b, status := readDoHRequest(r)
+	if req == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	rw := newDoHResponseWriter(r)
+	rw.tsigStatus = h.verifyTSIG(req, b)
+	if len(req.Question) > 0 && isTransfer(req.Question[0]) {
+		// zone transfers need a stream
+		_ = handleRcodeError(rw, req, dns.RcodeRefused)
+	} else {
+		h.Handler.ServeDNS(rw, req)
+	}
+	if rw.msg == nil {
+		// no answer
+		status = http.StatusInternalServerError
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	b, err := h.packResponse(rw.msg, req)
+	if err != nil {
+		status = http.StatusInternalServerError
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", DoHContentType)
+	hdr.Set("Content-Length", strconv.Itoa(len(b)))
+	if ttl, ok := dohMaxAge(rw.msg); ok {
+		hdr.Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+	_, _ = w.Write(b)
+}
+
+// verifyTSIG checks the signature of a request, if any.
+func (h *DoHHandler) verifyTSIG(req *dns.Msg, b []byte) error {
+	switch {
+	case req.IsTsig() == nil:
+		return nil
+	case h.TsigProvider == nil:
+		return dns.ErrSecret
+	default:
+		return dns.TsigVerifyWithProvider(b, h.TsigProvider, "", false)
+	}
+}
+
+// packResponse encodes the response, signing it if it carries a TSIG
+// record for a signed request.
+func (h *DoHHandler) packResponse(msg, req *dns.Msg) ([]byte, error) {
+	t := req.IsTsig()
+	if t == nil || msg.IsTsig() == nil || h.TsigProvider == nil {
+		return msg.Pack()
+	}
+
+	b, _, err := dns.TsigGenerateWithProvider(msg, h.TsigProvider, t.MAC, false)
+	return b, err
+}
+
+// readDoHRequest extracts the [dns.Msg] of a DNS-over-HTTPS request,
+// and its wire format, or the HTTP status code to reply with.
+func readDoHRequest(r *http.Request) (*dns.Msg, []byte, int) {
+	var b []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		s := strings.TrimRight(r.URL.Query().Get("dns"), "=")
+		b, err = base64.RawURLEncoding.DecodeString(s)
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct != DoHContentType {
+			return nil, nil, http.StatusUnsupportedMediaType
+		}
+		b, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize+1))
+		if len(b) > dns.MaxMsgSize {
+			return nil, nil, http.StatusRequestEntityTooLarge
+		}
+	default:
+		return nil, nil, http.StatusMethodNotAllowed
+	}
+
+	req := new(dns.Msg)
+	if err != nil || len(b) == 0 || req.Unpack(b) != nil {
+		return nil, nil, http.StatusBadRequest
+	}
+	return req, b, http.StatusOK
+}
+
+// dohMaxAge determines for how long a response can be cached,
+// from the TTLs of its records.
+func dohMaxAge(msg *dns.Msg) (uint32, bool) {
+	switch msg.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		ttl, ok := exdns.MinTTL(msg)
+		if soa, found := exdns.GetFirstRR[*dns.SOA](msg.Ns); found && ok {
+			// negative caching, RFC 2308
+			ttl = min(ttl, soa.Minttl)
+		}
+		return ttl, ok
+	default:
+		// don't cache failures
+		return 0, false
+	}
+}
+
+// dohResponseWriter is a [dns.ResponseWriter] capturing
+// the response to a DNS-over-HTTPS request.
+type dohResponseWriter struct {
+	local  net.Addr
+	remote net.Addr
+	tls    *tls.ConnectionState
+	msg    *dns.Msg
+
+	tsigStatus error
+}
+
+func newDoHResponseWriter(r *http.Request) *dohResponseWriter {
+	rw := &dohResponseWriter{
+		tls: r.TLS,
+	}
+
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		rw.local = addr
+	}
+
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		rw.remote = net.TCPAddrFromAddrPort(ap)
+	}
+
+	return rw
+}
+
+func (rw *dohResponseWriter) LocalAddr() net.Addr  { return rw.local }
+func (rw *dohResponseWriter) RemoteAddr() net.Addr { return rw.remote }
+
+// ConnectionState returns the TLS state of the HTTP request.
+func (rw *dohResponseWriter) ConnectionState() *tls.ConnectionState { return rw.tls }
+
+func (rw *dohResponseWriter) WriteMsg(msg *dns.Msg) error {
+	if msg == nil {
+		return core.ErrInvalid
+	}
+	rw.msg = msg
+	return nil
+}
+
+func (rw *dohResponseWriter) Write(b []byte) (int, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(b); err != nil {
+		return 0, err
+	}
+	rw.msg = msg
+	return len(b), nil
+}
+
+func (*dohResponseWriter) Close() error        { return nil }
+func (*dohResponseWriter) TsigTimersOnly(bool) {}
+func (*dohResponseWriter) Hijack()             {}
+
+// TsigStatus returns the result of verifying the TSIG of the request.
+func (rw *dohResponseWriter) TsigStatus() error { return rw.tsigStatus }
+
+// TsigVerified flags the TSIG of the request as verified by the [DoHHandler].
+func (*dohResponseWriter) TsigVerified() {}
+
+// listenDoH opens a DNS-over-HTTPS listener, or plain HTTP
+// if there is no [Server.TLSConfig].
+func (srv *Server) listenDoH(addr string) (*listener, error) {
+	addr, err := withDefaultPort(addr, DefaultDoHPort)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hs := srv.getHTTPServer()
+	serve := func() error { return hs.Serve(ln) }
+	if hs.TLSConfig != nil {
+		serve = func() error { return hs.ServeTLS(ln, "", "") }
+	}
+
+	l := &listener{
+		addr: ln.Addr(),
+		serve: func() error {
+			if err := serve(); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+		shutdown: hs.Shutdown,
+		close:    ln.Close,
+		started:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	close(l.started)
+	return l, nil
+}
+
+// getHTTPServer returns the [http.Server] used by the DNS-over-HTTPS
+// listeners, creating it if one wasn't provided.
+func (srv *Server) getHTTPServer() *http.Server {
+	if srv.HTTPServer == nil {
+		srv.HTTPServer = &http.Server{
+			ReadHeaderTimeout: core.Coalesce(srv.HandshakeTimeout, DefaultHandshakeTimeout),
+		}
+	}
+
+	hs := srv.HTTPServer
+	if hs.Handler == nil {
+		hs.Handler = srv.newHTTPHandler()
+	}
+	if hs.TLSConfig == nil && srv.TLSConfig != nil {
+		hs.TLSConfig = srv.TLSConfig.Clone()
+	}
+	return hs
+}
+
+// newHTTPHandler creates the [http.Handler] serving [DoHPath],
+// and [JSONPath] if enabled.
+func (srv *Server) newHTTPHandler() http.Handler {
+	doh := &DoHHandler{
+		Handler:      srv,
+		TsigProvider: srv.TsigProvider,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(DoHPath, doh)
+
+	if srv.JSON {
+		doh.JSON = &JSONHandler{
+			Handler:        srv,
+			AllowedOrigins: srv.CORSOrigins,
+		}
+		mux.Handle(JSONPath, doh.JSON)
+	}
+	return mux
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newTestDoHRequest(t *testing.T, method string) *http.Request {
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	req.Id = 0
+
+	b, err := req.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if method == http.MethodGet {
+		q := base64.RawURLEncoding.EncodeToString(b)
+		return httptest.NewRequest(method, DoHPath+"?dns="+q, nil)
+	}
+
+	r := httptest.NewRequest(method, DoHPath, bytes.NewReader(b))
+	r.Header.Set("Content-Type", DoHContentType)
+	return r
+}
+
+func TestDoHHandler(t *testing.T) {
+	h := &DoHHandler{Handler: newTestHandler()}
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newTestDoHRequest(t, method))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %v", method, w.Code)
+		}
+		if s := w.Header().Get("Content-Type"); s != DoHContentType {
+			t.Errorf("%s: unexpected Content-Type %q", method, s)
+		}
+		if s := w.Header().Get("Cache-Control"); s != "max-age=60" {
+			t.Errorf("%s: unexpected Cache-Control %q", method, s)
+		}
+
+		resp := new(dns.Msg)
+		if err := resp.Unpack(w.Body.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Id != 0 || len(resp.Answer) != 1 {
+			t.Errorf("%s: unexpected response %v", method, resp)
+		}
+	}
+
+	for _, tc := range []struct {
+		r      *http.Request
+		status int
+	}{
+		{httptest.NewRequest(http.MethodPut, DoHPath, nil), http.StatusMethodNotAllowed},
+		{httptest.NewRequest(http.MethodGet, DoHPath+"?dns=!!", nil), http.StatusBadRequest},
+		{httptest.NewRequest(http.MethodPost, DoHPath, nil), http.StatusUnsupportedMediaType},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tc.r)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: status %v, expected %v", tc.r.Method, tc.r.URL, w.Code, tc.status)
+		}
+	}
+}
+
+func TestServerDoH(t *testing.T) {
+	srv := &Server{
+		Handler:      newTestHandler(),
+		DoHAddresses: []string{"127.0.0.1:0"},
+	}
+	startTestServer(t, srv)
+
+	r := newTestDoHRequest(t, http.MethodPost)
+	resp, err := http.Post("http://"+srv.Addrs()[0].String()+DoHPath,
+		DoHContentType, r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	msg := new(dns.Msg)
+	if resp.StatusCode != http.StatusOK || msg.Unpack(b) != nil {
+		t.Errorf("unexpected response: %v", resp.Status)
+	}
+}
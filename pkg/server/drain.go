@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"darvaza.org/core"
+)
+
+const (
+	// drainPollInterval is how often Shutdown checks if
+	// the requests in progress have finished.
+	drainPollInterval = 10 * time.Millisecond
+)
+
+// Inflight returns the number of requests being processed
+// by the [Server].
+func (srv *Server) Inflight() int {
+	return int(srv.inflight.Load())
+}
+
+// ShutdownWithTimeout stops all the listeners at once, waiting up to
+// the given time for the requests in progress to finish, and returns
+// how many were abandoned.
+func (srv *Server) ShutdownWithTimeout(timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return srv.shutdown(ctx)
+}
+
+// waitInflight waits until there are no requests in progress or
+// the context expires, returning then how many remain.
+func (srv *Server) waitInflight(ctx context.Context) (int, error) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		n := srv.Inflight()
+		if n == 0 {
+			return 0, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return n, core.Wrapf(ctx.Err(), "%d requests abandoned", n)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/hex"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// DefaultUDPSize is the UDP buffer size advertised by the
+	// [Handler] unless [Handler.UDPSize] is specified.
+	DefaultUDPSize = 1232
+)
+
+var _ dns.ResponseWriter = (*ednsResponseWriter)(nil)
+
+// ednsResponseWriter is a [dns.ResponseWriter] setting the OPT
+// record of the responses according to the request, and truncating
+// them to fit the client's UDP buffer.
+type ednsResponseWriter struct {
+	dns.ResponseWriter
+
+	h   *Handler
+	opt *dns.OPT
+}
+
+func (rw *ednsResponseWriter) WriteMsg(msg *dns.Msg) error {
+	if msg == nil {
+		return core.ErrInvalid
+	}
+
+	msg = msg.Copy()
+	rw.h.setEDNS(msg, rw.opt)
+
+	if _, ok := rw.RemoteAddr().(*net.UDPAddr); ok {
+		truncateResponse(msg, rw.h.maxUDPSize(rw.opt))
+	}
+
+	return rw.ResponseWriter.WriteMsg(msg)
+}
+
+func (rw *ednsResponseWriter) Unwrap() dns.ResponseWriter { return rw.ResponseWriter }
+
+// newEDNSResponseWriter wraps the [dns.ResponseWriter] to handle
+// EDNS, or returns false if the request uses an unsupported version.
+func (h *Handler) newEDNSResponseWriter(w dns.ResponseWriter,
+	r *dns.Msg) (dns.ResponseWriter, bool) {
+	//
+	opt := r.IsEdns0()
+	if opt != nil && opt.Version() != 0 {
+		return nil, false
+	}
+
+	rw := &ednsResponseWriter{
+		ResponseWriter: w,
+		h:              h,
+		opt:            opt,
+	}
+	return rw, true
+}
+
+// setEDNS replaces the OPT record of a response with ours,
+// if the request had one.
+func (h *Handler) setEDNS(msg *dns.Msg, req *dns.OPT) {
+	msg.Extra = exdns.TrimRR(msg.Extra, func(rr dns.RR) bool {
+		return rr.Header().Rrtype == dns.TypeOPT
+	})
+
+	if req == nil {
+		return
+	}
+
+	opt := h.newOPT()
+	opt.SetDo(req.Do())
+	if h.NSID != "" && hasEDNS0Option(req, dns.EDNS0NSID) {
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{
+			Code: dns.EDNS0NSID,
+			Nsid: hex.EncodeToString([]byte(h.NSID)),
+		})
+	}
+	msg.Extra = append(msg.Extra, opt)
+}
+
+func (h *Handler) newOPT() *dns.OPT {
+	opt := &dns.OPT{
+		Hdr: dns.RR_Header{
+			Name:   ".",
+			Rrtype: dns.TypeOPT,
+		},
+	}
+	opt.SetUDPSize(h.udpSize())
+	return opt
+}
+
+func (h *Handler) udpSize() uint16 {
+	return core.Coalesce(h.UDPSize, DefaultUDPSize)
+}
+
+// maxUDPSize returns the largest response that can be sent
+// over UDP to a client.
+func (h *Handler) maxUDPSize(req *dns.OPT) int {
+	if req == nil {
+		return dns.MinMsgSize
+	}
+	return max(dns.MinMsgSize, int(min(req.UDPSize(), h.udpSize())))
+}
+
+func hasEDNS0Option(opt *dns.OPT, code uint16) bool {
+	for _, o := range opt.Option {
+		if o.Option() == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) handleBadVersion(w dns.ResponseWriter, r *dns.Msg) error {
+	m := newResponse(r)
+	m.Extra = append(m.Extra, h.newOPT())
+	m.Rcode = dns.RcodeBadVers
+	return w.WriteMsg(m)
+}
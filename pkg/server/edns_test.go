@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver"
+)
+
+// newTestBigLookuper returns a [resolver.Lookuper] answering with
+// n TXT records.
+func newTestBigLookuper(n int) resolver.Lookuper {
+	return resolver.LookuperFunc(func(_ context.Context,
+		qName string, qType uint16) (*dns.Msg, error) {
+		//
+		m := new(dns.Msg)
+		m.SetQuestion(qName, qType)
+		for i := 0; i < n; i++ {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{
+					Name:   qName,
+					Rrtype: dns.TypeTXT,
+					Class:  dns.ClassINET,
+				},
+				Txt: []string{strings.Repeat("x", 100)},
+			})
+		}
+		return m, nil
+	})
+}
+
+func serveTestEDNSRequest(h dns.Handler, remote net.Addr,
+	fn func(*dns.Msg)) *dns.Msg {
+	//
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeTXT)
+	if fn != nil {
+		fn(req)
+	}
+
+	rw := &dohResponseWriter{remote: remote}
+	h.ServeDNS(rw, req)
+	return rw.msg
+}
+
+func TestHandlerEDNS(t *testing.T) {
+	h := &Handler{
+		Lookuper: newTestBigLookuper(20),
+		NSID:     "ns1",
+	}
+	h.SetDefaults()
+
+	udp := net.UDPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:53"))
+	tcp := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:53"))
+
+	// no EDNS
+	resp := serveTestEDNSRequest(h, udp, nil)
+	if resp.IsEdns0() != nil || !resp.Truncated {
+		t.Errorf("unexpected response to non-EDNS UDP client: %v", resp)
+	}
+
+	// EDNS with NSID, DO and a large buffer
+	resp = serveTestEDNSRequest(h, udp, func(req *dns.Msg) {
+		req.SetEdns0(4096, true)
+		opt := req.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	})
+	opt := resp.IsEdns0()
+	switch {
+	case opt == nil:
+		t.Fatal("OPT missing")
+	case opt.UDPSize() != DefaultUDPSize, !opt.Do():
+		t.Errorf("unexpected OPT: %v", opt)
+	case !hasEDNS0Option(opt, dns.EDNS0NSID):
+		t.Error("NSID missing")
+	}
+	if resp.Len() > DefaultUDPSize {
+		t.Errorf("response of %v bytes exceeds our buffer", resp.Len())
+	}
+
+	// TCP isn't truncated
+	resp = serveTestEDNSRequest(h, tcp, nil)
+	if resp.Truncated || len(resp.Answer) != 20 {
+		t.Errorf("unexpected TCP response: %v answers, truncated %v",
+			len(resp.Answer), resp.Truncated)
+	}
+
+	// unknown version
+	resp = serveTestEDNSRequest(h, udp, func(req *dns.Msg) {
+		req.SetEdns0(4096, false)
+		req.IsEdns0().SetVersion(1)
+	})
+	if resp.Rcode != dns.RcodeBadVers || resp.IsEdns0() == nil {
+		t.Errorf("unexpected response to EDNS version 1: %v", resp)
+	}
+	if _, err := resp.Pack(); err != nil {
+		t.Error(err)
+	}
+}
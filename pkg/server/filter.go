@@ -0,0 +1,52 @@
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+// AcceptFunc decides if a request will be processed, before
+// any work is done.
+type AcceptFunc func(dns.ResponseWriter, *dns.Msg) dns.MsgAcceptAction
+
+// acceptRequest applies the pre-filters to a request, and tells
+// if it should be processed.
+func (h *Handler) acceptRequest(w dns.ResponseWriter, r *dns.Msg) (bool, error) {
+	action := dns.MsgAccept
+	switch {
+	case r.Response:
+		// not a request
+		action = dns.MsgIgnore
+	case h.Accept != nil:
+		action = h.Accept(w, r)
+	}
+
+	switch action {
+	case dns.MsgAccept:
+		return true, nil
+	case dns.MsgReject:
+		return false, handleRcodeError(w, r, dns.RcodeFormatError)
+	case dns.MsgRejectNotImplemented:
+		return false, handleNotImplemented(w, r)
+	default:
+		// ignore
+		return false, nil
+	}
+}
+
+// handleOpcode passes requests other than QUERY to the registered
+// handler, or answers NOTIMP.
+func (h *Handler) handleOpcode(w dns.ResponseWriter, r *dns.Msg) error {
+	if fn, ok := h.Opcodes[r.Opcode]; ok && fn != nil {
+		fn(w, r)
+		return nil
+	}
+
+	switch {
+	case r.Opcode == dns.OpcodeNotify && h.Authority != nil:
+		return h.handleNotify(w, r)
+	case r.Opcode == dns.OpcodeUpdate && h.Authority != nil:
+		return h.handleUpdate(w, r)
+	default:
+		return handleNotImplemented(w, r)
+	}
+}
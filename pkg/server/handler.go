@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"net/netip"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -26,15 +27,85 @@ type Handler struct {
 	Hostname string
 	Version  string
 	Authors  string
+	// Stats answers other CHAOS TXT queries, like "qps.bind."
+	// or "uptime.server.".
+	Stats ChaosStatsProvider
 
 	Context  context.Context
 	Timeout  time.Duration
 	Lookuper resolver.Lookuper
 	Extra    map[uint16]dns.HandlerFunc
 
+	// Zones handles queries for names under the given suffixes,
+	// in canonical form. The longest match is used.
+	Zones map[string]dns.HandlerFunc
+	// Types handles queries of the given class and type.
+	// Zones take precedence.
+	Types map[TypeKey]dns.HandlerFunc
+
+	// Opcodes handles requests other than QUERY, like NOTIFY or
+	// UPDATE. Those without handler are answered NOTIMP, but
+	// NOTIFY and UPDATE for the zones of the Authority.
+	Opcodes map[int]dns.HandlerFunc
+	// Accept, if set, is called before processing any request,
+	// allowing it to be rejected or ignored. Responses are always
+	// ignored.
+	Accept AcceptFunc
+
 	RemoteAddr *core.ContextKey[netip.Addr]
 
+	// Allow, if not empty, lists the networks allowed to make
+	// INET queries. Others are REFUSED.
+	Allow []netip.Prefix
+	// Deny lists the networks whose INET queries are REFUSED,
+	// even if allowed.
+	Deny []netip.Prefix
+	// Views route INET queries to a different [resolver.Lookuper]
+	// depending on the client's address. The first match is used.
+	Views []View
+
 	OnError func(dns.ResponseWriter, *dns.Msg, error)
+
+	// UDPSize is the UDP buffer size advertised to EDNS clients.
+	// [DefaultUDPSize] is used if not specified.
+	UDPSize uint16
+	// NSID, if set, is included in the responses to EDNS clients
+	// asking for it, RFC 5001.
+	NSID string
+
+	// MinimalANY makes ANY queries be answered with a synthesized
+	// HINFO record, RFC 8482, instead of passing them to the Lookuper.
+	MinimalANY bool
+
+	// TSIG lists the keys accepted on signed requests and what
+	// they can do, by canonical name. Others are REFUSED, and those
+	// failing verification NOTAUTH.
+	TSIG map[string]TSIGACL
+
+	// Middleware wraps the processing of requests, the first
+	// being the outermost.
+	Middleware []Middleware
+
+	// Authority provides the zones transferred via AXFR, and those
+	// accepting NOTIFY messages.
+	Authority *resolver.Authority
+	// AllowTransfer lists the networks allowed to transfer the
+	// zones of the Authority. Requests signed with a TSIG key
+	// are allowed too.
+	AllowTransfer []netip.Prefix
+	// AllowNotify lists the networks allowed to send NOTIFY
+	// messages for the zones of the Authority. Requests signed
+	// with a TSIG key are allowed too.
+	AllowNotify []netip.Prefix
+	// OnNotify is called when a NOTIFY message is accepted.
+	OnNotify NotifyFunc
+	// Updates lists who can make dynamic updates to the zones of the
+	// Authority, by canonical name. Zones without [UpdatePolicy] are
+	// REFUSED.
+	Updates map[string]UpdatePolicy
+
+	chain    atomic.Pointer[handlerRef]
+	lookuper atomic.Pointer[lookuperRef]
 }
 
 // SetDefaults fills gaps in the [Handler] struct
@@ -48,6 +119,7 @@ func (h *Handler) SetDefaults() {
 	if h.Extra == nil {
 		h.Extra = make(map[uint16]dns.HandlerFunc)
 	}
+	h.chain.Store(&handlerRef{h.newChain()})
 }
 
 func (h *Handler) onError(rw dns.ResponseWriter, req *dns.Msg, err error) {
@@ -58,32 +130,58 @@ func (h *Handler) onError(rw dns.ResponseWriter, req *dns.Msg, err error) {
 
 // ServeDNS handles requests passed by [dns.ServeMUX]
 func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
-	var err error
+	h.getChain().ServeDNS(w, r)
+}
 
-	if len(r.Question) != 1 {
-		err = handleNotImplemented(w, r)
-		if err != nil {
-			h.onError(w, r, err)
-		}
-		return
+// serve handles requests after the middlewares.
+func (h *Handler) serve(w dns.ResponseWriter, r *dns.Msg) {
+	ok, err := h.acceptRequest(w, r)
+	if ok {
+		w, ok, err = h.checkTSIG(w, r)
+	}
+	if ok {
+		err = h.serveEDNS(w, r)
+	}
+
+	if err != nil {
+		h.onError(w, r, err)
+	}
+}
+
+func (h *Handler) serveEDNS(w dns.ResponseWriter, r *dns.Msg) error {
+	rw, ok := h.newEDNSResponseWriter(w, r)
+	if !ok {
+		return h.handleBadVersion(w, r)
+	}
+	return h.serveDNS(rw, r)
+}
+
+func (h *Handler) serveDNS(w dns.ResponseWriter, r *dns.Msg) error {
+	switch {
+	case r.Opcode != dns.OpcodeQuery:
+		return h.handleOpcode(w, r)
+	case len(r.Question) != 1:
+		return handleNotImplemented(w, r)
 	}
 
 	// TODO: what about the other questions?
 	q := r.Question[0]
+	if fn := h.getRoute(q); fn != nil {
+		// call custom handler
+		fn(w, r)
+		return nil
+	}
+
 	switch q.Qclass {
 	case dns.ClassCHAOS:
 		// call CHAOS class handler
-		err = h.handleCHAOS(w, r, q)
+		return h.handleCHAOS(w, r, q)
 	case dns.ClassINET:
 		// call INET class handler
-		err = h.handleINET(w, r, q)
+		return h.handleINET(w, r, q)
 	default:
 		// check other classes
-		err = h.handleExtra(w, r, q)
-	}
-
-	if err != nil {
-		h.onError(w, r, err)
+		return h.handleExtra(w, r, q)
 	}
 }
 
@@ -101,20 +199,40 @@ func (h *Handler) handleCHAOS(w dns.ResponseWriter, r *dns.Msg, q dns.Question)
 		if s := h.Hostname; s != "" {
 			return handleTXTResponse(w, r, s)
 		}
+	default:
+		if q.Qtype != dns.TypeTXT || h.Stats == nil {
+			break
+		}
+		if s, ok := h.Stats.ChaosStat(q.Name); ok {
+			return handleTXTResponse(w, r, s)
+		}
 	}
 
 	return handleNotImplemented(w, r)
 }
 
 func (h *Handler) handleINET(w dns.ResponseWriter, r *dns.Msg, q dns.Question) error {
-	if h.Lookuper == nil {
+	addr, _ := clientAddr(w.RemoteAddr())
+	switch {
+	case isTransfer(q) && h.Authority != nil:
+		return h.handleTransfer(w, r, q)
+	case !h.IsAllowed(addr):
+		return handleRcodeError(w, r, dns.RcodeRefused)
+	case q.Qtype == dns.TypeANY && h.MinimalANY:
+		return handleMinimalANY(w, r, q)
+	}
+
+	lookuper := h.getLookuper(addr)
+	if lookuper == nil {
 		return handleNotImplemented(w, r)
 	}
 
 	ctx, cancel := h.newLookupContext(w.RemoteAddr())
 	defer cancel()
 
-	rsp, err := h.Lookuper.Lookup(ctx, q.Name, q.Qtype)
+	ctx = withQueryLogTrace(ctx, w)
+	ctx = withTracing(ctx, w)
+	rsp, err := lookuper.Lookup(ctx, q.Name, q.Qtype)
 	switch {
 	case err != nil:
 		// TODO: log error
@@ -124,9 +242,10 @@ func (h *Handler) handleINET(w dns.ResponseWriter, r *dns.Msg, q dns.Question) e
 		// nil answer from resolver
 		return handleRcodeError(w, r, dns.RcodeServerFailure)
 	default:
-		// success
+		// answer, authoritative negative ones included
+		rcode := rsp.Rcode
 		rsp.SetReply(r)
-		rsp.SetRcode(r, dns.RcodeSuccess)
+		rsp.Rcode = rcode
 		return w.WriteMsg(rsp)
 	}
 }
@@ -140,7 +259,7 @@ func (h *Handler) newLookupContext(remoteAddr net.Addr) (context.Context, contex
 	}
 	// RemoteAddr
 	if h.RemoteAddr != nil {
-		addr, ok := core.AddrFromNetIP(remoteAddr)
+		addr, ok := clientAddr(remoteAddr)
 		if ok {
 			ctx = h.RemoteAddr.WithValue(ctx, addr)
 		}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver"
+)
+
+// newTestLookuper returns a [resolver.Lookuper] answering
+// every query with a TXT record containing the given text.
+func newTestLookuper(text string) resolver.Lookuper {
+	return resolver.LookuperFunc(func(_ context.Context,
+		qName string, qType uint16) (*dns.Msg, error) {
+		//
+		m := new(dns.Msg)
+		m.SetQuestion(qName, qType)
+		m.Answer = []dns.RR{
+			&dns.TXT{
+				Hdr: dns.RR_Header{
+					Name:   qName,
+					Rrtype: dns.TypeTXT,
+					Class:  dns.ClassINET,
+				},
+				Txt: []string{text},
+			},
+		}
+		return m, nil
+	})
+}
+
+// serveTestRequest passes a TXT query from the given client
+// to the [dns.Handler] and returns the response.
+func serveTestRequest(t *testing.T, h dns.Handler, client string) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeTXT)
+
+	rw := &dohResponseWriter{
+		remote: net.UDPAddrFromAddrPort(netip.MustParseAddrPort(client)),
+	}
+	h.ServeDNS(rw, req)
+	if rw.msg == nil {
+		t.Fatalf("%s: no response", client)
+	}
+	return rw.msg
+}
+
+func TestHandlerACL(t *testing.T) {
+	h := &Handler{
+		Lookuper: newTestLookuper("external"),
+		Allow: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("192.0.2.0/24"),
+		},
+		Deny: []netip.Prefix{
+			netip.MustParsePrefix("10.1.0.0/16"),
+		},
+		Views: []View{
+			{
+				Name:     "internal",
+				Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				Lookuper: newTestLookuper("internal"),
+			},
+		},
+	}
+	h.SetDefaults()
+
+	for _, tc := range []struct {
+		client string
+		rcode  int
+		text   string
+	}{
+		{"10.2.0.1:1234", dns.RcodeSuccess, "internal"},
+		{"[::ffff:10.2.0.1]:1234", dns.RcodeSuccess, "internal"},
+		{"192.0.2.1:1234", dns.RcodeSuccess, "external"},
+		{"10.1.0.1:1234", dns.RcodeRefused, ""},
+		{"198.51.100.1:1234", dns.RcodeRefused, ""},
+	} {
+		resp := serveTestRequest(t, h, tc.client)
+		if resp.Rcode != tc.rcode {
+			t.Errorf("%s: unexpected rcode %s", tc.client, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+
+		if tc.text != "" {
+			txt, ok := resp.Answer[0].(*dns.TXT)
+			if !ok || txt.Txt[0] != tc.text {
+				t.Errorf("%s: unexpected answer %v", tc.client, resp.Answer)
+			}
+		}
+	}
+}
+
+func TestHandlerFilters(t *testing.T) {
+	h := &Handler{
+		Lookuper: newTestLookuper("ok"),
+		Opcodes: map[int]dns.HandlerFunc{
+			dns.OpcodeNotify: func(w dns.ResponseWriter, r *dns.Msg) {
+				_ = handleRcodeError(w, r, dns.RcodeRefused)
+			},
+		},
+		Accept: func(_ dns.ResponseWriter, r *dns.Msg) dns.MsgAcceptAction {
+			if r.Question[0].Name == "bad.example." {
+				return dns.MsgReject
+			}
+			return dns.MsgAccept
+		},
+	}
+	h.SetDefaults()
+
+	for _, tc := range []struct {
+		name   string
+		opcode int
+		qr     bool
+		rcode  int
+	}{
+		{"example.org.", dns.OpcodeQuery, false, dns.RcodeSuccess},
+		{"example.org.", dns.OpcodeQuery, true, -1},
+		{"example.org.", dns.OpcodeNotify, false, dns.RcodeRefused},
+		{"example.org.", dns.OpcodeUpdate, false, dns.RcodeNotImplemented},
+		{"bad.example.", dns.OpcodeQuery, false, dns.RcodeFormatError},
+	} {
+		req := new(dns.Msg)
+		req.SetQuestion(tc.name, dns.TypeTXT)
+		req.Opcode = tc.opcode
+		req.Response = tc.qr
+
+		rw := &dohResponseWriter{
+			remote: net.UDPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:53")),
+		}
+		h.ServeDNS(rw, req)
+
+		switch {
+		case tc.rcode < 0 && rw.msg != nil:
+			t.Errorf("%v: unexpected response %v", tc, rw.msg)
+		case tc.rcode >= 0 && rw.msg == nil:
+			t.Errorf("%v: no response", tc)
+		case tc.rcode >= 0 && rw.msg.Rcode != tc.rcode:
+			t.Errorf("%v: unexpected rcode %s", tc, dns.RcodeToString[rw.msg.Rcode])
+		}
+	}
+}
+
+func TestHandlerMinimalANY(t *testing.T) {
+	for _, minimal := range []bool{true, false} {
+		h := &Handler{
+			Lookuper:   newTestLookuper("upstream"),
+			MinimalANY: minimal,
+		}
+		h.SetDefaults()
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeANY)
+
+		rw := &dohResponseWriter{
+			remote: net.UDPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:53")),
+		}
+		h.ServeDNS(rw, req)
+
+		if rw.msg == nil || len(rw.msg.Answer) != 1 {
+			t.Fatalf("%v: unexpected response %v", minimal, rw.msg)
+		}
+
+		_, isHINFO := rw.msg.Answer[0].(*dns.HINFO)
+		if isHINFO != minimal {
+			t.Errorf("%v: unexpected answer %v", minimal, rw.msg.Answer[0])
+		}
+	}
+}
+
+func TestHandlerRoutes(t *testing.T) {
+	h := &Handler{
+		Lookuper: newTestLookuper("default"),
+	}
+	h.HandleZone("Internal.Example.", newTestLookuperHandler("internal"))
+	h.HandleZone("deep.internal.example.", newTestLookuperHandler("deep"))
+	h.HandleType(dns.ClassINET, dns.TypeHTTPS, newTestLookuperHandler("https"))
+	h.SetDefaults()
+
+	type testCase struct {
+		name  string
+		qType uint16
+		text  string
+	}
+
+	run := func(tests []testCase) {
+		for _, tc := range tests {
+			req := new(dns.Msg)
+			req.SetQuestion(tc.name, tc.qType)
+
+			rw := &dohResponseWriter{
+				remote: net.UDPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:53")),
+			}
+			h.ServeDNS(rw, req)
+
+			if s := getTestTXT(t, rw.msg); s != tc.text {
+				t.Errorf("%s %s: unexpected answer %q", tc.name, dns.TypeToString[tc.qType], s)
+			}
+		}
+	}
+
+	run([]testCase{
+		{"www.example.org.", dns.TypeTXT, "default"},
+		{"www.example.org.", dns.TypeHTTPS, "https"},
+		{"internal.example.", dns.TypeTXT, "internal"},
+		{"host.INTERNAL.example.", dns.TypeHTTPS, "internal"},
+		{"a.deep.internal.example.", dns.TypeTXT, "deep"},
+	})
+
+	// the root zone covers every other name
+	h.HandleZone(".", newTestLookuperHandler("root"))
+	run([]testCase{
+		{"www.example.org.", dns.TypeTXT, "root"},
+		{"www.example.org.", dns.TypeHTTPS, "root"},
+		{".", dns.TypeTXT, "root"},
+		{"host.internal.example.", dns.TypeTXT, "internal"},
+	})
+}
+
+// newTestLookuperHandler returns a [dns.HandlerFunc] answering
+// with a TXT record containing the given text.
+func newTestLookuperHandler(text string) dns.HandlerFunc {
+	l := newTestLookuper(text)
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		q := r.Question[0]
+		resp, _ := l.Lookup(context.Background(), q.Name, q.Qtype)
+		resp.SetReply(r)
+		_ = w.WriteMsg(resp)
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// JSONPath is where the JSON API is served, besides [DoHPath]
+	// when requested using the Accept header.
+	JSONPath = "/resolve"
+	// JSONContentType is the media type of the JSON API responses.
+	JSONContentType = "application/dns-json"
+)
+
+var _ http.Handler = (*JSONHandler)(nil)
+
+// JSONHandler is an [http.Handler] implementing the JSON API used
+// by Google and Cloudflare DNS-over-HTTPS services, on top of a
+// [dns.Handler].
+type JSONHandler struct {
+	Handler dns.Handler
+
+	// AllowedOrigins are the origins allowed to use the API from
+	// browsers. "*" allows any.
+	AllowedOrigins []string
+}
+
+// JSONQuestion is an entry of the Question section of a [JSONResponse].
+type JSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+// JSONRR is a record of a [JSONResponse].
+type JSONRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// JSONResponse is the response of the JSON API.
+type JSONResponse struct {
+	Status     int            `json:"Status"`
+	TC         bool           `json:"TC"`
+	RD         bool           `json:"RD"`
+	RA         bool           `json:"RA"`
+	AD         bool           `json:"AD"`
+	CD         bool           `json:"CD"`
+	Question   []JSONQuestion `json:"Question"`
+	Answer     []JSONRR       `json:"Answer,omitempty"`
+	Authority  []JSONRR       `json:"Authority,omitempty"`
+	Additional []JSONRR       `json:"Additional,omitempty"`
+}
+
+// NewJSONResponse converts a [dns.Msg] into a [JSONResponse].
+func NewJSONResponse(msg *dns.Msg) *JSONResponse {
+	out := &JSONResponse{
+		Status:     msg.Rcode,
+		TC:         msg.Truncated,
+		RD:         msg.RecursionDesired,
+		RA:         msg.RecursionAvailable,
+		AD:         msg.AuthenticatedData,
+		CD:         msg.CheckingDisabled,
+		Answer:     newJSONRRs(msg.Answer),
+		Authority:  newJSONRRs(msg.Ns),
+		Additional: newJSONRRs(msg.Extra),
+	}
+
+	for _, q := range msg.Question {
+		out.Question = append(out.Question, JSONQuestion{
+			Name: q.Name,
+			Type: q.Qtype,
+		})
+	}
+	return out
+}
+
+func newJSONRRs(records []dns.RR) []JSONRR {
+	var out []JSONRR
+	for _, rr := range records {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT {
+			continue
+		}
+
+		out = append(out, JSONRR{
+			Name: hdr.Name,
+			Type: hdr.Rrtype,
+			TTL:  hdr.Ttl,
+			Data: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	return out
+}
+
+// ServeHTTP handles GET requests for the name and type given
+// as query parameters.
+func (h *JSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	switch r.Method {
+	case http.MethodGet:
+		// continue
+	case http.MethodOptions:
+		// preflight
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		status := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	req, err := newJSONRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw := newDoHResponseWriter(r)
+	h.Handler.ServeDNS(rw, req)
+	if rw.msg == nil {
+		status := http.StatusInternalServerError
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", JSONContentType)
+	if ttl, ok := dohMaxAge(rw.msg); ok {
+		hdr.Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+	_ = json.NewEncoder(w).Encode(NewJSONResponse(rw.msg))
+}
+
+// newJSONRequest assembles a [dns.Msg] from the name, type, cd and do
+// query parameters.
+func newJSONRequest(r *http.Request) (*dns.Msg, error) {
+	q := r.URL.Query()
+
+	name := q.Get("name")
+	if name == "" || len(name) > 253 {
+		return nil, core.Wrap(core.ErrInvalid, "invalid name")
+	}
+
+	qType, ok := parseJSONType(q.Get("type"))
+	if !ok {
+		return nil, core.Wrap(core.ErrInvalid, "invalid type")
+	}
+
+	req := exdns.NewRequestFromParts(dns.Fqdn(name), dns.ClassINET, qType)
+	req.CheckingDisabled = parseJSONBool(q.Get("cd"))
+	if parseJSONBool(q.Get("do")) {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	return req, nil
+}
+
+func parseJSONType(s string) (uint16, bool) {
+	if s == "" {
+		return dns.TypeA, true
+	}
+
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return uint16(n), true
+	}
+
+	qType, ok := dns.StringToType[strings.ToUpper(s)]
+	return qType, ok
+}
+
+func parseJSONBool(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "true":
+		return true
+	default:
+		return false
+	}
+}
+
+// setCORS adds the CORS headers if the request comes from
+// an allowed origin.
+func (h *JSONHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	hdr := w.Header()
+	hdr.Add("Vary", "Origin")
+
+	switch {
+	case core.SliceContains(h.AllowedOrigins, "*"):
+		hdr.Set("Access-Control-Allow-Origin", "*")
+	case core.SliceContains(h.AllowedOrigins, origin):
+		hdr.Set("Access-Control-Allow-Origin", origin)
+	default:
+		return
+	}
+
+	hdr.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	hdr.Set("Access-Control-Allow-Headers", "Accept")
+}
+
+// isJSONRequest tells if a request to [DoHPath] asks for
+// the JSON API.
+func isJSONRequest(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), JSONContentType)
+}
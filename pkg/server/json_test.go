@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestJSONHandler(t *testing.T) {
+	h := &JSONHandler{
+		Handler:        newTestHandler(),
+		AllowedOrigins: []string{"https://example.org"},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, JSONPath+"?name=example.org&type=a", nil)
+	r.Header.Set("Origin", "https://example.org")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v", w.Code)
+	}
+	if s := w.Header().Get("Access-Control-Allow-Origin"); s != "https://example.org" {
+		t.Errorf("unexpected Access-Control-Allow-Origin %q", s)
+	}
+
+	var resp JSONResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case resp.Status != dns.RcodeSuccess,
+		len(resp.Question) != 1, resp.Question[0].Name != "example.org.",
+		len(resp.Answer) != 1, resp.Answer[0].Data != "192.0.2.1",
+		resp.Answer[0].TTL != 60:
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	for _, tc := range []struct {
+		url    string
+		origin string
+		status int
+		cors   bool
+	}{
+		{JSONPath + "?type=A", "", http.StatusBadRequest, false},
+		{JSONPath + "?name=example.org&type=BOGUS", "", http.StatusBadRequest, false},
+		{JSONPath + "?name=example.org&type=28", "https://other.example", http.StatusOK, false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		cors := w.Header().Get("Access-Control-Allow-Origin") != ""
+		if w.Code != tc.status || cors != tc.cors {
+			t.Errorf("%s: status %v cors %v", tc.url, w.Code, cors)
+		}
+	}
+}
+
+func TestDoHHandlerJSON(t *testing.T) {
+	srv := &Server{
+		Handler: newTestHandler(),
+		JSON:    true,
+	}
+	h := srv.newHTTPHandler()
+
+	r := httptest.NewRequest(http.MethodGet, DoHPath+"?name=example.org", nil)
+	r.Header.Set("Accept", JSONContentType)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if s := w.Header().Get("Content-Type"); w.Code != http.StatusOK || s != JSONContentType {
+		t.Errorf("unexpected response: %v %q", w.Code, s)
+	}
+}
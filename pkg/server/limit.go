@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/netip"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+var _ dns.Handler = (*ConcurrencyLimit)(nil)
+
+// ShedPolicy indicates what a [ConcurrencyLimit] does with
+// queries over the limits.
+type ShedPolicy int
+
+const (
+	// ShedDrop discards the query without response.
+	ShedDrop ShedPolicy = iota
+	// ShedRefused answers REFUSED.
+	ShedRefused
+	// ShedServerFailure answers SERVFAIL.
+	ShedServerFailure
+)
+
+// ConcurrencyStats describes the load of a [ConcurrencyLimit].
+type ConcurrencyStats struct {
+	// Inflight is the number of queries being processed.
+	Inflight int
+	// Clients is the number of clients with queries being processed.
+	Clients int
+	// Peak is the highest Inflight value observed.
+	Peak int
+	// Shed is the number of queries rejected for exceeding
+	// the limits.
+	Shed uint64
+}
+
+// ConcurrencyLimit is a [dns.Handler] middleware limiting how many
+// queries are processed at the same time, in total and for each
+// client address.
+type ConcurrencyLimit struct {
+	mu      sync.Mutex
+	clients map[netip.Addr]int
+	stats   ConcurrencyStats
+
+	Next dns.Handler
+
+	// MaxInflight limits the queries processed at the same time.
+	// Zero means no limit.
+	MaxInflight int
+	// MaxPerClient limits the queries from the same address processed
+	// at the same time. Zero means no limit.
+	MaxPerClient int
+	// Policy indicates what to do with queries over the limits.
+	Policy ShedPolicy
+}
+
+// NewConcurrencyLimit creates a [ConcurrencyLimit] middleware
+// dropping queries over the given limits. Zero means no limit.
+// next can be nil if it will be used via [ConcurrencyLimit.Wrap].
+func NewConcurrencyLimit(next dns.Handler, total, perClient int) (*ConcurrencyLimit, error) {
+	if total < 0 || perClient < 0 {
+		return nil, core.ErrInvalid
+	}
+
+	cl := &ConcurrencyLimit{
+		Next:         next,
+		MaxInflight:  total,
+		MaxPerClient: perClient,
+	}
+	return cl, nil
+}
+
+// ServeDNS passes the request to the next [dns.Handler]
+// if the limits allow it.
+func (cl *ConcurrencyLimit) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	cl.serve(cl.Next, w, r)
+}
+
+func (cl *ConcurrencyLimit) serve(next dns.Handler, w dns.ResponseWriter, r *dns.Msg) {
+	addr, _ := clientAddr(w.RemoteAddr())
+	if !cl.acquire(addr) {
+		cl.shed(w, r)
+		return
+	}
+	defer cl.release(addr)
+
+	next.ServeDNS(w, r)
+}
+
+func (cl *ConcurrencyLimit) acquire(addr netip.Addr) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.clients == nil {
+		cl.clients = make(map[netip.Addr]int)
+	}
+
+	n := cl.clients[addr]
+	switch {
+	case cl.MaxInflight > 0 && cl.stats.Inflight >= cl.MaxInflight,
+		cl.MaxPerClient > 0 && n >= cl.MaxPerClient:
+		cl.stats.Shed++
+		return false
+	}
+
+	cl.clients[addr] = n + 1
+	cl.stats.Inflight++
+	cl.stats.Peak = max(cl.stats.Peak, cl.stats.Inflight)
+	return true
+}
+
+func (cl *ConcurrencyLimit) release(addr netip.Addr) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if n := cl.clients[addr]; n > 1 {
+		cl.clients[addr] = n - 1
+	} else {
+		delete(cl.clients, addr)
+	}
+	cl.stats.Inflight--
+}
+
+func (cl *ConcurrencyLimit) shed(w dns.ResponseWriter, r *dns.Msg) {
+	switch cl.Policy {
+	case ShedRefused:
+		_ = handleRcodeError(w, r, dns.RcodeRefused)
+	case ShedServerFailure:
+		_ = handleRcodeError(w, r, dns.RcodeServerFailure)
+	}
+}
+
+// Stats returns the current load of the [ConcurrencyLimit].
+func (cl *ConcurrencyLimit) Stats() ConcurrencyStats {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	out := cl.stats
+	out.Clients = len(cl.clients)
+	return out
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	var wg sync.WaitGroup
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	next := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		entered <- struct{}{}
+		<-release
+		_ = handleRcodeError(w, r, dns.RcodeSuccess)
+	})
+
+	cl, err := NewConcurrencyLimit(next, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Policy = ShedRefused
+
+	serve := func(client string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+
+		rw := &dohResponseWriter{
+			remote: net.UDPAddrFromAddrPort(netip.MustParseAddrPort(client)),
+		}
+		cl.ServeDNS(rw, req)
+		return rw.msg
+	}
+
+	for _, s := range []string{"192.0.2.1:53", "192.0.2.2:53"} {
+		wg.Add(1)
+		go func(s string) {
+			defer wg.Done()
+			if resp := serve(s); resp == nil || resp.Rcode != dns.RcodeSuccess {
+				t.Errorf("%s: unexpected response %v", s, resp)
+			}
+		}(s)
+		<-entered
+	}
+
+	for _, s := range []string{
+		"192.0.2.1:54", // same client
+		"192.0.2.3:53", // global limit
+	} {
+		if resp := serve(s); resp == nil || resp.Rcode != dns.RcodeRefused {
+			t.Errorf("%s: unexpected response %v", s, resp)
+		}
+	}
+
+	st := cl.Stats()
+	if st.Inflight != 2 || st.Clients != 2 || st.Shed != 2 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if st := cl.Stats(); st.Inflight != 0 || st.Clients != 0 || st.Peak != 2 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/metrics"
+)
+
+var (
+	_ dns.Handler        = (*Metrics)(nil)
+	_ dns.ResponseWriter = (*metricsWriter)(nil)
+)
+
+// Metrics is a [dns.Handler] middleware reporting the queries by
+// type, rcode and transport, and how long they took, to a
+// [metrics.Registry].
+type Metrics struct {
+	Next dns.Handler
+
+	queries  *metrics.Counter
+	duration *metrics.Histogram
+}
+
+// NewMetrics creates a [Metrics] middleware reporting to the given
+// [metrics.Registry]. next can be nil if it will be used via
+// [Metrics.Wrap].
+func NewMetrics(next dns.Handler, reg *metrics.Registry) (*Metrics, error) {
+	if reg == nil {
+		return nil, core.ErrInvalid
+	}
+
+	m := &Metrics{
+		Next: next,
+		queries: reg.Counter("dns_server_queries_total",
+			"Queries received by type, rcode and transport.",
+			"qtype", "rcode", "transport"),
+		duration: reg.Histogram("dns_server_query_duration_seconds",
+			"Time taken to answer the queries.", nil, "transport"),
+	}
+	return m, nil
+}
+
+// ServeDNS passes the request to the next [dns.Handler], accounting
+// the response.
+func (m *Metrics) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m.serve(m.Next, w, r)
+}
+
+func (m *Metrics) serve(next dns.Handler, w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	mw := &metricsWriter{
+		ResponseWriter: w,
+		rcode:          -1,
+	}
+	next.ServeDNS(mw, r)
+
+	qType := "NONE"
+	if len(r.Question) > 0 {
+		qType = core.Coalesce(dns.TypeToString[r.Question[0].Qtype], "OTHER")
+	}
+
+	rcode := "DROPPED"
+	if mw.rcode >= 0 {
+		rcode = core.Coalesce(dns.RcodeToString[mw.rcode], "OTHER")
+	}
+
+	transport := queryTransport(w)
+	m.queries.Inc(qType, rcode, transport)
+	m.duration.ObserveDuration(time.Since(start), transport)
+}
+
+// metricsWriter is a [dns.ResponseWriter] recording the rcode
+// of the response for [Metrics].
+type metricsWriter struct {
+	dns.ResponseWriter
+
+	rcode int
+}
+
+func (rw *metricsWriter) WriteMsg(msg *dns.Msg) error {
+	if msg != nil {
+		rw.rcode = msg.Rcode
+	}
+	return rw.ResponseWriter.WriteMsg(msg)
+}
+
+func (rw *metricsWriter) Write(b []byte) (int, error) {
+	var hdr dns.Msg
+	if err := hdr.Unpack(b); err == nil {
+		rw.rcode = hdr.Rcode
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *metricsWriter) Unwrap() dns.ResponseWriter { return rw.ResponseWriter }
+
+// serverMetrics are the metrics reported by a [Server].
+type serverMetrics struct {
+	conns *metrics.Gauge
+}
+
+// initMetrics registers the metrics of the [Server], if enabled.
+func (srv *Server) initMetrics() {
+	reg := srv.Metrics
+	if reg == nil {
+		return
+	}
+
+	reg.Gauge("dns_server_inflight_requests", "Requests being processed.").
+		SetFunc(func() float64 { return float64(srv.Inflight()) })
+
+	srv.metrics = &serverMetrics{
+		conns: reg.Gauge("dns_server_open_connections",
+			"Open connections by transport.", "transport"),
+	}
+}
+
+// wrapMetrics makes a TCP [net.Listener] count its open connections.
+func (srv *Server) wrapMetrics(ln net.Listener, transport string) net.Listener {
+	if srv.metrics == nil {
+		return ln
+	}
+
+	return &metricsListener{
+		Listener:  ln,
+		conns:     srv.metrics.conns,
+		transport: transport,
+	}
+}
+
+// metricsListener is a [net.Listener] counting open connections.
+type metricsListener struct {
+	net.Listener
+
+	conns     *metrics.Gauge
+	transport string
+}
+
+func (ml *metricsListener) Accept() (net.Conn, error) {
+	conn, err := ml.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	ml.conns.Add(1, ml.transport)
+	return &metricsConn{Conn: conn, ml: ml}, nil
+}
+
+// metricsConn is a [net.Conn] counted by a [metricsListener].
+type metricsConn struct {
+	net.Conn
+
+	once sync.Once
+	ml   *metricsListener
+}
+
+func (mc *metricsConn) Close() error {
+	mc.once.Do(func() { mc.ml.conns.Add(-1, mc.ml.transport) })
+	return mc.Conn.Close()
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"darvaza.org/resolver/pkg/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	m, err := NewMetrics(nil, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{Lookuper: newTestLookuper("hello")}
+	h.Use(m.Wrap)
+	h.SetDefaults()
+
+	for i := 0; i < 2; i++ {
+		serveTestRequest(t, h, "192.0.2.1:5353")
+	}
+
+	var buf strings.Builder
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		`dns_server_queries_total{qtype="TXT",rcode="NOERROR",transport="http"} 2`,
+		`dns_server_query_duration_seconds_count{transport="http"} 2`,
+	} {
+		if !strings.Contains(buf.String(), s+"\n") {
+			t.Errorf("%q not found in:\n%s", s, buf.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+// Middleware wraps a [dns.Handler] to extend it, i.e. to filter
+// the requests or alter the responses.
+type Middleware func(next dns.Handler) dns.Handler
+
+// Chain wraps a [dns.Handler] with the given middlewares. The first
+// is the outermost, seeing the requests first and the responses last.
+func Chain(h dns.Handler, middlewares ...Middleware) dns.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if mw := middlewares[i]; mw != nil {
+			h = mw(h)
+		}
+	}
+	return h
+}
+
+// findResponseWriter looks for a [dns.ResponseWriter] of the given type
+// among those wrapping each other via an Unwrap method.
+func findResponseWriter[T dns.ResponseWriter](w dns.ResponseWriter) (T, bool) {
+	for w != nil {
+		if rw, ok := w.(T); ok {
+			return rw, true
+		}
+
+		u, ok := w.(interface{ Unwrap() dns.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
+
+// Use appends middlewares to the [Handler]. They take effect
+// on the next call to [Handler.SetDefaults].
+func (h *Handler) Use(middlewares ...Middleware) {
+	h.Middleware = append(h.Middleware, middlewares...)
+}
+
+// getChain returns the [dns.Handler] processing the requests,
+// built by [Handler.SetDefaults] or on first use.
+func (h *Handler) getChain() dns.Handler {
+	if ref := h.chain.Load(); ref != nil {
+		return ref.h
+	}
+
+	// first wins
+	h.chain.CompareAndSwap(nil, &handlerRef{h.newChain()})
+	return h.chain.Load().h
+}
+
+func (h *Handler) newChain() dns.Handler {
+	return Chain(dns.HandlerFunc(h.serve), h.Middleware...)
+}
+
+// wrappedHandler is a [dns.Handler] passing the requests through
+// a shared middleware to its own next [dns.Handler], so the same
+// middleware can be used in several chains.
+type wrappedHandler struct {
+	next  dns.Handler
+	serve func(next dns.Handler, w dns.ResponseWriter, r *dns.Msg)
+}
+
+// ServeDNS passes the request to the middleware.
+func (wh *wrappedHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	wh.serve(wh.next, w, r)
+}
+
+// Wrap returns a [dns.Handler] passing the requests through the
+// [RRL] to the given next one, allowing it to be used as [Middleware].
+func (rrl *RRL) Wrap(next dns.Handler) dns.Handler {
+	return &wrappedHandler{next: next, serve: rrl.serve}
+}
+
+// Wrap returns a [dns.Handler] passing the requests through the
+// [ConcurrencyLimit] to the given next one, allowing it to be used as [Middleware].
+func (cl *ConcurrencyLimit) Wrap(next dns.Handler) dns.Handler {
+	return &wrappedHandler{next: next, serve: cl.serve}
+}
+
+// Wrap returns a [dns.Handler] passing the requests through the
+// [QueryLog] to the given next one, allowing it to be used as [Middleware].
+func (ql *QueryLog) Wrap(next dns.Handler) dns.Handler {
+	return &wrappedHandler{next: next, serve: ql.serve}
+}
+
+// Wrap returns a [dns.Handler] passing the requests through the
+// [Dnstap] to the given next one, allowing it to be used as [Middleware].
+func (dt *Dnstap) Wrap(next dns.Handler) dns.Handler {
+	return &wrappedHandler{next: next, serve: dt.serve}
+}
+
+// Wrap returns a [dns.Handler] passing the requests through the
+// [Metrics] to the given next one, allowing it to be used as [Middleware].
+func (m *Metrics) Wrap(next dns.Handler) dns.Handler {
+	return &wrappedHandler{next: next, serve: m.serve}
+}
+
+// Wrap returns a [dns.Handler] passing the requests through the
+// [Tracing] to the given next one, allowing it to be used as [Middleware].
+func (t *Tracing) Wrap(next dns.Handler) dns.Handler {
+	return &wrappedHandler{next: next, serve: t.serve}
+}
+
+// Wrap returns a [dns.Handler] passing the requests through the
+// [QueryCounter] to the given next one, allowing it to be used as [Middleware].
+func (qc *QueryCounter) Wrap(next dns.Handler) dns.Handler {
+	return &wrappedHandler{next: next, serve: qc.serve}
+}
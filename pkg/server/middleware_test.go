@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/metrics"
+)
+
+func newTestMiddleware(name string, trace *[]string) Middleware {
+	return func(next dns.Handler) dns.Handler {
+		return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			*trace = append(*trace, name+">")
+			next.ServeDNS(w, r)
+			*trace = append(*trace, "<"+name)
+		})
+	}
+}
+
+func TestHandlerMiddleware(t *testing.T) {
+	var trace []string
+
+	h := &Handler{
+		Lookuper: newTestLookuper("ok"),
+	}
+	h.Use(newTestMiddleware("a", &trace), newTestMiddleware("b", &trace))
+	h.SetDefaults()
+
+	resp := serveTestRequest(t, h, "192.0.2.1:53")
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Errorf("unexpected rcode %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	if s := strings.Join(trace, " "); s != "a> b> <b <a" {
+		t.Errorf("unexpected order: %s", s)
+	}
+}
+
+func TestChainRRL(t *testing.T) {
+	rrl, err := NewRRL(nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Chain(newTestHandler(), rrl.Wrap)
+	remote := net.UDPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:53"))
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+
+	rw := &dohResponseWriter{remote: remote}
+	h.ServeDNS(rw, req)
+	if rw.msg == nil || rrl.Next != nil {
+		t.Error("RRL not chained")
+	}
+}
+
+func TestHandlerMiddlewareParallel(t *testing.T) {
+	qc := new(QueryCounter)
+	m, err := NewMetrics(nil, metrics.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := NewConcurrencyLimit(nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same middlewares in two chains, one built on first use
+	handlers := []*Handler{
+		{Lookuper: newTestLookuper("first")},
+		{Lookuper: newTestLookuper("second")},
+	}
+	for _, h := range handlers {
+		h.Use(qc.Wrap, m.Wrap, cl.Wrap)
+	}
+	handlers[1].SetDefaults()
+
+	const workers, requests = 8, 16
+	remote := net.UDPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:53"))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < requests; j++ {
+				req := new(dns.Msg)
+				req.SetQuestion("example.org.", dns.TypeTXT)
+
+				rw := &dohResponseWriter{remote: remote}
+				handlers[j%2].ServeDNS(rw, req)
+
+				text := []string{"first", "second"}[j%2]
+				if rw.msg == nil || !hasTestText(rw.msg, text) {
+					t.Errorf("%s: unexpected response %v", text, rw.msg)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := qc.Total(); n != workers*requests {
+		t.Errorf("expected %v requests, got %v", workers*requests, n)
+	}
+}
+
+// hasTestText tells if the first answer of a response is
+// a TXT record with the given text.
+func hasTestText(resp *dns.Msg, text string) bool {
+	if len(resp.Answer) == 0 {
+		return false
+	}
+	txt, ok := resp.Answer[0].(*dns.TXT)
+	return ok && txt.Txt[0] == text
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+const (
+	// proxyV1MaxLength is the longest a PROXY protocol v1 header can be.
+	proxyV1MaxLength = 107
+)
+
+var (
+	// proxyV2Signature starts all PROXY protocol v2 headers.
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+// wrapProxy makes a [net.Listener] expect PROXY protocol headers on
+// the connections coming from the ProxyProtocol networks.
+func (srv *Server) wrapProxy(ln net.Listener) net.Listener {
+	if len(srv.ProxyProtocol) == 0 {
+		return ln
+	}
+
+	return &proxyListener{
+		Listener: ln,
+		trusted:  srv.ProxyProtocol,
+	}
+}
+
+// proxyListener is a [net.Listener] reading the PROXY protocol
+// header sent by trusted load balancers.
+type proxyListener struct {
+	net.Listener
+
+	trusted []netip.Prefix
+}
+
+func (pl *proxyListener) Accept() (net.Conn, error) {
+	conn, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if addr, ok := clientAddr(conn.RemoteAddr()); ok && prefixesContain(pl.trusted, addr) {
+		return &proxyConn{Conn: conn}, nil
+	}
+	return conn, nil
+}
+
+// proxyConn is a [net.Conn] reading the PROXY protocol header
+// before the first read, and reporting the client address it
+// carries as RemoteAddr.
+type proxyConn struct {
+	net.Conn
+
+	once   sync.Once
+	err    error
+	br     *bufio.Reader
+	remote atomic.Pointer[net.TCPAddr]
+}
+
+func (pc *proxyConn) readHeader() error {
+	pc.once.Do(func() {
+		pc.br = bufio.NewReader(pc.Conn)
+
+		addr, err := readProxyHeader(pc.br)
+		switch {
+		case err != nil:
+			pc.err = err
+		case addr != nil:
+			pc.remote.Store(addr)
+		}
+	})
+	return pc.err
+}
+
+func (pc *proxyConn) Read(b []byte) (int, error) {
+	if err := pc.readHeader(); err != nil {
+		return 0, err
+	}
+	return pc.br.Read(b)
+}
+
+// RemoteAddr returns the address of the client given by the
+// PROXY header, or that of the load balancer if it had none.
+func (pc *proxyConn) RemoteAddr() net.Addr {
+	if addr := pc.remote.Load(); addr != nil {
+		return addr
+	}
+	return pc.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY protocol header, v1 or v2, returning
+// the address of the client if given.
+func readProxyHeader(br *bufio.Reader) (*net.TCPAddr, error) {
+	sig, err := br.Peek(len(proxyV2Signature))
+	switch {
+	case err != nil:
+		return nil, err
+	case bytes.Equal(sig, proxyV2Signature):
+		return readProxyV2Header(br)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		return readProxyV1Header(br)
+	default:
+		return nil, errProxyHeader
+	}
+}
+
+// readProxyV1Header reads a text PROXY header, like
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 53\r\n".
+func readProxyV1Header(br *bufio.Reader) (*net.TCPAddr, error) {
+	line, err := br.ReadSlice('\n')
+	switch {
+	case err != nil:
+		return nil, errProxyHeader
+	case len(line) > proxyV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")):
+		return nil, errProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	switch {
+	case len(fields) > 1 && fields[1] == "UNKNOWN":
+		// unknown client
+		return nil, nil
+	case len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6"):
+		return nil, errProxyHeader
+	}
+
+	ap, err := netip.ParseAddrPort(net.JoinHostPort(fields[2], fields[4]))
+	if err != nil {
+		return nil, errProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(ap), nil
+}
+
+// readProxyV2Header reads a binary PROXY header.
+func readProxyV2Header(br *bufio.Reader) (*net.TCPAddr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, errProxyHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, errProxyHeader
+	}
+
+	switch cmd := hdr[12]; {
+	case cmd>>4 != 2:
+		// unknown version
+		return nil, errProxyHeader
+	case cmd&0xf == 0:
+		// LOCAL, health checks from the proxy itself
+		return nil, nil
+	case cmd&0xf != 1:
+		// not PROXY
+		return nil, errProxyHeader
+	}
+
+	var ip netip.Addr
+	var port uint16
+
+	switch family := hdr[13] >> 4; {
+	case family == 1 && len(body) >= 12:
+		// AF_INET
+		ip = netip.AddrFrom4([4]byte(body[0:4]))
+		port = binary.BigEndian.Uint16(body[8:10])
+	case family == 2 && len(body) >= 36:
+		// AF_INET6
+		ip = netip.AddrFrom16([16]byte(body[0:16]))
+		port = binary.BigEndian.Uint16(body[32:34])
+	case family == 1, family == 2:
+		return nil, errProxyHeader
+	default:
+		// AF_UNSPEC or AF_UNIX
+		return nil, nil
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip.Unmap(), port)), nil
+}
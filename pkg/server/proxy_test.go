@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := append([]byte(nil), proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12,
+		192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0, 53)
+
+	for _, tc := range []struct {
+		name   string
+		header []byte
+		addr   string
+		ok     bool
+	}{
+		{"v1", []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 53\r\n"), "192.0.2.1:56324", true},
+		{"v1-tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 53\r\n"), "[2001:db8::1]:56324", true},
+		{"v1-unknown", []byte("PROXY UNKNOWN\r\n"), "", true},
+		{"v1-bad", []byte("PROXY TCP4 192.0.2.1\r\n"), "", false},
+		{"v2", v2, "192.0.2.1:56324", true},
+		{"none", []byte("\x00\x1c0123456789abcdef"), "", false},
+	} {
+		br := bufio.NewReader(bytes.NewReader(append(tc.header, "payload"...)))
+		addr, err := readProxyHeader(br)
+
+		var s string
+		if addr != nil {
+			s = addr.String()
+		}
+
+		switch {
+		case tc.ok != (err == nil):
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		case s != tc.addr:
+			t.Errorf("%s: unexpected address %q", tc.name, s)
+		}
+	}
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	srv := &Server{
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			_ = handleTXTResponse(w, r, w.RemoteAddr().String())
+		}),
+		Addresses:     []string{"127.0.0.1:0"},
+		ProxyProtocol: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	}
+	startTestServer(t, srv)
+
+	var addr string
+	for _, a := range srv.Addrs() {
+		if a.Network() == "tcp" {
+			addr = a.String()
+		}
+	}
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	if _, err := nc.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 53\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &dns.Conn{Conn: nc}
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeTXT)
+	if err := conn.WriteMsg(req); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := conn.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txt, ok := resp.Answer[0].(*dns.TXT)
+	if !ok || txt.Txt[0] != "192.0.2.1:56324" {
+		t.Errorf("unexpected response: %v", resp.Answer)
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver"
+)
+
+var (
+	_ dns.Handler        = (*QueryLog)(nil)
+	_ dns.ResponseWriter = (*queryLogWriter)(nil)
+	_ QueryLogSink       = QueryLogSinkFunc(nil)
+)
+
+// QueryLogEntry describes a query and how it was answered.
+type QueryLogEntry struct {
+	Time     time.Time
+	Duration time.Duration
+
+	Client netip.Addr
+	// Transport is "udp", "tcp", "tls", "http" or "https".
+	Transport string
+
+	QName string
+	QType uint16
+	// Rcode is the rcode of the response, or -1 if none was sent.
+	Rcode int
+	// Answers is the number of records in the answer section.
+	Answers int
+	// CacheHit indicates the answer came from a [resolver.Cached].
+	CacheHit bool
+}
+
+// String describes the entry in a single line.
+func (e QueryLogEntry) String() string {
+	rcode := "-"
+	if e.Rcode >= 0 {
+		rcode = dns.RcodeToString[e.Rcode]
+	}
+
+	s := fmt.Sprintf("%s %s %s %s %s %s %d %v",
+		e.Time.Format(time.RFC3339Nano), e.Client, e.Transport,
+		e.QName, dns.TypeToString[e.QType], rcode, e.Answers,
+		e.Duration.Round(time.Microsecond))
+
+	if e.CacheHit {
+		s += " cached"
+	}
+	return s
+}
+
+// QueryLogSink receives the entries of a [QueryLog].
+type QueryLogSink interface {
+	LogQuery(QueryLogEntry) error
+}
+
+// QueryLogSinkFunc is a function implementing [QueryLogSink].
+type QueryLogSinkFunc func(QueryLogEntry) error
+
+// LogQuery calls the function.
+func (fn QueryLogSinkFunc) LogQuery(e QueryLogEntry) error {
+	return fn(e)
+}
+
+// QueryLog is a [dns.Handler] middleware passing a [QueryLogEntry]
+// describing each request to a [QueryLogSink]. It can be disabled
+// and enabled at any time.
+type QueryLog struct {
+	disabled atomic.Bool
+
+	Next dns.Handler
+	Sink QueryLogSink
+
+	// OnError is called when the Sink fails.
+	OnError func(error)
+}
+
+// NewQueryLog creates an enabled [QueryLog] middleware using the given
+// [QueryLogSink]. next can be nil if it will be used via [QueryLog.Wrap].
+func NewQueryLog(next dns.Handler, sink QueryLogSink) (*QueryLog, error) {
+	if sink == nil {
+		return nil, core.ErrInvalid
+	}
+
+	ql := &QueryLog{
+		Next: next,
+		Sink: sink,
+	}
+	return ql, nil
+}
+
+// SetEnabled enables or disables the logging.
+func (ql *QueryLog) SetEnabled(enabled bool) {
+	ql.disabled.Store(!enabled)
+}
+
+// Enabled tells if queries are being logged.
+func (ql *QueryLog) Enabled() bool {
+	return ql.Sink != nil && !ql.disabled.Load()
+}
+
+// ServeDNS passes the request to the next [dns.Handler], logging
+// the response.
+func (ql *QueryLog) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	ql.serve(ql.Next, w, r)
+}
+
+func (ql *QueryLog) serve(next dns.Handler, w dns.ResponseWriter, r *dns.Msg) {
+	if !ql.Enabled() {
+		next.ServeDNS(w, r)
+		return
+	}
+
+	lw := &queryLogWriter{
+		ResponseWriter: w,
+		entry:          newQueryLogEntry(w, r),
+	}
+	next.ServeDNS(lw, r)
+
+	e := lw.entry
+	e.Duration = time.Since(e.Time)
+	if lw.trace != nil {
+		e.CacheHit = lw.trace.CacheHit()
+	}
+
+	if err := ql.Sink.LogQuery(e); err != nil && ql.OnError != nil {
+		ql.OnError(err)
+	}
+}
+
+func newQueryLogEntry(w dns.ResponseWriter, r *dns.Msg) QueryLogEntry {
+	e := QueryLogEntry{
+		Time:      time.Now(),
+		Transport: queryTransport(w),
+		Rcode:     -1,
+	}
+
+	e.Client, _ = clientAddr(w.RemoteAddr())
+	if len(r.Question) > 0 {
+		e.QName, e.QType = r.Question[0].Name, r.Question[0].Qtype
+	}
+	return e
+}
+
+// queryTransport tells how the request was received.
+func queryTransport(w dns.ResponseWriter) string {
+	if rw, ok := findResponseWriter[*dohResponseWriter](w); ok {
+		if rw.tls == nil {
+			return "http"
+		}
+		return "https"
+	}
+
+	if cs, ok := w.(dns.ConnectionStater); ok && cs.ConnectionState() != nil {
+		return "tls"
+	}
+
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// queryLogWriter is a [dns.ResponseWriter] recording the response
+// for the [QueryLog].
+type queryLogWriter struct {
+	dns.ResponseWriter
+
+	entry QueryLogEntry
+	trace *resolver.QueryTrace
+}
+
+func (rw *queryLogWriter) WriteMsg(msg *dns.Msg) error {
+	if msg != nil {
+		rw.entry.Rcode = msg.Rcode
+		rw.entry.Answers = len(msg.Answer)
+	}
+	return rw.ResponseWriter.WriteMsg(msg)
+}
+
+func (rw *queryLogWriter) Write(b []byte) (int, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(b); err == nil {
+		rw.entry.Rcode = msg.Rcode
+		rw.entry.Answers = len(msg.Answer)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *queryLogWriter) Unwrap() dns.ResponseWriter { return rw.ResponseWriter }
+
+// withQueryLogTrace attaches a [resolver.QueryTrace] to the context
+// if the request is being logged, to know if the answer came from
+// a cache.
+func withQueryLogTrace(ctx context.Context, w dns.ResponseWriter) context.Context {
+	if rw, ok := findResponseWriter[*queryLogWriter](w); ok {
+		ctx, rw.trace = resolver.WithQueryTrace(ctx)
+	}
+	return ctx
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/slog"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+var (
+	_ QueryLogSink = QueryLogChannel(nil)
+	_ QueryLogSink = (*QueryLogLogger)(nil)
+	_ QueryLogSink = (*QueryLogFile)(nil)
+
+	errQueryLogFull = errors.New("query log channel full")
+)
+
+// QueryLogChannel is a [QueryLogSink] sending the entries to a channel.
+// Entries are discarded if the channel is full.
+type QueryLogChannel chan<- QueryLogEntry
+
+// LogQuery sends the entry to the channel, without blocking.
+func (ch QueryLogChannel) LogQuery(e QueryLogEntry) error {
+	select {
+	case ch <- e:
+		return nil
+	default:
+		return errQueryLogFull
+	}
+}
+
+// QueryLogLogger is a [QueryLogSink] writing the entries to
+// a [slog.Logger] at [slog.Info] level.
+type QueryLogLogger struct {
+	Logger slog.Logger
+}
+
+// NewQueryLogLogger creates a [QueryLogLogger] using the given
+// [slog.Logger].
+func NewQueryLogLogger(log slog.Logger) (*QueryLogLogger, error) {
+	if log == nil {
+		return nil, core.ErrInvalid
+	}
+	return &QueryLogLogger{Logger: log}, nil
+}
+
+// LogQuery writes the entry to the [slog.Logger].
+func (ql *QueryLogLogger) LogQuery(e QueryLogEntry) error {
+	l, ok := ql.Logger.Info().WithEnabled()
+	if !ok {
+		return nil
+	}
+
+	fields := slog.Fields{
+		"client":    e.Client.String(),
+		"transport": e.Transport,
+		"qname":     e.QName,
+		"qtype":     dns.TypeToString[e.QType],
+		"answers":   e.Answers,
+		"duration":  e.Duration,
+		"cached":    e.CacheHit,
+	}
+	if e.Rcode >= 0 {
+		fields["rcode"] = dns.RcodeToString[e.Rcode]
+	}
+
+	l.WithFields(fields).Print("query")
+	return nil
+}
+
+// QueryLogFile is a [QueryLogSink] writing the entries to a file,
+// one per line, rotating it when it reaches MaxSize.
+// Rotated files get a numeric suffix, ".1" being the newest,
+// and only MaxBackups of them are kept.
+type QueryLogFile struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	Filename   string
+	MaxSize    int64
+	MaxBackups int
+}
+
+// NewQueryLogFile creates a [QueryLogFile] appending to the given file,
+// rotating it after maxSize bytes. Zero means never.
+func NewQueryLogFile(filename string, maxSize int64, maxBackups int) (*QueryLogFile, error) {
+	if filename == "" || maxSize < 0 || maxBackups < 0 {
+		return nil, core.ErrInvalid
+	}
+
+	ql := &QueryLogFile{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+
+	if err := ql.open(); err != nil {
+		return nil, err
+	}
+	return ql, nil
+}
+
+// LogQuery writes the entry to the file, rotating it first
+// if it would exceed MaxSize.
+func (ql *QueryLogFile) LogQuery(e QueryLogEntry) error {
+	line := e.String() + "\n"
+
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+
+	if ql.f == nil {
+		return os.ErrClosed
+	}
+
+	if ql.MaxSize > 0 && ql.size > 0 && ql.size+int64(len(line)) > ql.MaxSize {
+		if err := ql.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := ql.f.WriteString(line)
+	ql.size += int64(n)
+	return err
+}
+
+// Close closes the file.
+func (ql *QueryLogFile) Close() error {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+
+	if ql.f == nil {
+		return nil
+	}
+
+	err := ql.f.Close()
+	ql.f = nil
+	return err
+}
+
+func (ql *QueryLogFile) open() error {
+	f, err := os.OpenFile(ql.Filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	ql.f, ql.size = f, fi.Size()
+	return nil
+}
+
+// rotate renames the current file, shifting the older ones, and
+// opens a new one.
+func (ql *QueryLogFile) rotate() error {
+	if err := ql.f.Close(); err != nil {
+		return err
+	}
+	ql.f = nil
+
+	if ql.MaxBackups == 0 {
+		if err := os.Remove(ql.Filename); err != nil {
+			return err
+		}
+		return ql.open()
+	}
+
+	for i := ql.MaxBackups - 1; i > 0; i-- {
+		err := os.Rename(ql.backupName(i), ql.backupName(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(ql.Filename, ql.backupName(1)); err != nil {
+		return err
+	}
+	return ql.open()
+}
+
+func (ql *QueryLogFile) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", ql.Filename, i)
+}
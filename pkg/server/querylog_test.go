@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver"
+)
+
+func TestQueryLog(t *testing.T) {
+	next := resolver.ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		rr, err := dns.NewRR(req.Question[0].Name + " 300 IN TXT hello")
+		if err != nil {
+			return nil, err
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = []dns.RR{rr}
+		return resp, nil
+	})
+
+	cached, err := resolver.NewCachedExchanger(next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan QueryLogEntry, 4)
+	ql, err := NewQueryLog(nil, QueryLogChannel(ch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{Lookuper: cached}
+	h.Use(ql.Wrap)
+	h.SetDefaults()
+
+	for i, cacheHit := range []bool{false, true} {
+		serveTestRequest(t, h, "192.0.2.1:5353")
+
+		e := <-ch
+		switch {
+		case e.Client.String() != "192.0.2.1", e.Transport != "http":
+			t.Errorf("%v: unexpected client: %s %s", i, e.Client, e.Transport)
+		case e.QName != "example.org.", e.QType != dns.TypeTXT:
+			t.Errorf("%v: unexpected question: %s", i, e)
+		case e.Rcode != dns.RcodeSuccess, e.Answers != 1:
+			t.Errorf("%v: unexpected response: %s", i, e)
+		case e.CacheHit != cacheHit:
+			t.Errorf("%v: unexpected cache hit: %s", i, e)
+		}
+	}
+
+	ql.SetEnabled(false)
+	serveTestRequest(t, h, "192.0.2.1:5353")
+	if len(ch) != 0 {
+		t.Error("query logged while disabled")
+	}
+}
+
+func TestQueryLogFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "query.log")
+
+	e := QueryLogEntry{
+		Time:      time.Now(),
+		Transport: "udp",
+		QName:     "example.org.",
+		QType:     dns.TypeA,
+	}
+	size := int64(len(e.String()) + 1)
+
+	ql, err := NewQueryLogFile(filename, 2*size, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ql.Close()
+
+	for i := 0; i < 7; i++ {
+		if err := ql.LogQuery(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2+2+2+1, the oldest discarded
+	for name, lines := range map[string]int{
+		filename:        1,
+		filename + ".1": 2,
+		filename + ".2": 2,
+		filename + ".3": 0,
+	} {
+		if n := countTestLines(t, name); n != lines {
+			t.Errorf("%s: %v lines, expected %v", filepath.Base(name), n, lines)
+		}
+	}
+}
+
+func countTestLines(t *testing.T, filename string) int {
+	f, err := os.Open(filename)
+	switch {
+	case os.IsNotExist(err):
+		return 0
+	case err != nil:
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var n int
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver"
+)
+
+var _ dns.Handler = (*Server)(nil)
+
+// lookuperRef allows storing a [resolver.Lookuper] atomically.
+type lookuperRef struct {
+	l resolver.Lookuper
+}
+
+// handlerRef allows storing a [dns.Handler] atomically.
+type handlerRef struct {
+	h dns.Handler
+}
+
+// SetLookuper atomically replaces the default [resolver.Lookuper].
+// Queries in progress finish using the previous one.
+func (h *Handler) SetLookuper(l resolver.Lookuper) {
+	h.SwapLookuper(l)
+}
+
+// SwapLookuper atomically replaces the default [resolver.Lookuper],
+// returning the previous one. Once called, the Lookuper field is
+// no longer used.
+func (h *Handler) SwapLookuper(l resolver.Lookuper) resolver.Lookuper {
+	if old := h.lookuper.Swap(&lookuperRef{l}); old != nil {
+		return old.l
+	}
+	return h.Lookuper
+}
+
+// GetLookuper returns the default [resolver.Lookuper].
+func (h *Handler) GetLookuper() resolver.Lookuper {
+	if p := h.lookuper.Load(); p != nil {
+		return p.l
+	}
+	return h.Lookuper
+}
+
+// ReloadHandler atomically replaces the [dns.Handler] of a running
+// [Server]. Requests in progress finish using the previous one.
+// Once called, the Handler field is no longer used.
+func (srv *Server) ReloadHandler(h dns.Handler) error {
+	if h == nil {
+		return core.ErrInvalid
+	}
+
+	srv.handler.Store(&handlerRef{h})
+	return nil
+}
+
+// GetHandler returns the [dns.Handler] used by the [Server].
+func (srv *Server) GetHandler() dns.Handler {
+	if p := srv.handler.Load(); p != nil {
+		return p.h
+	}
+	return srv.Handler
+}
+
+// ServeDNS passes the request to the current [dns.Handler]
+// of the [Server], unless it's shutting down.
+func (srv *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	srv.inflight.Add(1)
+	defer srv.inflight.Add(-1)
+
+	if srv.draining.Load() {
+		// ignored
+		return
+	}
+
+	srv.GetHandler().ServeDNS(w, r)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func getTestTXT(t *testing.T, resp *dns.Msg) string {
+	if resp == nil || len(resp.Answer) == 0 {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+
+	txt, ok := resp.Answer[0].(*dns.TXT)
+	if !ok {
+		t.Fatalf("unexpected answer: %v", resp.Answer[0])
+	}
+	return txt.Txt[0]
+}
+
+func TestHandlerSwapLookuper(t *testing.T) {
+	first := newTestLookuper("first")
+	h := &Handler{Lookuper: first}
+	h.SetDefaults()
+
+	if s := getTestTXT(t, serveTestRequest(t, h, "192.0.2.1:53")); s != "first" {
+		t.Errorf("unexpected answer %q", s)
+	}
+
+	if old := h.SwapLookuper(newTestLookuper("second")); old == nil {
+		t.Error("previous Lookuper not returned")
+	}
+
+	if s := getTestTXT(t, serveTestRequest(t, h, "192.0.2.1:53")); s != "second" {
+		t.Errorf("unexpected answer %q after swap", s)
+	}
+}
+
+func TestServerReloadHandler(t *testing.T) {
+	srv := &Server{
+		Handler:   &Handler{Lookuper: newTestLookuper("first")},
+		Addresses: []string{"127.0.0.1:0"},
+	}
+	startTestServer(t, srv)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeTXT)
+	addr := srv.Addrs()[0].String()
+
+	resp, _, err := new(dns.Client).Exchange(req, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := getTestTXT(t, resp); s != "first" {
+		t.Errorf("unexpected answer %q", s)
+	}
+
+	if err := srv.ReloadHandler(&Handler{Lookuper: newTestLookuper("second")}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _, err = new(dns.Client).Exchange(req, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := getTestTXT(t, resp); s != "second" {
+		t.Errorf("unexpected answer %q after reload", s)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+// TypeKey identifies the class and type of the queries
+// handled by an entry of [Handler.Types].
+type TypeKey struct {
+	Class uint16
+	Type  uint16
+}
+
+// HandleType registers a handler for the queries of the given
+// class and type.
+func (h *Handler) HandleType(qClass, qType uint16, fn dns.HandlerFunc) {
+	if h.Types == nil {
+		h.Types = make(map[TypeKey]dns.HandlerFunc)
+	}
+	h.Types[TypeKey{qClass, qType}] = fn
+}
+
+// HandleZone registers a handler for the queries of names
+// under the given suffix.
+func (h *Handler) HandleZone(suffix string, fn dns.HandlerFunc) {
+	if h.Zones == nil {
+		h.Zones = make(map[string]dns.HandlerFunc)
+	}
+	h.Zones[dns.CanonicalName(suffix)] = fn
+}
+
+// getRoute returns the handler registered for the name, using
+// the longest suffix, or for the class and type of the query.
+func (h *Handler) getRoute(q dns.Question) dns.HandlerFunc {
+	if len(h.Zones) > 0 {
+		name := dns.CanonicalName(q.Name)
+		for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+			if fn := h.Zones[name[off:]]; fn != nil {
+				return fn
+			}
+		}
+
+		// NextLabel stops before the root
+		if fn := h.Zones["."]; fn != nil {
+			return fn
+		}
+	}
+
+	return h.Types[TypeKey{q.Qclass, q.Qtype}]
+}
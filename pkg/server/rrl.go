@@ -0,0 +1,242 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/cache/x/simplelru"
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// DefaultRRLWindow is the period over which the [RRL] averages
+	// the responses sent to a client network.
+	DefaultRRLWindow = 15 * time.Second
+	// DefaultRRLSlip indicates how many dropped responses are replaced
+	// by a truncated one by default.
+	DefaultRRLSlip = 2
+	// DefaultRRLIPv4PrefixLen is the size of the IPv4 networks
+	// accounted together.
+	DefaultRRLIPv4PrefixLen = 24
+	// DefaultRRLIPv6PrefixLen is the size of the IPv6 networks
+	// accounted together.
+	DefaultRRLIPv6PrefixLen = 56
+	// DefaultRRLTableSize is the number of buckets the [RRL] tracks.
+	DefaultRRLTableSize = 10000
+)
+
+var (
+	_ dns.Handler        = (*RRL)(nil)
+	_ dns.ResponseWriter = (*rrlResponseWriter)(nil)
+)
+
+// RRL is a [dns.Handler] middleware implementing BIND-style Response
+// Rate Limiting. UDP responses exceeding the limits for a client network
+// are dropped, except one in Slip which is sent empty and truncated
+// so legitimate clients retry over TCP.
+type RRL struct {
+	mu    sync.Mutex
+	table *simplelru.LRU[string, *rrlEntry]
+
+	Next dns.Handler
+
+	// ResponsesPerSecond limits identical responses to a client network.
+	ResponsesPerSecond float64
+	// NXDomainsPerSecond limits NXDOMAIN responses for names of the
+	// same zone to a client network.
+	NXDomainsPerSecond float64
+	// ErrorsPerSecond limits any other error response to a client
+	// network.
+	ErrorsPerSecond float64
+
+	// Window is the period over which the rates are averaged, allowing
+	// bursts of that many seconds of responses.
+	Window time.Duration
+	// Slip is how many dropped responses are replaced by a truncated one.
+	// Zero means never, and one means always.
+	Slip int
+
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+	TableSize     int
+}
+
+// NewRRL creates a [RRL] middleware allowing up to the given number
+// of responses per second of each kind to each client network, using
+// the defaults for everything else. next can be nil if it will be used
+// via [RRL.Wrap].
+func NewRRL(next dns.Handler, rate float64) (*RRL, error) {
+	if rate <= 0 {
+		return nil, core.ErrInvalid
+	}
+
+	rrl := &RRL{
+		Next:               next,
+		ResponsesPerSecond: rate,
+		NXDomainsPerSecond: rate,
+		ErrorsPerSecond:    rate,
+		Window:             DefaultRRLWindow,
+		Slip:               DefaultRRLSlip,
+		IPv4PrefixLen:      DefaultRRLIPv4PrefixLen,
+		IPv6PrefixLen:      DefaultRRLIPv6PrefixLen,
+		TableSize:          DefaultRRLTableSize,
+	}
+	return rrl, nil
+}
+
+// ServeDNS passes the request to the next [dns.Handler], limiting
+// the responses sent over UDP.
+func (rrl *RRL) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	rrl.serve(rrl.Next, w, r)
+}
+
+func (rrl *RRL) serve(next dns.Handler, w dns.ResponseWriter, r *dns.Msg) {
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		if addr, ok := clientAddr(w.RemoteAddr()); ok {
+			w = &rrlResponseWriter{
+				ResponseWriter: w,
+				rrl:            rrl,
+				addr:           addr,
+			}
+		}
+	}
+
+	next.ServeDNS(w, r)
+}
+
+type rrlAction int
+
+const (
+	rrlSend rrlAction = iota
+	rrlDrop
+	rrlSlip
+)
+
+// check decides what to do with a response to the given client.
+func (rrl *RRL) check(addr netip.Addr, resp *dns.Msg, now time.Time) rrlAction {
+	rate, key := rrl.classify(resp)
+	if rate <= 0 {
+		// unlimited
+		return rrlSend
+	}
+
+	bits := core.IIf(addr.Is4(), rrl.IPv4PrefixLen, rrl.IPv6PrefixLen)
+	prefix := netip.PrefixFrom(addr, bits).Masked()
+	key = prefix.String() + " " + key
+
+	burst := max(1, rate*rrl.Window.Seconds())
+
+	rrl.mu.Lock()
+	defer rrl.mu.Unlock()
+
+	if rrl.table == nil {
+		rrl.table = simplelru.NewLRU[string, *rrlEntry](rrl.TableSize, nil, nil)
+	}
+
+	e, _, ok := rrl.table.Get(key)
+	if !ok {
+		e = &rrlEntry{credit: burst, last: now}
+	}
+	rrl.table.Add(key, e, 1, now.Add(rrl.Window))
+
+	return e.Take(now, rate, burst, rrl.Slip)
+}
+
+// classify returns the rate limit and the accounting key
+// of a response.
+func (rrl *RRL) classify(resp *dns.Msg) (float64, string) {
+	var qName, qType string
+	if len(resp.Question) > 0 {
+		q := resp.Question[0]
+		qName, qType = dns.CanonicalName(q.Name), strconv.Itoa(int(q.Qtype))
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		return rrl.ResponsesPerSecond, "R " + qName + " " + qType
+	case dns.RcodeNameError:
+		if soa, ok := exdns.GetFirstRR[*dns.SOA](resp.Ns); ok {
+			// by zone
+			qName = dns.CanonicalName(soa.Hdr.Name)
+		}
+		return rrl.NXDomainsPerSecond, "N " + qName
+	default:
+		return rrl.ErrorsPerSecond, "E"
+	}
+}
+
+// rrlEntry accounts the responses sent to a client network.
+type rrlEntry struct {
+	credit float64
+	last   time.Time
+	drops  int
+}
+
+// Take consumes a credit, if available, or decides if the
+// response is dropped or truncated.
+func (e *rrlEntry) Take(now time.Time, rate, burst float64, slip int) rrlAction {
+	if elapsed := now.Sub(e.last); elapsed > 0 {
+		e.credit = min(burst, e.credit+elapsed.Seconds()*rate)
+		e.last = now
+	}
+
+	switch {
+	case e.credit >= 1:
+		e.credit--
+		return rrlSend
+	case slip <= 0:
+		return rrlDrop
+	default:
+		e.drops++
+		return core.IIf(e.drops%slip == 0, rrlSlip, rrlDrop)
+	}
+}
+
+// rrlResponseWriter is a [dns.ResponseWriter] applying
+// the [RRL] to the responses.
+type rrlResponseWriter struct {
+	dns.ResponseWriter
+
+	rrl  *RRL
+	addr netip.Addr
+}
+
+func (rw *rrlResponseWriter) WriteMsg(msg *dns.Msg) error {
+	if msg == nil {
+		return core.ErrInvalid
+	}
+
+	switch rw.rrl.check(rw.addr, msg, time.Now()) {
+	case rrlDrop:
+		return nil
+	case rrlSlip:
+		tc := new(dns.Msg)
+		tc.MsgHdr = msg.MsgHdr
+		tc.Question = msg.Question
+		tc.Truncated = true
+		return rw.ResponseWriter.WriteMsg(tc)
+	default:
+		return rw.ResponseWriter.WriteMsg(msg)
+	}
+}
+
+func (rw *rrlResponseWriter) Unwrap() dns.ResponseWriter { return rw.ResponseWriter }
+
+func (rw *rrlResponseWriter) Write(b []byte) (int, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(b); err != nil {
+		return 0, err
+	}
+
+	if err := rw.WriteMsg(msg); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
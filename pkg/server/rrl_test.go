@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRRL(t *testing.T) {
+	rrl, err := NewRRL(newTestHandler(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrl.Window = time.Second
+
+	serve := func(remote net.Addr) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+
+		rw := &dohResponseWriter{remote: remote}
+		rrl.ServeDNS(rw, req)
+		return rw.msg
+	}
+
+	udp := func(s string) net.Addr {
+		return net.UDPAddrFromAddrPort(netip.MustParseAddrPort(s))
+	}
+
+	// same /24
+	var sent, truncated, dropped int
+	for _, s := range []string{
+		"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53", "192.0.2.4:53", "192.0.2.5:53",
+	} {
+		switch resp := serve(udp(s)); {
+		case resp == nil:
+			dropped++
+		case resp.Truncated && len(resp.Answer) == 0:
+			truncated++
+		default:
+			sent++
+		}
+	}
+
+	if sent != 1 || truncated != 2 || dropped != 2 {
+		t.Errorf("sent:%v truncated:%v dropped:%v", sent, truncated, dropped)
+	}
+
+	// other network
+	if resp := serve(udp("198.51.100.1:53")); resp == nil || resp.Truncated {
+		t.Errorf("unexpected response to another network: %v", resp)
+	}
+
+	// TCP isn't limited
+	tcp := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:53"))
+	if resp := serve(tcp); resp == nil || resp.Truncated {
+		t.Errorf("unexpected response over TCP: %v", resp)
+	}
+}
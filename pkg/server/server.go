@@ -1,2 +1,398 @@
 // Package server aids writing DNS servers
 package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/netutil"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/metrics"
+)
+
+const (
+	// DefaultPort is the port used by plain DNS listeners
+	// when the address doesn't specify one.
+	DefaultPort = "53"
+	// DefaultTLSPort is the port used by DNS-over-TLS listeners
+	// when the address doesn't specify one.
+	DefaultTLSPort = "853"
+)
+
+// Server listens on multiple addresses and transports, passing the
+// requests to a [dns.Handler].
+type Server struct {
+	mu      sync.Mutex
+	wg      core.WaitGroup
+	servers []*listener
+	running bool
+	handler atomic.Pointer[handlerRef]
+
+	inflight atomic.Int64
+	draining atomic.Bool
+
+	Handler dns.Handler
+
+	// Addresses are where to listen for plain DNS requests, over
+	// both UDP and TCP. [DefaultPort] is used if not specified.
+	Addresses []string
+	// TLSAddresses are where to listen for DNS-over-TLS requests.
+	// [DefaultTLSPort] is used if not specified.
+	TLSAddresses []string
+	// DoHAddresses are where to listen for DNS-over-HTTPS requests,
+	// or plain HTTP if there is no TLSConfig.
+	// [DefaultDoHPort] is used if not specified.
+	DoHAddresses []string
+
+	// TLSConfig is used by the DNS-over-TLS listeners, advertising
+	// the "dot" protocol via ALPN, and by the DNS-over-HTTPS ones.
+	TLSConfig *tls.Config
+	// HTTPServer is used by the DNS-over-HTTPS listeners. If not
+	// provided one will be created. If it has no Handler, one serving
+	// [DoHPath] will be set.
+	HTTPServer *http.Server
+	// JSON enables the JSON API on the DNS-over-HTTPS listeners.
+	JSON bool
+	// CORSOrigins are the origins allowed to use the JSON API
+	// from browsers. "*" allows any.
+	CORSOrigins []string
+	// TsigProvider verifies the TSIG of signed requests and signs the
+	// responses. If not set, signed requests are rejected.
+	TsigProvider dns.TsigProvider
+	// HandshakeTimeout is how long DNS-over-TLS clients have to complete
+	// the handshake. [DefaultHandshakeTimeout] is used if not specified.
+	HandshakeTimeout time.Duration
+	// ProxyProtocol lists the networks of the load balancers sending
+	// PROXY protocol headers, v1 or v2, on the TCP and DNS-over-TLS
+	// listeners. Connections from them must start with one, and
+	// the address of the client it carries is used as RemoteAddr.
+	ProxyProtocol []netip.Prefix
+
+	// TCPIdleTimeout is how long TCP and DNS-over-TLS connections are
+	// kept open waiting for the next query. If not specified, the
+	// [dns.Server] default of 8s is used.
+	TCPIdleTimeout time.Duration
+	// TCPMaxQueries limits the queries served on each TCP and
+	// DNS-over-TLS connection before closing it. If not specified,
+	// the [dns.Server] default of 128 is used. -1 means no limit.
+	TCPMaxQueries int
+	// TCPMaxConnections limits the connections each TCP and
+	// DNS-over-TLS listener serves at the same time. Zero means
+	// no limit.
+	TCPMaxConnections int
+	// UDPSize is the size of the buffer used to read UDP requests.
+	// If not specified, the [dns.Server] default of 512 is used.
+	UDPSize int
+	// UDPReadBuffer is the size of the operating system's receive
+	// buffer of the UDP sockets. If not specified, the system
+	// default is used.
+	UDPReadBuffer int
+	// UDPReaders is the number of goroutines reading requests from
+	// each UDP socket. If not specified, one is used.
+	UDPReaders int
+
+	// Metrics, if set, receives the number of requests in progress
+	// and of open TCP and DNS-over-TLS connections.
+	Metrics *metrics.Registry
+	metrics *serverMetrics
+}
+
+// listener serves one socket.
+type listener struct {
+	addr     net.Addr
+	serve    func() error
+	shutdown func(context.Context) error
+	close    func() error
+	started  chan struct{}
+	done     chan struct{}
+	closing  atomic.Bool
+}
+
+// newListener creates a [listener] using a [dns.Server]
+// for the given socket.
+func (srv *Server) newListener(ln net.Listener, pc net.PacketConn) *listener {
+	s := &dns.Server{
+		Listener:      ln,
+		PacketConn:    pc,
+		Handler:       dns.HandlerFunc(srv.serveTSIG),
+		TsigProvider:  srv.getTsigProvider(),
+		UDPSize:       srv.UDPSize,
+		MaxTCPQueries: srv.TCPMaxQueries,
+	}
+
+	if d := srv.TCPIdleTimeout; d > 0 {
+		s.IdleTimeout = func() time.Duration { return d }
+	}
+
+	l := &listener{
+		shutdown: s.ShutdownContext,
+		started:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	l.serve = func() error {
+		err := s.ActivateAndServe()
+		if l.closing.Load() && errors.Is(err, net.ErrClosed) {
+			// shared socket closed by another listener
+			return nil
+		}
+		return err
+	}
+	s.NotifyStartedFunc = func() { close(l.started) }
+
+	if pc != nil {
+		l.addr, l.close = pc.LocalAddr(), pc.Close
+	} else {
+		l.addr, l.close = ln.Addr(), ln.Close
+	}
+	return l
+}
+
+// Listen opens all the configured listeners. On failure
+// those already opened are closed.
+func (srv *Server) Listen() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch {
+	case srv.GetHandler() == nil:
+		return core.Wrap(core.ErrInvalid, "handler not specified")
+	case srv.servers != nil:
+		return core.ErrExists
+	}
+
+	srv.initMetrics()
+	servers, err := srv.listen()
+	if err != nil {
+		closeListeners(servers)
+		return err
+	}
+
+	srv.servers = servers
+	return nil
+}
+
+func (srv *Server) listen() ([]*listener, error) {
+	var out []*listener
+
+	for _, addr := range srv.Addresses {
+		s, err := srv.listenPlain(addr)
+		out = append(out, s...)
+		if err != nil {
+			return out, err
+		}
+	}
+
+	for _, addr := range srv.TLSAddresses {
+		s, err := srv.listenTLS(addr)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, s)
+	}
+
+	for _, addr := range srv.DoHAddresses {
+		s, err := srv.listenDoH(addr)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, s)
+	}
+
+	if len(out) == 0 {
+		return nil, core.Wrap(core.ErrInvalid, "no addresses to listen")
+	}
+	return out, nil
+}
+
+func (srv *Server) listenPlain(addr string) ([]*listener, error) {
+	addr, err := withDefaultPort(addr, DefaultPort)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// UDP readers share the socket
+	out := []*listener{srv.newListener(nil, pc)}
+	for i := 1; i < srv.UDPReaders; i++ {
+		out = append(out, srv.newListener(nil, pc))
+	}
+
+	if n := srv.UDPReadBuffer; n > 0 {
+		if uc, ok := pc.(*net.UDPConn); ok {
+			if err := uc.SetReadBuffer(n); err != nil {
+				return out, err
+			}
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return out, err
+	}
+	return append(out, srv.newListener(srv.wrapTCP(ln, "tcp"), nil)), nil
+}
+
+// wrapTCP applies the metrics, the TCPMaxConnections limit and
+// the PROXY protocol to a TCP [net.Listener].
+func (srv *Server) wrapTCP(ln net.Listener, transport string) net.Listener {
+	ln = srv.wrapMetrics(ln, transport)
+	if n := srv.TCPMaxConnections; n > 0 {
+		ln = netutil.LimitListener(ln, n)
+	}
+	return srv.wrapProxy(ln)
+}
+
+// Addrs returns the addresses the [Server] is listening on.
+func (srv *Server) Addrs() []net.Addr {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	out := make([]net.Addr, 0, len(srv.servers))
+	for i, l := range srv.servers {
+		if i > 0 && l.addr == srv.servers[i-1].addr {
+			// UDP readers
+			continue
+		}
+		out = append(out, l.addr)
+	}
+	return out
+}
+
+// Serve handles requests on all the listeners, opening them if
+// [Server.Listen] wasn't called, until the context is cancelled
+// or one of them fails.
+func (srv *Server) Serve(ctx context.Context) error {
+	if ctx == nil {
+		return core.ErrInvalid
+	}
+
+	srv.mu.Lock()
+	servers, running := srv.servers, srv.running
+	srv.running = servers != nil
+	srv.mu.Unlock()
+
+	switch {
+	case running:
+		return core.ErrExists
+	case servers == nil:
+		if err := srv.Listen(); err != nil {
+			return err
+		}
+		return srv.Serve(ctx)
+	}
+
+	errCh := make(chan error, len(servers))
+	for _, l := range servers {
+		l := l
+		srv.wg.Go(func() error {
+			defer close(l.done)
+
+			err := l.serve()
+			errCh <- err
+			return err
+		})
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-errCh:
+		// listener failed
+	}
+
+	_ = srv.Shutdown(context.Background())
+	return srv.wg.Wait()
+}
+
+// Shutdown stops all the listeners at once, waiting until the given
+// context expires for the requests in progress to finish.
+// New requests are ignored from the start.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	_, err := srv.shutdown(ctx)
+	return err
+}
+
+func (srv *Server) shutdown(ctx context.Context) (int, error) {
+	srv.mu.Lock()
+	servers, running := srv.servers, srv.running
+	srv.mu.Unlock()
+
+	switch {
+	case !running:
+		closeListeners(servers)
+		return 0, nil
+	case !srv.draining.CompareAndSwap(false, true):
+		// already shutting down
+		return srv.waitInflight(ctx)
+	}
+
+	for _, l := range servers {
+		l.closing.Store(true)
+	}
+
+	errCh := make(chan error, len(servers))
+	for _, l := range servers {
+		go func(l *listener) {
+			select {
+			case <-l.started:
+				errCh <- l.shutdown(ctx)
+			case <-l.done:
+				// failed to start
+				errCh <- nil
+			}
+		}(l)
+	}
+
+	var errs core.CompoundError
+	for range servers {
+		err := <-errCh
+		if err != nil && !errors.Is(err, ctx.Err()) {
+			errs.AppendError(err)
+		}
+	}
+
+	n, err := srv.waitInflight(ctx)
+	if err != nil {
+		errs.AppendError(err)
+	}
+	return n, errs.AsError()
+}
+
+func closeListeners(servers []*listener) {
+	for _, l := range servers {
+		_ = l.close()
+	}
+}
+
+// withDefaultPort adds a port to the address if it doesn't have one.
+func withDefaultPort(addr, port string) (string, error) {
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		// portless IP address
+		return net.JoinHostPort(ip.String(), port), nil
+	}
+
+	if addr == "" || strings.HasPrefix(addr, ":") {
+		// any address
+		return core.Coalesce(addr, ":"+port), nil
+	}
+
+	host, p, err := core.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, core.Coalesce(p, port)), nil
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func newTestHandler() dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := newResponse(r)
+		m.Answer = []dns.RR{
+			&dns.A{
+				Hdr: dns.RR_Header{
+					Name:   r.Question[0].Name,
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+					Ttl:    60,
+				},
+				A: net.IPv4(192, 0, 2, 1),
+			},
+		}
+		_ = w.WriteMsg(m)
+	})
+}
+
+func startTestServer(t *testing.T, srv *Server) {
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestServerDoT(t *testing.T) {
+	srv := &Server{
+		Handler:      newTestHandler(),
+		Addresses:    []string{"127.0.0.1:0"},
+		TLSAddresses: []string{"127.0.0.1:0"},
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{newTestCertificate(t)},
+		},
+		HandshakeTimeout: 100 * time.Millisecond,
+	}
+	startTestServer(t, srv)
+
+	// UDP, TCP, TLS
+	addrs := srv.Addrs()
+	if len(addrs) != 3 {
+		t.Fatalf("unexpected listeners: %v", addrs)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+
+	for i, proto := range []string{"udp", "tcp"} {
+		c := &dns.Client{Net: proto}
+		if _, _, err := c.Exchange(req, addrs[i].String()); err != nil {
+			t.Errorf("%s: %v", proto, err)
+		}
+	}
+
+	conn, err := dns.DialWithTLS("tcp", addrs[2].String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{ALPNDoT},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMsg(req); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := conn.ReadMsg(); err != nil || len(resp.Answer) != 1 {
+		t.Errorf("unexpected DoT response: %v %v", resp, err)
+	}
+
+	state := conn.Conn.(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != ALPNDoT {
+		t.Errorf("unexpected ALPN protocol %q", state.NegotiatedProtocol)
+	}
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	srv := &Server{
+		Handler:      newTestHandler(),
+		TLSAddresses: []string{"127.0.0.1:0"},
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{newTestCertificate(t)},
+		},
+		HandshakeTimeout: 50 * time.Millisecond,
+	}
+	startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// never handshake
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("unexpected data")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("connection not closed after the handshake timeout")
+	}
+}
+
+func TestServerTuning(t *testing.T) {
+	srv := &Server{
+		Handler:           newTestHandler(),
+		Addresses:         []string{"127.0.0.1:0"},
+		TCPIdleTimeout:    time.Second,
+		TCPMaxQueries:     1,
+		TCPMaxConnections: 1,
+		UDPReadBuffer:     1 << 16,
+		UDPReaders:        4,
+	}
+	startTestServer(t, srv)
+
+	// UDP, TCP
+	addrs := srv.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf("unexpected listeners: %v", addrs)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+
+	c := new(dns.Client)
+	for i := 0; i < 8; i++ {
+		if _, _, err := c.Exchange(req, addrs[0].String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, err := dns.Dial("tcp", addrs[1].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 2; i++ {
+		if err := conn.WriteMsg(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// closed after the first query
+	if _, err := conn.ReadMsg(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ReadMsg(); err == nil {
+		t.Error("unexpected second response")
+	}
+}
+
+func TestServerShutdownWithTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	srv := &Server{
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			started <- struct{}{}
+			<-release
+			_ = w.WriteMsg(newResponse(r))
+		}),
+		Addresses: []string{"127.0.0.1:0"},
+	}
+	startTestServer(t, srv)
+	defer close(release)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+
+	go func() {
+		c := &dns.Client{Timeout: time.Second}
+		_, _, _ = c.Exchange(req, srv.Addrs()[0].String())
+	}()
+	<-started
+
+	if n := srv.Inflight(); n != 1 {
+		t.Errorf("unexpected inflight %v", n)
+	}
+
+	n, err := srv.ShutdownWithTimeout(50 * time.Millisecond)
+	switch {
+	case err == nil:
+		t.Error("abandoned requests not reported")
+	case n != 1:
+		t.Errorf("unexpected abandoned count %v", n)
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	for _, tc := range []struct {
+		addr, expected string
+	}{
+		{"", ":53"},
+		{":5353", ":5353"},
+		{"127.0.0.1", "127.0.0.1:53"},
+		{"127.0.0.1:5353", "127.0.0.1:5353"},
+		{"::1", "[::1]:53"},
+		{"[::1]:5353", "[::1]:5353"},
+		{"localhost", "localhost:53"},
+	} {
+		s, err := withDefaultPort(tc.addr, DefaultPort)
+		if err != nil || s != tc.expected {
+			t.Errorf("%q: %q %v, expected %q", tc.addr, s, err, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"darvaza.org/core"
+)
+
+const (
+	// DefaultHandshakeTimeout is the maximum time a DNS-over-TLS client
+	// can take to complete the handshake unless [Server.HandshakeTimeout]
+	// is specified.
+	DefaultHandshakeTimeout = 5 * time.Second
+
+	// ALPNDoT is the ALPN protocol ID of DNS-over-TLS, RFC 7858.
+	ALPNDoT = "dot"
+)
+
+func (srv *Server) listenTLS(addr string) (*listener, error) {
+	if srv.TLSConfig == nil {
+		return nil, core.Wrap(core.ErrInvalid, "TLSConfig not specified")
+	}
+
+	addr, err := withDefaultPort(addr, DefaultTLSPort)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tl := &tlsListener{
+		Listener: srv.wrapTCP(ln, "tls"),
+		config:   newDoTConfig(srv.TLSConfig),
+		timeout:  core.Coalesce(srv.HandshakeTimeout, DefaultHandshakeTimeout),
+	}
+	return srv.newListener(tl, nil), nil
+}
+
+// newDoTConfig returns a copy of the [tls.Config] advertising
+// the DNS-over-TLS protocol.
+func newDoTConfig(base *tls.Config) *tls.Config {
+	cfg := base.Clone()
+	if !core.SliceContains(cfg.NextProtos, ALPNDoT) {
+		cfg.NextProtos = append([]string{ALPNDoT}, cfg.NextProtos...)
+	}
+	return cfg
+}
+
+// tlsListener is a [net.Listener] wrapping accepted connections in
+// TLS, limiting how long the handshake can take.
+type tlsListener struct {
+	net.Listener
+
+	config  *tls.Config
+	timeout time.Duration
+}
+
+func (tl *tlsListener) Accept() (net.Conn, error) {
+	conn, err := tl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &tlsConn{
+		Conn:    tls.Server(conn, tl.config),
+		timeout: tl.timeout,
+	}
+	return tc, nil
+}
+
+// tlsConn is a [tls.Conn] that completes the handshake, within
+// a deadline, before the first read or write.
+type tlsConn struct {
+	*tls.Conn
+
+	once    sync.Once
+	err     error
+	timeout time.Duration
+}
+
+func (tc *tlsConn) handshake() error {
+	tc.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+		defer cancel()
+
+		tc.err = tc.Conn.HandshakeContext(ctx)
+	})
+	return tc.err
+}
+
+func (tc *tlsConn) Read(b []byte) (int, error) {
+	if err := tc.handshake(); err != nil {
+		return 0, err
+	}
+	return tc.Conn.Read(b)
+}
+
+func (tc *tlsConn) Write(b []byte) (int, error) {
+	if err := tc.handshake(); err != nil {
+		return 0, err
+	}
+	return tc.Conn.Write(b)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/reflect"
+)
+
+var (
+	_ dns.Handler        = (*Tracing)(nil)
+	_ dns.ResponseWriter = (*tracingWriter)(nil)
+)
+
+// Tracing is a [dns.Handler] middleware creating a "dns.server" span for
+// every request using a [reflect.Tracer]. The span, and the tracer, are
+// passed to the [resolver.Lookuper] of the [Handler] through the context,
+// so [reflect.Lookuper] and [reflect.Client] layers create child spans.
+type Tracing struct {
+	Next   dns.Handler
+	Tracer reflect.Tracer
+}
+
+// NewTracing creates a [Tracing] middleware using the given [reflect.Tracer].
+// next can be nil if it will be used via [Tracing.Wrap].
+func NewTracing(next dns.Handler, t reflect.Tracer) (*Tracing, error) {
+	if t == nil {
+		return nil, core.ErrInvalid
+	}
+
+	return &Tracing{Next: next, Tracer: t}, nil
+}
+
+// ServeDNS passes the request to the next [dns.Handler] within a span.
+func (t *Tracing) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	t.serve(t.Next, w, r)
+}
+
+func (t *Tracing) serve(next dns.Handler, w dns.ResponseWriter, r *dns.Msg) {
+	attrs := append(reflect.RequestAttributes(r),
+		reflect.Attribute{Key: "network.transport", Value: queryTransport(w)})
+	if addr, ok := clientAddr(w.RemoteAddr()); ok {
+		attrs = append(attrs, reflect.Attribute{Key: "client.address", Value: addr.String()})
+	}
+
+	ctx := reflect.WithTracer(context.Background(), t.Tracer)
+	ctx, span := reflect.StartSpan(ctx, "dns.server", attrs...)
+	defer span.End()
+
+	tw := &tracingWriter{
+		ResponseWriter: w,
+		ctx:            ctx,
+		span:           span,
+	}
+	next.ServeDNS(tw, r)
+
+	if !tw.written {
+		span.SetAttributes(reflect.Attribute{Key: "dns.response.dropped", Value: true})
+	}
+}
+
+// tracingWriter is a [dns.ResponseWriter] carrying the span
+// of a [Tracing] request.
+type tracingWriter struct {
+	dns.ResponseWriter
+
+	ctx     context.Context
+	span    reflect.Span
+	written bool
+}
+
+func (rw *tracingWriter) WriteMsg(msg *dns.Msg) error {
+	if msg != nil {
+		rw.setResponse(msg)
+	}
+	return rw.ResponseWriter.WriteMsg(msg)
+}
+
+func (rw *tracingWriter) Write(b []byte) (int, error) {
+	var msg dns.Msg
+	if err := msg.Unpack(b); err == nil {
+		rw.setResponse(&msg)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *tracingWriter) Unwrap() dns.ResponseWriter { return rw.ResponseWriter }
+
+func (rw *tracingWriter) setResponse(msg *dns.Msg) {
+	rw.written = true
+	rw.span.SetAttributes(
+		reflect.Attribute{Key: "dns.response.code", Value: dns.RcodeToString[msg.Rcode]},
+		reflect.Attribute{Key: "dns.response.answers", Value: len(msg.Answer)},
+	)
+}
+
+// withTracing makes the context of a lookup carry the values of the
+// [Tracing] span of the request, if any.
+func withTracing(ctx context.Context, w dns.ResponseWriter) context.Context {
+	if rw, ok := findResponseWriter[*tracingWriter](w); ok {
+		return &tracingContext{Context: ctx, values: rw.ctx}
+	}
+	return ctx
+}
+
+// tracingContext is a [context.Context] falling back to the values of
+// the span's context, while keeping its own deadline and cancellation.
+type tracingContext struct {
+	context.Context
+
+	values context.Context
+}
+
+func (ctx *tracingContext) Value(key any) any {
+	if v := ctx.Context.Value(key); v != nil {
+		return v
+	}
+	return ctx.values.Value(key)
+}
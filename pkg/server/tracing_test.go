@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/slog/handlers/discard"
+
+	"darvaza.org/resolver"
+	"darvaza.org/resolver/pkg/reflect"
+)
+
+type testSpanKey struct{}
+
+type testSpan struct {
+	name   string
+	parent string
+	attrs  map[string]any
+	ended  bool
+}
+
+func (s *testSpan) SetAttributes(attrs ...reflect.Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) RecordError(err error) { s.attrs["error"] = err }
+func (s *testSpan) End()                  { s.ended = true }
+func (*testSpan) TraceID() string         { return "0123456789abcdef" }
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string,
+	attrs ...reflect.Attribute) (context.Context, reflect.Span) {
+	//
+	s := &testSpan{name: name, attrs: make(map[string]any)}
+	if parent, ok := ctx.Value(testSpanKey{}).(*testSpan); ok {
+		s.parent = parent.name
+	}
+	s.SetAttributes(attrs...)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+func TestTracing(t *testing.T) {
+	var ids []string
+
+	tracer := new(testTracer)
+	l, err := reflect.NewWithLookuper("upstream", discard.New(),
+		newTestLookuper("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{
+		Lookuper: reflectIDLookuper(l, &ids),
+	}
+	h.Use(func(next dns.Handler) dns.Handler {
+		tr, _ := NewTracing(next, tracer)
+		return tr
+	})
+	h.SetDefaults()
+
+	serveTestRequest(t, h, "192.0.2.1:5353")
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(tracer.spans))
+	}
+
+	srv, layer := tracer.spans[0], tracer.spans[1]
+	switch {
+	case srv.name != "dns.server" || !srv.ended:
+		t.Errorf("unexpected server span %+v", srv)
+	case srv.attrs["dns.response.code"] != "NOERROR":
+		t.Errorf("unexpected server span attributes %v", srv.attrs)
+	case srv.attrs["client.address"] != "192.0.2.1":
+		t.Errorf("unexpected server span attributes %v", srv.attrs)
+	case layer.name != "resolver.exchange" || layer.parent != "dns.server" || !layer.ended:
+		t.Errorf("unexpected layer span %+v", layer)
+	case layer.attrs["reflect.name"] != "upstream":
+		t.Errorf("unexpected layer span attributes %v", layer.attrs)
+	}
+
+	if len(ids) != 1 || ids[0] != "0123456789abcdef" {
+		t.Errorf("trace ID not propagated: %v", ids)
+	}
+}
+
+// reflectIDLookuper records the reflect ID seen by the next layer.
+func reflectIDLookuper(next resolver.Lookuper, ids *[]string) resolver.Lookuper {
+	return resolver.LookuperFunc(func(ctx context.Context,
+		qName string, qType uint16) (*dns.Msg, error) {
+		//
+		id, _ := reflect.GetID(ctx)
+		*ids = append(*ids, id)
+		return next.Lookup(ctx, qName, qType)
+	})
+}
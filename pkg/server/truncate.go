@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+// truncateResponse makes a response fit in the given size. Records
+// not needed to answer are removed first, as minimal responses do,
+// and only if the answer doesn't fit it's truncated with TC set so
+// the client retries over TCP.
+func truncateResponse(msg *dns.Msg, size int) {
+	msg.Compress = true
+	if msg.Len() <= size {
+		return
+	}
+
+	// additional section
+	opt := msg.IsEdns0()
+	msg.Extra = nil
+	if opt != nil {
+		msg.Extra = []dns.RR{opt}
+	}
+	if msg.Len() <= size {
+		return
+	}
+
+	// authority section
+	if len(msg.Answer) > 0 {
+		msg.Ns = nil
+		if msg.Len() <= size {
+			return
+		}
+	}
+
+	msg.Truncate(size)
+	msg.Truncated = true
+}
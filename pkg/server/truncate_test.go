@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newTestTruncateResponse(answers int) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.org.", dns.TypeA)
+	msg.Response = true
+
+	for i := 0; i < answers; i++ {
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+			A:   net.IPv4(192, 0, 2, byte(i)),
+		})
+	}
+
+	for i := 0; i < 20; i++ {
+		ns := fmt.Sprintf("ns%v.example.net.", i)
+		msg.Ns = append(msg.Ns, &dns.NS{
+			Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeNS, Class: dns.ClassINET},
+			Ns:  ns,
+		})
+		msg.Extra = append(msg.Extra, &dns.A{
+			Hdr: dns.RR_Header{Name: ns, Rrtype: dns.TypeA, Class: dns.ClassINET},
+			A:   net.IPv4(198, 51, 100, byte(i)),
+		})
+	}
+
+	msg.SetEdns0(1232, false)
+	return msg
+}
+
+func TestTruncateResponse(t *testing.T) {
+	for _, tc := range []struct {
+		size      int
+		answers   int
+		ns, extra int
+		truncated bool
+	}{
+		{4096, 20, 20, 21, false},
+		{1000, 20, 20, 1, false},
+		{600, 20, 0, 1, false},
+		{512, 40, 0, 1, true},
+	} {
+		msg := newTestTruncateResponse(tc.answers)
+		truncateResponse(msg, tc.size)
+
+		answers := tc.answers
+		if tc.truncated {
+			// some, but not all
+			answers = min(len(msg.Answer), tc.answers-1)
+		}
+
+		switch {
+		case msg.Len() > tc.size,
+			len(msg.Answer) != answers, len(msg.Ns) != tc.ns,
+			len(msg.Extra) != tc.extra, msg.Truncated != tc.truncated,
+			msg.IsEdns0() == nil:
+			t.Errorf("%v: %v bytes, %v/%v/%v records, truncated %v",
+				tc.size, msg.Len(), len(msg.Answer), len(msg.Ns), len(msg.Extra),
+				msg.Truncated)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+var (
+	_ dns.ResponseWriter = (*tsigResponseWriter)(nil)
+	_ dns.TsigProvider   = noTsigProvider{}
+
+	_ tsigVerifier = (*tsigVerifiedWriter)(nil)
+	_ tsigVerifier = (*dohResponseWriter)(nil)
+)
+
+// TSIGACL describes what requests signed with a TSIG key can do.
+type TSIGACL struct {
+	// Zones limits the names the key can be used for.
+	// Empty means any.
+	Zones []string
+	// Opcodes limits the operations the key can be used for.
+	// Empty means any.
+	Opcodes []int
+}
+
+// Allows tells if the [TSIGACL] allows the request.
+func (acl *TSIGACL) Allows(r *dns.Msg) bool {
+	if len(acl.Opcodes) > 0 && !core.SliceContains(acl.Opcodes, r.Opcode) {
+		return false
+	}
+
+	if len(acl.Zones) > 0 {
+		for _, q := range r.Question {
+			if !acl.allowsName(q.Name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (acl *TSIGACL) allowsName(name string) bool {
+	for _, zone := range acl.Zones {
+		if dns.IsSubDomain(zone, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTSIG verifies the TSIG of a request, if any, and returns the
+// [dns.ResponseWriter] signing the response, or false if rejected.
+// Signed requests are only trusted when passed by a [Server] or a
+// [DoHHandler], as a plain [dns.Server] without TsigProvider doesn't
+// verify them.
+func (h *Handler) checkTSIG(w dns.ResponseWriter, r *dns.Msg) (dns.ResponseWriter, bool, error) {
+	t := r.IsTsig()
+	if t == nil {
+		return w, true, nil
+	}
+
+	if _, ok := findResponseWriter[tsigVerifier](w); !ok || w.TsigStatus() != nil {
+		// not verified, bad signature or unknown key, answer unsigned
+		return nil, false, handleRcodeError(w, r, dns.RcodeNotAuth)
+	}
+
+	rw := &tsigResponseWriter{
+		ResponseWriter: w,
+		tsig:           t,
+	}
+
+	acl, ok := h.TSIG[dns.CanonicalName(t.Hdr.Name)]
+	if !ok || !acl.Allows(r) {
+		return nil, false, handleRcodeError(rw, r, dns.RcodeRefused)
+	}
+	return rw, true, nil
+}
+
+// tsigResponseWriter is a [dns.ResponseWriter] adding a TSIG record
+// to the responses, to be signed by the server.
+type tsigResponseWriter struct {
+	dns.ResponseWriter
+
+	tsig *dns.TSIG
+}
+
+func (rw *tsigResponseWriter) WriteMsg(msg *dns.Msg) error {
+	if msg == nil {
+		return core.ErrInvalid
+	}
+
+	if msg.IsTsig() == nil {
+		msg.SetTsig(rw.tsig.Hdr.Name, rw.tsig.Algorithm, rw.tsig.Fudge, time.Now().Unix())
+	}
+	return rw.ResponseWriter.WriteMsg(msg)
+}
+
+func (rw *tsigResponseWriter) Unwrap() dns.ResponseWriter { return rw.ResponseWriter }
+
+// tsigVerifier is implemented by the [dns.ResponseWriter]s of the
+// transports verifying the TSIG of the requests, so their
+// TsigStatus can be trusted.
+type tsigVerifier interface {
+	dns.ResponseWriter
+
+	TsigVerified()
+}
+
+// tsigVerifiedWriter flags the requests of a [dns.Server] with
+// a TsigProvider as verified.
+type tsigVerifiedWriter struct {
+	dns.ResponseWriter
+}
+
+func (*tsigVerifiedWriter) TsigVerified() {}
+
+func (rw *tsigVerifiedWriter) Unwrap() dns.ResponseWriter { return rw.ResponseWriter }
+
+// ConnectionState returns the TLS state of the connection, if any.
+func (rw *tsigVerifiedWriter) ConnectionState() *tls.ConnectionState {
+	if cs, ok := rw.ResponseWriter.(dns.ConnectionStater); ok {
+		return cs.ConnectionState()
+	}
+	return nil
+}
+
+// serveTSIG passes the requests of the [dns.Server]s of the [Server],
+// which verify their TSIG using [Server.TsigProvider].
+func (srv *Server) serveTSIG(w dns.ResponseWriter, r *dns.Msg) {
+	srv.ServeDNS(&tsigVerifiedWriter{w}, r)
+}
+
+// noTsigProvider is a [dns.TsigProvider] without keys, so
+// signed requests are never trusted.
+type noTsigProvider struct{}
+
+func (noTsigProvider) Generate([]byte, *dns.TSIG) ([]byte, error) { return nil, dns.ErrSecret }
+func (noTsigProvider) Verify([]byte, *dns.TSIG) error             { return dns.ErrSecret }
+
+func (srv *Server) getTsigProvider() dns.TsigProvider {
+	if srv.TsigProvider != nil {
+		return srv.TsigProvider
+	}
+	return noTsigProvider{}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+func newTestKeyring(t *testing.T, secret string, names ...string) *exdns.TSIGKeyring {
+	kr := new(exdns.TSIGKeyring)
+	for _, name := range names {
+		err := kr.Add(exdns.TSIGKey{
+			Name:      name,
+			Algorithm: dns.HmacSHA256,
+			Secret:    secret,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return kr
+}
+
+func TestServerTSIG(t *testing.T) {
+	const secret = "c2VjcmV0LXNoYXJlZC1ieS1ib3RoLXNpZGVz"
+
+	srv := &Server{
+		Handler: &Handler{
+			Lookuper: newTestLookuper("signed"),
+			TSIG: map[string]TSIGACL{
+				"any.":     {},
+				"limited.": {Zones: []string{"example.com."}},
+			},
+		},
+		Addresses:    []string{"127.0.0.1:0"},
+		TsigProvider: newTestKeyring(t, secret, "any.", "limited.", "unlisted."),
+	}
+	startTestServer(t, srv)
+	addr := srv.Addrs()[0].String()
+
+	c, err := client.NewTSIG(client.NewDefaultClient(0),
+		newTestKeyring(t, secret, "any.", "limited.", "unlisted."))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		key   string
+		rcode int
+	}{
+		{"any.", dns.RcodeSuccess},
+		{"limited.", dns.RcodeRefused},
+		{"unlisted.", dns.RcodeRefused},
+	} {
+		if err := c.SetServerKey(addr, tc.key); err != nil {
+			t.Fatal(err)
+		}
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeTXT)
+		resp, _, err := c.ExchangeContext(context.Background(), req, addr)
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", tc.key, err)
+		case resp.Rcode != tc.rcode:
+			t.Errorf("%s: unexpected rcode %s", tc.key, dns.RcodeToString[resp.Rcode])
+		}
+	}
+
+	// wrong secret
+	dc := client.NewDefaultClient(0)
+	dc.TsigProvider = newTestKeyring(t, "d3Jvbmctc2VjcmV0", "any.")
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeTXT)
+	req.SetTsig("any.", dns.HmacSHA256, client.DefaultTSIGFudge, time.Now().Unix())
+
+	resp, _, err := dc.Exchange(req, addr)
+	switch {
+	case err != nil:
+		t.Error(err)
+	case resp.Rcode != dns.RcodeNotAuth:
+		t.Errorf("unexpected rcode %s with the wrong secret", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestHandlerTSIGUnverified(t *testing.T) {
+	// a plain dns.Server without TsigProvider doesn't verify
+	// the signatures
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	s := &dns.Server{
+		PacketConn: pc,
+		Handler: &Handler{
+			Lookuper: newTestLookuper("signed"),
+			TSIG:     map[string]TSIGACL{"any.": {}},
+		},
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = s.ActivateAndServe() }()
+	t.Cleanup(func() { _ = s.Shutdown() })
+	<-started
+
+	// forged, signed with a secret the server doesn't know
+	dc := client.NewDefaultClient(0)
+	dc.TsigProvider = newTestKeyring(t, "Zm9yZ2VkLXNlY3JldA==", "any.")
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeTXT)
+	req.SetTsig("any.", dns.HmacSHA256, client.DefaultTSIGFudge, time.Now().Unix())
+
+	resp, _, err := dc.Exchange(req, pc.LocalAddr().String())
+	switch {
+	case err != nil:
+		t.Error(err)
+	case resp.Rcode != dns.RcodeNotAuth:
+		t.Errorf("unverified signature accepted, rcode %s", dns.RcodeToString[resp.Rcode])
+	}
+}
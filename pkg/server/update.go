@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+// UpdatePolicy describes who can make dynamic updates to a zone.
+type UpdatePolicy struct {
+	// Allow lists the networks allowed to update the zone.
+	Allow []netip.Prefix
+	// Keys lists the TSIG keys allowed to update the zone.
+	Keys []string
+}
+
+// Allows tells if the [UpdatePolicy] allows the request, by the
+// TSIG key used to sign it or by the address of the client.
+func (p *UpdatePolicy) Allows(w dns.ResponseWriter, r *dns.Msg) bool {
+	if t := r.IsTsig(); t != nil {
+		name := dns.CanonicalName(t.Hdr.Name)
+		for _, key := range p.Keys {
+			if dns.CanonicalName(key) == name {
+				return true
+			}
+		}
+	}
+
+	addr, ok := clientAddr(w.RemoteAddr())
+	return ok && prefixesContain(p.Allow, addr)
+}
+
+// handleUpdate applies dynamic updates, RFC 2136, to the zones of
+// the Authority with an [UpdatePolicy] allowing the request.
+func (h *Handler) handleUpdate(w dns.ResponseWriter, r *dns.Msg) error {
+	if len(r.Question) != 1 {
+		return handleRcodeError(w, r, dns.RcodeFormatError)
+	}
+
+	z, ok := h.getAuthZone(r.Question[0].Name)
+	if !ok {
+		return handleRcodeError(w, r, dns.RcodeNotAuth)
+	}
+
+	p, ok := h.Updates[z.Origin()]
+	if !ok || !p.Allows(w, r) {
+		return handleRcodeError(w, r, dns.RcodeRefused)
+	}
+
+	rcode, err := z.Update(r)
+	if werr := handleRcodeError(w, r, rcode); err == nil {
+		err = werr
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHandlerUpdate(t *testing.T) {
+	a, z := newTestAuthority(t)
+	h := &Handler{
+		Authority: a,
+		Updates: map[string]UpdatePolicy{
+			"example.org.": {
+				Allow: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+			},
+		},
+	}
+
+	rr, _ := dns.NewRR("host.example.org. 300 IN A 192.0.2.10")
+	for _, tc := range []struct {
+		client string
+		rcode  int
+	}{
+		{"198.51.100.1:53", dns.RcodeRefused},
+		{"192.0.2.1:53", dns.RcodeSuccess},
+	} {
+		req := new(dns.Msg)
+		req.SetUpdate("example.org.")
+		req.Insert([]dns.RR{dns.Copy(rr)})
+
+		rw := &dohResponseWriter{
+			remote: net.UDPAddrFromAddrPort(netip.MustParseAddrPort(tc.client)),
+		}
+		h.ServeDNS(rw, req)
+		if rw.msg == nil || rw.msg.Rcode != tc.rcode {
+			t.Errorf("%s: unexpected response: %v", tc.client, rw.msg)
+		}
+	}
+
+	if z.Serial() != 8 {
+		t.Errorf("unexpected serial %v", z.Serial())
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver"
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// TransferChunkSize is the maximum number of records sent on
+// each message of a zone transfer.
+const TransferChunkSize = 100
+
+// NotifyFunc is called when a NOTIFY is accepted for a zone, with
+// the serial number announced, if any, and the address of the client.
+type NotifyFunc func(zone string, serial uint32, from netip.Addr)
+
+// getAuthZone returns the [resolver.Zone] of the Authority whose
+// apex is the given name.
+func (h *Handler) getAuthZone(name string) (*resolver.Zone, bool) {
+	if h.Authority != nil {
+		z, ok := h.Authority.GetZone(name)
+		if ok && z.Origin() == dns.CanonicalName(name) {
+			return z, true
+		}
+	}
+	return nil, false
+}
+
+// allowsXFR tells if a client can transfer zones or send NOTIFY
+// messages, by address or by using a TSIG key already verified.
+func allowsXFR(w dns.ResponseWriter, r *dns.Msg, allow []netip.Prefix) bool {
+	if r.IsTsig() != nil {
+		return true
+	}
+
+	addr, ok := clientAddr(w.RemoteAddr())
+	return ok && prefixesContain(allow, addr)
+}
+
+func isTransfer(q dns.Question) bool {
+	return q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR
+}
+
+// handleTransfer answers AXFR requests over TCP for the zones of the
+// Authority, RFC 5936. IXFR requests get a full transfer too.
+func (h *Handler) handleTransfer(w dns.ResponseWriter, r *dns.Msg, q dns.Question) error {
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		return handleRcodeError(w, r, dns.RcodeRefused)
+	}
+
+	z, ok := h.getAuthZone(q.Name)
+	switch {
+	case !ok:
+		return handleRcodeError(w, r, dns.RcodeNotAuth)
+	case !allowsXFR(w, r, h.AllowTransfer):
+		return handleRcodeError(w, r, dns.RcodeRefused)
+	}
+
+	records := z.AXFR()
+	if len(records) == 0 {
+		return handleRcodeError(w, r, dns.RcodeServerFailure)
+	}
+
+	for i := 0; i < len(records); i += TransferChunkSize {
+		m := newResponse(r)
+		m.Authoritative = true
+		m.Answer = records[i:min(i+TransferChunkSize, len(records))]
+		if err := w.WriteMsg(m); err != nil {
+			return err
+		}
+
+		// RFC 8945, section 5.3.1
+		w.TsigTimersOnly(true)
+	}
+	return nil
+}
+
+// handleNotify acknowledges NOTIFY messages for the zones of the
+// Authority, RFC 1996, and passes them to OnNotify.
+func (h *Handler) handleNotify(w dns.ResponseWriter, r *dns.Msg) error {
+	if len(r.Question) != 1 {
+		return handleRcodeError(w, r, dns.RcodeFormatError)
+	}
+
+	z, ok := h.getAuthZone(r.Question[0].Name)
+	switch {
+	case !ok:
+		return handleRcodeError(w, r, dns.RcodeNotAuth)
+	case !allowsXFR(w, r, h.AllowNotify):
+		return handleRcodeError(w, r, dns.RcodeRefused)
+	}
+
+	m := newResponse(r)
+	m.Authoritative = true
+	err := w.WriteMsg(m)
+
+	if h.OnNotify != nil {
+		var serial uint32
+		if soa, ok := exdns.GetFirstRR[*dns.SOA](r.Answer); ok {
+			serial = soa.Serial
+		}
+
+		addr, _ := clientAddr(w.RemoteAddr())
+		h.OnNotify(z.Origin(), serial, addr)
+	}
+	return err
+}
+
+// SendNotify sends a NOTIFY for the [resolver.Zone] to the given
+// servers, RFC 1996, so secondaries refresh it without waiting.
+// Servers not acknowledging it are reported in a [core.CompoundError].
+func SendNotify(ctx context.Context, c client.Client, z *resolver.Zone, servers ...string) error {
+	if c == nil || z == nil {
+		return core.ErrInvalid
+	}
+
+	req := new(dns.Msg)
+	req.SetNotify(z.Origin())
+	if soa := z.SOA(); soa != nil {
+		req.Answer = []dns.RR{soa}
+	}
+
+	var errs core.CompoundError
+	for _, server := range servers {
+		req.Id = dns.Id()
+
+		resp, _, err := c.ExchangeContext(ctx, req.Copy(), server)
+		switch {
+		case err != nil:
+			errs.AppendError(err)
+		case resp == nil || resp.Rcode != dns.RcodeSuccess:
+			errs.AppendError(errors.MsgAsError(resp))
+		}
+	}
+	return errs.AsError()
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver"
+)
+
+func newTestAuthority(t *testing.T) (*resolver.Authority, *resolver.Zone) {
+	z, err := resolver.NewZone("example.org.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 7 7200 3600 1209600 300",
+		"example.org. 3600 IN NS ns.example.org.",
+		"ns.example.org. 3600 IN A 192.0.2.1",
+		"www.example.org. 3600 IN A 192.0.2.2",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Add(rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := resolver.NewAuthority(nil, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, z
+}
+
+func TestHandlerTransfer(t *testing.T) {
+	a, z := newTestAuthority(t)
+	notified := make(chan uint32, 1)
+
+	h := &Handler{
+		Authority:     a,
+		AllowTransfer: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		AllowNotify:   []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		OnNotify: func(_ string, serial uint32, _ netip.Addr) {
+			notified <- serial
+		},
+	}
+	srv := &Server{
+		Handler:   h,
+		Addresses: []string{"127.0.0.1:0"},
+	}
+	startTestServer(t, srv)
+
+	var addr string
+	for _, a := range srv.Addrs() {
+		if a.Network() == "tcp" {
+			addr = a.String()
+		}
+	}
+
+	// AXFR
+	req := new(dns.Msg)
+	req.SetAxfr("example.org.")
+	ch, err := new(dns.Transfer).In(req, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []dns.RR
+	for env := range ch {
+		if env.Error != nil {
+			t.Fatal(env.Error)
+		}
+		records = append(records, env.RR...)
+	}
+	if len(records) != 5 {
+		t.Errorf("unexpected transfer: %v", records)
+	}
+
+	// NOTIFY
+	if err := SendNotify(context.Background(), &dns.Client{Net: "tcp"}, z, addr); err != nil {
+		t.Fatal(err)
+	}
+	if serial := <-notified; serial != 7 {
+		t.Errorf("unexpected serial %v", serial)
+	}
+
+	// not allowed
+	if err := srv.ReloadHandler(&Handler{Authority: a}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &dns.Client{Net: "tcp"}
+	resp, _, err := c.Exchange(req, addr)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case resp.Rcode != dns.RcodeRefused:
+		t.Errorf("unexpected rcode %s", dns.RcodeToString[resp.Rcode])
+	}
+}
@@ -2,7 +2,9 @@ package resolver
 
 import (
 	"context"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -22,9 +24,15 @@ var (
 // A Pool is a Exchanger with multiple possible servers behind and tries
 // some at random up to a given limit of parallel requests.
 type Pool struct {
-	mu sync.Mutex
-	c  client.Client
-	s  map[string]string
+	mu      sync.Mutex
+	c       client.Client
+	s       map[string]string
+	w       map[string]poolWeight
+	infra   *InfraCache
+	zone    string
+	health  poolHealth
+	stats   poolStats
+	metrics atomic.Pointer[poolMetrics]
 
 	// Attempts indicates how many times we will try. A negative
 	// value indicates we will keep on trying
@@ -36,6 +44,37 @@ type Pool struct {
 	// Interval indicates how long to wait until a new attempt is
 	// started.
 	Interval time.Duration
+
+	// AttemptTimeout is an optional maximum time each attempt
+	// can take.
+	AttemptTimeout time.Duration
+
+	// Failover indicates that, if Attempts is positive, every server
+	// should be tried at least once before giving up.
+	Failover bool
+
+	// ConsistentHashing indicates servers are chosen by hashing the
+	// query name instead of at random or by RTT, so each name is sent
+	// to the same server while it's available.
+	ConsistentHashing bool
+
+	// MaxFailures is the number of consecutive failures after which
+	// a server is taken out of rotation. Zero disables passive
+	// health checking.
+	MaxFailures int
+
+	// Quarantine indicates how long a failing server is kept out
+	// of rotation before it's given another chance.
+	// [DefaultPoolQuarantine] is used if zero.
+	Quarantine time.Duration
+
+	// OnStateChange, if set, is called when a server is taken out
+	// of rotation or reinstated.
+	OnStateChange func(server string, healthy bool)
+
+	// OnServersChange, if set, is called when [Pool.SetServers]
+	// changes the list of servers.
+	OnServersChange func(added, removed []string)
 }
 
 // Add adds servers to the [Pool].
@@ -67,6 +106,9 @@ func (p *Pool) Remove(servers ...string) error {
 		}
 
 		delete(p.s, s)
+		delete(p.w, s)
+		p.health.Forget(s)
+		p.stats.Forget(s)
 	}
 
 	return nil
@@ -87,16 +129,31 @@ func (p *Pool) Servers() []string {
 }
 
 // Server returns on registered server chosen at
-// random. They can repeat.
+// random among those with the best priority, or by
+// RTT if an [InfraCache] has been set.
+// They can repeat.
 func (p *Pool) Server() string {
+	servers := p.Servers()
+	if len(servers) == 0 {
+		return ""
+	}
+	return p.selectServer(servers)
+}
+
+// SetInfraCache sets the [InfraCache] used to choose servers
+// and where the results of exchanges are recorded.
+func (p *Pool) SetInfraCache(infra *InfraCache) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for _, s := range p.s {
-		return s
-	}
+	p.infra = infra
+}
+
+func (p *Pool) getInfraCache() *InfraCache {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	return ""
+	return p.infra
 }
 
 // Len indicates how many servers are registered
@@ -157,21 +214,26 @@ func (p *Pool) ExchangeWithClient(ctx context.Context, req *dns.Msg, c client.Cl
 		c = client.NewDefaultClient(0)
 	}
 
-	return p.doExchangeWithClient(ctx, req, c)
+	return p.doExchangeWithClient(ctx, applyRequestOptions(ctx, req), c)
 }
 
 func (p *Pool) doExchangeWithClient(ctx context.Context, req *dns.Msg, c client.Client) (*dns.Msg, error) {
 	// context
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	if p.Deadline > 0 {
-		until := time.Now().Add(p.Deadline)
+	if d := p.getDeadline(ctx); d > 0 {
+		until := time.Now().Add(d)
 
 		ctx, cancel = context.WithDeadline(ctx, until)
 		defer cancel()
 	}
 
-	n, t := p.Attempts, p.Interval
+	n, t := p.getAttempts(ctx), p.getInterval(ctx)
+	if l := p.Len(); p.Failover && n > 0 && n < l {
+		// try them all
+		n = l
+	}
+
 	switch {
 	case n == 0, n == 1:
 		// once
@@ -185,16 +247,195 @@ func (p *Pool) doExchangeWithClient(ctx context.Context, req *dns.Msg, c client.
 	}
 }
 
-func (p *Pool) doExchangeCh(ctx context.Context, req *dns.Msg, c client.Client, out chan<- *poolEx) {
-	server := p.Server()
-	resp, _, err := c.ExchangeContext(ctx, req, server)
+// getAttempts returns the number of attempts for an exchange,
+// as set on the context or the [Pool].
+func (p *Pool) getAttempts(ctx context.Context) int {
+	if n, ok := GetMaxAttempts(ctx); ok {
+		return n
+	}
+	return p.Attempts
+}
+
+// getDeadline returns the deadline of an exchange,
+// as set on the context or the [Pool].
+func (p *Pool) getDeadline(ctx context.Context) time.Duration {
+	if d, ok := GetExchangeDeadline(ctx); ok {
+		return d
+	}
+	return p.Deadline
+}
+
+// getInterval returns the hedging interval of an exchange,
+// as set on the context or the [Pool].
+func (p *Pool) getInterval(ctx context.Context) time.Duration {
+	if t, ok := GetHedgingInterval(ctx); ok {
+		return t
+	}
+	return p.Interval
+}
+
+func (p *Pool) doExchangeCh(ctx context.Context, req *dns.Msg, c client.Client,
+	st *poolState, out chan<- *poolEx) {
+	//
+	server := p.nextServer(st)
+	ex, rtt := p.doExchangeAttempt(ctx, req, c, server)
+
+	// the exchange's context is cancelled once a result
+	// has been delivered, flagging the remaining attempts
+	// as abandoned and releasing them.
+	p.recordHealth(ctx, server, ex)
+	p.recordStats(ctx, server, ex, rtt)
+	p.traceExchange(ctx, server, req, ex, rtt)
+
+	select {
+	case out <- ex:
+	case <-ctx.Done():
+		// result no longer wanted
+	}
+}
+
+func (p *Pool) doExchangeAttempt(ctx context.Context, req *dns.Msg,
+	c client.Client, server string) (*poolEx, time.Duration) {
+	//
+	if p.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+		defer cancel()
+	}
+
+	c2, server2 := transportClient(ctx, c, server)
+	resp, rtt, err := c2.ExchangeContext(ctx, req, server2)
+	p.recordExchange(server, resp, rtt, err)
+	if err == nil {
+		resp, err = p.checkLame(server, resp)
+	}
+
 	if e2 := errors.ValidateResponse(server, resp, err); e2 != nil {
 		err = e2
 	}
 
-	// out would be closed if we already delivered a response.
-	defer func() { _ = recover() }()
-	out <- &poolEx{resp, err}
+	return &poolEx{resp, err}, rtt
+}
+
+func (p *Pool) traceExchange(ctx context.Context, server string,
+	req *dns.Msg, ex *poolEx, rtt time.Duration) {
+	//
+	if t, ok := GetQueryTrace(ctx); ok {
+		step := newQueryTraceStep(req, ex.resp, rtt, ex.err)
+		step.Zone = p.zone
+		step.Server = server
+		t.add(step)
+	}
+}
+
+// nextServer chooses a server not tried yet during this exchange,
+// or starts over if all have been tried already.
+func (p *Pool) nextServer(st *poolState) string {
+	servers := p.Servers()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	servers = p.preferHealthy(servers)
+
+	candidates := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if !st.tried[s] {
+			candidates = append(candidates, s)
+		}
+	}
+
+	if len(candidates) == 0 {
+		// start over
+		candidates = servers
+		st.tried = make(map[string]bool)
+	}
+
+	server := p.selectServerByKey(st.key, candidates)
+	st.tried[server] = true
+	return server
+}
+
+func (p *Pool) selectServer(servers []string) string {
+	servers, weights := p.topTier(servers)
+	if infra := p.getInfraCache(); infra != nil {
+		if p.zone != "" {
+			servers = p.preferNotLame(infra, servers)
+		}
+		return infra.Select(servers)
+	}
+
+	return weightedRandom(servers, weights)
+}
+
+func (p *Pool) preferNotLame(infra *InfraCache, servers []string) []string {
+	healthy := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if !infra.IsLame(p.zone, s) {
+			healthy = append(healthy, s)
+		}
+	}
+
+	if len(healthy) > 0 {
+		return healthy
+	}
+	return servers
+}
+
+// checkLame verifies the response from a zone's nameserver, and if lame
+// flags the server and turns the response into an error so another
+// server is tried.
+func (p *Pool) checkLame(server string, resp *dns.Msg) (*dns.Msg, error) {
+	infra := p.getInfraCache()
+	if infra == nil || p.zone == "" || resp == nil {
+		return resp, nil
+	}
+
+	reason := lameReason(p.zone, resp)
+	if reason == "" {
+		return resp, nil
+	}
+
+	infra.SetLame(p.zone, server, reason)
+	return nil, &net.DNSError{
+		Err:         "lame delegation: " + reason,
+		Name:        questionName(msgQuestion(resp)),
+		Server:      server,
+		IsTemporary: true,
+	}
+}
+
+// lameReason tells why a response from a nameserver of the given zone
+// is considered lame, or empty if it isn't.
+func lameReason(zone string, resp *dns.Msg) string {
+	switch {
+	case resp.Rcode == dns.RcodeRefused:
+		return "refused"
+	case resp.Rcode != dns.RcodeSuccess, resp.Authoritative, len(resp.Answer) > 0:
+		return ""
+	}
+
+	ns, ok := exdns.GetFirstRR[*dns.NS](resp.Ns)
+	switch {
+	case !ok:
+		return core.IIf(exdns.HasNsType(resp, dns.TypeSOA), "", "not authoritative")
+	case !isStrictSubDomain(zone, ns.Hdr.Name):
+		return "upward referral"
+	default:
+		return ""
+	}
+}
+
+func (p *Pool) recordExchange(server string, resp *dns.Msg, rtt time.Duration, err error) {
+	infra := p.getInfraCache()
+	switch {
+	case infra == nil:
+		// not tracked
+	case err == nil && resp != nil:
+		infra.Update(server, rtt, resp.IsEdns0() != nil)
+	case errors.IsTimeout(err):
+		infra.Timeout(server)
+	}
 }
 
 func (*Pool) returnTimeout(req *dns.Msg, err error) (*dns.Msg, error) {
@@ -206,9 +447,8 @@ func (p *Pool) doExchangeOnce(ctx context.Context, req *dns.Msg,
 	c client.Client) (*dns.Msg, error) {
 	// spawn
 	ch := make(chan *poolEx)
-	defer close(ch)
 
-	go p.doExchangeCh(ctx, req, c, ch)
+	go p.doExchangeCh(ctx, req, c, newPoolState(req), ch)
 
 	// wait
 	select {
@@ -227,10 +467,10 @@ func (p *Pool) doExchangeWait(ctx context.Context, req *dns.Msg,
 	var err error
 
 	ch := make(chan *poolEx)
-	defer close(ch)
 
+	st := newPoolState(req)
 	for p.next(&n) {
-		go p.doExchangeCh(ctx, req, c, ch)
+		go p.doExchangeCh(ctx, req, c, st, ch)
 
 		select {
 		case <-ctx.Done():
@@ -255,19 +495,18 @@ func (p *Pool) doExchangeWait(ctx context.Context, req *dns.Msg,
 func (p *Pool) doExchangeInterval(ctx context.Context, req *dns.Msg,
 	c client.Client, n int, interval time.Duration) (*dns.Msg, error) {
 	//
-	var wg sync.WaitGroup
 	var err error
 
 	// responses
 	ch := make(chan *poolEx)
-	defer close(ch)
 
 	// spawning timer
 	tick := time.NewTicker(interval)
 	defer tick.Stop()
 
 	// spawn first
-	p.spawnExchangeCh(ctx, req, &wg, c, ch)
+	st := newPoolState(req)
+	p.spawnExchangeCh(ctx, req, c, st, ch)
 
 	for p.next(&n) {
 		select {
@@ -286,13 +525,13 @@ func (p *Pool) doExchangeInterval(ctx context.Context, req *dns.Msg,
 			return p.returnTimeout(req, ctx.Err())
 		case <-tick.C:
 			// spawn another
-			p.spawnExchangeCh(ctx, req, &wg, c, ch)
+			p.spawnExchangeCh(ctx, req, c, st, ch)
 		}
 	}
 
 	tick.Stop()
 	// carry on waiting
-	return p.waitExchangeInterval(ctx, req, &wg, ch, err)
+	return p.waitExchangeInterval(ctx, req, &st.wg, ch, err)
 }
 
 func (p *Pool) waitExchangeInterval(ctx context.Context, req *dns.Msg,
@@ -328,13 +567,12 @@ func (p *Pool) waitExchangeInterval(ctx context.Context, req *dns.Msg,
 }
 
 func (p *Pool) spawnExchangeCh(ctx context.Context, req *dns.Msg,
-	wg *sync.WaitGroup,
-	c client.Client, ch chan<- *poolEx) {
+	c client.Client, st *poolState, ch chan<- *poolEx) {
 	//
-	wg.Add(1)
+	st.wg.Add(1)
 	go func() {
-		defer wg.Done()
-		p.doExchangeCh(ctx, req, c, ch)
+		defer st.wg.Done()
+		p.doExchangeCh(ctx, req, c, st, ch)
 	}()
 }
 
@@ -353,6 +591,21 @@ func (*Pool) next(n *int) bool {
 	}
 }
 
+// poolState tracks the attempts of an exchange
+type poolState struct {
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	key   string
+	tried map[string]bool
+}
+
+func newPoolState(req *dns.Msg) *poolState {
+	return &poolState{
+		key:   dns.CanonicalName(req.Question[0].Name),
+		tried: make(map[string]bool),
+	}
+}
+
 type poolEx struct {
 	resp *dns.Msg
 	err  error
@@ -398,6 +651,7 @@ func NewPoolExchanger(c client.Client, servers ...string) (*Pool, error) {
 	p := &Pool{
 		c: c,
 		s: make(map[string]string),
+		w: make(map[string]poolWeight),
 	}
 
 	err := p.Add(servers...)
@@ -0,0 +1,44 @@
+package resolver
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// selectServerByKey chooses a server for a query name, using
+// consistent hashing if enabled.
+func (p *Pool) selectServerByKey(key string, servers []string) string {
+	if key == "" || !p.ConsistentHashing {
+		return p.selectServer(servers)
+	}
+
+	servers, weights := p.topTier(servers)
+	return hashedServer(key, servers, weights)
+}
+
+// hashedServer chooses a server for a key using weighted rendezvous
+// hashing, so each key maps to the same server while it's available
+// and only the keys of a removed server move elsewhere.
+func hashedServer(key string, servers []string, weights []poolWeight) string {
+	var best string
+	var bestScore float64
+
+	for i, s := range servers {
+		score := rendezvousScore(key, s, weights[i].weight)
+		if best == "" || score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+	return best
+}
+
+func rendezvousScore(key, server string, weight uint16) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(server))
+
+	// uniform in (0, 1)
+	u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+	return float64(max(weight, 1)) / -math.Log(u)
+}
@@ -0,0 +1,220 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// DefaultPoolQuarantine indicates how long a [Pool] server is
+	// kept out of rotation after failing, if not specified.
+	DefaultPoolQuarantine = 30 * time.Second
+
+	// DefaultPoolHealthCheckInterval indicates how often a [Pool]
+	// probes its servers if not specified.
+	DefaultPoolHealthCheckInterval = 30 * time.Second
+
+	// DefaultPoolHealthCheckTimeout indicates how long a [Pool]
+	// waits for a probe if AttemptTimeout isn't set.
+	DefaultPoolHealthCheckTimeout = 2 * time.Second
+)
+
+// poolHealth tracks the failures of the servers of a [Pool]
+type poolHealth struct {
+	mu      sync.Mutex
+	servers map[string]*poolServerHealth
+}
+
+type poolServerHealth struct {
+	failures int
+	down     bool
+	until    time.Time
+}
+
+// revive:disable:flag-parameter
+
+// record updates the state of a server and tells if it changed.
+func (ph *poolHealth) record(server string, ok bool, threshold int,
+	quarantine time.Duration) (changed bool) {
+	// revive:enable:flag-parameter
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	if ph.servers == nil {
+		ph.servers = make(map[string]*poolServerHealth)
+	}
+
+	sh, found := ph.servers[server]
+	switch {
+	case ok && !found:
+		return false
+	case ok:
+		delete(ph.servers, server)
+		return sh.down
+	case !found:
+		sh = new(poolServerHealth)
+		ph.servers[server] = sh
+	}
+
+	sh.failures++
+	if sh.failures < threshold {
+		return false
+	}
+
+	sh.until = time.Now().Add(quarantine)
+	changed = !sh.down
+	sh.down = true
+	return changed
+}
+
+// IsHealthy tells if a server is in rotation. Quarantined servers
+// return to rotation once their time expires, but a new failure
+// sends them back.
+func (ph *poolHealth) IsHealthy(server string) bool {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	sh, ok := ph.servers[server]
+	return !ok || !sh.down || time.Now().After(sh.until)
+}
+
+func (ph *poolHealth) Forget(server string) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	delete(ph.servers, server)
+}
+
+// IsHealthy tells if a server of the [Pool] is in rotation.
+func (p *Pool) IsHealthy(server string) bool {
+	return p.health.IsHealthy(server)
+}
+
+// preferHealthy removes quarantined servers from a list,
+// unless none would remain.
+func (p *Pool) preferHealthy(servers []string) []string {
+	healthy := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if p.health.IsHealthy(s) {
+			healthy = append(healthy, s)
+		}
+	}
+
+	if len(healthy) > 0 {
+		return healthy
+	}
+	return servers
+}
+
+// recordHealth tracks the outcome of an exchange for passive
+// health checking, if enabled.
+func (p *Pool) recordHealth(ctx context.Context, server string, ex *poolEx) {
+	switch {
+	case p.MaxFailures <= 0:
+		// disabled
+	case ctx.Err() != nil && ex.resp == nil:
+		// cancelled, not the server's fault
+	default:
+		p.setHealth(server, !isPoolFailure(ex), p.MaxFailures)
+	}
+}
+
+// revive:disable:flag-parameter
+
+func (p *Pool) setHealth(server string, ok bool, threshold int) {
+	// revive:enable:flag-parameter
+	quarantine := p.Quarantine
+	if quarantine <= 0 {
+		quarantine = DefaultPoolQuarantine
+	}
+
+	if p.health.record(server, ok, threshold, quarantine) {
+		if fn := p.OnStateChange; fn != nil {
+			fn(server, ok)
+		}
+	}
+}
+
+func isPoolFailure(ex *poolEx) bool {
+	switch {
+	case ex.resp != nil:
+		return ex.resp.Rcode == dns.RcodeServerFailure
+	case ex.err == nil:
+		return true
+	default:
+		return errors.IsTimeout(ex.err) || errors.IsTemporary(ex.err)
+	}
+}
+
+// CheckHealth probes every server of the [Pool] with a `. NS` query,
+// quarantining those failing and reinstating those recovered.
+func (p *Pool) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	c := p.c
+	if c == nil {
+		c = client.NewDefaultClient(0)
+	}
+
+	threshold := max(p.MaxFailures, 1)
+	for _, server := range p.Servers() {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+
+			ok := p.probeServer(ctx, c, server)
+			p.setHealth(server, ok, threshold)
+		}(server)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) probeServer(ctx context.Context, c client.Client, server string) bool {
+	timeout := p.AttemptTimeout
+	if timeout <= 0 {
+		timeout = DefaultPoolHealthCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req := exdns.NewRequestFromParts(".", dns.ClassINET, dns.TypeNS)
+	resp, _, err := c.ExchangeContext(ctx, req, server)
+	switch {
+	case err != nil, resp == nil:
+		return false
+	default:
+		return resp.Rcode == dns.RcodeSuccess
+	}
+}
+
+// StartHealthCheck probes the servers of the [Pool] periodically
+// in the background until the context is cancelled.
+// [DefaultPoolHealthCheckInterval] is used if interval is zero.
+func (p *Pool) StartHealthCheck(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultPoolHealthCheckInterval
+	}
+
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		for {
+			p.CheckHealth(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,178 @@
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// DefaultPoolWatchInterval indicates how often [Pool.WatchFile]
+// checks the file for changes if not specified.
+const DefaultPoolWatchInterval = 5 * time.Second
+
+// SetServers replaces the servers of the [Pool] atomically.
+// If any of the addresses is invalid, nothing is changed.
+// Servers kept retain their weights and health state.
+func (p *Pool) SetServers(servers ...string) error {
+	next := make(map[string]string, len(servers))
+	for _, s := range servers {
+		s, err := exdns.AsServerAddress(s)
+		if err != nil {
+			return err
+		}
+		next[s] = s
+	}
+
+	p.mu.Lock()
+	var added, removed []string
+	for s := range next {
+		if _, ok := p.s[s]; !ok {
+			added = append(added, s)
+		}
+	}
+	for s := range p.s {
+		if _, ok := next[s]; !ok {
+			removed = append(removed, s)
+			delete(p.w, s)
+			p.health.Forget(s)
+			p.stats.Forget(s)
+		}
+	}
+	p.s = next
+	fn := p.OnServersChange
+	p.mu.Unlock()
+
+	if fn != nil && len(added)+len(removed) > 0 {
+		sort.Strings(added)
+		sort.Strings(removed)
+		fn(added, removed)
+	}
+	return nil
+}
+
+// LoadServers replaces the servers of the [Pool] with those read
+// from a list. Each line contains a server address, optionally
+// prefixed by `nameserver` as in `resolv.conf`, and lines starting
+// with `#` or `;` are ignored, as are other `resolv.conf` options.
+func (p *Pool) LoadServers(r io.Reader) error {
+	var servers []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if s, ok := parsePoolServerLine(scanner.Text()); ok {
+			servers = append(servers, s)
+		}
+	}
+
+	switch {
+	case scanner.Err() != nil:
+		return scanner.Err()
+	case len(servers) == 0:
+		return core.Wrap(core.ErrInvalid, "no servers")
+	default:
+		return p.SetServers(servers...)
+	}
+}
+
+func parsePoolServerLine(line string) (string, bool) {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 0:
+		return "", false
+	case fields[0][0] == '#', fields[0][0] == ';':
+		// comment
+		return "", false
+	case fields[0] == "nameserver" && len(fields) > 1:
+		return fields[1], true
+	case len(fields) == 1 && !isResolvConfKeyword(fields[0]):
+		return fields[0], true
+	default:
+		return "", false
+	}
+}
+
+func isResolvConfKeyword(s string) bool {
+	switch s {
+	case "nameserver", "domain", "search", "sortlist", "options":
+		return true
+	default:
+		return false
+	}
+}
+
+// LoadServersFile replaces the servers of the [Pool] with those
+// listed on a file, as described in [Pool.LoadServers].
+func (p *Pool) LoadServersFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return p.LoadServers(f)
+}
+
+// WatchFile loads the servers of the [Pool] from a file, and keeps
+// reloading it in the background whenever it changes, until the context
+// is cancelled. Errors reloading the file leave the servers as they were.
+// [DefaultPoolWatchInterval] is used if interval is zero.
+func (p *Pool) WatchFile(ctx context.Context, filename string, interval time.Duration) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := p.LoadServersFile(filename); err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		interval = DefaultPoolWatchInterval
+	}
+
+	go p.watchFile(ctx, filename, interval, fi)
+	return nil
+}
+
+func (p *Pool) watchFile(ctx context.Context, filename string,
+	interval time.Duration, last os.FileInfo) {
+	//
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			last = p.reloadIfChanged(filename, last)
+		}
+	}
+}
+
+// reloadIfChanged reloads the servers from a file if it
+// changed since last seen, and returns its new state.
+func (p *Pool) reloadIfChanged(filename string, last os.FileInfo) os.FileInfo {
+	fi, err := os.Stat(filename)
+	switch {
+	case err != nil:
+		// gone
+		return last
+	case fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size():
+		// unchanged
+		return last
+	case p.LoadServersFile(filename) != nil:
+		// failed, try again later
+		return last
+	default:
+		return fi
+	}
+}
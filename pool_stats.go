@@ -0,0 +1,158 @@
+package resolver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/metrics"
+)
+
+// PoolServerStats describes the activity of a [Pool] server.
+type PoolServerStats struct {
+	Server  string
+	Healthy bool
+
+	// Queries is the number of exchanges sent to the server,
+	// excluding those abandoned because another server answered
+	// first.
+	Queries uint64
+	// Successes is the number of responses received.
+	Successes uint64
+	// Timeouts is the number of exchanges that timed out.
+	Timeouts uint64
+	// Errors is the number of exchanges that failed otherwise.
+	Errors uint64
+	// Rcodes is the number of responses received by RCODE.
+	Rcodes map[int]uint64
+	// MeanRTT is the average round-trip time of the responses.
+	MeanRTT time.Duration
+}
+
+// poolStats tracks the activity of the servers of a [Pool]
+type poolStats struct {
+	mu      sync.Mutex
+	servers map[string]*PoolServerStats
+	rtt     map[string]time.Duration
+}
+
+func (ps *poolStats) record(server string, ex *poolEx, rtt time.Duration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.servers == nil {
+		ps.servers = make(map[string]*PoolServerStats)
+		ps.rtt = make(map[string]time.Duration)
+	}
+
+	st, ok := ps.servers[server]
+	if !ok {
+		st = &PoolServerStats{
+			Server: server,
+			Rcodes: make(map[int]uint64),
+		}
+		ps.servers[server] = st
+	}
+
+	st.Queries++
+	switch {
+	case ex.resp != nil:
+		st.Successes++
+		st.Rcodes[ex.resp.Rcode]++
+		ps.rtt[server] += rtt
+		st.MeanRTT = ps.rtt[server] / time.Duration(st.Successes)
+	case errors.IsTimeout(ex.err):
+		st.Timeouts++
+	default:
+		st.Errors++
+	}
+}
+
+func (ps *poolStats) Forget(server string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.servers, server)
+	delete(ps.rtt, server)
+}
+
+func (ps *poolStats) Get(server string) PoolServerStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	st, ok := ps.servers[server]
+	if !ok {
+		return PoolServerStats{
+			Server: server,
+			Rcodes: make(map[int]uint64),
+		}
+	}
+
+	out := *st
+	out.Rcodes = make(map[int]uint64, len(st.Rcodes))
+	for k, v := range st.Rcodes {
+		out.Rcodes[k] = v
+	}
+	return out
+}
+
+// poolMetrics are the metrics reported by a [Pool].
+type poolMetrics struct {
+	queries *metrics.Counter
+	rtt     *metrics.Histogram
+}
+
+func (pm *poolMetrics) record(server string, ex *poolEx, rtt time.Duration) {
+	switch {
+	case ex.resp != nil:
+		pm.queries.Inc(server, "success")
+		pm.rtt.ObserveDuration(rtt, server)
+	case errors.IsTimeout(ex.err):
+		pm.queries.Inc(server, "timeout")
+	default:
+		pm.queries.Inc(server, "error")
+	}
+}
+
+// SetMetrics makes the [Pool] report the exchanges with its servers,
+// and their round-trip times, to a [metrics.Registry]. nil stops it.
+func (p *Pool) SetMetrics(reg *metrics.Registry) {
+	if reg == nil {
+		p.metrics.Store(nil)
+		return
+	}
+
+	p.metrics.Store(&poolMetrics{
+		queries: reg.Counter("resolver_upstream_queries_total",
+			"Exchanges with upstream servers by result.", "server", "result"),
+		rtt: reg.Histogram("resolver_upstream_rtt_seconds",
+			"Round-trip time of the upstream responses.", nil, "server"),
+	})
+}
+
+// recordStats accounts the outcome of an exchange unless it
+// was abandoned.
+func (p *Pool) recordStats(ctx context.Context, server string, ex *poolEx, rtt time.Duration) {
+	if ctx.Err() == nil || ex.resp != nil {
+		p.stats.record(server, ex, rtt)
+		if pm := p.metrics.Load(); pm != nil {
+			pm.record(server, ex, rtt)
+		}
+	}
+}
+
+// Snapshot returns the statistics of every server of the [Pool],
+// sorted by address.
+func (p *Pool) Snapshot() []PoolServerStats {
+	servers := p.Servers()
+	sort.Strings(servers)
+
+	out := make([]PoolServerStats, len(servers))
+	for i, s := range servers {
+		out[i] = p.stats.Get(s)
+		out[i].Healthy = p.IsHealthy(s)
+	}
+	return out
+}
@@ -0,0 +1,491 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+func TestPoolFailover(t *testing.T) {
+	var mu sync.Mutex
+	var tried []string
+
+	// only one server answers, identifying itself
+	c := client.ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		tried = append(tried, server)
+		mu.Unlock()
+
+		if server != "192.0.2.3:53" {
+			return nil, 0, errors.ErrTimeout(server, nil)
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = []dns.RR{
+			&dns.TXT{
+				Hdr: dns.RR_Header{
+					Name:   req.Question[0].Name,
+					Rrtype: dns.TypeTXT,
+					Class:  dns.ClassINET,
+				},
+				Txt: []string{server},
+			},
+		}
+		return resp, time.Millisecond, nil
+	})
+
+	lookup := func(t *testing.T, p *Pool) []string {
+		mu.Lock()
+		tried = nil
+		mu.Unlock()
+
+		resp, err := p.Lookup(context.Background(), "example.org.", dns.TypeTXT)
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case len(resp.Answer) != 1:
+			t.Fatalf("unexpected answer %v", resp.Answer)
+		}
+
+		if txt := resp.Answer[0].(*dns.TXT).Txt; txt[0] != "192.0.2.3:53" {
+			t.Errorf("answered by %q", txt[0])
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), tried...)
+	}
+
+	t.Run("random", func(t *testing.T) {
+		p, err := NewPoolExchanger(c, "192.0.2.1", "192.0.2.2", "192.0.2.3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Attempts = 1
+		p.Failover = true
+
+		for i := 0; i < 8; i++ {
+			// every server at most once, until the healthy one
+			seq := lookup(t, p)
+			seen := make(map[string]bool)
+			for _, server := range seq {
+				if seen[server] {
+					t.Errorf("%v: %s tried twice: %q", i, server, seq)
+				}
+				seen[server] = true
+			}
+
+			if seq[len(seq)-1] != "192.0.2.3:53" {
+				t.Errorf("%v: healthy server not last: %q", i, seq)
+			}
+		}
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		p, err := NewPoolExchanger(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, server := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+			if err := p.AddWeighted(uint16(i), 1, server); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p.Attempts = 1
+		p.Failover = true
+
+		expected := []string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}
+		for i := 0; i < 4; i++ {
+			if seq := lookup(t, p); !equalStrings(seq, expected) {
+				t.Errorf("%v: tried %q, expected %q", i, seq, expected)
+			}
+		}
+	})
+}
+
+func TestPoolHealth(t *testing.T) {
+	var mu sync.Mutex
+	var down bool
+	tried := make(map[string]int)
+	changes := make(map[string][]bool)
+
+	// the first server fails while down
+	c := client.ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		tried[server]++
+		if server == "192.0.2.1:53" && down {
+			return nil, 0, errors.ErrTimeout(server, nil)
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, time.Millisecond, nil
+	})
+
+	p, err := NewPoolExchanger(c, "192.0.2.1", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Attempts = 2
+	p.MaxFailures = 2
+	p.OnStateChange = func(server string, healthy bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes[server] = append(changes[server], healthy)
+	}
+
+	mu.Lock()
+	down = true
+	mu.Unlock()
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		if _, err := p.Lookup(ctx, "example.org.", dns.TypeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	if n := tried["192.0.2.1:53"]; n != 2 {
+		t.Errorf("failing server tried %v times, expected 2", n)
+	}
+	down = false
+	mu.Unlock()
+
+	if p.IsHealthy("192.0.2.1:53") {
+		t.Error("failing server not quarantined")
+	}
+
+	p.CheckHealth(ctx)
+	if !p.IsHealthy("192.0.2.1:53") {
+		t.Error("recovered server not reinstated")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if s := changes["192.0.2.1:53"]; len(s) != 2 || s[0] || !s[1] {
+		t.Errorf("unexpected state changes: %v", s)
+	}
+}
+
+func TestPoolWeighted(t *testing.T) {
+	var mu sync.Mutex
+	tried := make(map[string]int)
+
+	// the primary tier fails
+	c := client.ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		tried[server]++
+		mu.Unlock()
+
+		if server == "192.0.2.1:53" {
+			return nil, 0, errors.ErrTimeout(server, nil)
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, time.Millisecond, nil
+	})
+
+	p, err := NewPoolExchanger(c, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddWeighted(10, 9, "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddWeighted(10, 1, "192.0.2.3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddWeighted(20, 1, "192.0.2.4"); err != nil {
+		t.Fatal(err)
+	}
+	p.Attempts = 2
+
+	const n = 200
+	for i := 0; i < n; i++ {
+		if _, err := p.Lookup(context.Background(), "example.org.", dns.TypeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	switch {
+	case tried["192.0.2.1:53"] != n:
+		t.Errorf("primary tried %v times, expected %v", tried["192.0.2.1:53"], n)
+	case tried["192.0.2.4:53"] != 0:
+		t.Errorf("lowest tier tried %v times", tried["192.0.2.4:53"])
+	case tried["192.0.2.2:53"] <= tried["192.0.2.3:53"]:
+		t.Errorf("weights ignored: %v", tried)
+	}
+}
+
+func TestPoolContextOverrides(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+
+	c := client.ExchangeFunc(func(_ context.Context, _ *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return nil, 0, errors.ErrTimeout(server, nil)
+	})
+
+	p, err := NewPoolExchanger(c, "192.0.2.1", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Attempts = 1
+	p.Deadline = time.Minute
+
+	ctx := WithMaxAttempts(context.Background(), 3)
+	ctx = WithExchangeDeadline(ctx, 0)
+	if _, err := p.Lookup(ctx, "example.org.", dns.TypeA); !errors.IsTimeout(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %v", calls)
+	}
+}
+
+func TestPoolRequestOptions(t *testing.T) {
+	var got *dns.Msg
+	var network string
+
+	exchange := func(name string) client.ExchangeFunc {
+		return func(_ context.Context, req *dns.Msg,
+			_ string) (*dns.Msg, time.Duration, error) {
+			got, network = req, name
+
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			return resp, time.Millisecond, nil
+		}
+	}
+
+	c, err := client.NewAutoClient(exchange("udp"), exchange("tcp"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPoolExchanger(c, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subnet := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.IPv4(192, 0, 2, 0),
+	}
+
+	ctx := WithRecursionDesired(context.Background(), true)
+	ctx = WithCheckingDisabled(ctx, true)
+	ctx = WithEDNSOptions(ctx, subnet)
+	ctx = WithTransportHint(ctx, TransportTCP)
+
+	req := exdns.NewRequestFromParts("example.org.", dns.ClassINET, dns.TypeA)
+	if _, err := p.Exchange(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := got.IsEdns0()
+	switch {
+	case !got.RecursionDesired || !got.CheckingDisabled:
+		t.Errorf("header bits not applied: %v", got.MsgHdr)
+	case opt == nil || len(opt.Option) != 1 || opt.Option[0].String() != subnet.String():
+		t.Errorf("EDNS options not applied: %v", opt)
+	case network != "tcp":
+		t.Errorf("transport hint ignored, used %s", network)
+	case req.RecursionDesired || req.CheckingDisabled:
+		t.Error("original request modified")
+	}
+}
+
+func TestPoolCancelLosers(t *testing.T) {
+	var first sync.Once
+	cancelled := make(chan struct{})
+
+	// the first attempt hangs until cancelled
+	c := client.ExchangeFunc(func(ctx context.Context, req *dns.Msg,
+		_ string) (*dns.Msg, time.Duration, error) {
+		hang := false
+		first.Do(func() { hang = true })
+		if hang {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, 0, ctx.Err()
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, time.Millisecond, nil
+	})
+
+	p, err := NewPoolExchanger(c, "192.0.2.1", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Attempts = 2
+	p.Interval = 10 * time.Millisecond
+
+	if _, err := p.Lookup(context.Background(), "example.org.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("losing attempt not cancelled")
+	}
+}
+
+func TestPoolSetServers(t *testing.T) {
+	var added, removed []string
+
+	p, err := NewPoolExchanger(nil, "192.0.2.1", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.OnServersChange = func(a, r []string) {
+		added, removed = a, r
+	}
+
+	if err := p.SetServers("192.0.2.2", "not a server:x"); err == nil {
+		t.Error("invalid server accepted")
+	} else if p.Len() != 2 {
+		t.Error("servers changed on error")
+	}
+
+	conf := "# upstreams\nnameserver 192.0.2.2\nsearch example.org\n192.0.2.3\n"
+	if err := p.LoadServers(strings.NewReader(conf)); err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case p.Len() != 2:
+		t.Errorf("unexpected servers: %v", p.Servers())
+	case len(added) != 1 || added[0] != "192.0.2.3:53":
+		t.Errorf("unexpected added servers: %v", added)
+	case len(removed) != 1 || removed[0] != "192.0.2.1:53":
+		t.Errorf("unexpected removed servers: %v", removed)
+	}
+}
+
+func TestPoolConsistentHashing(t *testing.T) {
+	var mu sync.Mutex
+	var last string
+
+	c := client.ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		last = server
+		mu.Unlock()
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, time.Millisecond, nil
+	})
+
+	servers := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}
+	p, err := NewPoolExchanger(c, servers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ConsistentHashing = true
+
+	ctx := context.Background()
+	assigned := make(map[string]string)
+	for i := 0; i < 64; i++ {
+		qName := fmt.Sprintf("host%v.example.org.", i)
+		for j := 0; j < 3; j++ {
+			if _, err := p.Lookup(ctx, qName, dns.TypeA); err != nil {
+				t.Fatal(err)
+			}
+
+			if s, ok := assigned[qName]; ok && s != last {
+				t.Fatalf("%s: moved from %s to %s", qName, s, last)
+			}
+			assigned[qName] = last
+		}
+	}
+
+	// only the names of the removed server move
+	if err := p.Remove(servers[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	for qName, s := range assigned {
+		if _, err := p.Lookup(ctx, qName, dns.TypeA); err != nil {
+			t.Fatal(err)
+		}
+
+		if s != "192.0.2.1:53" && s != last {
+			t.Errorf("%s: moved from %s to %s", qName, s, last)
+		}
+	}
+}
+
+func TestPoolSnapshot(t *testing.T) {
+	c := client.ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		if server == "192.0.2.1:53" {
+			return nil, 0, errors.ErrTimeout(server, nil)
+		}
+
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeNameError)
+		return resp, 10 * time.Millisecond, nil
+	})
+
+	// the failing server is always tried first
+	p, err := NewPoolExchanger(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddWeighted(0, 1, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddWeighted(1, 1, "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	p.Attempts = 2
+	p.Failover = true
+
+	for i := 0; i < 4; i++ {
+		_, _ = p.Lookup(context.Background(), "example.org.", dns.TypeA)
+	}
+
+	stats := p.Snapshot()
+	if len(stats) != 2 {
+		t.Fatalf("unexpected snapshot: %v", stats)
+	}
+
+	bad, good := stats[0], stats[1]
+	switch {
+	case bad.Queries == 0 || bad.Timeouts != bad.Queries || bad.Successes != 0:
+		t.Errorf("unexpected stats for %s: %+v", bad.Server, bad)
+	case good.Queries != 4 || good.Successes != 4 || good.Rcodes[dns.RcodeNameError] != 4:
+		t.Errorf("unexpected stats for %s: %+v", good.Server, good)
+	case good.MeanRTT != 10*time.Millisecond:
+		t.Errorf("unexpected mean RTT: %v", good.MeanRTT)
+	}
+}
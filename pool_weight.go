@@ -0,0 +1,99 @@
+package resolver
+
+import (
+	"math"
+	"math/rand"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// poolWeight describes the preference of a [Pool] server. Like MX
+// records, lower priority values are preferred and weight is
+// used to choose among servers of the same priority.
+type poolWeight struct {
+	priority uint16
+	weight   uint16
+}
+
+var defaultPoolWeight = poolWeight{weight: 1}
+
+// AddWeighted adds servers to the [Pool] with the given priority and weight.
+// Servers with lower priority values are preferred, and only when all have
+// been tried others are used. Within the same priority servers are chosen at
+// random proportionally to their weight. Servers added using [Pool.Add]
+// have priority 0 and weight 1.
+func (p *Pool) AddWeighted(priority, weight uint16, servers ...string) error {
+	if weight == 0 {
+		return core.Wrap(core.ErrInvalid, "zero weight")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range servers {
+		s, err := exdns.AsServerAddress(s)
+		if err != nil {
+			return err
+		}
+
+		p.s[s] = s
+		p.w[s] = poolWeight{priority, weight}
+	}
+
+	return nil
+}
+
+func (p *Pool) getWeight(server string) poolWeight {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if w, ok := p.w[server]; ok {
+		return w
+	}
+	return defaultPoolWeight
+}
+
+// topTier returns the servers with the lowest priority value,
+// and their weights, reusing the given slice.
+func (p *Pool) topTier(servers []string) ([]string, []poolWeight) {
+	best := uint16(math.MaxUint16)
+	weights := make([]poolWeight, len(servers))
+	for i, s := range servers {
+		weights[i] = p.getWeight(s)
+		best = min(best, weights[i].priority)
+	}
+
+	var j int
+	for i, s := range servers {
+		if weights[i].priority == best {
+			servers[j], weights[j] = s, weights[i]
+			j++
+		}
+	}
+
+	return servers[:j], weights[:j]
+}
+
+// weightedRandom chooses a server at random proportionally to
+// their weights.
+func weightedRandom(servers []string, weights []poolWeight) string {
+	var total int
+	for _, w := range weights {
+		total += int(w.weight)
+	}
+
+	if total > 0 {
+		n := rand.Intn(total)
+		for i, w := range weights {
+			if n < int(w.weight) {
+				return servers[i]
+			}
+			n -= int(w.weight)
+		}
+	}
+
+	s, _ := core.SliceRandom(servers)
+	return s
+}
@@ -0,0 +1,185 @@
+package resolver
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/client"
+)
+
+const (
+	// DefaultResolvConf is the file read by [SystemResolvConf]
+	// on systems other than Windows.
+	DefaultResolvConf = "/etc/resolv.conf"
+
+	// DefaultResolvConfNDots is the ndots option used if not specified.
+	DefaultResolvConfNDots = 1
+	// DefaultResolvConfTimeout is the timeout option used if not specified.
+	DefaultResolvConfTimeout = 5 * time.Second
+	// DefaultResolvConfAttempts is the attempts option used if not specified.
+	DefaultResolvConfAttempts = 2
+)
+
+// limits applied to options, as glibc does.
+const (
+	maxResolvConfNDots    = 15
+	maxResolvConfTimeout  = 30 * time.Second
+	maxResolvConfAttempts = 5
+)
+
+// ResolvConf describes the system's resolver configuration,
+// as found in `resolv.conf`.
+type ResolvConf struct {
+	// Nameservers lists the recursive servers to use, in order.
+	Nameservers []string
+	// Search lists the domains tried for relative names.
+	Search []string
+	// NDots is how many dots a name needs to be tried as absolute
+	// before the Search domains.
+	NDots int
+	// Timeout is how long to wait for each server.
+	Timeout time.Duration
+	// Attempts is how many times each server is tried.
+	Attempts int
+	// Rotate indicates servers are chosen at random
+	// instead of in order.
+	Rotate bool
+}
+
+// ParseResolvConf reads a `resolv.conf` file. Unknown keywords
+// and options are ignored, and missing values take their defaults.
+// If no nameserver is listed, the local host is used.
+func ParseResolvConf(r io.Reader) (*ResolvConf, error) {
+	conf := &ResolvConf{
+		NDots:    DefaultResolvConfNDots,
+		Timeout:  DefaultResolvConfTimeout,
+		Attempts: DefaultResolvConfAttempts,
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		conf.parseLine(scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(conf.Nameservers) == 0 {
+		conf.Nameservers = []string{"127.0.0.1", "::1"}
+	}
+	return conf, nil
+}
+
+func (conf *ResolvConf) parseLine(line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0][0] == '#' || fields[0][0] == ';' {
+		return
+	}
+
+	switch fields[0] {
+	case "nameserver":
+		if len(fields) > 1 {
+			conf.Nameservers = append(conf.Nameservers, fields[1])
+		}
+	case "domain":
+		// the last of domain and search wins
+		conf.Search = fields[1:min(len(fields), 2)]
+	case "search":
+		conf.Search = fields[1:]
+	case "options":
+		for _, opt := range fields[1:] {
+			conf.parseOption(opt)
+		}
+	}
+}
+
+func (conf *ResolvConf) parseOption(opt string) {
+	name, value, _ := strings.Cut(opt, ":")
+	n, err := strconv.Atoi(value)
+
+	switch {
+	case name == "rotate":
+		conf.Rotate = true
+	case err != nil || n < 0:
+		// numeric options only
+	case name == "ndots":
+		conf.NDots = min(n, maxResolvConfNDots)
+	case name == "timeout" && n > 0:
+		conf.Timeout = min(time.Duration(n)*time.Second, maxResolvConfTimeout)
+	case name == "attempts" && n > 0:
+		conf.Attempts = min(n, maxResolvConfAttempts)
+	}
+}
+
+// ReadResolvConf reads a `resolv.conf` file by name.
+// See [ParseResolvConf] for details.
+func ReadResolvConf(filename string) (*ResolvConf, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseResolvConf(f)
+}
+
+// NewPool creates a [Pool] using the configured nameservers, tried
+// in order unless Rotate is set, each given Timeout and Attempts
+// opportunities.
+func (conf *ResolvConf) NewPool(c client.Client) (*Pool, error) {
+	if conf == nil || len(conf.Nameservers) == 0 {
+		return nil, core.Wrap(core.ErrInvalid, "no servers")
+	}
+
+	p, err := NewPoolExchanger(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range conf.Nameservers {
+		var priority uint16
+		if !conf.Rotate {
+			priority = uint16(i)
+		}
+
+		if err := p.AddWeighted(priority, 1, s); err != nil {
+			return nil, err
+		}
+	}
+
+	p.AttemptTimeout = conf.Timeout
+	p.Attempts = max(conf.Attempts, 1) * len(conf.Nameservers)
+	p.Failover = true
+	return p, nil
+}
+
+// NewResolver creates a [LookupResolver] using a [Pool] of the
+// configured nameservers and applying the configured search domains.
+func (conf *ResolvConf) NewResolver(c client.Client) (*LookupResolver, error) {
+	p, err := conf.NewPool(c)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewResolver(p)
+	r.SetSearch(conf.NDots, conf.Search...)
+	return r, nil
+}
+
+// NewSystemLookupResolver creates a [LookupResolver] behaving like
+// the system resolver as described by [SystemResolvConf], but
+// through this package.
+func NewSystemLookupResolver() (*LookupResolver, error) {
+	conf, err := SystemResolvConf()
+	if err != nil {
+		return nil, err
+	}
+	return conf.NewResolver(nil)
+}
@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want ResolvConf
+	}{
+		{"empty", "", ResolvConf{
+			Nameservers: []string{"127.0.0.1", "::1"},
+			NDots:       1,
+			Timeout:     5 * time.Second,
+			Attempts:    2,
+		}},
+		{"full", `# generated
+nameserver 192.0.2.1
+nameserver 2001:db8::1 ; trailing
+; nameserver 192.0.2.9
+search example.org example.net
+sortlist 192.0.2.0/24
+options ndots:2 timeout:3 attempts:4 rotate edns0
+`, ResolvConf{
+			Nameservers: []string{"192.0.2.1", "2001:db8::1"},
+			Search:      []string{"example.org", "example.net"},
+			NDots:       2,
+			Timeout:     3 * time.Second,
+			Attempts:    4,
+			Rotate:      true,
+		}},
+		{"domain after search", "nameserver 192.0.2.1\nsearch a.example\ndomain b.example\n", ResolvConf{
+			Nameservers: []string{"192.0.2.1"},
+			Search:      []string{"b.example"},
+			NDots:       1,
+			Timeout:     5 * time.Second,
+			Attempts:    2,
+		}},
+		{"limits", "nameserver 192.0.2.1\noptions ndots:99 timeout:60 attempts:9\n", ResolvConf{
+			Nameservers: []string{"192.0.2.1"},
+			NDots:       15,
+			Timeout:     30 * time.Second,
+			Attempts:    5,
+		}},
+		{"invalid options", "nameserver 192.0.2.1\noptions ndots:x timeout:0 attempts:-1\n", ResolvConf{
+			Nameservers: []string{"192.0.2.1"},
+			NDots:       1,
+			Timeout:     5 * time.Second,
+			Attempts:    2,
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseResolvConf(strings.NewReader(tc.conf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("got %+v, expected %+v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolvConfNewResolver(t *testing.T) {
+	var mu sync.Mutex
+	var tried []string
+
+	c := client.ExchangeFunc(func(_ context.Context, _ *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		tried = append(tried, server)
+		mu.Unlock()
+
+		return nil, 0, errors.ErrTimeout(server, nil)
+	})
+
+	conf := &ResolvConf{
+		Nameservers: []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		Search:      []string{"example.org"},
+		NDots:       1,
+		Timeout:     time.Second,
+		Attempts:    2,
+	}
+
+	r, err := conf.NewResolver(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := r.Search(); len(s) != 1 || s[0] != "example.org." {
+		t.Errorf("unexpected search list: %v", s)
+	}
+
+	_, _ = r.LookupRawRR(context.Background(), "host.example.com.", dns.TypeA, dns.ClassINET)
+
+	expected := []string{
+		"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53",
+		"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53",
+	}
+	if !reflect.DeepEqual(tried, expected) {
+		t.Errorf("tried %v, expected %v", tried, expected)
+	}
+}
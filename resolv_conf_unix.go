@@ -0,0 +1,10 @@
+//go:build !windows
+
+package resolver
+
+// SystemResolvConf returns the resolver configuration of the system,
+// read from [DefaultResolvConf]. On macOS this file reflects the
+// primary configuration of the system, but not the scoped resolvers.
+func SystemResolvConf() (*ResolvConf, error) {
+	return ReadResolvConf(DefaultResolvConf)
+}
@@ -0,0 +1,101 @@
+//go:build windows
+
+package resolver
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// SystemResolvConf returns the resolver configuration of the system,
+// assembled from the DNS servers and suffixes of the network adapters
+// that are up, and default options.
+func SystemResolvConf() (*ResolvConf, error) {
+	adapters, err := adapterAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &ResolvConf{
+		NDots:    DefaultResolvConfNDots,
+		Timeout:  DefaultResolvConfTimeout,
+		Attempts: DefaultResolvConfAttempts,
+	}
+
+	seen := make(map[string]bool)
+	for _, aa := range adapters {
+		if aa.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+
+		for dns := aa.FirstDnsServerAddress; dns != nil; dns = dns.Next {
+			ip := dns.Address.IP()
+			switch {
+			case ip == nil, ip.IsUnspecified():
+				// skip
+			case isWindowsDefaultSiteLocal(ip):
+				// deprecated fec0:0:0:ffff::{1,2,3} defaults
+			case !seen[ip.String()]:
+				seen[ip.String()] = true
+				conf.Nameservers = append(conf.Nameservers, ip.String())
+			}
+		}
+
+		if s := windows.UTF16PtrToString(aa.DnsSuffix); s != "" && !seen[s] {
+			seen[s] = true
+			conf.Search = append(conf.Search, s)
+		}
+	}
+
+	if len(conf.Nameservers) == 0 {
+		conf.Nameservers = []string{"127.0.0.1", "::1"}
+	}
+	return conf, nil
+}
+
+var windowsDefaultSiteLocal = netip.MustParsePrefix("fec0:0:0:ffff::/120")
+
+func isWindowsDefaultSiteLocal(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok || !windowsDefaultSiteLocal.Contains(addr) {
+		return false
+	}
+	n := addr.As16()[15]
+	return n >= 1 && n <= 3
+}
+
+// adapterAddresses returns the list of network adapters,
+// as done by the standard library.
+func adapterAddresses() ([]*windows.IpAdapterAddresses, error) {
+	var b []byte
+
+	l := uint32(15000) // recommended initial size
+	for {
+		b = make([]byte, l)
+		const flags = windows.GAA_FLAG_SKIP_UNICAST |
+			windows.GAA_FLAG_SKIP_ANYCAST |
+			windows.GAA_FLAG_SKIP_MULTICAST
+		err := windows.GetAdaptersAddresses(syscall.AF_UNSPEC, flags, 0,
+			(*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0])), &l)
+		if err == nil {
+			if l == 0 {
+				return nil, nil
+			}
+			break
+		}
+		if err.(syscall.Errno) != syscall.ERROR_BUFFER_OVERFLOW || l <= uint32(len(b)) {
+			return nil, os.NewSyscallError("getadaptersaddresses", err)
+		}
+	}
+
+	var out []*windows.IpAdapterAddresses
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0])); aa != nil; aa = aa.Next {
+		out = append(out, aa)
+	}
+	return out, nil
+}
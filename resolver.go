@@ -4,6 +4,7 @@ package resolver
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/miekg/dns"
 	"golang.org/x/net/idna"
@@ -42,6 +43,40 @@ type LookupResolver struct {
 	h      Lookuper
 	strict *idna.Profile
 	loose  *idna.Profile
+
+	// ImplicitMX makes LookupMX fall back to the addresses of the
+	// domain when it has no MX records, RFC 5321.
+	ImplicitMX bool
+	// RequireAuthenticatedTLSA makes LookupTLSA fail unless the
+	// response was DNSSEC-authenticated, carrying the AD bit.
+	RequireAuthenticatedTLSA bool
+	// AddressOrder determines how the addresses of a host are
+	// sorted, following RFC 6724 unless specified.
+	AddressOrder AddressOrder
+	// UnicodeOutput, if set, is the IDNA profile used to convert
+	// the names returned, like CNAME targets or MX and SRV hosts,
+	// back to Unicode for display. [idna.Display] validates the
+	// labels strictly, leaving as-is those that fail, while
+	// [idna.Punycode] converts them regardless.
+	UnicodeOutput *idna.Profile
+	// Timeout, if positive, limits how long lookups can take
+	// when the context given has no deadline.
+	Timeout time.Duration
+
+	search []string
+	ndots  int
+}
+
+// withTimeout applies the Timeout to contexts without deadline.
+func (r LookupResolver) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if _, ok := ctx.Deadline(); ok || r.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.Timeout)
 }
 
 // LookupAddr performs a reverse lookup for the given address, returning a
@@ -0,0 +1,143 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// caaFlagCritical is the Issuer Critical flag of CAA records.
+const caaFlagCritical = 128
+
+// CAA is a Certification Authority Authorization record, RFC 8659.
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// Critical tells if the Issuer Critical flag is set.
+func (c *CAA) Critical() bool {
+	return c.Flag&caaFlagCritical != 0
+}
+
+// LookupCAA returns the relevant CAA records for a domain, climbing
+// towards the root until a name with CAA records is found, as described
+// in RFC 8659 section 3, and the name they belong to.
+// No records and an empty name are returned if no CAA exists up the tree.
+func (r LookupResolver) LookupCAA(ctx context.Context, name string) ([]*CAA, string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	// a candidate without CAA up the tree fails, to try the next
+	v, err := withSearch(r, name, func(name string) (caaResult, error) {
+		return r.doLookupCAA(ctx, name)
+	})
+	switch {
+	case err == nil:
+		return v.records, v.name, nil
+	case errors.IsNotFound(err):
+		return nil, "", nil
+	default:
+		return nil, "", err
+	}
+}
+
+// caaResult is the outcome of [LookupResolver.doLookupCAA]
+type caaResult struct {
+	records []*CAA
+	name    string
+}
+
+func (r LookupResolver) doLookupCAA(ctx context.Context, name string) (caaResult, error) {
+	host, err := sanitiseHost(dns.Fqdn(name), r.loose)
+	if err != nil {
+		return caaResult{}, err
+	}
+
+	qName := dns.CanonicalName(host)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(qName, off) {
+		out, err := r.lookupCAA(ctx, qName[off:])
+		switch {
+		case err != nil:
+			return caaResult{}, err
+		case len(out) > 0:
+			return caaResult{out, exdns.Decanonize(qName[off:])}, nil
+		}
+	}
+
+	return caaResult{}, errors.ErrTypeNotFound(host)
+}
+
+func (r LookupResolver) lookupCAA(ctx context.Context, qName string) ([]*CAA, error) {
+	msg, err := r.h.Lookup(ctx, qName, dns.TypeCAA)
+	if err2 := errors.ValidateResponse("", msg, err); err2 != nil {
+		if err2.IsNotFound {
+			// NXDOMAIN and NODATA continue with the parent
+			return nil, nil
+		}
+		return nil, err2
+	}
+
+	var out []*CAA
+	exdns.ForEachAnswer(msg, func(rr *dns.CAA) {
+		if strings.EqualFold(rr.Hdr.Name, qName) {
+			out = append(out, &CAA{
+				Flag:  rr.Flag,
+				Tag:   strings.ToLower(rr.Tag),
+				Value: rr.Value,
+			})
+		}
+	})
+	return out, nil
+}
+
+// CAAPermits tells if a set of CAA records, as returned by
+// [LookupResolver.LookupCAA], allows the given issuer domain name
+// to issue certificates, for wildcard names if indicated.
+// Unknown critical tags forbid any issuance.
+func CAAPermits(records []*CAA, issuer string, wildcard bool) bool {
+	var issue, issueWild []*CAA
+
+	for _, c := range records {
+		switch c.Tag {
+		case "issue":
+			issue = append(issue, c)
+		case "issuewild":
+			issueWild = append(issueWild, c)
+		case "iodef", "contactemail", "contactphone", "issuemail", "issuevmc":
+			// not relevant
+		default:
+			if c.Critical() {
+				return false
+			}
+		}
+	}
+
+	set := issue
+	if wildcard && len(issueWild) > 0 {
+		set = issueWild
+	}
+	if len(set) == 0 {
+		return true
+	}
+
+	issuer = strings.ToLower(exdns.Decanonize(issuer))
+	for _, c := range set {
+		if caaIssuer(c.Value) == issuer {
+			return true
+		}
+	}
+	return false
+}
+
+// caaIssuer extracts the issuer domain name of an issue or
+// issuewild value, ignoring its parameters.
+func caaIssuer(value string) string {
+	s, _, _ := strings.Cut(value, ";")
+	return strings.ToLower(strings.TrimSpace(s))
+}
@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newTestZoneResolver(t *testing.T, records ...string) *LookupResolver {
+	z := newTestZone(t)
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Add(rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewResolver(z)
+}
+
+func TestLookupCAA(t *testing.T) {
+	r := newTestZoneResolver(t,
+		`example.org. 3600 IN CAA 0 issue "ca.example.net; account=1"`,
+		`example.org. 3600 IN CAA 0 issuewild ";"`,
+		`www.example.org. 3600 IN CAA 0 issue "other.example.net"`,
+		`strict.example.org. 3600 IN CAA 128 tbs "x"`,
+	)
+
+	tests := []struct {
+		name     string
+		owner    string
+		issuer   string
+		wildcard bool
+		permits  bool
+	}{
+		{"example.org", "example.org", "ca.example.net", false, true},
+		{"a.b.example.org", "example.org", "CA.example.net.", false, true},
+		{"a.b.example.org", "example.org", "ca.example.net", true, false},
+		{"www.example.org", "www.example.org", "ca.example.net", false, false},
+		{"www.example.org", "www.example.org", "other.example.net", false, true},
+		{"strict.example.org", "strict.example.org", "ca.example.net", false, false},
+	}
+
+	for _, tc := range tests {
+		records, owner, err := r.LookupCAA(context.Background(), tc.name)
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case owner != tc.owner:
+			t.Errorf("%s: expected records from %q, got %q", tc.name, tc.owner, owner)
+		case CAAPermits(records, tc.issuer, tc.wildcard) != tc.permits:
+			t.Errorf("%s: %s wildcard:%v expected %v", tc.name, tc.issuer,
+				tc.wildcard, tc.permits)
+		}
+	}
+
+	if !CAAPermits(nil, "ca.example.net", false) {
+		t.Error("no CAA records should permit any issuer")
+	}
+}
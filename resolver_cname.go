@@ -14,6 +14,17 @@ import (
 // LookupCNAME returns the final canonical name after following zero or
 // more CNAME records
 func (r LookupResolver) LookupCNAME(ctx context.Context,
+	host string) (string, error) {
+	//
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return withSearch(r, host, func(host string) (string, error) {
+		return r.lookupCNAME(ctx, host)
+	})
+}
+
+func (r LookupResolver) lookupCNAME(ctx context.Context,
 	host string) (string, error) {
 	//
 	host, err := sanitiseHost(host, r.strict)
@@ -25,7 +36,7 @@ func (r LookupResolver) LookupCNAME(ctx context.Context,
 	cname, e2 := r.doLookupCNAME(ctx, qName)
 	switch {
 	case e2 == nil:
-		return exdns.Decanonize(cname), nil
+		return r.toUnicode(exdns.Decanonize(cname)), nil
 	case !e2.IsNotFound:
 		return "", e2
 	}
@@ -37,7 +48,7 @@ func (r LookupResolver) LookupCNAME(ctx context.Context,
 	case err != nil:
 		return "", err
 	case len(addrs) > 0:
-		return host, nil
+		return r.toUnicode(host), nil
 	default:
 		return "", e2
 	}
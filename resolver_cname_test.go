@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 
+	"golang.org/x/net/idna"
+
 	"darvaza.org/resolver/pkg/exdns"
 )
 
@@ -44,3 +46,47 @@ func cnameInList(value string, options ...string) bool {
 	}
 	return false
 }
+
+func TestLookupUnicodeOutput(t *testing.T) {
+	r := newTestZoneResolver(t,
+		"shop.example.org. 3600 IN CNAME xn--bcher-kva.example.org.",
+		"xn--bcher-kva.example.org. 3600 IN A 192.0.2.4",
+		"example.org. 3600 IN MX 10 xn--strae-oqa.example.org.",
+		"_sip._tcp.example.org. 3600 IN SRV 0 0 5060 _bad.xn--bcher-kva.example.org.",
+	)
+
+	ctx := context.Background()
+	for _, profile := range []*idna.Profile{nil, idna.Display} {
+		r.UnicodeOutput = profile
+
+		cname, err := r.LookupCNAME(ctx, "shop.example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mxs, err := r.LookupMX(ctx, "example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, srvs, err := r.LookupSRV(ctx, "sip", "tcp", "example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"xn--bcher-kva.example.org", "xn--strae-oqa.example.org."}
+		if profile != nil {
+			want = []string{"bücher.example.org", "straße.example.org."}
+		}
+
+		switch {
+		case cname != want[0]:
+			t.Errorf("%v: unexpected CNAME %q", profile, cname)
+		case mxs[0].Host != want[1]:
+			t.Errorf("%v: unexpected MX %q", profile, mxs[0].Host)
+		case srvs[0].Target != "_bad.xn--bcher-kva.example.org":
+			// invalid labels are left as-is
+			t.Errorf("%v: unexpected SRV target %q", profile, srvs[0].Target)
+		}
+	}
+}
@@ -52,23 +52,25 @@ func (r LookupResolver) LookupNetIP(ctx context.Context,
 // in the form of a slice of net.IP.
 // The network must be one of "ip", "ip4" or "ip6".
 func (r LookupResolver) LookupIP(ctx context.Context,
-	network, host string) (s []net.IP, err error) {
+	network, host string) ([]net.IP, error) {
 	//
-	network, err = sanitiseNetwork(network)
+	network, err := sanitiseNetwork(network)
 	if err != nil {
 		return nil, err
 	}
 
-	host, err = sanitiseHost(host, r.strict)
-	if err != nil {
-		return nil, err
-	}
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	if ctx == nil {
-		ctx = context.Background()
-	}
+	return withSearch(r, host, func(name string) ([]net.IP, error) {
+		qHost, err := sanitiseHost(name, r.strict)
+		if err != nil {
+			return nil, err
+		}
 
-	return r.doLookupIP(ctx, network, host, true)
+		s, err := r.doLookupIP(ctx, network, qHost, true)
+		return r.sortIPs(s), err
+	})
 }
 
 func (r LookupResolver) doLookupIP(ctx context.Context,
@@ -102,7 +104,10 @@ func (r LookupResolver) goLookupIP(ctx context.Context,
 		defer wg.Done()
 		s2, e2 = r.goLookupIPq(ctx, qhost, dns.TypeAAAA, cname)
 	}()
-	wg.Wait()
+
+	if err := waitContext(ctx, &wg); err != nil {
+		return nil, errors.ErrTimeout(qhost, err)
+	}
 
 	s := append(s1, s2...)
 	switch {
@@ -139,7 +144,9 @@ func (r LookupResolver) goLookupIPq(ctx context.Context,
 		}()
 	}
 
-	wg.Wait()
+	if err := waitContext(ctx, &wg); err != nil {
+		return nil, errors.ErrTimeout(qHost, err)
+	}
 
 	s := append(s1, s2...)
 	switch {
@@ -158,6 +165,10 @@ func (r LookupResolver) goLookupIPq(ctx context.Context,
 func (r LookupResolver) lookupIPq(ctx context.Context,
 	qHost string, qType uint16) ([]net.IP, error) {
 	//
+	if err := ctx.Err(); err != nil {
+		return nil, errors.ErrTimeout(qHost, err)
+	}
+
 	msg, e1 := r.h.Lookup(ctx, qHost, qType)
 	s, e2 := msgToIPq(msg, qType)
 
@@ -4,6 +4,11 @@ import (
 	"context"
 	"net/netip"
 	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
 )
 
 func TestLookupNetIP(t *testing.T) {
@@ -93,3 +98,33 @@ func validateTestLookupNetIPResult(network string,
 		return "", true
 	}
 }
+
+func TestLookupResolverTimeout(t *testing.T) {
+	// a stuck upstream ignoring the context
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	r := NewResolver(LookuperFunc(func(context.Context, string, uint16) (*dns.Msg, error) {
+		<-stuck
+		return nil, nil
+	}))
+	r.Timeout = 20 * time.Millisecond
+
+	start := time.Now()
+	_, err := r.LookupIP(context.Background(), "ip", "www.example.org")
+	switch {
+	case !errors.IsTimeout(err):
+		t.Errorf("expected timeout, got %v", err)
+	case time.Since(start) > time.Second:
+		t.Errorf("took %v", time.Since(start))
+	}
+
+	// the caller's deadline takes precedence
+	r.Timeout = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := r.LookupIP(ctx, "ip4", "www.example.org"); !errors.IsTimeout(err) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
@@ -3,30 +3,123 @@ package resolver
 import (
 	"context"
 	"net"
+	"sort"
+
+	"github.com/miekg/dns"
 
 	"darvaza.org/resolver/pkg/errors"
 	"darvaza.org/resolver/pkg/exdns"
-	"github.com/miekg/dns"
 )
 
 // LookupMX returns the DNS MX records for the given domain name
-// sorted by preference
+// sorted by preference. Records whose target isn't a valid host
+// name are skipped, and domains publishing a null MX, RFC 7505,
+// fail with an error satisfying [errors.IsNullMX].
+// If ImplicitMX is set, domains without MX records but with addresses
+// get the domain itself as MX, as described in RFC 5321 section 5.1.
 func (r LookupResolver) LookupMX(ctx context.Context,
 	name string) ([]*net.MX, error) {
-	var netmxs []*net.MX
-	name = dns.Fqdn(name)
-	msg, err := r.h.Lookup(ctx, dns.CanonicalName(name), dns.TypeMX)
+	//
+	netmxs, err := r.lookupMX(ctx, name)
+	for _, mx := range netmxs {
+		mx.Host = r.toUnicode(mx.Host)
+	}
+	return netmxs, err
+}
+
+func (r LookupResolver) lookupMX(ctx context.Context,
+	name string) ([]*net.MX, error) {
+	//
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return withSearch(r, name, func(name string) ([]*net.MX, error) {
+		return r.doLookupMX(ctx, name)
+	})
+}
+
+func (r LookupResolver) doLookupMX(ctx context.Context,
+	name string) ([]*net.MX, error) {
+	//
+	host, err := sanitiseHost(dns.Fqdn(name), r.loose)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := r.h.Lookup(ctx, dns.CanonicalName(host), dns.TypeMX)
 	if err2 := errors.ValidateResponse("", msg, err); err2 != nil {
+		if r.ImplicitMX && err2.Err == errors.NODATA {
+			return r.lookupImplicitMX(ctx, host)
+		}
 		return nil, err2
 	}
 
+	netmxs, err := r.msgToMX(msg, host)
+	switch {
+	case err != nil:
+		return nil, err
+	case len(netmxs) == 0 && r.ImplicitMX:
+		return r.lookupImplicitMX(ctx, host)
+	case len(netmxs) == 0:
+		return nil, errors.ErrTypeNotFound(host)
+	default:
+		return netmxs, nil
+	}
+}
+
+func (r LookupResolver) msgToMX(msg *dns.Msg, host string) ([]*net.MX, error) {
+	var netmxs []*net.MX
+	var nullMX, invalid bool
+
 	exdns.ForEachAnswer(msg, func(rr *dns.MX) {
-		z := makeNetMX(rr)
-		if z != nil {
-			netmxs = append(netmxs, z)
+		switch {
+		case rr.Mx == ".":
+			nullMX = true
+		case !r.isValidMX(rr.Mx):
+			invalid = true
+		default:
+			netmxs = append(netmxs, makeNetMX(rr))
 		}
 	})
-	return netmxs, nil
+
+	switch {
+	case len(netmxs) > 0:
+		sort.SliceStable(netmxs, func(i, j int) bool {
+			return netmxs[i].Pref < netmxs[j].Pref
+		})
+		return netmxs, nil
+	case nullMX:
+		return nil, errors.ErrNullMX(host)
+	case invalid:
+		err := errors.ErrBadResponse()
+		err.Name = host
+		return nil, err
+	default:
+		return nil, nil
+	}
+}
+
+func (r LookupResolver) isValidMX(target string) bool {
+	if _, ok := dns.IsDomainName(target); !ok {
+		return false
+	}
+	_, err := sanitiseHost(target, r.strict)
+	return err == nil
+}
+
+// lookupImplicitMX returns the domain itself as MX if it has addresses.
+func (r LookupResolver) lookupImplicitMX(ctx context.Context,
+	host string) ([]*net.MX, error) {
+	//
+	addrs, err := r.doLookupIP(ctx, netIP4or6, host, true)
+	switch {
+	case len(addrs) > 0:
+		return []*net.MX{{Host: host, Pref: 0}}, nil
+	case err != nil:
+		return nil, err
+	default:
+		return nil, errors.ErrTypeNotFound(host)
+	}
 }
 
 func makeNetMX(d *dns.MX) *net.MX {
@@ -2,7 +2,12 @@ package resolver
 
 import (
 	"context"
+	"net"
 	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
 )
 
 // revive:disable:cognitive-complexity
@@ -39,3 +44,82 @@ func TestLookupMX(t *testing.T) {
 		}
 	}
 }
+
+func newTestRecordsLookuper(zone map[string][]string) Lookuper {
+	return LookuperFunc(func(_ context.Context, qName string, qType uint16) (*dns.Msg, error) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(qName, qType)
+		msg.Authoritative = true
+
+		records, ok := zone[qName]
+		if !ok {
+			msg.Rcode = dns.RcodeNameError
+			return msg, nil
+		}
+
+		for _, s := range records {
+			rr, err := dns.NewRR(qName + " 300 IN " + s)
+			if err != nil {
+				return nil, err
+			}
+			if rr.Header().Rrtype == qType {
+				msg.Answer = append(msg.Answer, rr)
+			}
+		}
+		return msg, nil
+	})
+}
+
+func TestLookupMXPolicy(t *testing.T) {
+	l := newTestRecordsLookuper(map[string][]string{
+		"sorted.example.": {"MX 20 mx2.example.", "MX 10 mx1.example.",
+			"MX 30 bad_name.example."},
+		"null.example.":     {"MX 0 ."},
+		"invalid.example.":  {"MX 10 -bad-.example."},
+		"implicit.example.": {"A 192.0.2.1"},
+		"empty.example.":    {"TXT hello"},
+	})
+
+	tests := []struct {
+		name     string
+		implicit bool
+		hosts    []string
+		check    func(error) bool
+	}{
+		{"sorted.example", false, []string{"mx1.example.", "mx2.example."}, nil},
+		{"null.example", false, nil, errors.IsNullMX},
+		{"invalid.example", false, nil, isBadResponse},
+		{"implicit.example", false, nil, errors.IsNotFound},
+		{"implicit.example", true, []string{"implicit.example."}, nil},
+		{"empty.example", true, nil, errors.IsNotFound},
+		{"missing.example", true, nil, errors.IsNotFound},
+	}
+
+	for _, tc := range tests {
+		r := NewResolver(l)
+		r.ImplicitMX = tc.implicit
+
+		mxs, err := r.LookupMX(context.Background(), tc.name)
+		switch {
+		case tc.check != nil:
+			if !tc.check(err) {
+				t.Errorf("%s: unexpected error %v", tc.name, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case len(mxs) != len(tc.hosts):
+			t.Errorf("%s: expected %v, got %d records", tc.name, tc.hosts, len(mxs))
+		default:
+			for i, mx := range mxs {
+				if mx.Host != tc.hosts[i] {
+					t.Errorf("%s: expected %v at %d, got %v", tc.name, tc.hosts[i], i, mx.Host)
+				}
+			}
+		}
+	}
+}
+
+func isBadResponse(err error) bool {
+	e, ok := err.(*net.DNSError)
+	return ok && e.Err == errors.BADRESPONSE
+}
@@ -0,0 +1,76 @@
+package resolver
+
+import (
+	"context"
+	"sort"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// NAPTR is a Naming Authority Pointer record, RFC 3403,
+// as used by SIP and ENUM.
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+// LookupNAPTR returns the NAPTR records of the given name, sorted
+// by order and preference. Records setting both a regular expression
+// and a replacement are invalid and skipped.
+func (r LookupResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return withSearch(r, name, func(name string) ([]*NAPTR, error) {
+		return r.doLookupNAPTR(ctx, name)
+	})
+}
+
+func (r LookupResolver) doLookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	host, err := sanitiseHost(dns.Fqdn(name), r.loose)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := r.h.Lookup(ctx, dns.CanonicalName(host), dns.TypeNAPTR)
+	if err2 := errors.ValidateResponse("", msg, err); err2 != nil {
+		return nil, err2
+	}
+
+	var out []*NAPTR
+	exdns.ForEachAnswer(msg, func(rr *dns.NAPTR) {
+		if rr.Regexp != "" && rr.Replacement != "." {
+			// RFC 3403, section 4.1
+			return
+		}
+
+		out = append(out, &NAPTR{
+			Order:       rr.Order,
+			Preference:  rr.Preference,
+			Flags:       rr.Flags,
+			Service:     rr.Service,
+			Regexp:      rr.Regexp,
+			Replacement: r.toUnicode(rr.Replacement),
+		})
+	})
+
+	if len(out) == 0 {
+		return nil, errors.ErrTypeNotFound(host)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.Preference < b.Preference
+	})
+	return out, nil
+}
@@ -0,0 +1,36 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestLookupNAPTR(t *testing.T) {
+	r := newTestZoneResolver(t,
+		`sip.example.org. 3600 IN NAPTR 100 20 "s" "SIP+D2T" "" _sip._tcp.example.org.`,
+		`sip.example.org. 3600 IN NAPTR 100 10 "s" "SIP+D2U" "" _sip._udp.example.org.`,
+		`sip.example.org. 3600 IN NAPTR 50 50 "s" "SIPS+D2T" "" _sips._tcp.example.org.`,
+		`sip.example.org. 3600 IN NAPTR 10 10 "u" "E2U+sip" "!^.*$!sip:a@b!" bad.example.org.`,
+	)
+
+	out, err := r.LookupNAPTR(context.Background(), "sip.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"SIPS+D2T", "SIP+D2U", "SIP+D2T"}
+	if len(out) != len(expected) {
+		t.Fatalf("expected %v, got %d records", expected, len(out))
+	}
+	for i, s := range expected {
+		if out[i].Service != s {
+			t.Errorf("expected %q at %d, got %q", s, i, out[i].Service)
+		}
+	}
+
+	if _, err := r.LookupNAPTR(context.Background(), "www.example.org"); !errors.IsNotFound(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package resolver
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+)
+
+// AddressOrder determines how LookupIP, LookupIPAddr and LookupNetIP
+// sort the addresses of a host.
+type AddressOrder int
+
+const (
+	// AddressOrderRFC6724 sorts the addresses following the RFC 6724
+	// destination address selection rules, using the source addresses
+	// the system would use to reach them.
+	AddressOrderRFC6724 AddressOrder = iota
+	// AddressOrderNone returns the IPv4 addresses before the IPv6 ones,
+	// in the order they were received.
+	AddressOrderNone
+	// AddressOrderPreferIPv4 sorts as [AddressOrderRFC6724] but all
+	// IPv4 addresses go first.
+	AddressOrderPreferIPv4
+	// AddressOrderPreferIPv6 sorts as [AddressOrderRFC6724] but all
+	// IPv6 addresses go first.
+	AddressOrderPreferIPv6
+	// AddressOrderInterleave sorts as [AddressOrderRFC6724] and then
+	// alternates the address families, starting with the preferred one,
+	// as expected by Happy Eyeballs, RFC 8305.
+	AddressOrderInterleave
+)
+
+// srcAddrFunc finds the source address used to reach a destination.
+// Replaceable for testing.
+var srcAddrFunc = systemSrcAddr
+
+func systemSrcAddr(dst netip.Addr) (netip.Addr, bool) {
+	// connecting a UDP socket sends nothing but chooses a route
+	c, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(dst, 9)))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	defer c.Close()
+
+	addr, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	src := addr.AddrPort().Addr().Unmap()
+	return src, src.IsValid()
+}
+
+// sortIPs sorts the addresses of a host as configured.
+func (r LookupResolver) sortIPs(ips []net.IP) []net.IP {
+	if r.AddressOrder == AddressOrderNone || len(ips) < 2 {
+		return ips
+	}
+
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+
+	addrs = SortAddrs(addrs, r.AddressOrder)
+
+	out := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		out = append(out, net.IP(addr.AsSlice()))
+	}
+	return out
+}
+
+// SortAddrs sorts a list of destination addresses following the
+// given [AddressOrder]. The slice is modified.
+func SortAddrs(addrs []netip.Addr, order AddressOrder) []netip.Addr {
+	if order == AddressOrderNone || len(addrs) < 2 {
+		return addrs
+	}
+
+	sortRFC6724(addrs)
+
+	switch order {
+	case AddressOrderPreferIPv4:
+		sort.SliceStable(addrs, func(i, j int) bool {
+			return addrs[i].Is4() && !addrs[j].Is4()
+		})
+	case AddressOrderPreferIPv6:
+		sort.SliceStable(addrs, func(i, j int) bool {
+			return !addrs[i].Is4() && addrs[j].Is4()
+		})
+	case AddressOrderInterleave:
+		addrs = interleaveAddrs(addrs)
+	}
+	return addrs
+}
+
+func interleaveAddrs(addrs []netip.Addr) []netip.Addr {
+	var first, second []netip.Addr
+
+	for _, addr := range addrs {
+		if addr.Is4() == addrs[0].Is4() {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+
+	out := addrs[:0]
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// rfc6724Info holds the attributes of a destination address
+// relevant to the sorting.
+type rfc6724Info struct {
+	dst    netip.Addr
+	src    netip.Addr
+	usable bool
+
+	dstPolicy rfc6724Policy
+	srcPolicy rfc6724Policy
+	dstScope  int
+	srcScope  int
+}
+
+func sortRFC6724(addrs []netip.Addr) {
+	infos := make([]rfc6724Info, len(addrs))
+	for i, dst := range addrs {
+		src, ok := srcAddrFunc(dst)
+		infos[i] = rfc6724Info{
+			dst:       dst,
+			src:       src,
+			usable:    ok,
+			dstPolicy: classifyRFC6724(dst),
+			srcPolicy: classifyRFC6724(src),
+			dstScope:  scopeRFC6724(dst),
+			srcScope:  scopeRFC6724(src),
+		}
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return lessRFC6724(&infos[i], &infos[j])
+	})
+
+	for i := range infos {
+		addrs[i] = infos[i].dst
+	}
+}
+
+// revive:disable:cognitive-complexity
+// revive:disable:cyclomatic
+func lessRFC6724(a, b *rfc6724Info) bool {
+	// revive:enable:cognitive-complexity
+	// revive:enable:cyclomatic
+	//
+	// applies the rules of RFC 6724 section 6 that don't
+	// require information the system doesn't expose.
+
+	// Rule 1: Avoid unusable destinations.
+	if a.usable != b.usable {
+		return a.usable
+	}
+	if !a.usable {
+		return false
+	}
+
+	// Rule 2: Prefer matching scope.
+	aMatch, bMatch := a.dstScope == a.srcScope, b.dstScope == b.srcScope
+	if aMatch != bMatch {
+		return aMatch
+	}
+
+	// Rule 5: Prefer matching label.
+	aMatch = a.dstPolicy.label == a.srcPolicy.label
+	bMatch = b.dstPolicy.label == b.srcPolicy.label
+	if aMatch != bMatch {
+		return aMatch
+	}
+
+	// Rule 6: Prefer higher precedence.
+	if a.dstPolicy.precedence != b.dstPolicy.precedence {
+		return a.dstPolicy.precedence > b.dstPolicy.precedence
+	}
+
+	// Rule 8: Prefer smaller scope.
+	if a.dstScope != b.dstScope {
+		return a.dstScope < b.dstScope
+	}
+
+	// Rule 9: Use longest matching prefix, within the same family.
+	if a.dst.Is4() == b.dst.Is4() {
+		la, lb := commonPrefixLen(a.src, a.dst), commonPrefixLen(b.src, b.dst)
+		if la != lb {
+			return la > lb
+		}
+	}
+
+	// Rule 10: Otherwise, leave the order unchanged.
+	return false
+}
+
+// rfc6724Policy is an entry of the default policy table.
+type rfc6724Policy struct {
+	prefix     netip.Prefix
+	precedence int
+	label      int
+}
+
+// rfc6724PolicyTable is the default policy table of RFC 6724
+// section 2.1, longest prefixes first.
+var rfc6724PolicyTable = []rfc6724Policy{
+	{netip.MustParsePrefix("::1/128"), 50, 0},
+	{netip.MustParsePrefix("::ffff:0:0/96"), 35, 4},
+	{netip.MustParsePrefix("::/96"), 1, 3},
+	{netip.MustParsePrefix("2001::/32"), 5, 5},
+	{netip.MustParsePrefix("2002::/16"), 30, 2},
+	{netip.MustParsePrefix("3ffe::/16"), 1, 12},
+	{netip.MustParsePrefix("fec0::/10"), 1, 11},
+	{netip.MustParsePrefix("fc00::/7"), 3, 13},
+	{netip.MustParsePrefix("::/0"), 40, 1},
+}
+
+func classifyRFC6724(addr netip.Addr) rfc6724Policy {
+	if !addr.IsValid() {
+		return rfc6724Policy{}
+	}
+
+	a16 := netip.AddrFrom16(addr.As16())
+	for _, p := range rfc6724PolicyTable {
+		if p.prefix.Contains(a16) {
+			return p
+		}
+	}
+	return rfc6724Policy{}
+}
+
+var siteLocalPrefix = netip.MustParsePrefix("fec0::/10")
+
+// Scopes of RFC 4291, as used by RFC 6724.
+const (
+	scopeLinkLocal = 0x2
+	scopeSiteLocal = 0x5
+	scopeGlobal    = 0xe
+)
+
+func scopeRFC6724(addr netip.Addr) int {
+	switch {
+	case !addr.IsValid():
+		return 0
+	case addr.IsMulticast() && addr.Is6():
+		return int(addr.As16()[1] & 0xf)
+	case addr.IsLoopback(), addr.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	case addr.Is6() && siteLocalPrefix.Contains(addr):
+		return scopeSiteLocal
+	default:
+		return scopeGlobal
+	}
+}
+
+func commonPrefixLen(a, b netip.Addr) int {
+	if !a.IsValid() || a.Is4() != b.Is4() {
+		return 0
+	}
+
+	ab, bb := a.AsSlice(), b.AsSlice()
+	if a.Is6() {
+		// RFC 6724 only compares the prefix, up to 64 bits
+		ab, bb = ab[:8], bb[:8]
+	}
+
+	var n int
+	for i := range ab {
+		x := ab[i] ^ bb[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return n
+}
@@ -0,0 +1,69 @@
+package resolver
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestSortAddrs(t *testing.T) {
+	defer func(fn func(netip.Addr) (netip.Addr, bool)) {
+		srcAddrFunc = fn
+	}(srcAddrFunc)
+
+	srcAddrFunc = func(dst netip.Addr) (netip.Addr, bool) {
+		switch {
+		case dst == netip.MustParseAddr("198.51.100.1"):
+			// no route
+			return netip.Addr{}, false
+		case dst.Is4():
+			return netip.MustParseAddr("192.0.2.100"), true
+		default:
+			return netip.MustParseAddr("2001:db8::100"), true
+		}
+	}
+
+	addrs := func(ss ...string) []netip.Addr {
+		out := make([]netip.Addr, len(ss))
+		for i, s := range ss {
+			out[i] = netip.MustParseAddr(s)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		order    AddressOrder
+		addrs    []netip.Addr
+		expected []netip.Addr
+	}{
+		{"none", AddressOrderNone,
+			addrs("192.0.2.1", "2001:db8::1"),
+			addrs("192.0.2.1", "2001:db8::1")},
+		{"rfc6724", AddressOrderRFC6724,
+			addrs("192.0.2.1", "2001:db8::1"),
+			addrs("2001:db8::1", "192.0.2.1")},
+		{"unusable", AddressOrderRFC6724,
+			addrs("198.51.100.1", "192.0.2.1"),
+			addrs("192.0.2.1", "198.51.100.1")},
+		{"prefix", AddressOrderRFC6724,
+			addrs("2001:db9::1", "2001:db8::1"),
+			addrs("2001:db8::1", "2001:db9::1")},
+		{"ipv4", AddressOrderPreferIPv4,
+			addrs("2001:db8::1", "192.0.2.1", "2001:db8::2"),
+			addrs("192.0.2.1", "2001:db8::1", "2001:db8::2")},
+		{"ipv6", AddressOrderPreferIPv6,
+			addrs("198.51.100.1", "192.0.2.1", "2001:db8::1"),
+			addrs("2001:db8::1", "192.0.2.1", "198.51.100.1")},
+		{"interleave", AddressOrderInterleave,
+			addrs("192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2", "2001:db8::3"),
+			addrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "2001:db8::3")},
+	}
+
+	for _, tc := range tests {
+		out := SortAddrs(tc.addrs, tc.order)
+		if !reflect.DeepEqual(out, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, out)
+		}
+	}
+}
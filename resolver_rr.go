@@ -0,0 +1,111 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// LookupRawRR returns the records of the given type and class of
+// a name, or all of them for ANY. Classes other than INET require
+// the [Lookuper] to also be an [Exchanger].
+func (r LookupResolver) LookupRawRR(ctx context.Context,
+	name string, qType, qClass uint16) ([]dns.RR, error) {
+	//
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return withSearch(r, name, func(name string) ([]dns.RR, error) {
+		return r.doLookupRawRR(ctx, name, qType, qClass)
+	})
+}
+
+func (r LookupResolver) doLookupRawRR(ctx context.Context,
+	name string, qType, qClass uint16) ([]dns.RR, error) {
+	//
+	host, err := sanitiseHost(dns.Fqdn(name), r.loose)
+	if err != nil {
+		return nil, err
+	}
+
+	qName := dns.CanonicalName(host)
+	msg, err := r.lookupRawRR(ctx, qName, qType, qClass)
+	if err2 := errors.ValidateResponse("", msg, err); err2 != nil {
+		return nil, err2
+	}
+
+	var out []dns.RR
+	for _, rr := range msg.Answer {
+		hdr := rr.Header()
+		switch {
+		case hdr.Class != qClass && qClass != dns.ClassANY:
+			// wrong class
+		case hdr.Rrtype == qType, qType == dns.TypeANY:
+			out = append(out, rr)
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, errors.ErrTypeNotFound(qName)
+	}
+	return out, nil
+}
+
+func (r LookupResolver) lookupRawRR(ctx context.Context,
+	qName string, qType, qClass uint16) (*dns.Msg, error) {
+	//
+	if qClass == dns.ClassINET {
+		return r.h.Lookup(ctx, qName, qType)
+	}
+
+	e, ok := r.h.(Exchanger)
+	if !ok {
+		return nil, errors.ErrNotImplemented(qName)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(qName, qType)
+	req.Question[0].Qclass = qClass
+	return e.Exchange(ctx, req)
+}
+
+// LookupRR returns the INET records of a name of the type
+// given as type parameter, like *dns.A or *dns.TLSA.
+func LookupRR[T dns.RR](ctx context.Context, r *LookupResolver, name string) ([]T, error) {
+	qType, ok := rrType[T]()
+	if !ok || r == nil {
+		return nil, core.ErrInvalid
+	}
+
+	records, err := r.LookupRawRR(ctx, name, qType, dns.ClassINET)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]T, 0, len(records))
+	for _, rr := range records {
+		if v, ok := rr.(T); ok {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// rrType finds the RR type implemented by the given Go type,
+// which must be a concrete one.
+func rrType[T dns.RR]() (uint16, bool) {
+	var out uint16
+	var matches int
+
+	for qType, fn := range dns.TypeToRR {
+		if _, ok := fn().(T); ok {
+			out = qType
+			matches++
+		}
+	}
+	return out, matches == 1
+}
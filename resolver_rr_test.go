@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestLookupRR(t *testing.T) {
+	r := newTestZoneResolver(t,
+		`www.example.org. 3600 IN TXT "hello"`,
+	)
+	ctx := context.Background()
+
+	addrs, err := LookupRR[*dns.A](ctx, r, "www.example.org")
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(addrs) != 1 || addrs[0].A.String() != "192.0.2.2":
+		t.Errorf("unexpected A records: %v", addrs)
+	}
+
+	txts, err := LookupRR[*dns.TXT](ctx, r, "www.example.org")
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(txts) != 1 || txts[0].Txt[0] != "hello":
+		t.Errorf("unexpected TXT records: %v", txts)
+	}
+
+	if _, err := LookupRR[*dns.MX](ctx, r, "www.example.org"); !errors.IsNotFound(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := LookupRR[dns.RR](ctx, r, "www.example.org"); err != core.ErrInvalid {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	all, err := r.LookupRawRR(ctx, "www.example.org", dns.TypeANY, dns.ClassINET)
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(all) != 2:
+		t.Errorf("unexpected ANY records: %v", all)
+	}
+
+	_, err = r.LookupRawRR(ctx, "www.example.org", dns.TypeTXT, dns.ClassCHAOS)
+	if err == nil {
+		t.Error("CHAOS lookup didn't fail")
+	}
+}
@@ -0,0 +1,68 @@
+package resolver
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+// SetSearch sets the domains tried, in order, for relative names, and
+// how many dots a name needs to be tried as absolute before them, like
+// the search and ndots options of resolv.conf. Names ending with a dot
+// are always absolute.
+func (r *LookupResolver) SetSearch(ndots int, domains ...string) {
+	search := make([]string, 0, len(domains))
+	for _, s := range domains {
+		if s = strings.Trim(s, "."); s != "" {
+			search = append(search, dns.Fqdn(s))
+		}
+	}
+
+	r.search = search
+	r.ndots = ndots
+}
+
+// Search returns the domains tried for relative names.
+func (r LookupResolver) Search() []string {
+	return append([]string(nil), r.search...)
+}
+
+// searchNames returns the names to try, in order, for a given name.
+func (r LookupResolver) searchNames(name string) []string {
+	if len(r.search) == 0 || name == "" || strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	out := make([]string, 0, len(r.search)+1)
+	for _, suffix := range r.search {
+		out = append(out, name+"."+suffix)
+	}
+
+	absolute := dns.Fqdn(name)
+	if strings.Count(name, ".") >= r.ndots {
+		return append([]string{absolute}, out...)
+	}
+	return append(out, absolute)
+}
+
+// withSearch calls a function for each name to try until one succeeds
+// or times out. Otherwise the error of the first candidate is returned.
+func withSearch[T any](r LookupResolver, name string, fn func(string) (T, error)) (T, error) {
+	var zero T
+	var firstErr error
+
+	for _, s := range r.searchNames(name) {
+		v, err := fn(s)
+		switch {
+		case err == nil:
+			return v, nil
+		case errors.IsTimeout(err):
+			return zero, err
+		case firstErr == nil:
+			firstErr = err
+		}
+	}
+	return zero, firstErr
+}
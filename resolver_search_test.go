@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSearchNames(t *testing.T) {
+	var r LookupResolver
+	r.SetSearch(2, "corp.example.", "example.org")
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"www", []string{"www.corp.example.", "www.example.org.", "www."}},
+		{"a.b", []string{"a.b.corp.example.", "a.b.example.org.", "a.b."}},
+		{"a.b.c", []string{"a.b.c.", "a.b.c.corp.example.", "a.b.c.example.org."}},
+		{"www.example.org.", []string{"www.example.org."}},
+	}
+
+	for _, tc := range tests {
+		if out := r.searchNames(tc.name); !reflect.DeepEqual(out, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, out)
+		}
+	}
+}
+
+func TestLookupIPSearch(t *testing.T) {
+	r := newTestZoneResolver(t)
+	r.AddressOrder = AddressOrderNone
+	r.SetSearch(1, "sub.example.org", "example.org")
+
+	for _, name := range []string{"www", "www.example.org"} {
+		ips, err := r.LookupIP(context.Background(), "ip4", name)
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", name, err)
+		case len(ips) != 1 || ips[0].String() != "192.0.2.2":
+			t.Errorf("%s: unexpected addresses %v", name, ips)
+		}
+	}
+
+	records, err := r.LookupRawRR(context.Background(), "a.b", dns.TypeTXT, dns.ClassINET)
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(records) != 1:
+		t.Errorf("unexpected records %v", records)
+	}
+
+	if _, err := r.LookupIP(context.Background(), "ip4", "www."); err == nil {
+		t.Error("absolute name was searched")
+	}
+}
+
+func TestLookupSearch(t *testing.T) {
+	r := newTestZoneResolver(t,
+		"mx.example.org. 3600 IN MX 10 www.example.org.",
+		"_sip._tcp.srv.example.org. 3600 IN SRV 0 0 5060 www.example.org.",
+	)
+	r.SetSearch(1, "sub.example.org", "example.org")
+	ctx := context.Background()
+
+	txt, err := r.LookupTXT(ctx, "a.b")
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(txt) != 1 || txt[0] != "deep":
+		t.Errorf("unexpected TXT %q", txt)
+	}
+
+	cname, err := r.LookupCNAME(ctx, "alias")
+	switch {
+	case err != nil:
+		t.Error(err)
+	case cname != "www.example.org":
+		t.Errorf("unexpected CNAME %q", cname)
+	}
+
+	mx, err := r.LookupMX(ctx, "mx")
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(mx) != 1 || mx[0].Host != "www.example.org.":
+		t.Errorf("unexpected MX %v", mx)
+	}
+
+	_, srv, err := r.LookupSRV(ctx, "sip", "tcp", "srv")
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(srv) != 1 || srv[0].Port != 5060:
+		t.Errorf("unexpected SRV %v", srv)
+	}
+
+	if _, err := r.LookupTXT(ctx, "a.b."); err == nil {
+		t.Error("absolute name was searched")
+	}
+}
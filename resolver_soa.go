@@ -0,0 +1,75 @@
+package resolver
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// SOA describes the Start of Authority of a zone.
+type SOA struct {
+	// Zone is the name of the apex of the zone.
+	Zone    string
+	NS      string
+	Mbox    string
+	Serial  uint32
+	Refresh time.Duration
+	Retry   time.Duration
+	Expire  time.Duration
+	MinTTL  time.Duration
+}
+
+// LookupSOA returns the SOA of the zone containing the given name.
+// Names other than the apex get it from the authority section of
+// the negative response.
+func (r LookupResolver) LookupSOA(ctx context.Context, name string) (*SOA, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return withSearch(r, name, func(name string) (*SOA, error) {
+		return r.doLookupSOA(ctx, name)
+	})
+}
+
+func (r LookupResolver) doLookupSOA(ctx context.Context, name string) (*SOA, error) {
+	host, err := sanitiseHost(dns.Fqdn(name), r.loose)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := r.h.Lookup(ctx, dns.CanonicalName(host), dns.TypeSOA)
+	if err2 := errors.ValidateResponse("", msg, err); err2 != nil {
+		if !err2.IsNotFound || msg == nil {
+			return nil, err2
+		}
+	}
+
+	var soa *dns.SOA
+	exdns.ForEachAnswer(msg, func(rr *dns.SOA) {
+		soa = core.Coalesce(soa, rr)
+	})
+	exdns.ForEachRR(msg.Ns, func(rr *dns.SOA) {
+		soa = core.Coalesce(soa, rr)
+	})
+
+	if soa == nil {
+		return nil, errors.ErrTypeNotFound(host)
+	}
+
+	return &SOA{
+		Zone:    r.toUnicode(soa.Hdr.Name),
+		NS:      r.toUnicode(soa.Ns),
+		Mbox:    soa.Mbox,
+		Serial:  soa.Serial,
+		Refresh: time.Duration(soa.Refresh) * time.Second,
+		Retry:   time.Duration(soa.Retry) * time.Second,
+		Expire:  time.Duration(soa.Expire) * time.Second,
+		MinTTL:  time.Duration(soa.Minttl) * time.Second,
+	}, nil
+}
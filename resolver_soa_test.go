@@ -0,0 +1,29 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestLookupSOA(t *testing.T) {
+	r := newTestZoneResolver(t)
+
+	for _, name := range []string{"example.org", "www.example.org", "a.b.example.org."} {
+		soa, err := r.LookupSOA(context.Background(), name)
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", name, err)
+		case soa.Zone != "example.org." || soa.Serial != 1 ||
+			soa.NS != "ns.example.org." || soa.MinTTL != 300*time.Second:
+			t.Errorf("%s: unexpected SOA %+v", name, soa)
+		}
+	}
+
+	if _, err := r.LookupSOA(context.Background(), "example.com"); err == nil ||
+		errors.IsTimeout(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -16,39 +16,37 @@ import (
 func (r LookupResolver) LookupSRV(ctx context.Context,
 	service, proto, name string) (string, []*net.SRV, error) {
 	//
-	if ctx == nil {
-		ctx = context.Background()
-	}
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	target, err := r.sanitiseTargetSRV(service, proto, name)
+	target, err := targetSRV(service, proto, name)
 	if err != nil {
 		return "", nil, err
 	}
 
-	netsrvs, err := r.doLookupSRV(ctx, target)
+	netsrvs, err := withSearch(r, target, func(target string) ([]*net.SRV, error) {
+		host, err := sanitiseHost(dns.Fqdn(target), r.loose)
+		if err != nil {
+			return nil, err
+		}
+		return r.doLookupSRV(ctx, host)
+	})
+	for _, srv := range netsrvs {
+		srv.Target = r.toUnicode(srv.Target)
+	}
 	return exdns.Decanonize(name), netsrvs, err
 }
 
-func (r LookupResolver) sanitiseTargetSRV(service, proto, name string) (string, error) {
-	var target string
-	var err error
-
+func targetSRV(service, proto, name string) (string, error) {
 	switch {
 	case service != "" && proto != "":
-		target = "_" + service + "._" + proto + "." + name
+		return "_" + service + "._" + proto + "." + name, nil
 	case service != "":
-		target = "_" + service + "." + name
+		return "_" + service + "." + name, nil
 	case proto != "":
-		err = fmt.Errorf("%q: proto (%q) can't be specified without service for SRV", name, proto)
-	default:
-		target = name
-	}
-
-	switch {
-	case err != nil:
-		return "", err
+		return "", fmt.Errorf("%q: proto (%q) can't be specified without service for SRV", name, proto)
 	default:
-		return sanitiseHost(dns.Fqdn(target), r.loose)
+		return name, nil
 	}
 }
 
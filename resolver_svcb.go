@@ -0,0 +1,150 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sort"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// SVCB describes a SVCB or HTTPS record, RFC 9460, with its
+// service parameters parsed.
+type SVCB struct {
+	// Priority is zero for AliasMode records, which only
+	// point to another name to be looked up instead.
+	Priority uint16
+	// Target is the name of the alternative endpoint, the owner
+	// of the record when it was "." on a ServiceMode record.
+	Target string
+
+	// ALPN lists the protocols supported by the endpoint,
+	// and NoDefaultALPN excludes the default one of the scheme.
+	ALPN          []string
+	NoDefaultALPN bool
+	// Port is the port of the endpoint, or zero if not specified.
+	Port uint16
+	// IPv4Hint and IPv6Hint are addresses of the Target that can
+	// be used while they are being resolved.
+	IPv4Hint []netip.Addr
+	IPv6Hint []netip.Addr
+	// ECH is the encoded ECHConfigList, for TLS Encrypted Client Hello.
+	ECH []byte
+	// DoHPath is the URI template of DNS-over-HTTPS endpoints, RFC 9461.
+	DoHPath string
+}
+
+// IsAlias tells if the record is in AliasMode.
+func (s *SVCB) IsAlias() bool {
+	return s.Priority == 0
+}
+
+// LookupSVCB returns the SVCB records of the given name, sorted by
+// priority, AliasMode first. For services other than HTTPS the name
+// is usually prefixed with the port and scheme, like "_dns.example.org"
+// or "_8443._foo.example.org".
+func (r LookupResolver) LookupSVCB(ctx context.Context, name string) ([]*SVCB, error) {
+	return r.lookupSVCB(ctx, name, dns.TypeSVCB)
+}
+
+// LookupHTTPS returns the HTTPS records of the given name, sorted by
+// priority, AliasMode first. Origins on ports other than 443 use
+// names like "_8443._https.example.org".
+func (r LookupResolver) LookupHTTPS(ctx context.Context, name string) ([]*SVCB, error) {
+	return r.lookupSVCB(ctx, name, dns.TypeHTTPS)
+}
+
+func (r LookupResolver) lookupSVCB(ctx context.Context,
+	name string, qType uint16) ([]*SVCB, error) {
+	//
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return withSearch(r, name, func(name string) ([]*SVCB, error) {
+		return r.doLookupSVCB(ctx, name, qType)
+	})
+}
+
+func (r LookupResolver) doLookupSVCB(ctx context.Context,
+	name string, qType uint16) ([]*SVCB, error) {
+	//
+	host, err := sanitiseHost(dns.Fqdn(name), r.loose)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := r.h.Lookup(ctx, dns.CanonicalName(host), qType)
+	if err2 := errors.ValidateResponse("", msg, err); err2 != nil {
+		return nil, err2
+	}
+
+	var out []*SVCB
+	exdns.ForEachAnswer(msg, func(rr *dns.SVCB) {
+		out = append(out, makeSVCB(rr))
+	})
+	exdns.ForEachAnswer(msg, func(rr *dns.HTTPS) {
+		out = append(out, makeSVCB(&rr.SVCB))
+	})
+
+	for _, s := range out {
+		s.Target = r.toUnicode(s.Target)
+	}
+
+	if len(out) == 0 {
+		return nil, errors.ErrTypeNotFound(host)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Priority < out[j].Priority
+	})
+	return out, nil
+}
+
+func makeSVCB(rr *dns.SVCB) *SVCB {
+	s := &SVCB{
+		Priority: rr.Priority,
+		Target:   rr.Target,
+	}
+
+	if s.Target == "." && !s.IsAlias() {
+		s.Target = rr.Hdr.Name
+	}
+
+	if s.IsAlias() {
+		// parameters are meaningless on AliasMode
+		return s
+	}
+
+	for _, kv := range rr.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			s.ALPN = append(s.ALPN, v.Alpn...)
+		case *dns.SVCBNoDefaultAlpn:
+			s.NoDefaultALPN = true
+		case *dns.SVCBPort:
+			s.Port = v.Port
+		case *dns.SVCBIPv4Hint:
+			s.IPv4Hint = appendNetIPs(s.IPv4Hint, v.Hint)
+		case *dns.SVCBIPv6Hint:
+			s.IPv6Hint = appendNetIPs(s.IPv6Hint, v.Hint)
+		case *dns.SVCBECHConfig:
+			s.ECH = append([]byte(nil), v.ECH...)
+		case *dns.SVCBDoHPath:
+			s.DoHPath = v.Template
+		}
+	}
+	return s
+}
+
+func appendNetIPs(out []netip.Addr, ips []net.IP) []netip.Addr {
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			out = append(out, addr.Unmap())
+		}
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestLookupHTTPS(t *testing.T) {
+	l := newTestRecordsLookuper(map[string][]string{
+		"example.org.": {
+			`HTTPS 2 . alpn="h2" ipv6hint=2001:db8::1`,
+			`HTTPS 1 svc.example.org. alpn="h3,h2" port=8443 ipv4hint=192.0.2.1 ech=AAE=`,
+		},
+		"alias.example.org.": {"HTTPS 0 example.org."},
+		"_dns.example.org.":  {`SVCB 1 dns.example.org. alpn="h2" dohpath="/q{?dns}"`},
+	})
+	r := NewResolver(l)
+	ctx := context.Background()
+
+	out, err := r.LookupHTTPS(ctx, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*SVCB{
+		{
+			Priority: 1,
+			Target:   "svc.example.org.",
+			ALPN:     []string{"h3", "h2"},
+			Port:     8443,
+			IPv4Hint: []netip.Addr{netip.MustParseAddr("192.0.2.1")},
+			ECH:      []byte{0, 1},
+		},
+		{
+			Priority: 2,
+			Target:   "example.org.",
+			ALPN:     []string{"h2"},
+			IPv6Hint: []netip.Addr{netip.MustParseAddr("2001:db8::1")},
+		},
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("unexpected records: %+v %+v", out[0], out[1])
+	}
+
+	out, err = r.LookupHTTPS(ctx, "alias.example.org")
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(out) != 1 || !out[0].IsAlias() || out[0].Target != "example.org.":
+		t.Errorf("unexpected alias: %+v", out)
+	}
+
+	out, err = r.LookupSVCB(ctx, "_dns.example.org")
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(out) != 1 || out[0].DoHPath != "/q{?dns}":
+		t.Errorf("unexpected SVCB: %+v", out)
+	}
+
+	if _, err := r.LookupSVCB(ctx, "example.org"); !errors.IsNotFound(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// TLSA certificate usages, RFC 6698 and RFC 7218.
+const (
+	TLSAUsagePKIXTA = 0
+	TLSAUsagePKIXEE = 1
+	TLSAUsageDANETA = 2
+	TLSAUsageDANEEE = 3
+)
+
+// TLSA selectors.
+const (
+	TLSASelectorCert = 0
+	TLSASelectorSPKI = 1
+)
+
+// TLSA matching types.
+const (
+	TLSAMatchFull   = 0
+	TLSAMatchSHA256 = 1
+	TLSAMatchSHA512 = 2
+)
+
+// ErrTLSAMismatch indicates a certificate chain doesn't match
+// any of the given TLSA records.
+var ErrTLSAMismatch = errors.New("certificate doesn't match any TLSA record")
+
+// TLSA is a validated TLSA record, RFC 6698.
+type TLSA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	// Data is the certificate association data, decoded.
+	Data []byte
+}
+
+// LookupTLSA returns the valid TLSA records of a TLS service,
+// like port 25 and proto "tcp" for SMTP. Records with unknown
+// parameters or malformed data are skipped.
+// If RequireAuthenticatedTLSA is set, responses without the AD bit
+// fail with [errors.UNAUTHENTICATED].
+func (r LookupResolver) LookupTLSA(ctx context.Context,
+	port uint16, proto, host string) ([]*TLSA, error) {
+	//
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	name := "_" + strconv.Itoa(int(port)) + "._" + proto + "." + host
+	return withSearch(r, name, func(name string) ([]*TLSA, error) {
+		return r.doLookupTLSA(ctx, name)
+	})
+}
+
+func (r LookupResolver) doLookupTLSA(ctx context.Context, name string) ([]*TLSA, error) {
+	host, err := sanitiseHost(dns.Fqdn(name), r.loose)
+	if err != nil {
+		return nil, err
+	}
+
+	qName := dns.CanonicalName(host)
+	msg, err := r.lookupTLSA(ctx, qName)
+	if err2 := errors.ValidateResponse("", msg, err); err2 != nil {
+		return nil, err2
+	}
+
+	if r.RequireAuthenticatedTLSA && !msg.AuthenticatedData {
+		return nil, errors.ErrUnauthenticated(qName, "")
+	}
+
+	var out []*TLSA
+	exdns.ForEachAnswer(msg, func(rr *dns.TLSA) {
+		if v, ok := makeTLSA(rr); ok {
+			out = append(out, v)
+		}
+	})
+
+	if len(out) == 0 {
+		return nil, errors.ErrTypeNotFound(qName)
+	}
+	return out, nil
+}
+
+// lookupTLSA asks for the AD bit when the Lookuper is also an [Exchanger].
+func (r LookupResolver) lookupTLSA(ctx context.Context, qName string) (*dns.Msg, error) {
+	if e, ok := r.h.(Exchanger); ok && r.RequireAuthenticatedTLSA {
+		req := new(dns.Msg)
+		req.SetQuestion(qName, dns.TypeTLSA)
+		req.AuthenticatedData = true
+		return e.Exchange(ctx, req)
+	}
+
+	return r.h.Lookup(ctx, qName, dns.TypeTLSA)
+}
+
+func makeTLSA(rr *dns.TLSA) (*TLSA, bool) {
+	data, err := hex.DecodeString(rr.Certificate)
+	switch {
+	case err != nil, len(data) == 0,
+		rr.Usage > TLSAUsageDANEEE,
+		rr.Selector > TLSASelectorSPKI:
+		return nil, false
+	}
+
+	switch rr.MatchingType {
+	case TLSAMatchFull:
+	case TLSAMatchSHA256:
+		if len(data) != sha256.Size {
+			return nil, false
+		}
+	case TLSAMatchSHA512:
+		if len(data) != sha512.Size {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	return &TLSA{
+		Usage:        rr.Usage,
+		Selector:     rr.Selector,
+		MatchingType: rr.MatchingType,
+		Data:         data,
+	}, true
+}
+
+// Matches tells if a certificate matches the association data
+// of the record, ignoring the usage.
+func (t *TLSA) Matches(cert *x509.Certificate) bool {
+	var b []byte
+	switch t.Selector {
+	case TLSASelectorCert:
+		b = cert.Raw
+	case TLSASelectorSPKI:
+		b = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+
+	switch t.MatchingType {
+	case TLSAMatchFull:
+		return bytes.Equal(b, t.Data)
+	case TLSAMatchSHA256:
+		sum := sha256.Sum256(b)
+		return bytes.Equal(sum[:], t.Data)
+	case TLSAMatchSHA512:
+		sum := sha512.Sum512(b)
+		return bytes.Equal(sum[:], t.Data)
+	default:
+		return false
+	}
+}
+
+// VerifyTLSA checks the certificates presented on a TLS connection
+// against TLSA records, RFC 7671. PKIX usages also require the chain
+// to have been verified by the [tls.Config], while DANE-TA requires
+// the chain to lead to the matching certificate.
+// [ErrTLSAMismatch] is returned if no record matches.
+func VerifyTLSA(state tls.ConnectionState, records []*TLSA) error {
+	certs := state.PeerCertificates
+	if len(certs) == 0 {
+		return core.Wrap(ErrTLSAMismatch, "no peer certificates")
+	}
+
+	for _, t := range records {
+		if t != nil && verifyTLSA(&state, t) {
+			return nil
+		}
+	}
+	return ErrTLSAMismatch
+}
+
+func verifyTLSA(state *tls.ConnectionState, t *TLSA) bool {
+	leaf := state.PeerCertificates[0]
+
+	switch t.Usage {
+	case TLSAUsageDANEEE:
+		return t.Matches(leaf)
+	case TLSAUsagePKIXEE:
+		return len(state.VerifiedChains) > 0 && t.Matches(leaf)
+	case TLSAUsagePKIXTA:
+		for _, chain := range state.VerifiedChains {
+			for _, cert := range chain[1:] {
+				if t.Matches(cert) {
+					return true
+				}
+			}
+		}
+	case TLSAUsageDANETA:
+		return verifyDANETA(state, t)
+	}
+	return false
+}
+
+func verifyDANETA(state *tls.ConnectionState, t *TLSA) bool {
+	certs := state.PeerCertificates
+	for i, ta := range certs[1:] {
+		if !t.Matches(ta) {
+			continue
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ta)
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1 : i+1] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
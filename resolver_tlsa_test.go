@@ -0,0 +1,114 @@
+package resolver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func newTestCert(t *testing.T, name string, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	//
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestVerifyTLSA(t *testing.T) {
+	ca, caKey := newTestCert(t, "ca.example.org", nil, nil)
+	leaf, _ := newTestCert(t, "mail.example.org", ca, caKey)
+	other, _ := newTestCert(t, "other.example.org", nil, nil)
+
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, ca}}
+
+	tests := []struct {
+		name   string
+		state  tls.ConnectionState
+		record TLSA
+		ok     bool
+	}{
+		{"DANE-EE SPKI", state, TLSA{3, 1, 1, spki[:]}, true},
+		{"DANE-EE full", state, TLSA{3, 0, 0, leaf.Raw}, true},
+		{"DANE-EE other", state, TLSA{3, 0, 0, other.Raw}, false},
+		{"PKIX-EE unverified", state, TLSA{1, 1, 1, spki[:]}, false},
+		{"DANE-TA", state, TLSA{2, 0, 0, ca.Raw}, true},
+		{"DANE-TA leaf", state, TLSA{2, 0, 0, leaf.Raw}, false},
+		{"DANE-TA broken chain", tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{leaf, other},
+		}, TLSA{2, 0, 0, other.Raw}, false},
+	}
+
+	for _, tc := range tests {
+		err := VerifyTLSA(tc.state, []*TLSA{&tc.record})
+		switch {
+		case tc.ok && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case !tc.ok && err == nil:
+			t.Errorf("%s: unexpected match", tc.name)
+		}
+	}
+}
+
+func TestLookupTLSA(t *testing.T) {
+	hash := hex.EncodeToString(make([]byte, sha256.Size))
+	l := newTestRecordsLookuper(map[string][]string{
+		"_25._tcp.mail.example.org.": {
+			"TLSA 3 1 1 " + hash,
+			"TLSA 3 1 1 abcd", // wrong length
+			"TLSA 4 1 1 " + hash,
+		},
+	})
+
+	r := NewResolver(l)
+	out, err := r.LookupTLSA(context.Background(), 25, "tcp", "mail.example.org")
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case len(out) != 1 || out[0].Usage != TLSAUsageDANEEE:
+		t.Errorf("unexpected records: %+v", out)
+	}
+
+	r.RequireAuthenticatedTLSA = true
+	_, err = r.LookupTLSA(context.Background(), 25, "tcp", "mail.example.org")
+	if e, ok := err.(*net.DNSError); !ok || e.Err != errors.UNAUTHENTICATED {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -3,12 +3,24 @@ package resolver
 import (
 	"context"
 
+	"darvaza.org/resolver/pkg/errors"
 	"darvaza.org/resolver/pkg/exdns"
 	"github.com/miekg/dns"
 )
 
 // LookupTXT returns the DNS TXT records for the given domain name
 func (r LookupResolver) LookupTXT(ctx context.Context,
+	name string) ([]string, error) {
+	//
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return withSearch(r, name, func(name string) ([]string, error) {
+		return r.doLookupTXT(ctx, name)
+	})
+}
+
+func (r LookupResolver) doLookupTXT(ctx context.Context,
 	name string) ([]string, error) {
 	//
 	var txt []string
@@ -28,5 +40,32 @@ func (r LookupResolver) LookupTXT(ctx context.Context,
 		}
 	})
 
+	if err == nil && len(txt) == 0 {
+		err = errors.ErrTypeNotFound(name)
+	}
 	return txt, err
 }
+
+// LookupTXTClass returns the TXT records of a name in the given class,
+// like [dns.ClassCHAOS] for "version.bind." or "id.server.". Search
+// domains don't apply, and classes other than INET require the
+// [Lookuper] to also be an [Exchanger].
+func (r LookupResolver) LookupTXTClass(ctx context.Context,
+	name string, qClass uint16) ([]string, error) {
+	//
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	records, err := r.doLookupRawRR(ctx, name, dns.TypeTXT, qClass)
+	if err != nil {
+		return nil, err
+	}
+
+	var txt []string
+	for _, rr := range records {
+		if v, ok := rr.(*dns.TXT); ok {
+			txt = append(txt, v.Txt...)
+		}
+	}
+	return txt, nil
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
 // revive:disable:cognitive-complexity
@@ -45,3 +48,45 @@ func TestLookupTXT(t *testing.T) {
 		}
 	}
 }
+
+func TestLookupTXTClass(t *testing.T) {
+	var calls int
+	upstream := ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		calls++
+		q := req.Question[0]
+
+		resp := new(dns.Msg)
+		if q.Qclass != dns.ClassCHAOS || q.Name != "version.bind." {
+			resp.SetRcode(req, dns.RcodeRefused)
+			return resp, nil
+		}
+
+		resp.SetReply(req)
+		rr, _ := dns.NewRR(`version.bind. 0 CH TXT "9.18.0"`)
+		resp.Answer = append(resp.Answer, rr)
+		return resp, nil
+	})
+
+	sf, err := NewSingleFlight(upstream, time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewResolver(sf)
+	r.SetSearch(1, "example.org")
+
+	txt, err := r.LookupTXTClass(context.Background(), "version.bind", dns.ClassCHAOS)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case len(txt) != 1 || txt[0] != "9.18.0":
+		t.Errorf("unexpected TXT: %v", txt)
+	case calls != 1:
+		t.Errorf("unexpected number of queries: %v", calls)
+	}
+
+	resp, err := sf.LookupClass(context.Background(), "id.server.", dns.ClassCHAOS, dns.TypeTXT)
+	if err != nil || resp.Rcode != dns.RcodeRefused {
+		t.Errorf("unexpected response: %v %v", resp, err)
+	}
+}
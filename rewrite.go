@@ -0,0 +1,256 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*Rewriter)(nil)
+	_ Exchanger = (*Rewriter)(nil)
+)
+
+// Rewriter is an [Exchanger] middleware renaming the questions of
+// requests before passing them to the next [Exchanger], restoring the
+// original name in the responses, and mapping the addresses on A and
+// AAAA records of the responses between networks.
+// Name rules are tried in the order they were added, and the first
+// matching is used.
+type Rewriter struct {
+	next Exchanger
+
+	mu    sync.RWMutex
+	names []nameRewrite
+	addrs []addrRewrite
+}
+
+type nameRewrite struct {
+	from string
+	to   string
+	re   *regexp.Regexp
+}
+
+// Rewrite returns the new name if the rule applies.
+func (nr nameRewrite) Rewrite(qName string) (string, bool) {
+	if nr.re != nil {
+		name := strings.TrimSuffix(qName, ".")
+		if !nr.re.MatchString(name) {
+			return "", false
+		}
+
+		name = dns.Fqdn(nr.re.ReplaceAllString(name, nr.to))
+		if _, ok := dns.IsDomainName(name); !ok {
+			return "", false
+		}
+		return dns.CanonicalName(name), true
+	}
+
+	switch {
+	case qName == nr.from:
+		return nr.to, true
+	case strings.HasSuffix(qName, "."+nr.from):
+		return qName[:len(qName)-len(nr.from)] + nr.to, true
+	case nr.from == ".":
+		return qName + nr.to, true
+	default:
+		return "", false
+	}
+}
+
+type addrRewrite struct {
+	from netip.Prefix
+	to   netip.Prefix
+}
+
+// Rewrite returns the address mapped to the new network
+// if the rule applies.
+func (ar addrRewrite) Rewrite(addr netip.Addr) (netip.Addr, bool) {
+	if !ar.from.Contains(addr) {
+		return addr, false
+	}
+
+	a, t := addr.AsSlice(), ar.to.Addr().AsSlice()
+	for i, bits := 0, ar.to.Bits(); i < len(a) && bits > 0; i, bits = i+1, bits-8 {
+		mask := byte(0xff)
+		if bits < 8 {
+			mask = ^byte(0xff >> bits)
+		}
+		a[i] = t[i]&mask | a[i]&^mask
+	}
+
+	out, _ := netip.AddrFromSlice(a)
+	return out, true
+}
+
+// AddSuffixRule renames the names under the given suffix,
+// or the suffix itself, to be under the other instead.
+func (rw *Rewriter) AddSuffixRule(from, to string) error {
+	for _, s := range []string{from, to} {
+		if _, ok := dns.IsDomainName(s); !ok {
+			return core.Wrapf(core.ErrInvalid, "invalid suffix %q", s)
+		}
+	}
+
+	rw.addNameRule(nameRewrite{
+		from: dns.CanonicalName(from),
+		to:   dns.CanonicalName(to),
+	})
+	return nil
+}
+
+// AddRegexRule renames the names matching the regular expression
+// using the replacement template, as in [regexp.Regexp.ReplaceAllString].
+// Names are matched in canonical form, without the trailing dot.
+func (rw *Rewriter) AddRegexRule(pattern, replacement string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return core.Wrapf(core.ErrInvalid, "invalid pattern %q", pattern)
+	}
+
+	rw.addNameRule(nameRewrite{re: re, to: replacement})
+	return nil
+}
+
+func (rw *Rewriter) addNameRule(nr nameRewrite) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.names = append(rw.names, nr)
+}
+
+// AddAddrRule maps the addresses of A and AAAA records within one
+// network to the same offset within another of the same family
+// and size.
+func (rw *Rewriter) AddAddrRule(from, to netip.Prefix) error {
+	from, to = from.Masked(), to.Masked()
+	switch {
+	case !from.IsValid() || !to.IsValid():
+		return core.Wrap(core.ErrInvalid, "invalid network")
+	case from.Addr().Is4() != to.Addr().Is4() || from.Bits() != to.Bits():
+		return core.Wrap(core.ErrInvalid, "networks don't match")
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.addrs = append(rw.addrs, addrRewrite{from: from, to: to})
+	return nil
+}
+
+// Lookup implements the [Lookuper] interface.
+func (rw *Rewriter) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return rw.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface, rewriting
+// the request and the response.
+func (rw *Rewriter) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	q := msgQuestion(req)
+	if ctx == nil || q == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	names, addrs := rw.getRules()
+
+	name, renamed := rewriteName(names, dns.CanonicalName(q.Name))
+	if renamed {
+		req = req.Copy()
+		req.Question[0].Name = name
+	}
+
+	resp, err := rw.next.Exchange(ctx, req)
+	if resp == nil || (!renamed && len(addrs) == 0) {
+		return resp, err
+	}
+
+	// answers may be shared
+	resp = resp.Copy()
+	if renamed {
+		restoreMsgName(resp, name, q.Name)
+	}
+
+	for _, section := range [][]dns.RR{resp.Answer, resp.Extra} {
+		rewriteAddrs(addrs, section)
+	}
+	return resp, err
+}
+
+// getRules returns the current rules. Rules are only appended,
+// so the slices can be used after releasing the lock.
+func (rw *Rewriter) getRules() ([]nameRewrite, []addrRewrite) {
+	rw.mu.RLock()
+	defer rw.mu.RUnlock()
+
+	return rw.names, rw.addrs
+}
+
+func rewriteName(rules []nameRewrite, qName string) (string, bool) {
+	for _, nr := range rules {
+		if name, ok := nr.Rewrite(qName); ok {
+			return name, name != qName
+		}
+	}
+	return "", false
+}
+
+func rewriteAddrs(rules []addrRewrite, records []dns.RR) {
+	for _, rr := range records {
+		switch v := rr.(type) {
+		case *dns.A:
+			v.A = rewriteIP(rules, v.A)
+		case *dns.AAAA:
+			v.AAAA = rewriteIP(rules, v.AAAA)
+		}
+	}
+}
+
+func rewriteIP(rules []addrRewrite, ip net.IP) net.IP {
+	addr, ok := netip.AddrFromSlice(ip)
+	if ok {
+		for _, ar := range rules {
+			if out, ok := ar.Rewrite(addr.Unmap()); ok {
+				return out.AsSlice()
+			}
+		}
+	}
+	return ip
+}
+
+// restoreMsgName renames back the question and records of a
+// response to the name originally asked.
+func restoreMsgName(resp *dns.Msg, name, original string) {
+	for i := range resp.Question {
+		if strings.EqualFold(resp.Question[i].Name, name) {
+			resp.Question[i].Name = original
+		}
+	}
+
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if hdr := rr.Header(); strings.EqualFold(hdr.Name, name) {
+				hdr.Name = original
+			}
+		}
+	}
+}
+
+// NewRewriter creates a [Rewriter] middleware without rules,
+// passing requests to the given [Exchanger].
+func NewRewriter(next Exchanger) (*Rewriter, error) {
+	if next == nil {
+		return nil, core.ErrInvalid
+	}
+	return &Rewriter{next: next}, nil
+}
@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRewriter(t *testing.T) {
+	rw, err := NewRewriter(newTestZone(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []func() error{
+		func() error { return rw.AddSuffixRule("svc.internal", "example.org") },
+		func() error { return rw.AddRegexRule(`^(\w+)-ns\.test$`, "$1.example.org") },
+		func() error {
+			return rw.AddAddrRule(netip.MustParsePrefix("192.0.2.0/30"),
+				netip.MustParsePrefix("10.1.2.0/30"))
+		},
+	} {
+		if err := fn(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		qType  uint16
+		rcode  int
+		answer []string
+	}{
+		{"www.example.org", dns.TypeA, dns.RcodeSuccess, []string{"10.1.2.2"}},
+		{"www.svc.internal", dns.TypeA, dns.RcodeSuccess, []string{"10.1.2.2"}},
+		{"ALIAS.svc.internal", dns.TypeA, dns.RcodeSuccess, []string{"www.example.org.", "10.1.2.2"}},
+		{"svc.internal", dns.TypeNS, dns.RcodeSuccess, []string{"ns.example.org."}},
+		{"ns-ns.test", dns.TypeA, dns.RcodeSuccess, []string{"10.1.2.1"}},
+		{"ns.sub.example.org", dns.TypeA, dns.RcodeSuccess, nil},
+		{"missing.svc.internal", dns.TypeA, dns.RcodeNameError, nil},
+	}
+
+	for _, tc := range tests {
+		qName := dns.Fqdn(tc.name)
+		msg, err := rw.Lookup(context.Background(), qName, tc.qType)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		answer := testRecordsData(msg.Answer)
+		if msg.Rcode != tc.rcode || !equalStrings(answer, tc.answer) {
+			t.Errorf("%s: unexpected response %s %q", tc.name,
+				dns.RcodeToString[msg.Rcode], answer)
+		}
+
+		if msg.Question[0].Name != qName {
+			t.Errorf("%s: question not restored: %q", tc.name, msg.Question[0].Name)
+		}
+		if len(msg.Answer) > 0 && msg.Answer[0].Header().Name != qName {
+			t.Errorf("%s: answer not restored: %v", tc.name, msg.Answer[0])
+		}
+	}
+
+	if err := rw.AddAddrRule(netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("2001:db8::/24")); err == nil {
+		t.Error("mismatched networks accepted")
+	}
+	if err := rw.AddRegexRule("(", ""); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+	if _, err := NewRewriter(nil); err == nil {
+		t.Error("nil exchanger accepted")
+	}
+}
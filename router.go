@@ -0,0 +1,116 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*SuffixRouter)(nil)
+	_ Exchanger = (*SuffixRouter)(nil)
+)
+
+// SuffixRouter is an [Exchanger] passing each request to the backend
+// of the longest domain suffix containing its name, for split-horizon
+// setups. A route for the root, ".", acts as default, otherwise
+// unmatched requests are refused. Routes can be modified at runtime.
+type SuffixRouter struct {
+	routes forwardZones
+}
+
+// Lookup implements the [Lookuper] interface.
+func (r *SuffixRouter) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return r.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface, passing the request
+// to the backend of the route matching its name.
+func (r *SuffixRouter) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	q := msgQuestion(req)
+	if ctx == nil || q == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	_, e, ok := r.routes.Get(dns.CanonicalName(q.Name))
+	if !ok {
+		return nil, errors.ErrRefused(q.Name)
+	}
+
+	resp, err := e.Exchange(ctx, req)
+	if err == nil && resp == nil {
+		err = errors.ErrBadResponse()
+	}
+	return resp, err
+}
+
+// Route returns the suffix and backend a name would be routed to.
+func (r *SuffixRouter) Route(qName string) (string, Exchanger, bool) {
+	return r.routes.Get(dns.CanonicalName(qName))
+}
+
+// AddRoute makes requests under the given suffix, unless a longer
+// one matches, be passed to the given [Exchanger], replacing any
+// previous backend of the suffix.
+func (r *SuffixRouter) AddRoute(suffix string, e Exchanger) error {
+	suffix, err := checkRoute(suffix, e)
+	if err != nil {
+		return err
+	}
+
+	r.routes.Set(suffix, e)
+	return nil
+}
+
+// RemoveRoute removes the route of the given suffix.
+func (r *SuffixRouter) RemoveRoute(suffix string) {
+	r.routes.Set(dns.CanonicalName(suffix), nil)
+}
+
+// SetRoutes replaces the whole routing table at once.
+func (r *SuffixRouter) SetRoutes(routes map[string]Exchanger) error {
+	table := make(map[string]Exchanger, len(routes))
+	for suffix, e := range routes {
+		suffix, err := checkRoute(suffix, e)
+		if err != nil {
+			return err
+		}
+		table[suffix] = e
+	}
+
+	r.routes.Replace(table)
+	return nil
+}
+
+// Routes returns a copy of the routing table.
+func (r *SuffixRouter) Routes() map[string]Exchanger {
+	return r.routes.Export()
+}
+
+func checkRoute(suffix string, e Exchanger) (string, error) {
+	if e == nil {
+		return "", core.Wrap(core.ErrInvalid, "no exchanger specified")
+	}
+
+	if _, ok := dns.IsDomainName(suffix); !ok {
+		return "", core.Wrapf(core.ErrInvalid, "invalid suffix %q", suffix)
+	}
+
+	return dns.CanonicalName(suffix), nil
+}
+
+// NewSuffixRouter creates a [SuffixRouter] using the given routing
+// table, which is copied. The backend of "." is used as default.
+func NewSuffixRouter(routes map[string]Exchanger) (*SuffixRouter, error) {
+	r := new(SuffixRouter)
+	if err := r.SetRoutes(routes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,92 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newTestRouterBackend(name string) Exchanger {
+	return ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+
+		rr, _ := dns.NewRR(req.Question[0].Name + ` 60 IN TXT "` + name + `"`)
+		resp.Answer = []dns.RR{rr}
+		return resp, nil
+	})
+}
+
+func TestSuffixRouter(t *testing.T) {
+	r, err := NewSuffixRouter(map[string]Exchanger{
+		"consul":            newTestRouterBackend("consul"),
+		"corp.example":      newTestRouterBackend("corp"),
+		"Dev.Corp.Example.": newTestRouterBackend("dev"),
+		".":                 newTestRouterBackend("public"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(qName, expected string) {
+		t.Helper()
+
+		msg, err := r.Lookup(context.Background(), qName, dns.TypeTXT)
+		switch {
+		case expected == "":
+			if err == nil {
+				t.Errorf("%s: expected refusal, got %v", qName, msg)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", qName, err)
+		case len(msg.Answer) != 1 ||
+			msg.Answer[0].(*dns.TXT).Txt[0] != expected:
+			t.Errorf("%s: expected %q, got %v", qName, expected, msg.Answer)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		backend string
+	}{
+		{"web.service.consul", "consul"},
+		{"consul", "consul"},
+		{"notconsul", "public"},
+		{"www.corp.example", "corp"},
+		{"WWW.DEV.corp.example", "dev"},
+		{"www.example.org", "public"},
+		{".", "public"},
+	}
+
+	for _, tc := range tests {
+		check(tc.name, tc.backend)
+	}
+
+	// runtime changes
+	if err := r.AddRoute("example.org", newTestRouterBackend("local")); err != nil {
+		t.Fatal(err)
+	}
+	r.RemoveRoute("dev.corp.example")
+	r.RemoveRoute(".")
+
+	check("www.example.org", "local")
+	check("www.dev.corp.example", "corp")
+	check("www.example.net", "")
+
+	if _, e, ok := r.Route("x.consul."); !ok || e == nil {
+		t.Error("route of x.consul not found")
+	}
+
+	routes := r.Routes()
+	if len(routes) != 3 || routes["example.org."] == nil {
+		t.Errorf("unexpected routes: %v", routes)
+	}
+
+	if err := r.AddRoute("consul", nil); err == nil {
+		t.Error("nil exchanger accepted")
+	}
+	if _, err := NewSuffixRouter(map[string]Exchanger{"a..b": r}); err == nil {
+		t.Error("invalid suffix accepted")
+	}
+}
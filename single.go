@@ -35,7 +35,8 @@ func (r SingleLookuper) Lookup(ctx context.Context,
 func (r SingleLookuper) Exchange(ctx context.Context,
 	msg *dns.Msg) (*dns.Msg, error) {
 	//
-	res, _, err := r.c.ExchangeContext(ctx, msg, r.remote)
+	c, server := transportClient(ctx, r.c, r.remote)
+	res, _, err := c.ExchangeContext(ctx, applyRequestOptions(ctx, msg), server)
 	if werr := errors.ValidateResponse(r.remote, res, err); werr != nil {
 		return nil, werr
 	}
@@ -6,10 +6,10 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
-	"golang.org/x/sync/singleflight"
 
 	"darvaza.org/core"
 
+	"darvaza.org/resolver/internal/flight"
 	"darvaza.org/resolver/pkg/client"
 	"darvaza.org/resolver/pkg/errors"
 	"darvaza.org/resolver/pkg/exdns"
@@ -27,10 +27,16 @@ type SingleFlightHasher func(context.Context, *dns.Msg) (string, error)
 // SingleFlight is an [Exchanger]/[Lookuper] that holds/caches
 // identical queries before passing them over to another [Exchanger].
 type SingleFlight struct {
-	e   Exchanger
-	g   singleflight.Group
-	exp time.Duration
-	h   SingleFlightHasher
+	e    Exchanger
+	g    flight.Group
+	exp  time.Duration
+	h    SingleFlightHasher
+	memo sfMemo
+
+	// Memoize, if positive, makes successful responses be remembered
+	// for as long as their TTLs allow, up to this cap, instead of only
+	// holding identical requests while in flight.
+	Memoize time.Duration
 }
 
 // Lookup implements the [Lookuper] interface holding/caching
@@ -44,6 +50,19 @@ func (sf *SingleFlight) Lookup(ctx context.Context, qName string, qType uint16)
 	return sf.Exchange(ctx, req)
 }
 
+// LookupClass makes a query of any class, like CHAOS or HESIOD,
+// holding/caching identical queries.
+func (sf *SingleFlight) LookupClass(ctx context.Context,
+	qName string, qClass, qType uint16) (*dns.Msg, error) {
+	//
+	if ctx == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	req := exdns.NewRequestFromParts(qName, qClass, qType)
+	return sf.Exchange(ctx, req)
+}
+
 // Exchange implements the [Exchanger] interface holding/caching
 // identical queries.
 func (sf *SingleFlight) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
@@ -85,8 +104,22 @@ func (sf *SingleFlight) doExchange(ctx context.Context, req *dns.Msg) (*dns.Msg,
 	if err != nil {
 		return nil, err
 	}
-	v, err, _ := sf.g.Do(key, func() (any, error) {
-		resp, err := sf.e.Exchange(ctx, req)
+
+	if sf.Memoize > 0 {
+		if resp, ok := sf.memo.Get(key); ok {
+			resp.Id = req.Id
+			return resp, nil
+		}
+	}
+
+	// the exchange isn't bound to the first caller's context,
+	// and may outlive it, so it gets its own copy of the request.
+	req2 := req.Copy()
+	v, err, _ := sf.g.Do(ctx, key, func(ctx context.Context) (any, error) {
+		resp, err := sf.e.Exchange(ctx, req2)
+		if err == nil && resp != nil && sf.Memoize > 0 {
+			sf.memo.Set(key, resp, sf.Memoize)
+		}
 		sf.deferredExpiration(key)
 		return resp, err
 	})
@@ -100,6 +133,10 @@ func (sf *SingleFlight) doExchange(ctx context.Context, req *dns.Msg) (*dns.Msg,
 		// this can't happen
 		q := msgQuestion(req)
 		return nil, errors.ErrInternalError(q.Name, "singleflight")
+	case ctx.Err() != nil:
+		// caller gave up
+		q := msgQuestion(req)
+		return nil, errors.ErrTimeout(q.Name, err)
 	default:
 		// failed
 		return nil, err
@@ -149,17 +186,14 @@ func NewSingleFlight(next Exchanger, exp time.Duration,
 }
 
 // DefaultSingleFlightHasher returns the base64 encoded
-// representation of the packed request, ignoring the ID.
+// representation of the packed request, normalized using
+// [exdns.NormalizeRequest].
 func DefaultSingleFlightHasher(_ context.Context, req *dns.Msg) (string, error) {
 	if req == nil {
 		return "", core.ErrInvalid
 	}
 
-	id := req.Id
-	req.Id = 0
-	b, err := req.Pack()
-	req.Id = id
-
+	b, err := exdns.NormalizeRequest(req).Pack()
 	if err != nil {
 		return "", errors.ErrBadRequest()
 	}
@@ -167,3 +201,21 @@ func DefaultSingleFlightHasher(_ context.Context, req *dns.Msg) (string, error)
 	s := base64.RawStdEncoding.EncodeToString(b)
 	return s, nil
 }
+
+// Stats returns the counters of the [SingleFlight].
+func (sf *SingleFlight) Stats() client.SingleFlightStats {
+	st := sf.g.Stats()
+	return client.SingleFlightStats{
+		Requests:  st.Requests,
+		Exchanges: st.Executions,
+		Coalesced: st.Coalesced,
+		Inflight:  st.Inflight,
+	}
+}
+
+// SetMaxInflight limits how many distinct requests are tracked at
+// the same time. Once reached, new requests are passed through without
+// coalescing. Zero means no limit.
+func (sf *SingleFlight) SetMaxInflight(n int) {
+	sf.g.SetLimit(n)
+}
@@ -0,0 +1,70 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/cache/x/simplelru"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// DefaultSingleFlightMemoSize indicates how many responses a
+// [SingleFlight] remembers when memoization is enabled.
+const DefaultSingleFlightMemoSize = 1024
+
+// sfMemo remembers completed responses of a [SingleFlight]
+type sfMemo struct {
+	mu  sync.Mutex
+	lru *simplelru.LRU[string, sfMemoEntry]
+}
+
+type sfMemoEntry struct {
+	msg   *dns.Msg
+	added time.Time
+}
+
+// Get returns a copy of a remembered response with the
+// TTLs reduced by the time elapsed.
+func (m *sfMemo) Get(key string) (*dns.Msg, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lru == nil {
+		return nil, false
+	}
+
+	e, _, ok := m.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	resp := e.msg.Copy()
+	if exdns.DecayTTL(resp, time.Since(e.added)) {
+		m.lru.Evict(key)
+		return nil, false
+	}
+	return resp, true
+}
+
+// Set remembers a successful response for its lowest TTL,
+// but no longer than maxTTL.
+func (m *sfMemo) Set(key string, resp *dns.Msg, maxTTL time.Duration) {
+	ttl, ok := cachedTTL(resp)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	expire := now.Add(min(time.Duration(ttl)*time.Second, maxTTL))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lru == nil {
+		m.lru = simplelru.NewLRU[string, sfMemoEntry](DefaultSingleFlightMemoSize, nil, nil)
+	}
+	m.lru.Add(key, sfMemoEntry{msg: resp.Copy(), added: now}, 1, expire)
+}
@@ -0,0 +1,75 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSingleFlightMemoize(t *testing.T) {
+	var calls int
+
+	sf, err := NewSingleFlight(newTestCachedUpstream(&calls, 300), time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Memoize = time.Minute
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+
+		resp, err := sf.Exchange(ctx, req)
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case resp.Id != req.Id:
+			t.Error("ID not restored")
+		case len(resp.Answer) != 1:
+			t.Errorf("unexpected answer: %v", resp.Answer)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected one upstream query, got %v", calls)
+	}
+}
+
+func TestSingleFlightAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	seen := make(chan string, 1)
+	next := ExchangerFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		<-release
+		seen <- req.Question[0].Name
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, nil
+	})
+
+	sf, err := NewSingleFlight(next, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller gives up, and reuses its request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	if _, err := sf.Exchange(ctx, req); err == nil {
+		t.Fatal("abandoned exchange answered")
+	}
+	req.Question[0].Name = "example.net."
+
+	close(release)
+	if name := <-seen; name != "example.org." {
+		t.Errorf("abandoned exchange saw %q", name)
+	}
+}
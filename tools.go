@@ -1,15 +1,18 @@
 package resolver
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 
 	"darvaza.org/core"
 	"github.com/miekg/dns"
 	"golang.org/x/net/idna"
 
 	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
 )
 
 const (
@@ -54,6 +57,37 @@ func sanitiseHost2(host string, p *idna.Profile) (string, *net.DNSError) {
 	}
 }
 
+// toUnicode converts a name for display using the UnicodeOutput
+// profile, if any. Names failing validation are returned as-is.
+func (r LookupResolver) toUnicode(name string) string {
+	if r.UnicodeOutput == nil || name == "" || name == "." {
+		return name
+	}
+
+	s, err := r.UnicodeOutput.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return s
+}
+
+// waitContext waits for the group to finish, or returns
+// the context's error if cancelled first.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func eqIP(ip1, ip2 net.IP) bool {
 	return ip1.Equal(ip2)
 }
@@ -65,6 +99,18 @@ func msgQuestion(m *dns.Msg) *dns.Question {
 	return nil
 }
 
+func questionName(q *dns.Question) string {
+	if q != nil {
+		return q.Name
+	}
+	return ""
+}
+
+func newRequestFromQuestion(q dns.Question) *dns.Msg {
+	qClass := core.IIf(q.Qclass == 0, dns.ClassINET, q.Qclass)
+	return exdns.NewRequestFromParts(dns.Fqdn(q.Name), qClass, q.Qtype)
+}
+
 func msgQType(m *dns.Msg) uint16 {
 	if q := msgQuestion(m); q != nil {
 		return q.Qtype
@@ -75,3 +121,10 @@ func msgQType(m *dns.Msg) uint16 {
 func rrIsAAAA(rr dns.RR) bool {
 	return rr.Header().Rrtype == dns.TypeAAAA
 }
+
+// isStrictSubDomain tells if child is a subdomain of parent,
+// but not the same name.
+func isStrictSubDomain(parent, child string) bool {
+	parent, child = dns.CanonicalName(parent), dns.CanonicalName(child)
+	return parent != child && dns.IsSubDomain(parent, child)
+}
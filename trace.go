@@ -0,0 +1,138 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+var queryTraceCtxKey = core.NewContextKey[*QueryTrace]("resolver.trace")
+
+// QueryTraceStep describes an exchange with an upstream server
+// made on behalf of a traced query.
+type QueryTraceStep struct {
+	Time   time.Time
+	Zone   string
+	Server string
+	QName  string
+	Err    error
+	RTT    time.Duration
+	Rcode  int
+	QType  uint16
+}
+
+// String describes the step in a `dig +trace` like fashion.
+func (s QueryTraceStep) String() string {
+	var status string
+
+	switch {
+	case s.Err != nil:
+		status = s.Err.Error()
+	case s.Rcode < 0:
+		status = "no response"
+	default:
+		status = dns.RcodeToString[s.Rcode]
+	}
+
+	zone := core.Coalesce(s.Zone, "-")
+	return fmt.Sprintf("%s %s %s @%s %s in %v", zone, s.QName,
+		dns.TypeToString[s.QType], s.Server, status, s.RTT.Round(time.Millisecond))
+}
+
+// QueryTrace records every exchange made by [Pool]s, including those
+// of the [IteratorLookuper], on behalf of a query, and if a [Cached]
+// answered from its cache.
+type QueryTrace struct {
+	mu       sync.Mutex
+	steps    []QueryTraceStep
+	cacheHit bool
+}
+
+// Steps returns a copy of the steps recorded so far.
+func (t *QueryTrace) Steps() []QueryTraceStep {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return core.SliceCopy(t.steps)
+}
+
+// String describes the trace, one step per line.
+func (t *QueryTrace) String() string {
+	var buf strings.Builder
+
+	for _, s := range t.Steps() {
+		_, _ = buf.WriteString(s.String())
+		_ = buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// CacheHit tells if a [Cached] answered from its cache on
+// behalf of the query.
+func (t *QueryTrace) CacheHit() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.cacheHit
+}
+
+func (t *QueryTrace) setCacheHit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cacheHit = true
+}
+
+func (t *QueryTrace) add(step QueryTraceStep) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.steps = append(t.steps, step)
+}
+
+// WithQueryTrace attaches a new [QueryTrace] to the context.
+func WithQueryTrace(ctx context.Context) (context.Context, *QueryTrace) {
+	t := new(QueryTrace)
+	return queryTraceCtxKey.WithValue(ctx, t), t
+}
+
+// GetQueryTrace returns the [QueryTrace] attached to the context, if any.
+func GetQueryTrace(ctx context.Context) (*QueryTrace, bool) {
+	return queryTraceCtxKey.Get(ctx)
+}
+
+func newQueryTraceStep(req, resp *dns.Msg, rtt time.Duration, err error) QueryTraceStep {
+	step := QueryTraceStep{
+		Time:  time.Now(),
+		Err:   err,
+		RTT:   rtt,
+		Rcode: -1,
+	}
+
+	if q := msgQuestion(req); q != nil {
+		step.QName, step.QType = q.Name, q.Qtype
+	}
+	if resp != nil {
+		step.Rcode = resp.Rcode
+	}
+	return step
+}
+
+// LookupTrace performs an iterative lookup recording every exchange made.
+func (r *IteratorLookuper) LookupTrace(ctx context.Context,
+	name string, qType uint16) (*dns.Msg, *QueryTrace, error) {
+	//
+	if ctx == nil {
+		return nil, nil, core.ErrInvalid
+	}
+
+	ctx, t := WithQueryTrace(ctx)
+	resp, err := r.Lookup(ctx, name, qType)
+	return resp, t, err
+}
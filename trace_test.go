@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/resolver/pkg/client"
+	"darvaza.org/resolver/pkg/errors"
+)
+
+func TestQueryTrace(t *testing.T) {
+	c := client.ExchangeFunc(func(_ context.Context, req *dns.Msg,
+		server string) (*dns.Msg, time.Duration, error) {
+		if server == "192.0.2.1:53" {
+			return nil, 0, errors.ErrTimeout(server, nil)
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		return resp, 5 * time.Millisecond, nil
+	})
+
+	p, err := NewPoolExchanger(c, "192.0.2.1", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Attempts = 1
+	p.Failover = true
+
+	ctx, tr := WithQueryTrace(context.Background())
+	for i := 0; i < 4; i++ {
+		if _, err := p.Lookup(ctx, "example.org.", dns.TypeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := tr.Steps()
+	if len(steps) < 4 {
+		t.Fatalf("expected at least 4 steps, got %v", len(steps))
+	}
+
+	for _, s := range steps {
+		switch s.Server {
+		case "192.0.2.1:53":
+			if s.Err == nil || s.Rcode != -1 {
+				t.Errorf("unexpected step for failing server: %s", s)
+			}
+		case "192.0.2.2:53":
+			if s.Err != nil || s.Rcode != dns.RcodeSuccess || s.RTT != 5*time.Millisecond {
+				t.Errorf("unexpected step for working server: %s", s)
+			}
+		default:
+			t.Errorf("unexpected server %q", s.Server)
+		}
+
+		if s.QName != "example.org." || s.QType != dns.TypeA {
+			t.Errorf("unexpected question: %s", s)
+		}
+	}
+
+	if lines := strings.Count(tr.String(), "\n"); lines != len(steps) {
+		t.Errorf("expected %v lines, got %v", len(steps), lines)
+	}
+
+	// untraced contexts are ignored
+	if _, ok := GetQueryTrace(context.Background()); ok {
+		t.Error("unexpected trace")
+	}
+}
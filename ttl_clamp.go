@@ -0,0 +1,143 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*TTLClamp)(nil)
+	_ Exchanger = (*TTLClamp)(nil)
+)
+
+// TTLClamp is an [Exchanger] middleware adjusting the TTL of
+// the records of every response to the configured range, or to
+// the range set for the closest domain containing the name asked.
+type TTLClamp struct {
+	next Exchanger
+
+	// MinTTL is the lowest TTL allowed, in seconds.
+	MinTTL uint32
+	// MaxTTL is the highest TTL allowed, in seconds.
+	// Zero means no limit.
+	MaxTTL uint32
+
+	mu      sync.RWMutex
+	domains map[string]ttlRange
+}
+
+type ttlRange struct {
+	minTTL uint32
+	maxTTL uint32
+}
+
+// SetDomain sets the TTL range of the responses for names under
+// the given domain, or the domain itself, overriding the general
+// one. Using the same value for both forces the TTL.
+func (c *TTLClamp) SetDomain(domain string, minTTL, maxTTL uint32) error {
+	if maxTTL > 0 && maxTTL < minTTL {
+		return core.Wrap(core.ErrInvalid, "inverted range")
+	}
+
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return core.Wrapf(core.ErrInvalid, "invalid domain %q", domain)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.domains == nil {
+		c.domains = make(map[string]ttlRange)
+	}
+	c.domains[dns.CanonicalName(domain)] = ttlRange{minTTL, maxTTL}
+	return nil
+}
+
+// RemoveDomain removes the TTL range of the given domain.
+func (c *TTLClamp) RemoveDomain(domain string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.domains, dns.CanonicalName(domain))
+}
+
+// getRange returns the TTL range applying to the name.
+func (c *TTLClamp) getRange(qName string) (minTTL, maxTTL uint32) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.domains) > 0 {
+		qName = dns.CanonicalName(qName)
+		for off, end := 0, false; !end; off, end = dns.NextLabel(qName, off) {
+			if r, ok := c.domains[qName[off:]]; ok {
+				return r.minTTL, r.maxTTL
+			}
+		}
+
+		// and the root
+		if r, ok := c.domains["."]; ok {
+			return r.minTTL, r.maxTTL
+		}
+	}
+
+	return c.MinTTL, c.MaxTTL
+}
+
+// Lookup implements the [Lookuper] interface.
+func (c *TTLClamp) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	if ctx == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return c.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface, adjusting
+// the TTLs of the response.
+func (c *TTLClamp) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if ctx == nil || req == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	resp, err := c.next.Exchange(ctx, req)
+	if resp != nil {
+		minTTL, maxTTL := c.getRange(questionName(msgQuestion(req)))
+		resp = clampMsgTTL(resp, minTTL, maxTTL)
+	}
+	return resp, err
+}
+
+// clampMsgTTL returns a copy of the message with the TTLs
+// adjusted, or the original if no clamping is configured.
+func clampMsgTTL(msg *dns.Msg, minTTL, maxTTL uint32) *dns.Msg {
+	if minTTL == 0 && maxTTL == 0 {
+		return msg
+	}
+
+	// responses may be shared
+	msg = msg.Copy()
+	exdns.ClampTTL(msg, minTTL, maxTTL)
+	return msg
+}
+
+// NewTTLClamp creates a [TTLClamp] middleware adjusting the TTLs
+// of the responses of the given [Exchanger] to the given range.
+func NewTTLClamp(next Exchanger, minTTL, maxTTL uint32) (*TTLClamp, error) {
+	if next == nil || (maxTTL > 0 && maxTTL < minTTL) {
+		return nil, core.ErrInvalid
+	}
+
+	return &TTLClamp{
+		next:   next,
+		MinTTL: minTTL,
+		MaxTTL: maxTTL,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTTLClamp(t *testing.T) {
+	tests := []struct {
+		ttl, minTTL, maxTTL uint32
+		expected            uint32
+	}{
+		{300, 0, 0, 300},
+		{5, 30, 0, 30},
+		{300, 30, 60, 60},
+		{45, 30, 60, 45},
+		{0, 30, 60, 30},
+	}
+
+	for _, tc := range tests {
+		var calls int
+
+		next := newTestCachedUpstream(&calls, tc.ttl)
+		c, err := NewTTLClamp(next, tc.minTTL, tc.maxTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := c.Lookup(context.Background(), "example.org.", dns.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ttl := resp.Answer[0].Header().Ttl; ttl != tc.expected {
+			t.Errorf("%v [%v, %v]: expected %v, got %v",
+				tc.ttl, tc.minTTL, tc.maxTTL, tc.expected, ttl)
+		}
+	}
+
+	if _, err := NewTTLClamp(newTestCachedUpstream(new(int), 0), 60, 30); err == nil {
+		t.Error("inverted range accepted")
+	}
+}
+
+func TestTTLClampDomains(t *testing.T) {
+	c, err := NewTTLClamp(newTestCachedUpstream(new(int), 300), 0, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		domain         string
+		minTTL, maxTTL uint32
+	}{
+		{"failover.example.org", 0, 5},
+		{"cdn.example.net", 900, 0},
+		{"fixed.example.net", 60, 60},
+	} {
+		if err := c.SetDomain(tc.domain, tc.minTTL, tc.maxTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		expected uint32
+	}{
+		{"www.example.org", 300},
+		{"failover.example.org", 5},
+		{"db.FAILOVER.example.org", 5},
+		{"img.cdn.example.net", 900},
+		{"fixed.example.net", 60},
+		{"notfixed.example.net", 300},
+	}
+
+	for _, tc := range tests {
+		resp, err := c.Lookup(context.Background(), tc.name, dns.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ttl := resp.Answer[0].Header().Ttl; ttl != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, ttl)
+		}
+	}
+
+	c.RemoveDomain("failover.example.org")
+	resp, err := c.Lookup(context.Background(), "failover.example.org", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := resp.Answer[0].Header().Ttl; ttl != 300 {
+		t.Errorf("removed domain still applied: %v", ttl)
+	}
+
+	if err := c.SetDomain("example.org", 60, 30); err == nil {
+		t.Error("inverted range accepted")
+	}
+}
+
+func TestCachedClamp(t *testing.T) {
+	var calls int
+
+	c, err := NewCachedExchanger(newTestCachedUpstream(&calls, 0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MinTTL = 30
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Lookup(context.Background(), "example.org.", dns.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl := resp.Answer[0].Header().Ttl; ttl != 30 {
+			t.Errorf("expected TTL 30, got %v", ttl)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected floor to allow caching, calls:%v", calls)
+	}
+}
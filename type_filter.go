@@ -0,0 +1,158 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*TypeFilter)(nil)
+	_ Exchanger = (*TypeFilter)(nil)
+)
+
+// TypePolicy describes which query types a [TypeFilter] refuses or
+// answers with NODATA, and which record types it strips from the
+// responses.
+type TypePolicy struct {
+	// Name identifies the policy.
+	Name string
+	// Networks lists the clients the policy applies to, when
+	// used as a view.
+	Networks []netip.Prefix
+
+	// Refuse lists the query types answered REFUSED.
+	Refuse []uint16
+	// Stub lists the query types answered NODATA without
+	// asking the next [Exchanger].
+	Stub []uint16
+	// Strip lists the record types removed from the responses,
+	// with the RRSIG records covering them.
+	Strip []uint16
+}
+
+// Contains tells if the [TypePolicy] applies to the given client address.
+func (p *TypePolicy) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p.Networks {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// TypeFilter is an [Exchanger] middleware applying a [TypePolicy] to
+// requests and their responses, i.e. to deny ANY and RRSIG queries
+// from external clients or strip HINFO and LOC records.
+type TypeFilter struct {
+	next Exchanger
+
+	// Default is the policy applied to clients not
+	// matching any view.
+	Default TypePolicy
+	// Views are policies applied to the clients on their
+	// networks instead of the Default. The first match is used.
+	Views []TypePolicy
+
+	// ClientAddr, if set, extracts the address of the client
+	// from the context, like set by server.Handler.RemoteAddr.
+	// Without it only the Default policy is applied.
+	ClientAddr *core.ContextKey[netip.Addr]
+}
+
+// Lookup implements the [Lookuper] interface.
+func (f *TypeFilter) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return f.Exchange(ctx, req)
+}
+
+// Exchange implements the [Exchanger] interface, applying the
+// [TypePolicy] of the client.
+func (f *TypeFilter) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	q := msgQuestion(req)
+	if ctx == nil || q == nil {
+		return nil, errors.ErrBadRequest()
+	}
+
+	p := f.getPolicy(ctx)
+	switch {
+	case hasType(p.Refuse, q.Qtype):
+		return nil, errors.ErrRefused(q.Name)
+	case hasType(p.Stub, q.Qtype):
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.RecursionAvailable = true
+		return resp, nil
+	}
+
+	resp, err := f.next.Exchange(ctx, req)
+	if resp != nil && len(p.Strip) > 0 {
+		resp = stripMsgTypes(resp, p.Strip)
+	}
+	return resp, err
+}
+
+func (f *TypeFilter) getPolicy(ctx context.Context) *TypePolicy {
+	if addr, ok := f.ClientAddr.Get(ctx); ok {
+		for i := range f.Views {
+			if p := &f.Views[i]; p.Contains(addr) {
+				return p
+			}
+		}
+	}
+	return &f.Default
+}
+
+func hasType(types []uint16, qType uint16) bool {
+	for _, t := range types {
+		if t == qType {
+			return true
+		}
+	}
+	return false
+}
+
+// stripMsgTypes returns a copy of the message without records
+// of the given types, or the original if there are none.
+func stripMsgTypes(msg *dns.Msg, types []uint16) *dns.Msg {
+	strip := func(rr dns.RR) bool {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			return hasType(types, sig.TypeCovered)
+		}
+		return hasType(types, rr.Header().Rrtype)
+	}
+
+	var found bool
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			found = found || strip(rr)
+		}
+	}
+
+	if !found {
+		return msg
+	}
+
+	// responses may be shared
+	msg = msg.Copy()
+	msg.Answer = exdns.TrimRR(msg.Answer, strip)
+	msg.Ns = exdns.TrimRR(msg.Ns, strip)
+	msg.Extra = exdns.TrimRR(msg.Extra, strip)
+	return msg
+}
+
+// NewTypeFilter creates a [TypeFilter] middleware with empty
+// policies, passing requests to the given [Exchanger].
+func NewTypeFilter(next Exchanger) (*TypeFilter, error) {
+	if next == nil {
+		return nil, core.ErrInvalid
+	}
+	return &TypeFilter{next: next}, nil
+}
@@ -0,0 +1,84 @@
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+func TestTypeFilter(t *testing.T) {
+	clientKey := core.NewContextKey[netip.Addr]("test.client")
+
+	z := newTestZone(t)
+	for _, s := range []string{
+		"www.example.org. 3600 IN HINFO \"x86\" \"linux\"",
+		"www.example.org. 3600 IN TXT \"hello\"",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Add(rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := NewTypeFilter(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ClientAddr = clientKey
+	f.Default = TypePolicy{
+		Name:   "external",
+		Refuse: []uint16{dns.TypeANY, dns.TypeRRSIG},
+		Stub:   []uint16{65535},
+		Strip:  []uint16{dns.TypeHINFO, dns.TypeLOC},
+	}
+	f.Views = []TypePolicy{
+		{
+			Name:     "internal",
+			Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		},
+	}
+
+	external := context.Background()
+	internal := clientKey.WithValue(external, netip.MustParseAddr("10.1.2.3"))
+
+	tests := []struct {
+		ctx     context.Context
+		qType   uint16
+		refused bool
+		answers int
+	}{
+		{external, dns.TypeA, false, 1},
+		{external, dns.TypeANY, true, 0},
+		{external, dns.TypeRRSIG, true, 0},
+		{external, 65535, false, 0},
+		{external, dns.TypeHINFO, false, 0},
+		{internal, dns.TypeANY, false, 3},
+		{internal, dns.TypeHINFO, false, 1},
+	}
+
+	for i, tc := range tests {
+		msg, err := f.Lookup(tc.ctx, "www.example.org", tc.qType)
+		switch {
+		case tc.refused:
+			if err == nil {
+				t.Errorf("%d: %s not refused", i, dns.TypeToString[tc.qType])
+			}
+		case err != nil:
+			t.Errorf("%d: %v", i, err)
+		case len(msg.Answer) != tc.answers:
+			t.Errorf("%d: %s: unexpected answer %v", i,
+				dns.TypeToString[tc.qType], msg.Answer)
+		}
+	}
+
+	if _, err := NewTypeFilter(nil); err == nil {
+		t.Error("nil exchanger accepted")
+	}
+}
@@ -0,0 +1,373 @@
+package resolver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+const (
+	// ZoneMaxCNAME is the maximum number of CNAME records a [Zone]
+	// follows within itself when answering a query.
+	ZoneMaxCNAME = 8
+)
+
+var (
+	_ Lookuper  = (*Zone)(nil)
+	_ Exchanger = (*Zone)(nil)
+)
+
+// Zone is an authoritative [Lookuper]/[Exchanger] answering for a
+// single zone using an in-memory store of records which can be
+// modified at runtime.
+// Wildcards are expanded as described in RFC 4592, and names at or
+// below a delegation produce referrals.
+type Zone struct {
+	mu      sync.RWMutex
+	origin  string
+	nodes   map[string][]dns.RR
+	journal ZoneJournal
+}
+
+// Origin returns the canonical name of the apex of the [Zone].
+func (z *Zone) Origin() string {
+	return z.origin
+}
+
+// Contains tells if a name belongs to the [Zone], including
+// names below delegations.
+func (z *Zone) Contains(name string) bool {
+	return dns.IsSubDomain(z.origin, dns.CanonicalName(name))
+}
+
+// SOA returns a copy of the SOA record of the [Zone], if any.
+func (z *Zone) SOA() *dns.SOA {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if soa := z.getSOA(); soa != nil {
+		return dns.Copy(soa).(*dns.SOA)
+	}
+	return nil
+}
+
+func (z *Zone) getSOA() *dns.SOA {
+	soa, _ := exdns.GetFirstRR[*dns.SOA](z.nodes[z.origin])
+	return soa
+}
+
+// Add adds records to the [Zone]. Duplicates are ignored, and a new
+// SOA record replaces the previous one.
+func (z *Zone) Add(records ...dns.RR) error {
+	for _, rr := range records {
+		if err := z.checkRR(rr); err != nil {
+			return err
+		}
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	for _, rr := range records {
+		z.unsafeAdd(dns.Copy(rr))
+	}
+	return nil
+}
+
+func (z *Zone) checkRR(rr dns.RR) error {
+	if rr == nil {
+		return core.Wrap(core.ErrInvalid, "nil record")
+	}
+
+	hdr := rr.Header()
+	switch {
+	case !z.Contains(hdr.Name):
+		return core.Wrapf(core.ErrInvalid, "%q: out of zone", hdr.Name)
+	case hdr.Rrtype == dns.TypeSOA && dns.CanonicalName(hdr.Name) != z.origin:
+		return core.Wrapf(core.ErrInvalid, "%q: SOA not at the apex", hdr.Name)
+	default:
+		return nil
+	}
+}
+
+func (z *Zone) unsafeAdd(rr dns.RR) {
+	hdr := rr.Header()
+	name := dns.CanonicalName(hdr.Name)
+
+	records := z.nodes[name]
+	if hdr.Rrtype == dns.TypeSOA {
+		records = exdns.TrimRR(records, func(v dns.RR) bool {
+			return v.Header().Rrtype == dns.TypeSOA
+		})
+	}
+
+	for _, v := range records {
+		if dns.IsDuplicate(v, rr) {
+			return
+		}
+	}
+
+	z.nodes[name] = append(records, rr)
+}
+
+// Remove removes the given records from the [Zone], ignoring
+// their TTL.
+func (z *Zone) Remove(records ...dns.RR) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	for _, rr := range records {
+		if rr != nil {
+			name := dns.CanonicalName(rr.Header().Name)
+			z.unsafeSetNode(name, exdns.TrimRR(z.nodes[name], func(v dns.RR) bool {
+				return dns.IsDuplicate(v, rr)
+			}))
+		}
+	}
+}
+
+// RemoveRRset removes the records of the given type from a name.
+// [dns.TypeANY] removes all its records.
+func (z *Zone) RemoveRRset(name string, rrType uint16) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	name = dns.CanonicalName(name)
+	z.unsafeSetNode(name, exdns.TrimRR(z.nodes[name], func(v dns.RR) bool {
+		return rrType == dns.TypeANY || v.Header().Rrtype == rrType
+	}))
+}
+
+func (z *Zone) unsafeSetNode(name string, records []dns.RR) {
+	if len(records) == 0 {
+		delete(z.nodes, name)
+	} else {
+		z.nodes[name] = records
+	}
+}
+
+// AXFR returns a copy of the records of the [Zone] in the order of
+// a zone transfer, starting and ending with its SOA record, RFC 5936.
+// Nil is returned if the [Zone] has no SOA record.
+func (z *Zone) AXFR() []dns.RR {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	soa := z.getSOA()
+	if soa == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(z.nodes))
+	for name := range z.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := []dns.RR{dns.Copy(soa)}
+	for _, name := range names {
+		for _, rr := range z.nodes[name] {
+			if rr.Header().Rrtype != dns.TypeSOA {
+				out = append(out, dns.Copy(rr))
+			}
+		}
+	}
+	return append(out, dns.Copy(soa))
+}
+
+// Lookup answers a query of class INET for the given name and type.
+func (z *Zone) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return z.Exchange(ctx, req)
+}
+
+// Exchange answers the first question of the request authoritatively.
+// NXDOMAIN and NODATA responses carry the SOA record of the [Zone] on
+// the authority section, and names outside the [Zone] are REFUSED.
+func (z *Zone) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if ctx == nil || req == nil || len(req.Question) == 0 {
+		return nil, errors.ErrBadRequest()
+	}
+
+	q := req.Question[0]
+	if q.Qclass != dns.ClassINET || !z.Contains(q.Name) {
+		return nil, errors.ErrRefused(q.Name)
+	}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if z.getSOA() == nil {
+		// not loaded
+		return nil, errors.ErrInternalError(q.Name, "")
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	qName := q.Name
+	for i := 0; i <= ZoneMaxCNAME; i++ {
+		target, ok := z.unsafeAnswer(resp, qName, q.Qtype)
+		if !ok || !z.Contains(target) {
+			break
+		}
+		qName = target
+	}
+
+	return resp, nil
+}
+
+// unsafeAnswer adds the answer for a name to the response, returning
+// the target to follow if it's an alias.
+func (z *Zone) unsafeAnswer(resp *dns.Msg, qName string, qType uint16) (string, bool) {
+	name := dns.CanonicalName(qName)
+	if cut, ok := z.unsafeGetCut(name, qType); ok {
+		z.unsafeAddReferral(resp, cut)
+		return "", false
+	}
+
+	records, ok := z.nodes[name]
+	if !ok && !z.unsafeHasDescendants(name) {
+		records, ok = z.unsafeGetWildcard(name)
+		if !ok {
+			resp.Rcode = dns.RcodeNameError
+			z.unsafeAddSOA(resp)
+			return "", false
+		}
+	}
+
+	var answer []dns.RR
+	for _, rr := range records {
+		if qType == dns.TypeANY || rr.Header().Rrtype == qType {
+			answer = append(answer, zoneCopyRR(rr, qName))
+		}
+	}
+
+	if len(answer) == 0 && qType != dns.TypeCNAME {
+		if cname, ok := exdns.GetFirstRR[*dns.CNAME](records); ok {
+			resp.Answer = append(resp.Answer, zoneCopyRR(cname, qName))
+			return cname.Target, true
+		}
+	}
+
+	if len(answer) == 0 {
+		// NODATA
+		z.unsafeAddSOA(resp)
+	}
+
+	resp.Answer = append(resp.Answer, answer...)
+	return "", false
+}
+
+// unsafeGetCut finds the topmost delegation a name belongs to.
+// DS records are answered by the parent side of the delegation.
+func (z *Zone) unsafeGetCut(name string, qType uint16) (string, bool) {
+	var cut string
+
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		s := name[off:]
+		switch {
+		case s == z.origin:
+			return cut, cut != ""
+		case off == 0 && qType == dns.TypeDS:
+			continue
+		case zoneHasType(z.nodes[s], dns.TypeNS):
+			cut = s
+		}
+	}
+
+	return cut, cut != ""
+}
+
+func (z *Zone) unsafeAddReferral(resp *dns.Msg, cut string) {
+	// only the aliases followed are authoritative
+	resp.Authoritative = len(resp.Answer) > 0
+
+	exdns.ForEachRR(z.nodes[cut], func(ns *dns.NS) {
+		resp.Ns = append(resp.Ns, dns.Copy(ns))
+
+		// glue
+		for _, rr := range z.nodes[dns.CanonicalName(ns.Ns)] {
+			switch rr.Header().Rrtype {
+			case dns.TypeA, dns.TypeAAAA:
+				resp.Extra = append(resp.Extra, dns.Copy(rr))
+			}
+		}
+	})
+}
+
+// unsafeHasDescendants tells if a name without records is an
+// empty non-terminal.
+func (z *Zone) unsafeHasDescendants(name string) bool {
+	suffix := "." + name
+	for s := range z.nodes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsafeGetWildcard returns the records of the wildcard at the
+// closest encloser of a name that doesn't exist, RFC 4592.
+func (z *Zone) unsafeGetWildcard(name string) ([]dns.RR, bool) {
+	off, end := dns.NextLabel(name, 0)
+	for ; !end; off, end = dns.NextLabel(name, off) {
+		s := name[off:]
+		if _, ok := z.nodes[s]; ok || z.unsafeHasDescendants(s) {
+			records, ok := z.nodes["*."+s]
+			return records, ok
+		}
+	}
+	return nil, false
+}
+
+// unsafeAddSOA adds the SOA record to the authority section of
+// a negative response, using its minimum as TTL, RFC 2308.
+func (z *Zone) unsafeAddSOA(resp *dns.Msg) {
+	soa := dns.Copy(z.getSOA()).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	resp.Ns = append(resp.Ns, soa)
+}
+
+func zoneHasType(records []dns.RR, rrType uint16) bool {
+	for _, rr := range records {
+		if rr.Header().Rrtype == rrType {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneCopyRR copies a record using the given owner name, to
+// preserve the case of the question and expand wildcards.
+func zoneCopyRR(rr dns.RR, name string) dns.RR {
+	rr = dns.Copy(rr)
+	rr.Header().Name = name
+	return rr
+}
+
+// NewZone creates an empty [Zone] for the given origin.
+// A SOA record needs to be added before it can answer.
+func NewZone(origin string) (*Zone, error) {
+	if _, ok := dns.IsDomainName(origin); !ok {
+		return nil, core.Wrap(core.ErrInvalid, "invalid name")
+	}
+
+	z := &Zone{
+		origin: dns.CanonicalName(origin),
+		nodes:  make(map[string][]dns.RR),
+	}
+	return z, nil
+}
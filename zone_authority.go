@@ -0,0 +1,111 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var (
+	_ Lookuper  = (*Authority)(nil)
+	_ Exchanger = (*Authority)(nil)
+)
+
+// Authority is an [Exchanger] answering authoritatively for a set of
+// [Zone]s, and passing requests for other names to the next [Exchanger],
+// typically a recursive one, or REFUSING them if there is none.
+type Authority struct {
+	mu    sync.RWMutex
+	zones map[string]*Zone
+	next  Exchanger
+}
+
+// AddZone adds a [Zone] to the [Authority].
+func (a *Authority) AddZone(z *Zone) error {
+	if z == nil {
+		return core.ErrInvalid
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.zones[z.origin]; ok {
+		return core.ErrExists
+	}
+
+	a.zones[z.origin] = z
+	return nil
+}
+
+// RemoveZone removes the [Zone] of the given origin from the [Authority].
+func (a *Authority) RemoveZone(origin string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.zones, dns.CanonicalName(origin))
+}
+
+// GetZone returns the closest [Zone] containing the given name.
+func (a *Authority) GetZone(name string) (*Zone, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	name = dns.CanonicalName(name)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if z, ok := a.zones[name[off:]]; ok {
+			return z, true
+		}
+	}
+
+	z, ok := a.zones["."]
+	return z, ok
+}
+
+// Lookup performs a lookup of class INET using the [Zone] containing
+// the name, or the next [Exchanger].
+func (a *Authority) Lookup(ctx context.Context, qName string, qType uint16) (*dns.Msg, error) {
+	req := exdns.NewRequestFromParts(dns.Fqdn(qName), dns.ClassINET, qType)
+	return a.Exchange(ctx, req)
+}
+
+// Exchange passes the request to the [Zone] containing the name of the
+// first question, or to the next [Exchanger].
+func (a *Authority) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if ctx == nil || req == nil || len(req.Question) == 0 {
+		return nil, errors.ErrBadRequest()
+	}
+
+	q := req.Question[0]
+	if z, ok := a.GetZone(q.Name); ok && q.Qclass == dns.ClassINET {
+		return z.Exchange(ctx, req)
+	}
+
+	if a.next != nil {
+		return a.next.Exchange(ctx, req)
+	}
+
+	return nil, errors.ErrRefused(q.Name)
+}
+
+// NewAuthority creates an [Authority] for the given zones, passing the
+// requests it can't answer to the given [Exchanger], if any.
+func NewAuthority(next Exchanger, zones ...*Zone) (*Authority, error) {
+	a := &Authority{
+		zones: make(map[string]*Zone),
+		next:  next,
+	}
+
+	for _, z := range zones {
+		if err := a.AddZone(z); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
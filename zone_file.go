@@ -0,0 +1,189 @@
+package resolver
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/errors"
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+// DefaultZoneWatchInterval indicates how often [Zone.WatchFile]
+// checks the file for changes if not specified.
+const DefaultZoneWatchInterval = 5 * time.Second
+
+// Serial returns the serial number of the SOA record of the [Zone],
+// or zero if it has none.
+func (z *Zone) Serial() uint32 {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if soa := z.getSOA(); soa != nil {
+		return soa.Serial
+	}
+	return 0
+}
+
+// LoadFile replaces the records of the [Zone] with those of a
+// master file, as described in [Zone.LoadReader].
+func (z *Zone) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return z.LoadReader(f, filename)
+}
+
+// LoadReader replaces the records of the [Zone] with those of an
+// RFC 1035 master file, which must contain a SOA record.
+// $INCLUDE directives are allowed, relative to the filename.
+func (z *Zone) LoadReader(f io.Reader, filename string) error {
+	nodes, err := z.parseZoneFile(f, filename)
+	if err != nil {
+		return core.Wrapf(err, "%q: failed to load zone", filename)
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.nodes = nodes
+	return nil
+}
+
+func (z *Zone) parseZoneFile(f io.Reader, filename string) (map[string][]dns.RR, error) {
+	tmp := &Zone{
+		origin: z.origin,
+		nodes:  make(map[string][]dns.RR),
+	}
+
+	zp := dns.NewZoneParser(f, z.origin, filename)
+	zp.SetIncludeAllowed(true)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if err := tmp.checkRR(rr); err != nil {
+			return nil, err
+		}
+		tmp.unsafeAdd(rr)
+	}
+
+	switch {
+	case zp.Err() != nil:
+		return nil, zp.Err()
+	case tmp.getSOA() == nil:
+		return nil, errors.New("no SOA record found")
+	default:
+		return tmp.nodes, nil
+	}
+}
+
+// WatchFile loads the [Zone] from a master file, and keeps reloading
+// it in the background whenever it changes, until the context is
+// cancelled. Changes are only applied if the serial number increases,
+// and errors reloading the file leave the [Zone] as it was.
+// [DefaultZoneWatchInterval] is used if interval is zero.
+func (z *Zone) WatchFile(ctx context.Context, filename string, interval time.Duration) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := z.LoadFile(filename); err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		interval = DefaultZoneWatchInterval
+	}
+
+	go z.watchFile(ctx, filename, interval, fi)
+	return nil
+}
+
+func (z *Zone) watchFile(ctx context.Context, filename string,
+	interval time.Duration, last os.FileInfo) {
+	//
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			last = z.reloadIfChanged(filename, last)
+		}
+	}
+}
+
+// reloadIfChanged reloads the zone from a file if it changed
+// since last seen, and returns its new state.
+func (z *Zone) reloadIfChanged(filename string, last os.FileInfo) os.FileInfo {
+	fi, err := os.Stat(filename)
+	switch {
+	case err != nil:
+		// gone
+		return last
+	case fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size():
+		// unchanged
+		return last
+	case z.reloadFile(filename) != nil:
+		// failed, try again later
+		return last
+	default:
+		return fi
+	}
+}
+
+// reloadFile replaces the records of the [Zone] with those of
+// a file if its serial number is newer.
+func (z *Zone) reloadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nodes, err := z.parseZoneFile(f, filename)
+	if err != nil {
+		return err
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	soa, _ := exdns.GetFirstRR[*dns.SOA](nodes[z.origin])
+	if cur := z.getSOA(); cur != nil && !SerialNewer(soa.Serial, cur.Serial) {
+		// serial not increased, ignored.
+		return nil
+	}
+
+	z.nodes = nodes
+	return nil
+}
+
+// SerialNewer compares two SOA serial numbers using the sequence
+// space arithmetic of RFC 1982, telling if a is newer than b.
+func SerialNewer(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+// NewZoneFromFile creates a [Zone] loading the given master file.
+func NewZoneFromFile(origin, filename string) (*Zone, error) {
+	z, err := NewZone(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := z.LoadFile(filename); err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
@@ -0,0 +1,96 @@
+package resolver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+
+	"darvaza.org/resolver/pkg/exdns"
+)
+
+var _ ZoneJournal = (*ZoneJournalWriter)(nil)
+
+// ZoneJournalWriter is a [ZoneJournal] writing the changes in text form,
+// a "; serial" comment followed by a line for each record removed or
+// added, prefixed by "del" or "add" respectively.
+// It can be replayed using [Zone.ReplayJournal].
+type ZoneJournalWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Append writes the changes of an update to the journal.
+func (j *ZoneJournalWriter) Append(serial uint32, removed, added []dns.RR) error {
+	var buf bytes.Buffer
+
+	_, _ = fmt.Fprintf(&buf, "; serial %d\n", serial)
+	for _, rr := range removed {
+		_, _ = fmt.Fprintf(&buf, "del %s\n", rr)
+	}
+	for _, rr := range added {
+		_, _ = fmt.Fprintf(&buf, "add %s\n", rr)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err := j.w.Write(buf.Bytes())
+	return err
+}
+
+// NewZoneJournalWriter creates a [ZoneJournalWriter] on
+// the given [io.Writer], typically a file opened for appending.
+func NewZoneJournalWriter(w io.Writer) (*ZoneJournalWriter, error) {
+	if w == nil {
+		return nil, core.ErrInvalid
+	}
+	return &ZoneJournalWriter{w: w}, nil
+}
+
+// ReplayJournal applies the changes recorded by a [ZoneJournalWriter],
+// to restore the state of the [Zone] after loading the master file
+// the journal started from.
+func (z *Zone) ReplayJournal(r io.Reader) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		op, s, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		switch op {
+		case "", ";":
+			// empty or comment
+			continue
+		case "del", "add":
+			// change
+		default:
+			return core.Wrapf(core.ErrInvalid, "line %d: unknown operation %q", line, op)
+		}
+
+		rr, err := dns.NewRR(s)
+		if err == nil && rr != nil {
+			err = z.checkRR(rr)
+		}
+		if err != nil || rr == nil {
+			return core.Wrapf(core.Coalesce(err, core.ErrInvalid), "line %d", line)
+		}
+
+		if op == "add" {
+			z.unsafeAdd(rr)
+		} else {
+			name := dns.CanonicalName(rr.Header().Name)
+			z.unsafeSetNode(name, exdns.TrimRR(z.nodes[name], func(v dns.RR) bool {
+				return dns.IsDuplicate(v, rr)
+			}))
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,236 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newTestZone(t *testing.T) *Zone {
+	z, err := NewZone("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 300",
+		"example.org. 3600 IN NS ns.example.org.",
+		"ns.example.org. 3600 IN A 192.0.2.1",
+		"www.example.org. 3600 IN A 192.0.2.2",
+		"alias.example.org. 3600 IN CNAME www.example.org.",
+		"a.b.example.org. 3600 IN TXT \"deep\"",
+		"*.wild.example.org. 3600 IN TXT \"wild\"",
+		"sub.example.org. 3600 IN NS ns.sub.example.org.",
+		"ns.sub.example.org. 3600 IN A 192.0.2.3",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Add(rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return z
+}
+
+func TestZone(t *testing.T) {
+	tests := []struct {
+		name   string
+		qName  string
+		qType  uint16
+		rcode  int
+		answer int
+		ns     uint16
+		aa     bool
+	}{
+		{"answer", "WWW.example.org.", dns.TypeA, dns.RcodeSuccess, 1, 0, true},
+		{"nodata", "www.example.org.", dns.TypeAAAA, dns.RcodeSuccess, 0, dns.TypeSOA, true},
+		{"nxdomain", "nope.example.org.", dns.TypeA, dns.RcodeNameError, 0, dns.TypeSOA, true},
+		{"empty-non-terminal", "b.example.org.", dns.TypeTXT, dns.RcodeSuccess, 0, dns.TypeSOA, true},
+		{"cname", "alias.example.org.", dns.TypeA, dns.RcodeSuccess, 2, 0, true},
+		{"wildcard", "x.wild.example.org.", dns.TypeTXT, dns.RcodeSuccess, 1, 0, true},
+		{"wildcard-nodata", "x.wild.example.org.", dns.TypeA, dns.RcodeSuccess, 0, dns.TypeSOA, true},
+		{"referral", "www.sub.example.org.", dns.TypeA, dns.RcodeSuccess, 0, dns.TypeNS, false},
+		{"ds", "sub.example.org.", dns.TypeDS, dns.RcodeSuccess, 0, dns.TypeSOA, true},
+	}
+
+	z := newTestZone(t)
+	for _, tc := range tests {
+		resp, err := z.Lookup(context.Background(), tc.qName, tc.qType)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+
+		switch {
+		case resp.Rcode != tc.rcode:
+			t.Errorf("%s: unexpected rcode %v", tc.name, dns.RcodeToString[resp.Rcode])
+		case len(resp.Answer) != tc.answer:
+			t.Errorf("%s: unexpected answer: %v", tc.name, resp.Answer)
+		case resp.Authoritative != tc.aa:
+			t.Errorf("%s: unexpected AA bit", tc.name)
+		case tc.ns != 0 && (len(resp.Ns) == 0 || resp.Ns[0].Header().Rrtype != tc.ns):
+			t.Errorf("%s: unexpected authority: %v", tc.name, resp.Ns)
+		case tc.answer > 0 && resp.Answer[0].Header().Name != tc.qName:
+			t.Errorf("%s: unexpected owner: %v", tc.name, resp.Answer[0])
+		}
+	}
+}
+
+func TestZoneRemove(t *testing.T) {
+	z := newTestZone(t)
+
+	z.RemoveRRset("www.example.org.", dns.TypeA)
+	resp, err := z.Lookup(context.Background(), "www.example.org.", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeNameError {
+		t.Errorf("unexpected rcode %v", dns.RcodeToString[resp.Rcode])
+	}
+
+	if err := z.Add(&dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA,
+		Class: dns.ClassINET}}); err == nil {
+		t.Error("out of zone record accepted")
+	}
+}
+
+func TestAuthority(t *testing.T) {
+	var calls []string
+
+	a, err := NewAuthority(newTestChainStep("next", &calls, nil).(Exchanger), newTestZone(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := a.Lookup(context.Background(), "nope.example.org.", dns.TypeA)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case resp.Rcode != dns.RcodeNameError || len(calls) != 0:
+		t.Errorf("unexpected in-zone response: %v", resp)
+	}
+
+	_, err = a.Lookup(context.Background(), "example.com.", dns.TypeA)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case len(calls) != 1:
+		t.Error("out of zone request not passed to next")
+	}
+}
+
+const testZoneFile = `$ORIGIN example.org.
+$TTL 3600
+@	IN SOA ns hostmaster %d 7200 3600 1209600 300
+	IN NS ns
+ns	IN A 192.0.2.1
+www	IN A %s
+`
+
+func writeTestZoneFile(t *testing.T, filename string, serial int, addr string) {
+	s := fmt.Sprintf(testZoneFile, serial, addr)
+	if err := os.WriteFile(filename, []byte(s), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZoneFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "example.org.zone")
+	writeTestZoneFile(t, filename, 2, "192.0.2.2")
+
+	z, err := NewZoneFromFile("example.org.", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z.Serial() != 2 {
+		t.Errorf("unexpected serial %v", z.Serial())
+	}
+
+	for _, tc := range []struct {
+		serial int
+		addr   string
+		want   string
+	}{
+		{1, "192.0.2.3", "192.0.2.2"},
+		{3, "192.0.2.4", "192.0.2.4"},
+	} {
+		writeTestZoneFile(t, filename, tc.serial, tc.addr)
+		if err := z.reloadFile(filename); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := z.Lookup(context.Background(), "www.example.org.", dns.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a, ok := resp.Answer[0].(*dns.A); !ok || a.A.String() != tc.want {
+			t.Errorf("serial %v: unexpected answer %v", tc.serial, resp.Answer)
+		}
+	}
+
+	if !SerialNewer(1, 0xffffffff) || SerialNewer(0xffffffff, 1) {
+		t.Error("serial wrap-around not handled")
+	}
+}
+
+func TestZoneUpdate(t *testing.T) {
+	var journal bytes.Buffer
+
+	z := newTestZone(t)
+	j, err := NewZoneJournalWriter(&journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetJournal(j)
+
+	rr1, _ := dns.NewRR("new.example.org. 300 IN A 192.0.2.10")
+	rr2, _ := dns.NewRR("www.example.org. 300 IN A 192.0.2.2")
+
+	// prerequisite not met
+	req := new(dns.Msg)
+	req.SetUpdate("example.org.")
+	req.RRsetUsed([]dns.RR{rr1})
+	req.Insert([]dns.RR{rr1})
+	if rcode, _ := z.Update(req); rcode != dns.RcodeNXRrset {
+		t.Errorf("unexpected rcode %v", dns.RcodeToString[rcode])
+	}
+
+	// add and remove
+	req = new(dns.Msg)
+	req.SetUpdate("example.org.")
+	req.RRsetNotUsed([]dns.RR{rr1})
+	req.Insert([]dns.RR{rr1})
+	req.Remove([]dns.RR{rr2})
+	if rcode, err := z.Update(req); rcode != dns.RcodeSuccess || err != nil {
+		t.Fatalf("unexpected rcode %v: %v", dns.RcodeToString[rcode], err)
+	}
+
+	if z.Serial() != 2 {
+		t.Errorf("unexpected serial %v", z.Serial())
+	}
+
+	// replay
+	z2 := newTestZone(t)
+	if err := z2.ReplayJournal(&journal); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, zone := range []*Zone{z, z2} {
+		resp, _ := zone.Lookup(context.Background(), "new.example.org.", dns.TypeA)
+		if len(resp.Answer) != 1 {
+			t.Errorf("unexpected answer: %v", resp.Answer)
+		}
+
+		resp, _ = zone.Lookup(context.Background(), "www.example.org.", dns.TypeA)
+		if resp.Rcode != dns.RcodeNameError {
+			t.Errorf("unexpected rcode %v", dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package resolver
+
+import (
+	"github.com/miekg/dns"
+
+	"darvaza.org/core"
+)
+
+// ZoneJournal records the changes made to a [Zone] by dynamic updates.
+type ZoneJournal interface {
+	// Append records the records removed and added by an update,
+	// and the resulting serial number.
+	Append(serial uint32, removed, added []dns.RR) error
+}
+
+// SetJournal attaches a [ZoneJournal] to the [Zone], recording
+// the changes made by [Zone.Update].
+func (z *Zone) SetJournal(j ZoneJournal) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.journal = j
+}
+
+// zoneUpdate tracks the changes made by an update.
+type zoneUpdate struct {
+	removed []dns.RR
+	added   []dns.RR
+	soa     bool
+
+	// saved holds the original records of the names changed
+	saved map[string][]dns.RR
+}
+
+// Update applies a dynamic update to the [Zone], RFC 2136, returning the
+// rcode for the response. Prerequisites are checked first and changes are
+// applied atomically, increasing the serial number of the SOA record unless
+// the update provides a newer one.
+// Errors are only returned if the [ZoneJournal] fails, and then the
+// changes are reverted.
+func (z *Zone) Update(req *dns.Msg) (int, error) {
+	if req == nil || len(req.Question) != 1 {
+		return dns.RcodeFormatError, nil
+	}
+
+	q := req.Question[0]
+	switch {
+	case q.Qtype != dns.TypeSOA || q.Qclass != dns.ClassINET:
+		return dns.RcodeFormatError, nil
+	case dns.CanonicalName(q.Name) != z.origin:
+		return dns.RcodeNotAuth, nil
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.getSOA() == nil {
+		return dns.RcodeServerFailure, nil
+	}
+
+	if rcode := z.unsafeCheckPrerequisites(req.Answer); rcode != dns.RcodeSuccess {
+		return rcode, nil
+	}
+
+	if rcode := z.checkUpdates(req.Ns); rcode != dns.RcodeSuccess {
+		return rcode, nil
+	}
+
+	u := &zoneUpdate{
+		saved: make(map[string][]dns.RR),
+	}
+	for _, rr := range req.Ns {
+		z.unsafeApplyUpdate(u, rr)
+	}
+
+	if len(u.removed) == 0 && len(u.added) == 0 {
+		// nothing changed
+		return dns.RcodeSuccess, nil
+	}
+
+	serial := z.unsafeIncreaseSerial(u)
+	if z.journal != nil {
+		if err := z.journal.Append(serial, u.removed, u.added); err != nil {
+			z.unsafeRevert(u)
+			return dns.RcodeServerFailure, err
+		}
+	}
+
+	return dns.RcodeSuccess, nil
+}
+
+// unsafeCheckPrerequisites checks the prerequisite section of
+// an update, RFC 2136 section 3.2.
+func (z *Zone) unsafeCheckPrerequisites(prereqs []dns.RR) int {
+	type rrsetKey struct {
+		name   string
+		rrType uint16
+	}
+
+	rrsets := make(map[rrsetKey][]dns.RR)
+	for _, rr := range prereqs {
+		hdr := rr.Header()
+		name := dns.CanonicalName(hdr.Name)
+
+		switch {
+		case hdr.Ttl != 0:
+			return dns.RcodeFormatError
+		case !z.Contains(name):
+			return dns.RcodeNotZone
+		}
+
+		records := z.nodes[name]
+		switch hdr.Class {
+		case dns.ClassANY:
+			// name or RRset in use
+			switch {
+			case hdr.Rrtype == dns.TypeANY && len(records) == 0:
+				return dns.RcodeNameError
+			case hdr.Rrtype != dns.TypeANY && !zoneHasType(records, hdr.Rrtype):
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			// name or RRset not in use
+			switch {
+			case hdr.Rrtype == dns.TypeANY && len(records) > 0:
+				return dns.RcodeYXDomain
+			case hdr.Rrtype != dns.TypeANY && zoneHasType(records, hdr.Rrtype):
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			// RRset exists, value dependent
+			key := rrsetKey{name, hdr.Rrtype}
+			rrsets[key] = append(rrsets[key], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	for key, rrset := range rrsets {
+		if !zoneSameRRset(z.nodes[key.name], key.rrType, rrset) {
+			return dns.RcodeNXRrset
+		}
+	}
+
+	return dns.RcodeSuccess
+}
+
+// checkUpdates validates the update section of an update,
+// RFC 2136 section 3.4.1.
+func (z *Zone) checkUpdates(updates []dns.RR) int {
+	for _, rr := range updates {
+		hdr := rr.Header()
+		if !z.Contains(hdr.Name) {
+			return dns.RcodeNotZone
+		}
+
+		var ok bool
+		switch hdr.Class {
+		case dns.ClassINET:
+			ok = hdr.Rrtype != dns.TypeANY && !zoneIsMetaType(hdr.Rrtype)
+		case dns.ClassANY:
+			ok = hdr.Ttl == 0 && !zoneIsMetaType(hdr.Rrtype)
+		case dns.ClassNONE:
+			ok = hdr.Ttl == 0 && hdr.Rrtype != dns.TypeANY && !zoneIsMetaType(hdr.Rrtype)
+		}
+
+		if !ok {
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// unsafeApplyUpdate applies one record of the update section,
+// RFC 2136 section 3.4.2.
+func (z *Zone) unsafeApplyUpdate(u *zoneUpdate, rr dns.RR) {
+	hdr := rr.Header()
+	name := dns.CanonicalName(hdr.Name)
+	apex := name == z.origin
+
+	switch hdr.Class {
+	case dns.ClassINET:
+		z.unsafeAddUpdate(u, dns.Copy(rr))
+	case dns.ClassANY:
+		// delete name or RRset, but the SOA and NS at the apex
+		z.unsafeRemoveFn(u, name, func(v dns.RR) bool {
+			t := v.Header().Rrtype
+			switch {
+			case apex && (t == dns.TypeSOA || t == dns.TypeNS):
+				return false
+			default:
+				return hdr.Rrtype == dns.TypeANY || t == hdr.Rrtype
+			}
+		})
+	case dns.ClassNONE:
+		// delete record, but the SOA and the last NS at the apex
+		records := z.nodes[name]
+		switch {
+		case hdr.Rrtype == dns.TypeSOA:
+			return
+		case apex && hdr.Rrtype == dns.TypeNS && zoneCountType(records, dns.TypeNS) < 2:
+			return
+		}
+
+		rr = dns.Copy(rr)
+		rr.Header().Class = dns.ClassINET
+		z.unsafeRemoveFn(u, name, func(v dns.RR) bool {
+			return dns.IsDuplicate(v, rr)
+		})
+	}
+}
+
+func (z *Zone) unsafeAddUpdate(u *zoneUpdate, rr dns.RR) {
+	hdr := rr.Header()
+	name := dns.CanonicalName(hdr.Name)
+	records := z.nodes[name]
+
+	var replace func(dns.RR) bool
+	switch hdr.Rrtype {
+	case dns.TypeCNAME:
+		if zoneCountType(records, dns.TypeCNAME) < len(records) {
+			// CNAME can't coexist with other data
+			return
+		}
+		replace = func(dns.RR) bool { return true }
+	case dns.TypeSOA:
+		soa, ok := rr.(*dns.SOA)
+		if !ok || name != z.origin || !SerialNewer(soa.Serial, z.getSOA().Serial) {
+			return
+		}
+		u.soa = true
+		replace = func(v dns.RR) bool { return v.Header().Rrtype == dns.TypeSOA }
+	default:
+		if zoneHasType(records, dns.TypeCNAME) {
+			return
+		}
+		// new TTL
+		replace = func(v dns.RR) bool { return dns.IsDuplicate(v, rr) }
+	}
+
+	z.unsafeRemoveFn(u, name, replace)
+	z.unsafeAdd(rr)
+	u.added = append(u.added, rr)
+}
+
+// unsafeRemoveFn removes the records of a name matching
+// the condition.
+func (z *Zone) unsafeRemoveFn(u *zoneUpdate, name string, cond func(dns.RR) bool) {
+	if _, ok := u.saved[name]; !ok {
+		u.saved[name] = core.SliceCopy(z.nodes[name])
+	}
+
+	var keep []dns.RR
+	for _, rr := range z.nodes[name] {
+		if cond(rr) {
+			u.removed = append(u.removed, rr)
+		} else {
+			keep = append(keep, rr)
+		}
+	}
+	z.unsafeSetNode(name, keep)
+}
+
+// unsafeIncreaseSerial replaces the SOA record with one with the next
+// serial number, unless the update provided one, and returns the new
+// serial number.
+func (z *Zone) unsafeIncreaseSerial(u *zoneUpdate) uint32 {
+	soa := z.getSOA()
+	if u.soa {
+		return soa.Serial
+	}
+
+	next := dns.Copy(soa).(*dns.SOA)
+	next.Serial++
+
+	z.unsafeRemoveFn(u, z.origin, func(v dns.RR) bool {
+		return v.Header().Rrtype == dns.TypeSOA
+	})
+	z.unsafeAdd(next)
+	u.added = append(u.added, next)
+	return next.Serial
+}
+
+// unsafeRevert undoes the changes of an update.
+func (z *Zone) unsafeRevert(u *zoneUpdate) {
+	for name, records := range u.saved {
+		z.unsafeSetNode(name, records)
+	}
+}
+
+// zoneSameRRset tells if the records of the given type match
+// the given RRset, ignoring TTLs.
+func zoneSameRRset(records []dns.RR, rrType uint16, rrset []dns.RR) bool {
+	var have []dns.RR
+	for _, rr := range records {
+		if rr.Header().Rrtype == rrType {
+			have = append(have, rr)
+		}
+	}
+
+	for _, rr := range have {
+		if !zoneContainsRR(rrset, rr) {
+			return false
+		}
+	}
+	for _, rr := range rrset {
+		if !zoneContainsRR(have, rr) {
+			return false
+		}
+	}
+	return true
+}
+
+func zoneContainsRR(records []dns.RR, rr dns.RR) bool {
+	for _, v := range records {
+		if dns.IsDuplicate(v, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+func zoneCountType(records []dns.RR, rrType uint16) int {
+	var n int
+	for _, rr := range records {
+		if rr.Header().Rrtype == rrType {
+			n++
+		}
+	}
+	return n
+}
+
+// zoneIsMetaType tells if a type can't be stored in a [Zone].
+func zoneIsMetaType(rrType uint16) bool {
+	switch rrType {
+	case dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB,
+		dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY:
+		return true
+	default:
+		return false
+	}
+}